@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func main() {
+	flowPath := flag.String("flow", "", "order flow to replay, CSV when the name ends in .csv and JSONL otherwise")
+	recordingPath := flag.String("recording", "", "market recording whose orders to replay")
+	synthetic := flag.Int("synthetic", 0, "generate this many events of synthetic flow instead of reading a file")
+	seed := flag.Int64("seed", 1, "seed of the latency draws and the synthetic flow")
+	latency := flag.Duration("latency", time.Millisecond, "base latency of the strategy's instructions")
+	jitter := flag.Duration("jitter", 0, "uniform jitter added to the latency")
+	spread := flag.Float64("spread", 20, "spread of the symmetric strategy, in basis points")
+	size := flag.Float64("size", 1, "size of each quote")
+	skew := flag.Float64("skew", 10, "inventory skew at the maximum inventory, in basis points")
+	maxInventory := flag.Float64("max-inventory", 10, "position at which the strategy stops adding to it")
+	fills := flag.Bool("fills", false, "list every fill of the strategy in the report")
+	flag.Parse()
+
+	config := services.DefaultMarketConfigs[services.MarketETH]
+
+	var flow []services.FlowEvent
+	switch {
+	case *synthetic > 0:
+		flow = services.SyntheticFlow(services.SyntheticFlowConfig{
+			Events: *synthetic, Interval: 10 * time.Millisecond, Price: 100, VolatilityBps: 5, DepthBps: 50,
+			MaxSize: 2, CancelRate: 0.2, TradeRate: 0.2, TickSize: config.TickSize, LotSize: config.LotSize,
+		}, *seed)
+	case *flowPath != "":
+		var err error
+		if flow, err = services.ReadFlowFile(*flowPath); err != nil {
+			log.Fatal("Error reading the order flow: ", err)
+		}
+	case *recordingPath != "":
+		recording, err := services.OpenRecording(*recordingPath)
+		if err != nil {
+			log.Fatal("Error opening the recording: ", err)
+		}
+		flow, err = services.RecordedFlow(recording.Events())
+		recording.Close()
+		if err != nil {
+			log.Fatal("Error reading the recording: ", err)
+		}
+	default:
+		log.Fatal("Pass -flow, -recording or -synthetic")
+	}
+
+	strategy := services.SymmetricSpread{
+		SpreadBps: *spread, Size: services.Money(*size), SkewBps: *skew, MaxInventory: services.Money(*maxInventory),
+	}
+	backtest := services.NewBacktest(services.BacktestConfig{
+		Market:  services.MarketETH,
+		Config:  config,
+		Latency: services.LatencyModel{Base: *latency, Jitter: *jitter},
+		Seed:    *seed,
+	}, services.Participant{UserID: "market_maker", Strategy: strategy})
+
+	report := backtest.Run(flow)
+	if !*fills {
+		for i := range report.Participants {
+			report.Participants[i].Fills = nil
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,39 +1,240 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/theghostmac/cryptex/internal/app/api"
+	"github.com/theghostmac/cryptex/internal/app/fix"
+	"github.com/theghostmac/cryptex/internal/app/grpcapi"
 	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+	"github.com/theghostmac/cryptex/internal/infrastructure/repositories"
+	"github.com/theghostmac/cryptex/web/middlewares"
 	"github.com/theghostmac/cryptex/web/server"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
 	// Set logging output to stdout
 	log.SetOutput(os.Stdout)
 
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Server stopped gracefully.")
+}
+
+// run starts the exchange and serves it until SIGINT or SIGTERM. Errors are returned rather than
+// fatal, so everything opened is closed on the way out.
+func run() error {
+	// Everything started below stops on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize the cryptoexchange application.
 	cryptoExchangeService := services.NewCryptoExchangeService()
 
+	// Persist to the SQLite database in CRYPTEX_DB, cryptex.db by default.
+	store, err := openStore(ctx)
+	if err != nil {
+		return fmt.Errorf("opening the store: %w", err)
+	}
+	defer store.Close()
+	cryptoExchangeService.UseStore(store)
+
+	// Keep an audit trail of every order book event.
+	go auditLog(cryptoExchangeService.Events.Subscribe("audit", 1024, messaging.Block, nil))
+
+	// Aggregate executed trades into candles and tickers.
+	go cryptoExchangeService.Candles.Run(ctx, cryptoExchangeService.Events)
+	go cryptoExchangeService.Tickers.Run(ctx, cryptoExchangeService.Events)
+
+	// Workers that leave something behind when they stop, the recorders and the market maker, are
+	// waited for before the store closes, whatever stops the process.
+	var workers sync.WaitGroup
+	defer func() {
+		stop()
+		workers.Wait()
+	}()
+
+	// Record every market's events to CRYPTEX_RECORD_DIR when it is set. The recordings are
+	// closed with their index before the process exits.
+	if dir := os.Getenv("CRYPTEX_RECORD_DIR"); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("starting the market recorders: %w", err)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := recordMarkets(ctx, cryptoExchangeService, dir); err != nil {
+				log.Printf("Market recording failed: %v", err)
+			}
+		}()
+	}
+
+	// Publish every change to the resting orders for the order-by-order feed.
+	l3Feed := services.NewL3Feed(cryptoExchangeService)
+	go l3Feed.Run(ctx)
+
+	// Pull good-till-time orders once they expire, and the orders of sessions whose dead man's switch
+	// ran out. Sweeping well within the shortest switch timeout keeps it from firing late.
+	go cryptoExchangeService.RunExpirySweeper(ctx, 100*time.Millisecond)
+
+	// Quote ETH for the account in CRYPTEX_MARKET_MAKER with the reference strategy.
+	if makerID := os.Getenv("CRYPTEX_MARKET_MAKER"); makerID != "" {
+		strategy := services.SymmetricSpread{SpreadBps: 20, Size: 1, SkewBps: 10, MaxInventory: 10}
+		maker := services.NewMarketMaker(cryptoExchangeService, services.MarketETH, makerID, strategy)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			// Its quotes are pulled once ctx is done.
+			maker.Run(ctx)
+		}()
+	}
+
+	// Accept FIX sessions on CRYPTEX_FIX_ADDR when it is set.
+	if addr := os.Getenv("CRYPTEX_FIX_ADDR"); addr != "" {
+		acceptor, err := fixAcceptor(cryptoExchangeService, addr)
+		if err != nil {
+			return fmt.Errorf("starting the FIX acceptor: %w", err)
+		}
+		go func() {
+			if err := acceptor.Serve(ctx); err != nil {
+				log.Printf("FIX acceptor stopped: %v", err)
+			}
+		}()
+	}
+
 	// Create a new API handler for the cryptoexchange feature.
 	cryptoExchangeHandler := api.NewCryptoExchangeHandler(cryptoExchangeService)
+	// Admin endpoints are only enabled with a token.
+	cryptoExchangeHandler.AdminToken = os.Getenv("CRYPTEX_ADMIN_TOKEN")
+	cryptoExchangeHandler.L3 = l3Feed
+
+	// Rate limits come from CRYPTEX_RATE_LIMITS when set, and are reloaded on SIGHUP.
+	rateLimiter := middlewares.NewRateLimiter(loadRateLimits())
+	go reloadRateLimitsOnHangup(rateLimiter)
 
-	// Register the API handler to handle incoming requests at the specified endpoint.
-	http.HandleFunc("/cryptoexchange/trade", cryptoExchangeHandler.Trade)
+	// Register the API handlers behind their rate limits.
+	router := api.NewRouter(cryptoExchangeHandler, rateLimiter)
 
 	// Define the graceful shutdown server
 	shutdownServer := &server.GracefulShutdown{
 		ListenAddr:  ":8080", // Change this to the desired address
-		BaseHandler: nil,     // Replace nil with your actual HTTP handler if needed.
+		BaseHandler: router,
+	}
+
+	// Serve the gRPC API on CRYPTEX_GRPC_ADDR, next to HTTP and on the same services.
+	grpcAddr := os.Getenv("CRYPTEX_GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
 	}
 
 	// Start the server using the StartRunner
-	runner := &server.StartRunner{ListenAddr: shutdownServer.ListenAddr}
-	if err := runner.Run(); err != nil {
-		log.Fatal("Error starting the server: ", err)
+	runner := &server.StartRunner{
+		ListenAddr:  shutdownServer.ListenAddr,
+		BaseHandler: shutdownServer.BaseHandler,
+		GRPCAddr:    grpcAddr,
+		GRPCServer:  grpcapi.NewGRPCServer(cryptoExchangeService, rateLimiter),
 	}
+	// A server that failed stops the rest too, so the recordings still get their index.
+	if err := runner.Run(ctx); err != nil {
+		return fmt.Errorf("starting the server: %w", err)
+	}
+	return nil
+}
 
-	fmt.Println("Server stopped gracefully.")
+func auditLog(subscriber *messaging.Subscriber) {
+	for event := range subscriber.Events() {
+		log.Printf("[audit] %s %+v", event.EventType(), event)
+	}
+}
+
+// recordMarkets records each market to its own file, named after the market and the start time,
+// until ctx is done. The files of a process that is killed lack their index, and are scanned when
+// opened.
+func recordMarkets(ctx context.Context, exchange *services.CryptoExchangeService, dir string) error {
+	started := time.Now().UTC().Format("20060102T150405")
+	return services.RecordMarkets(ctx, exchange.Events, time.Second, func(market services.Market) (*services.MarketRecorder, error) {
+		return services.CreateRecording(filepath.Join(dir, fmt.Sprintf("%s-%s.cxmd", market, started)), market)
+	})
+}
+
+// fixAcceptor listens for the counterparties in CRYPTEX_FIX_SESSIONS, a comma-separated list of
+// CompID=userID pairs, keeping each session's messages in CRYPTEX_FIX_STORE.
+func fixAcceptor(exchange *services.CryptoExchangeService, addr string) (*fix.Acceptor, error) {
+	dir := os.Getenv("CRYPTEX_FIX_STORE")
+	if dir == "" {
+		dir = "fix-store"
+	}
+	acceptor := fix.NewAcceptor("CRYPTEX", exchange)
+	for _, pair := range strings.Split(os.Getenv("CRYPTEX_FIX_SESSIONS"), ",") {
+		if pair == "" {
+			continue
+		}
+		compID, userID, ok := strings.Cut(pair, "=")
+		if !ok || compID == "" || userID == "" {
+			return nil, fmt.Errorf("invalid FIX session %q, expected CompID=userID", pair)
+		}
+		store, err := fix.OpenFileStore(dir, acceptor.CompID+"-"+compID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := acceptor.AddSession(fix.SessionConfig{CompID: compID, UserID: userID}, store); err != nil {
+			return nil, err
+		}
+	}
+	return acceptor, acceptor.Listen(addr)
+}
+
+// openStore opens the SQLite database in CRYPTEX_DB. Setting it to "memory" keeps everything in
+// memory instead, and loses it on exit.
+func openStore(ctx context.Context) (services.Store, error) {
+	path := os.Getenv("CRYPTEX_DB")
+	switch path {
+	case "":
+		path = "cryptex.db"
+	case "memory":
+		log.Printf("Warning: CRYPTEX_DB=memory, orders, trades, accounts and balances are lost on exit")
+		return repositories.NewMemoryStore(), nil
+	}
+	return repositories.OpenSQLite(ctx, path)
+}
+
+func loadRateLimits() middlewares.RateLimitConfig {
+	path := os.Getenv("CRYPTEX_RATE_LIMITS")
+	if path == "" {
+		return middlewares.DefaultRateLimitConfig()
+	}
+	config, err := middlewares.LoadRateLimitConfig(path)
+	if err != nil {
+		log.Printf("Could not load rate limits from %s, using defaults: %v", path, err)
+		return middlewares.DefaultRateLimitConfig()
+	}
+	return config
+}
+
+func reloadRateLimitsOnHangup(limiter *middlewares.RateLimiter) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		path := os.Getenv("CRYPTEX_RATE_LIMITS")
+		if path == "" {
+			continue
+		}
+		config, err := middlewares.LoadRateLimitConfig(path)
+		if err != nil {
+			log.Printf("Rate limit reload failed, keeping current limits: %v", err)
+			continue
+		}
+		limiter.Reload(config)
+		log.Printf("Rate limits reloaded from %s", path)
+	}
 }
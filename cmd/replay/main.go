@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/internal/app/api"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+func main() {
+	path := flag.String("recording", "", "market recording to replay")
+	speed := flag.Float64("speed", 1, "replay speed: 1 is real time, 10 ten times faster, 0 as fast as possible")
+	fromSequence := flag.Uint64("from-seq", 0, "start at the first event with at least this sequence number")
+	fromTime := flag.String("from-time", "", "start at the first event at or after this RFC 3339 time")
+	addr := flag.String("addr", ":8081", "address serving the replayed feed at /ws/markets/{market}")
+	flowPath := flag.String("flow", "", "write the recorded orders as JSONL order flow for cmd/backtest instead of serving them")
+	flag.Parse()
+
+	if *path == "" {
+		log.Fatal("Pass -recording")
+	}
+	recording, err := services.OpenRecording(*path)
+	if err != nil {
+		log.Fatal("Error opening the recording: ", err)
+	}
+	defer recording.Close()
+	if !recording.Complete {
+		log.Printf("The recording of %s was not closed; replaying up to its last complete event", recording.Market)
+	}
+
+	events := recording.Events()
+	switch {
+	case *fromTime != "":
+		at, err := time.Parse(time.RFC3339Nano, *fromTime)
+		if err != nil {
+			log.Fatal("Error parsing -from-time: ", err)
+		}
+		events = recording.FromTime(at.UnixNano())
+	case *fromSequence > 0:
+		events = recording.FromSequence(*fromSequence)
+	}
+
+	if *flowPath != "" {
+		if err := writeFlow(*flowPath, events); err != nil {
+			log.Fatal("Error writing the order flow: ", err)
+		}
+		return
+	}
+
+	bus := messaging.NewDispatcher()
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/markets/{market}", api.MarketFeed(bus)).Methods(http.MethodGet)
+	go func() {
+		if err := http.ListenAndServe(*addr, router); err != nil {
+			log.Fatal("Error serving the feed: ", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("Serving the replay of %s at ws://localhost%s/ws/markets/%s, starting when a client connects\n", recording.Market, *addr, recording.Market)
+	for bus.SubscriberCount() == 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	published, err := services.Replayer{Speed: *speed}.Replay(ctx, events, bus)
+	if err != nil && err != context.Canceled {
+		log.Fatal("Error replaying: ", err)
+	}
+	fmt.Printf("Replayed %d events.\n", published)
+}
+
+func writeFlow(path string, events *services.RecordingReader) error {
+	flow, err := services.RecordedFlow(events)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, event := range flow {
+		if err := encoder.Encode(event); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// MarketStateRequest is the JSON body changing a market's state.
+type MarketStateRequest struct {
+	State services.MarketState `json:"state"`
+	// DurationSec ends a halt or an auction on its own after that many seconds.
+	DurationSec int64 `json:"durationSec,omitempty"`
+}
+
+// SetMarketState halts, opens, auctions or puts a market into cancel-only mode.
+func (exh *CryptoExchangeHandler) SetMarketState(writer http.ResponseWriter, request *http.Request) {
+	var body MarketStateRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	market := services.Market(mux.Vars(request)["market"])
+	status, err := exh.Service.SetMarketState(request.Context(), market, body.State, time.Duration(body.DurationSec)*time.Second)
+	switch {
+	case errors.Is(err, services.ErrMarketNotFound), errors.Is(err, services.ErrUnknownMarketState):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not record the uncross of market %s: %v", market, err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "auction trades could not be recorded"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, status)
+}
+
+// DepositRequest is the JSON body crediting funds to an account.
+type DepositRequest struct {
+	Asset  services.Asset `json:"asset"`
+	Amount services.Money `json:"amount"`
+}
+
+// Deposit credits funds to a user's wallet, so the account can place orders against them.
+func (exh *CryptoExchangeHandler) Deposit(writer http.ResponseWriter, request *http.Request) {
+	var body DepositRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	userID := mux.Vars(request)["user"]
+	user, err := exh.Service.Users.Deposit(request.Context(), userID, body.Asset, body.Amount)
+	switch {
+	case errors.Is(err, services.ErrInvalidDeposit):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		RespondWithError(writer, http.StatusNotFound, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not record a deposit for user %s: %v", userID, err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "deposit could not be recorded"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, exh.userResponse(user))
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// BatchOperationRequest is one operation of a batch: "place", "cancel" or "amend".
+type BatchOperationRequest struct {
+	Op        services.BatchOp `json:"op"`
+	OrderID   string           `json:"orderId,omitempty"`
+	OrderType TypeOfOrder      `json:"orderType,omitempty"` // place only, limit when omitted.
+	Bid       bool             `json:"bid,omitempty"`
+	Price     services.Money   `json:"price,omitempty"`
+	Size      services.Money   `json:"size,omitempty"`
+	ExpiresAt *time.Time       `json:"expiresAt,omitempty"`
+}
+
+// BatchRequest is the JSON body of a batch of operations on one market.
+type BatchRequest struct {
+	Market services.Market `json:"market"`
+	// AllOrNothing rolls back the whole batch when any operation fails.
+	AllOrNothing bool                    `json:"allOrNothing"`
+	Operations   []BatchOperationRequest `json:"operations"`
+}
+
+// BatchResponse carries a result per operation, in request order.
+type BatchResponse struct {
+	Results    []services.BatchResult `json:"results"`
+	RolledBack bool                   `json:"rolledBack"`
+}
+
+// Batch places, cancels and amends the user's orders on one market in a single request.
+func (exh *CryptoExchangeHandler) Batch(writer http.ResponseWriter, request *http.Request) {
+	var body BatchRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	user, _ := middlewares.UserFromContext(request.Context())
+
+	operations := make([]services.BatchOperation, len(body.Operations))
+	for i, op := range body.Operations {
+		operations[i] = services.BatchOperation{
+			Op:      op.Op,
+			OrderID: op.OrderID,
+			Type:    services.OrderTypeLimit,
+			Bid:     op.Bid,
+			Price:   op.Price,
+			Size:    op.Size,
+		}
+		if op.OrderType == MarketOrder {
+			operations[i].Type = services.OrderTypeMarket
+		}
+		if op.ExpiresAt != nil {
+			operations[i].ExpiresAt = op.ExpiresAt.UnixNano()
+		}
+	}
+
+	results, rolledBack, err := exh.Service.ExecuteBatch(request.Context(), body.Market, user.ID, operations, body.AllOrNothing)
+	switch {
+	case errors.Is(err, services.ErrMarketNotFound), errors.Is(err, services.ErrEmptyBatch), errors.Is(err, services.ErrBatchTooLarge):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not record batch of user %s: %v", user.ID, err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "batch could not be recorded"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, BatchResponse{Results: results, RolledBack: rolledBack})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// ConvertQuoteRequest is the JSON body asking for a quote to convert Amount of From into To.
+type ConvertQuoteRequest struct {
+	From   services.Asset `json:"from"`
+	To     services.Asset `json:"to"`
+	Amount services.Money `json:"amount"`
+}
+
+// QuoteConvert responds with a firm quote for a conversion, to be accepted before it expires.
+func (exh *CryptoExchangeHandler) QuoteConvert(writer http.ResponseWriter, request *http.Request) {
+	var body ConvertQuoteRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	user, _ := middlewares.UserFromContext(request.Context())
+	quote, err := exh.Service.Convert.Quote(user.ID, body.From, body.To, body.Amount)
+	if err != nil {
+		respondConvertError(writer, err)
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, quote)
+}
+
+// AcceptConvert executes a quote of the user.
+func (exh *CryptoExchangeHandler) AcceptConvert(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	result, err := exh.Service.Convert.Accept(request.Context(), user.ID, mux.Vars(request)["id"])
+	if err != nil {
+		respondConvertError(writer, err)
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, result)
+}
+
+func respondConvertError(writer http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, services.ErrQuoteNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrQuoteExpired), errors.Is(err, services.ErrQuoteMoved):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrMarketHalted), errors.Is(err, services.ErrMarketCancelOnly), errors.Is(err, services.ErrMarketInAuction):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, services.ErrSameAsset), errors.Is(err, services.ErrNoConvertRoute),
+		errors.Is(err, services.ErrInvalidConvertAmount), errors.Is(err, services.ErrInsufficientLiquidity),
+		errors.Is(err, services.ErrInsufficientBalance), services.IsInvalidOrder(err):
+	default:
+		log.Printf("Could not convert: %v", err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "conversion could not be recorded"})
+		return
+	}
+	RespondWithError(writer, status, map[string]interface{}{"msg": err.Error()})
+}
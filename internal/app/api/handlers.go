@@ -1,11 +1,15 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
 	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -13,6 +17,10 @@ import (
 // CryptoExchangeHandler handles incoming HTTP requests for the cryptoexchange feature.
 type CryptoExchangeHandler struct {
 	Service *services.CryptoExchangeService
+	// AdminToken guards the admin endpoints, which are disabled when it is empty.
+	AdminToken string
+	// L3 serves the order-by-order feed, which is disabled when it is nil.
+	L3 *services.L3Feed
 }
 
 func NewCryptoExchangeHandler(service *services.CryptoExchangeService) *CryptoExchangeHandler {
@@ -47,6 +55,15 @@ type TradeRequest struct {
 	Price     services.Money  `json:"price"`
 	Size      services.Money  `json:"size"`
 	Market    services.Market `json:"market"`
+	// ExpiresAt makes a limit order good-till-time. It is good-till-cancelled when omitted.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// ProtectionPrice and MaxSlippageBps bound the prices a market order fills at. Remainder is
+	// "cancel" (the default) or "rest", for the part the bound leaves unfilled.
+	ProtectionPrice services.Money           `json:"protectionPrice,omitempty"`
+	MaxSlippageBps  float64                  `json:"maxSlippageBps,omitempty"`
+	Remainder       services.RemainderAction `json:"remainder,omitempty"`
+	// Notional sizes a market order in quote currency instead of Size.
+	Notional services.Money `json:"notional,omitempty"`
 }
 
 // TradeResponse represents the JSON response for a trade.
@@ -60,7 +77,10 @@ func (exh *CryptoExchangeHandler) Trade(writer http.ResponseWriter, request *htt
 	// Print the raw dataForTrade body and headers for debugging purposes.
 	body, _ := io.ReadAll(request.Body)
 	log.Printf("Received dataForTrade body: %s", body)
-	log.Printf("Received dataForTrade headers: %v", request.Header)
+	headers := request.Header.Clone()
+	headers.Del("Authorization")
+	log.Printf("Received dataForTrade headers: %v", headers)
+	request.Body = io.NopCloser(bytes.NewReader(body))
 
 	// Parse the incoming JSON dataForTrade. ✅
 	var dataForTrade TradeRequest
@@ -70,14 +90,52 @@ func (exh *CryptoExchangeHandler) Trade(writer http.ResponseWriter, request *htt
 	}
 
 	market := services.Market(dataForTrade.Market)
-	orderBook := exh.Service.OrderBooks[market]
-
 	placedOrder := services.NewOrder(dataForTrade.Bid, dataForTrade.Size)
+	if dataForTrade.ExpiresAt != nil {
+		placedOrder.ExpiresAt = dataForTrade.ExpiresAt.UnixNano()
+	}
+	if user, ok := middlewares.UserFromContext(request.Context()); ok {
+		placedOrder.UserID = user.ID
+	}
 
-	orderBook.PlaceLimitOrder(dataForTrade.Price, placedOrder)
+	var (
+		matches []services.MatchEngine
+		err     error
+	)
+	if dataForTrade.OrderType == MarketOrder {
+		placedOrder.Protection = services.Protection{
+			Price:          dataForTrade.ProtectionPrice,
+			MaxSlippageBps: dataForTrade.MaxSlippageBps,
+			Remainder:      dataForTrade.Remainder,
+		}
+		placedOrder.Notional = dataForTrade.Notional
+		matches, err = exh.Service.PlaceMarketOrder(request.Context(), market, placedOrder)
+	} else {
+		err = exh.Service.PlaceLimitOrder(request.Context(), market, dataForTrade.Price, placedOrder)
+	}
+	switch {
+	case errors.Is(err, services.ErrMarketNotFound), errors.Is(err, services.ErrInsufficientLiquidity),
+		errors.Is(err, services.ErrInsufficientBalance), services.IsInvalidOrder(err):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case errors.Is(err, services.ErrMarketHalted), errors.Is(err, services.ErrMarketCancelOnly):
+		RespondWithError(writer, http.StatusServiceUnavailable, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not record order %s: %v", placedOrder.ID, err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "order could not be recorded"})
+		return
+	}
 
 	// write the JSON response.
-	response := map[string]interface{}{"msg": "order placed"}
+	response := map[string]interface{}{"msg": "order placed", "orderId": placedOrder.ID, "status": placedOrder.Status()}
+	if dataForTrade.OrderType == MarketOrder {
+		response["filledSize"] = placedOrder.InitialSize - placedOrder.Size
+		response["averagePrice"] = services.AveragePrice(matches)
+		if placedOrder.Notional != 0 {
+			response["unspentNotional"] = services.Unspent(placedOrder, matches)
+		}
+	}
 	RespondWithJSON(writer, http.StatusOK, response)
 
 	//// Validate the dataForTrade data (e.g., check if required fields are present).
@@ -87,7 +145,8 @@ func (exh *CryptoExchangeHandler) Trade(writer http.ResponseWriter, request *htt
 	//}
 }
 
-func (exh *CryptoExchangeHandler) GetBook(writer http.ResponseWriter, request *http.Request) error {
+// GetBook responds with the resting orders of the requested market.
+func (exh *CryptoExchangeHandler) GetBook(writer http.ResponseWriter, request *http.Request) {
 	// Retrieve the market parameter from the request URL
 	vars := mux.Vars(request)
 	market := services.Market(vars["market"])
@@ -98,6 +157,7 @@ func (exh *CryptoExchangeHandler) GetBook(writer http.ResponseWriter, request *h
 		// If market not found, return an error response
 		response := map[string]interface{}{"msg": "market not found"}
 		RespondWithError(writer, http.StatusBadRequest, response)
+		return
 	}
 
 	dataFromOrderBook := OrderBookData{
@@ -105,21 +165,22 @@ func (exh *CryptoExchangeHandler) GetBook(writer http.ResponseWriter, request *h
 		[]*Order{},
 	}
 	// Loop through the asks in the order book
-	for _, limit := range bookOfOrders.Asks {
-		for _, order := range limit.Orders {
-			// Process the order as needed
-			o := Order{
-				Price:     order.Limit.Price,
-				Size:      order.Size,
-				Bid:       order.Bid,
-				Timestamp: order.TimeStamp,
+	bookOfOrders.Exclusive(func() {
+		for _, limit := range bookOfOrders.Asks {
+			for _, order := range limit.Orders {
+				// Process the order as needed
+				o := Order{
+					Price:     order.Limit.Price,
+					Size:      order.Size,
+					Bid:       order.Bid,
+					Timestamp: order.TimeStamp,
+				}
+				dataFromOrderBook.Asks = append(dataFromOrderBook.Asks, &o)
+				log.Printf("Order: %s", order.OrderString())
 			}
-			dataFromOrderBook.Asks = append(dataFromOrderBook.Asks, &o)
-			log.Printf("Order: %.2f", order)
 		}
-	}
+	})
 	RespondWithJSON(writer, http.StatusOK, dataFromOrderBook)
-	return nil
 }
 
 // RespondWithJSON is a utility function to respond with a JSON syntax.
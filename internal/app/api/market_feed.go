@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+const (
+	// feedBuffer is how many events a market feed client may fall behind before it is disconnected.
+	feedBuffer = 256
+	// FeedL3Snapshot is the first message of the L3 feed, the snapshot its messages follow.
+	FeedL3Snapshot = "l3.snapshot"
+)
+
+// FeedMessage is one event of a market feed.
+type FeedMessage struct {
+	Type  string      `json:"type"`
+	Event interface{} `json:"event"`
+}
+
+// PublicTrade is a trade without the orders and accounts behind it.
+type PublicTrade struct {
+	services.EventHeader
+	TradeID  string         `json:"tradeId"`
+	Price    services.Money `json:"price"`
+	Size     services.Money `json:"size"`
+	TakerBid bool           `json:"takerBid"`
+}
+
+// publicEvent returns what the market feed shows of an event, and whether it shows it at all.
+func publicEvent(event messaging.Event) (interface{}, bool) {
+	switch ev := event.(type) {
+	case services.TradeExecuted:
+		return PublicTrade{EventHeader: ev.EventHeader, TradeID: ev.TradeID, Price: ev.Price, Size: ev.Size, TakerBid: ev.TakerBid}, true
+	case services.LevelChanged, services.MarketStateChanged, services.IndicativePriceChanged:
+		return ev, true
+	}
+	return nil, false
+}
+
+// MarketFeed returns the public feed of the market named in the path, unless it is not listed.
+func (exh *CryptoExchangeHandler) MarketFeed() http.HandlerFunc {
+	feed := MarketFeed(exh.Service.Events)
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if _, ok := exh.Service.OrderBooks[services.Market(mux.Vars(request)["market"])]; !ok {
+			RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+			return
+		}
+		feed(writer, request)
+	}
+}
+
+// L3Feed streams every change to the market's resting orders over WebSocket. The first message
+// is an L3 snapshot, and the messages after it follow on from its sequence number. A client that
+// sees a gap, or is disconnected for falling behind, reconnects for a new snapshot.
+func (exh *CryptoExchangeHandler) L3Feed(writer http.ResponseWriter, request *http.Request) {
+	if exh.L3 == nil {
+		RespondWithError(writer, http.StatusServiceUnavailable, map[string]interface{}{"msg": "l3 feed is not enabled"})
+		return
+	}
+	market := services.Market(mux.Vars(request)["market"])
+	// Subscribe before taking the snapshot, so no message falls between the two.
+	subscriber := exh.L3.Messages.Subscribe("l3:"+string(market), feedBuffer, messaging.Disconnect, func(event messaging.Event) bool {
+		return event.(services.L3Message).Market == market
+	})
+	defer exh.L3.Messages.Unsubscribe(subscriber)
+	snapshot, err := exh.L3.Snapshot(market)
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+		return
+	}
+	initial := []FeedMessage{{Type: FeedL3Snapshot, Event: snapshot}}
+	streamFeed(writer, request, subscriber, initial, func(event messaging.Event) (FeedMessage, bool) {
+		message := event.(services.L3Message)
+		return FeedMessage{Type: message.EventType(), Event: message}, message.Sequence > snapshot.Sequence
+	})
+}
+
+// GetL3Book returns every resting order of the market, by anonymized ID, as of an L3 sequence number.
+func (exh *CryptoExchangeHandler) GetL3Book(writer http.ResponseWriter, request *http.Request) {
+	if exh.L3 == nil {
+		RespondWithError(writer, http.StatusServiceUnavailable, map[string]interface{}{"msg": "l3 feed is not enabled"})
+		return
+	}
+	snapshot, err := exh.L3.Snapshot(services.Market(mux.Vars(request)["market"]))
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, snapshot)
+}
+
+// MarketFeed streams the public events published on the bus for the market named in the path
+// over WebSocket: trades, level changes, state changes and indicative auction prices. Sequence
+// numbers keep growing but skip the private order events. A client that falls behind is
+// disconnected, and resyncs from GET /book/{market}.
+func MarketFeed(events *messaging.Dispatcher) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		market := services.Market(mux.Vars(request)["market"])
+		subscriber := events.Subscribe("feed:"+string(market), feedBuffer, messaging.Disconnect, func(event messaging.Event) bool {
+			marketEvent, ok := event.(services.MarketEvent)
+			if !ok || marketEvent.Header().Market != market {
+				return false
+			}
+			_, public := publicEvent(event)
+			return public
+		})
+		defer events.Unsubscribe(subscriber)
+		streamFeed(writer, request, subscriber, nil, func(event messaging.Event) (FeedMessage, bool) {
+			message, _ := publicEvent(event)
+			return FeedMessage{Type: event.EventType(), Event: message}, true
+		})
+	}
+}
+
+// streamFeed upgrades the request and writes the initial messages, then the subscriber's events
+// that message keeps, until the client goes away or the subscriber is cut off.
+func streamFeed(writer http.ResponseWriter, request *http.Request, subscriber *messaging.Subscriber, initial []FeedMessage, message func(messaging.Event) (FeedMessage, bool)) {
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The feed takes no commands; reading only notices the client going away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, m := range initial {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(m); err != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"), time.Now().Add(wsWriteTimeout))
+				return
+			}
+			m, keep := message(event)
+			if !keep {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
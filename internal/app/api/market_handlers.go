@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// defaultCandleCount is how many candles are returned when the request gives no range.
+const defaultCandleCount = 500
+
+// GetCandles responds with the OHLCV candles of a market.
+// Query parameters: interval (default 1m), from and to as unix seconds or RFC 3339.
+func (exh *CryptoExchangeHandler) GetCandles(writer http.ResponseWriter, request *http.Request) {
+	market := services.Market(mux.Vars(request)["market"])
+	if _, ok := exh.Service.OrderBooks[market]; !ok {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+		return
+	}
+
+	query := request.URL.Query()
+	interval := services.CandleInterval(query.Get("interval"))
+	if interval == "" {
+		interval = services.Interval1m
+	}
+	duration, err := interval.Duration()
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+
+	to, err := parseTimeParam(query.Get("to"), exh.Service.Clock.Now())
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid to: " + err.Error()})
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-defaultCandleCount*duration))
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid from: " + err.Error()})
+		return
+	}
+
+	candles, err := exh.Service.Candles.Candles(request.Context(), market, interval, from, to)
+	switch {
+	case errors.Is(err, services.ErrTooManyCandles):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, candles)
+}
+
+// parseTimeParam reads a time given as unix seconds or RFC 3339, falling back to a default when empty.
+func parseTimeParam(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetTicker responds with the rolling 24h statistics and top of book of a market.
+func (exh *CryptoExchangeHandler) GetTicker(writer http.ResponseWriter, request *http.Request) {
+	market := services.Market(mux.Vars(request)["market"])
+	orderBook, ok := exh.Service.OrderBooks[market]
+	if !ok {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, exh.Service.Tickers.Ticker(market, orderBook, exh.Service.Clock.Now()))
+}
+
+// GetTickers responds with the tickers of every market, sorted by market.
+func (exh *CryptoExchangeHandler) GetTickers(writer http.ResponseWriter, request *http.Request) {
+	now := exh.Service.Clock.Now()
+	tickers := []services.Ticker{}
+	for market, orderBook := range exh.Service.OrderBooks {
+		tickers = append(tickers, exh.Service.Tickers.Ticker(market, orderBook, now))
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Market < tickers[j].Market })
+	RespondWithJSON(writer, http.StatusOK, tickers)
+}
+
+// MarketResponse describes a market, its state and the rules its orders must follow.
+type MarketResponse struct {
+	Market services.Market       `json:"market"`
+	Status services.MarketStatus `json:"status"`
+	Config services.MarketConfig `json:"config"`
+	// Indicative is the price and volume the ongoing auction would uncross at.
+	Indicative *services.AuctionResult `json:"indicative,omitempty"`
+}
+
+// GetMarket responds with the state and trading rules of a market.
+func (exh *CryptoExchangeHandler) GetMarket(writer http.ResponseWriter, request *http.Request) {
+	market := services.Market(mux.Vars(request)["market"])
+	orderBook, ok := exh.Service.OrderBooks[market]
+	if !ok {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+		return
+	}
+	response := MarketResponse{Market: market, Config: orderBook.Config}
+	orderBook.Exclusive(func() {
+		response.Status = orderBook.Status()
+		if response.Status.State == services.MarketAuction {
+			indicative := orderBook.Indicative()
+			response.Indicative = &indicative
+		}
+	})
+	RespondWithJSON(writer, http.StatusOK, response)
+}
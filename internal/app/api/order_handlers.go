@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+const (
+	defaultOrdersPageSize = 50
+	maxOrdersPageSize     = 500
+)
+
+// OrdersPage is one page of an order listing.
+type OrdersPage struct {
+	Orders []services.OrderState `json:"orders"`
+	// NextCursor is passed as the "after" query parameter to get the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// GetOrder responds with the status and fills of one of the user's orders.
+func (exh *CryptoExchangeHandler) GetOrder(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	state, ok := exh.Service.Orders.Get(mux.Vars(request)["id"])
+	// Someone else's order is reported as missing, so order IDs can't be probed.
+	if !ok || state.UserID != user.ID {
+		RespondWithError(writer, http.StatusNotFound, map[string]interface{}{"msg": "order not found"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, state)
+}
+
+// ListOrders responds with a page of the user's orders, newest first.
+// Query parameters: status ("open", "closed" or a single status), market, limit and after.
+func (exh *CryptoExchangeHandler) ListOrders(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	query := request.URL.Query()
+
+	limit := defaultOrdersPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxOrdersPageSize {
+			RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "limit must be between 1 and 500"})
+			return
+		}
+		limit = parsed
+	}
+
+	filter := services.OrderFilter{
+		Status: query.Get("status"),
+		Market: services.Market(query.Get("market")),
+	}
+	orders, next, err := exh.Service.Orders.List(user.ID, filter, query.Get("after"), limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, OrdersPage{Orders: orders, NextCursor: next})
+}
+
+// CancelledOrders lists the IDs of the orders pulled by a cancel.
+type CancelledOrders struct {
+	Cancelled []string `json:"cancelled"`
+}
+
+// CancelOrder cancels one of the user's resting orders.
+func (exh *CryptoExchangeHandler) CancelOrder(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	id := mux.Vars(request)["id"]
+	err := exh.Service.CancelUserOrder(request.Context(), user.ID, id)
+	switch {
+	case errors.Is(err, services.ErrOrderNotResting):
+		RespondWithError(writer, http.StatusNotFound, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not record cancel of order %s: %v", id, err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "cancel could not be recorded"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, CancelledOrders{Cancelled: []string{id}})
+}
+
+// CancelAllOrders cancels every resting order of the user, optionally narrowed by the market and side query parameters.
+func (exh *CryptoExchangeHandler) CancelAllOrders(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	query := request.URL.Query()
+	filter := services.CancelFilter{
+		UserID: user.ID,
+		Market: services.Market(query.Get("market")),
+		Side:   services.Side(query.Get("side")),
+	}
+	cancelled, status, err := exh.cancelAll(request.Context(), filter)
+	if err != nil {
+		RespondWithError(writer, status, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, CancelledOrders{Cancelled: cancelled})
+}
+
+// cancelAll runs a mass cancel for the REST and WebSocket APIs, mapping failures to an HTTP status.
+func (exh *CryptoExchangeHandler) cancelAll(ctx context.Context, filter services.CancelFilter) ([]string, int, error) {
+	if !filter.Side.Valid() {
+		return nil, http.StatusBadRequest, errors.New("side must be bid or ask")
+	}
+	cancelled, err := exh.Service.CancelAll(ctx, filter, services.CancelReasonMassCancel)
+	switch {
+	case errors.Is(err, services.ErrMarketNotFound):
+		return nil, http.StatusBadRequest, err
+	case err != nil:
+		log.Printf("Could not record mass cancel for user %s: %v", filter.UserID, err)
+		return nil, http.StatusInternalServerError, errors.New("cancel could not be recorded")
+	}
+	return cancelled, http.StatusOK, nil
+}
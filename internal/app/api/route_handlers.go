@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// RouteRequest is the JSON body of an order routed across the markets trading a pair.
+type RouteRequest struct {
+	Base  services.Asset `json:"base"`
+	Quote services.Asset `json:"quote"`
+	Bid   bool           `json:"bid"`
+	Size  services.Money `json:"size"`
+	// DryRun only reports how the order would be split.
+	DryRun bool `json:"dryRun"`
+}
+
+// RouteOrder buys or sells a pair across its own market and the synthetic route through USD,
+// and responds with the part traded along each path and its effective price.
+func (exh *CryptoExchangeHandler) RouteOrder(writer http.ResponseWriter, request *http.Request) {
+	var body RouteRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	user, _ := middlewares.UserFromContext(request.Context())
+	order, err := exh.Service.Router.Route(request.Context(), user.ID, body.Base, body.Quote, body.Bid, body.Size, body.DryRun)
+	switch {
+	case errors.Is(err, services.ErrNoRoute), errors.Is(err, services.ErrSameAsset),
+		errors.Is(err, services.ErrInsufficientLiquidity), errors.Is(err, services.ErrInsufficientBalance), services.IsInvalidOrder(err):
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	case errors.Is(err, services.ErrMarketHalted), errors.Is(err, services.ErrMarketCancelOnly), errors.Is(err, services.ErrMarketInAuction):
+		RespondWithError(writer, http.StatusServiceUnavailable, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Could not route order: %v", err)
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": "order could not be recorded"})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, order)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// NewRouter registers the cryptoexchange endpoints, each group behind its own rate limit.
+func NewRouter(exh *CryptoExchangeHandler, limiter *middlewares.RateLimiter) *mux.Router {
+	router := mux.NewRouter()
+	requireSession := middlewares.RequireSession(exh.Service.Users)
+
+	// Order placement.
+	orders := router.PathPrefix("/cryptoexchange").Subrouter()
+	orders.Use(limiter.Middleware(middlewares.ClassOrders), requireSession)
+	orders.HandleFunc("/trade", exh.Trade).Methods(http.MethodPost)
+	orders.HandleFunc("/batch", exh.Batch).Methods(http.MethodPost)
+	orders.HandleFunc("/route", exh.RouteOrder).Methods(http.MethodPost)
+
+	// Conversions between assets.
+	convert := router.PathPrefix("/convert").Subrouter()
+	convert.Use(limiter.Middleware(middlewares.ClassOrders), requireSession)
+	convert.HandleFunc("/quote", exh.QuoteConvert).Methods(http.MethodPost)
+	convert.HandleFunc("/quote/{id}/accept", exh.AcceptConvert).Methods(http.MethodPost)
+
+	// Order cancels.
+	cancels := router.PathPrefix("/orders").Methods(http.MethodDelete).Subrouter()
+	cancels.Use(limiter.Middleware(middlewares.ClassCancels), requireSession)
+	cancels.HandleFunc("", exh.CancelAllOrders)
+	cancels.HandleFunc("/{id}", exh.CancelOrder)
+
+	// Order queries.
+	orderQueries := router.PathPrefix("/orders").Subrouter()
+	orderQueries.Use(limiter.Middleware(middlewares.ClassMarketData), requireSession)
+	orderQueries.HandleFunc("", exh.ListOrders).Methods(http.MethodGet)
+	orderQueries.HandleFunc("/{id}", exh.GetOrder).Methods(http.MethodGet)
+
+	// Market data.
+	marketData := router.PathPrefix("/book").Subrouter()
+	marketData.Use(limiter.Middleware(middlewares.ClassMarketData))
+	marketData.HandleFunc("/{market}", exh.GetBook).Methods(http.MethodGet)
+	marketData.HandleFunc("/{market}/l3", exh.GetL3Book).Methods(http.MethodGet)
+
+	markets := router.PathPrefix("/markets").Subrouter()
+	markets.Use(limiter.Middleware(middlewares.ClassMarketData))
+	markets.HandleFunc("/tickers", exh.GetTickers).Methods(http.MethodGet)
+	markets.HandleFunc("/{market}", exh.GetMarket).Methods(http.MethodGet)
+	markets.HandleFunc("/{market}/candles", exh.GetCandles).Methods(http.MethodGet)
+	markets.HandleFunc("/{market}/ticker", exh.GetTicker).Methods(http.MethodGet)
+
+	// Registration and login.
+	auth := router.PathPrefix("/users").Subrouter()
+	auth.Use(limiter.Middleware(middlewares.ClassAuth))
+	auth.HandleFunc("/register", exh.Register).Methods(http.MethodPost)
+	auth.HandleFunc("/login", exh.Login).Methods(http.MethodPost)
+
+	// Account management for logged in users.
+	account := router.PathPrefix("/account").Subrouter()
+	account.Use(limiter.Middleware(middlewares.ClassMarketData), requireSession)
+	account.HandleFunc("", exh.Me).Methods(http.MethodGet)
+	account.HandleFunc("/logout", exh.Logout).Methods(http.MethodPost)
+	account.HandleFunc("/sessions", exh.RevokeAllSessions).Methods(http.MethodDelete)
+	account.HandleFunc("/2fa/enroll", exh.EnrollTOTP).Methods(http.MethodPost)
+	account.HandleFunc("/2fa/verify", exh.VerifyTOTP).Methods(http.MethodPost)
+	account.HandleFunc("/dead-mans-switch", exh.ArmSwitch).Methods(http.MethodPost)
+	account.HandleFunc("/dead-mans-switch", exh.DisarmSwitch).Methods(http.MethodDelete)
+	account.HandleFunc("/dead-mans-switch/heartbeat", exh.SwitchHeartbeat).Methods(http.MethodPost)
+
+	// Market and account administration.
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(middlewares.RequireAdmin(exh.AdminToken))
+	admin.HandleFunc("/markets/{market}/state", exh.SetMarketState).Methods(http.MethodPut)
+	admin.HandleFunc("/users/{user}/deposits", exh.Deposit).Methods(http.MethodPost)
+
+	// Trading over WebSocket. The handler authenticates the upgrade itself.
+	router.Handle("/ws", limiter.Middleware(middlewares.ClassMarketData)(exh.WebSocket(limiter))).Methods(http.MethodGet)
+	// Public market data over WebSocket.
+	router.Handle("/ws/markets/{market}", limiter.Middleware(middlewares.ClassMarketData)(exh.MarketFeed())).Methods(http.MethodGet)
+	router.Handle("/ws/markets/{market}/l3", limiter.Middleware(middlewares.ClassMarketData)(http.HandlerFunc(exh.L3Feed))).Methods(http.MethodGet)
+
+	return router
+}
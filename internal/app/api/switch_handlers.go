@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// ArmSwitchRequest is the JSON body arming a dead man's switch.
+type ArmSwitchRequest struct {
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+// SwitchResponse reports the state of the session's dead man's switch.
+type SwitchResponse struct {
+	Armed    bool       `json:"armed"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// ArmSwitch arms the session's dead man's switch, or changes its timeout. Unless a heartbeat
+// arrives before the deadline, every open order of the account is cancelled.
+func (exh *CryptoExchangeHandler) ArmSwitch(writer http.ResponseWriter, request *http.Request) {
+	var body ArmSwitchRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	session, _ := middlewares.SessionFromContext(request.Context())
+	deadline, err := exh.Service.Switches.Arm(session.ID, session.UserID, time.Duration(body.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, SwitchResponse{Armed: true, Deadline: &deadline})
+}
+
+// SwitchHeartbeat pushes back the deadline of the session's dead man's switch.
+func (exh *CryptoExchangeHandler) SwitchHeartbeat(writer http.ResponseWriter, request *http.Request) {
+	session, _ := middlewares.SessionFromContext(request.Context())
+	deadline, err := exh.Service.Switches.Heartbeat(session.ID)
+	if errors.Is(err, services.ErrSwitchNotArmed) {
+		RespondWithError(writer, http.StatusConflict, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, SwitchResponse{Armed: true, Deadline: &deadline})
+}
+
+// DisarmSwitch stops the session's dead man's switch without cancelling anything.
+func (exh *CryptoExchangeHandler) DisarmSwitch(writer http.ResponseWriter, request *http.Request) {
+	session, _ := middlewares.SessionFromContext(request.Context())
+	exh.Service.Switches.Disarm(session.ID)
+	RespondWithJSON(writer, http.StatusOK, SwitchResponse{Armed: false})
+}
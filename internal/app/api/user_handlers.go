@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+// CredentialsRequest is the JSON body for registration and login.
+type CredentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	TOTPCode string `json:"totpCode,omitempty"`
+}
+
+// LoginResponse carries the session token returned by a successful login.
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// UserResponse is the public view of an account.
+type UserResponse struct {
+	ID               string                            `json:"id"`
+	Email            string                            `json:"email"`
+	TwoFactorEnabled bool                              `json:"twoFactorEnabled"`
+	Balances         map[services.Asset]services.Money `json:"balances"`
+	OpenOrders       []*Order                          `json:"openOrders"`
+}
+
+// TOTPEnrollmentResponse holds what an authenticator app needs to start generating codes.
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// TOTPVerifyRequest is the JSON body confirming a 2FA enrollment.
+type TOTPVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+// Register creates a new user account.
+func (exh *CryptoExchangeHandler) Register(writer http.ResponseWriter, request *http.Request) {
+	var credentials CredentialsRequest
+	if err := json.NewDecoder(request.Body).Decode(&credentials); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+
+	user, err := exh.Service.Users.Register(credentials.Email, credentials.Password)
+	switch {
+	case errors.Is(err, services.ErrEmailTaken):
+		RespondWithError(writer, http.StatusConflict, map[string]interface{}{"msg": err.Error()})
+		return
+	case err != nil:
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusCreated, exh.userResponse(user))
+}
+
+// Login opens a session and returns its token.
+func (exh *CryptoExchangeHandler) Login(writer http.ResponseWriter, request *http.Request) {
+	var credentials CredentialsRequest
+	if err := json.NewDecoder(request.Body).Decode(&credentials); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+
+	token, session, err := exh.Service.Users.Login(credentials.Email, credentials.Password, credentials.TOTPCode)
+	if err != nil {
+		RespondWithError(writer, http.StatusUnauthorized, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, LoginResponse{Token: token, ExpiresAt: session.ExpiresAt})
+}
+
+// Logout revokes the session used for the request.
+func (exh *CryptoExchangeHandler) Logout(writer http.ResponseWriter, request *http.Request) {
+	if err := exh.Service.Users.RevokeSession(middlewares.BearerToken(request)); err != nil {
+		RespondWithError(writer, http.StatusUnauthorized, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, map[string]interface{}{"msg": "logged out"})
+}
+
+// RevokeAllSessions logs the user out of every session, including the current one.
+func (exh *CryptoExchangeHandler) RevokeAllSessions(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	revoked := exh.Service.Users.RevokeAllSessions(user.ID)
+	RespondWithJSON(writer, http.StatusOK, map[string]interface{}{"revoked": revoked})
+}
+
+// Me responds with the authenticated user's account, balances and open orders.
+func (exh *CryptoExchangeHandler) Me(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	RespondWithJSON(writer, http.StatusOK, exh.userResponse(user))
+}
+
+// EnrollTOTP starts two-factor enrollment for the authenticated user.
+func (exh *CryptoExchangeHandler) EnrollTOTP(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	secret, uri, err := exh.Service.Users.EnrollTOTP(user.ID)
+	if err != nil {
+		RespondWithError(writer, http.StatusInternalServerError, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, TOTPEnrollmentResponse{Secret: secret, ProvisioningURI: uri})
+}
+
+// VerifyTOTP confirms two-factor enrollment with a first code.
+func (exh *CryptoExchangeHandler) VerifyTOTP(writer http.ResponseWriter, request *http.Request) {
+	user, _ := middlewares.UserFromContext(request.Context())
+	var verify TOTPVerifyRequest
+	if err := json.NewDecoder(request.Body).Decode(&verify); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": "invalid request body"})
+		return
+	}
+	if err := exh.Service.Users.ConfirmTOTP(user.ID, verify.Code); err != nil {
+		RespondWithError(writer, http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		return
+	}
+	RespondWithJSON(writer, http.StatusOK, map[string]interface{}{"msg": "two-factor authentication enabled"})
+}
+
+func (exh *CryptoExchangeHandler) userResponse(user *services.User) UserResponse {
+	response := UserResponse{
+		ID:               user.ID,
+		Email:            user.Email,
+		TwoFactorEnabled: user.TwoFactorEnabled(),
+		Balances:         user.Wallet.Balances(),
+		OpenOrders:       []*Order{},
+	}
+	for _, book := range exh.Service.OrderBooks {
+		book.Exclusive(func() {
+			for _, order := range book.OrdersByUser(user.ID) {
+				response.OpenOrders = append(response.OpenOrders, &Order{
+					Price:     order.Limit.Price,
+					Size:      order.Size,
+					Bid:       order.Bid,
+					Timestamp: order.TimeStamp,
+				})
+			}
+		})
+	}
+	return response
+}
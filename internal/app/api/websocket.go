@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+const (
+	// Commands a WebSocket client can send.
+	OpPing      = "ping"
+	OpCancelAll = "cancel_all"
+	// OpArm arms the connection's dead man's switch, OpHeartbeat feeds it and OpDisarm stops it.
+	// Closing the connection while the switch is armed fires it at once.
+	OpArm       = "arm"
+	OpHeartbeat = "heartbeat"
+	OpDisarm    = "disarm"
+
+	// OpDeadMansSwitch is pushed to the client when its switch fires.
+	OpDeadMansSwitch = "dead_mans_switch"
+
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsSendBuffer   = 64
+)
+
+// WSCommand is a message sent by a WebSocket client.
+type WSCommand struct {
+	// ID is echoed back in the response so clients can pair them up.
+	ID     string          `json:"id,omitempty"`
+	Op     string          `json:"op"`
+	Market services.Market `json:"market,omitempty"`
+	Side   services.Side   `json:"side,omitempty"`
+	// TimeoutMs is the dead man's switch timeout of an arm command.
+	TimeoutMs int64 `json:"timeoutMs,omitempty"`
+}
+
+// WSResponse answers a WSCommand.
+type WSResponse struct {
+	ID        string     `json:"id,omitempty"`
+	Op        string     `json:"op"`
+	Cancelled []string   `json:"cancelled,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsSession is one authenticated WebSocket connection. Only the write loop writes to the connection.
+type wsSession struct {
+	// id names the connection to the dead man's switch.
+	id   string
+	conn *websocket.Conn
+	user *services.User
+	send chan interface{}
+	done chan struct{}
+}
+
+// WebSocket upgrades the request to a WebSocket carrying trading commands for the logged in user.
+// Browsers can't set headers on the upgrade, so the session token may also be passed as the "token" query parameter.
+// Commands share the rate limits of their REST counterparts.
+func (exh *CryptoExchangeHandler) WebSocket(limiter *middlewares.RateLimiter) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		token := middlewares.BearerToken(request)
+		if token == "" {
+			token = request.URL.Query().Get("token")
+		}
+		user, _, err := exh.Service.Users.Authenticate(token)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			// The upgrader has already responded.
+			return
+		}
+		session := &wsSession{
+			id:   "ws-" + services.NewID(),
+			conn: conn,
+			user: user,
+			send: make(chan interface{}, wsSendBuffer),
+			done: make(chan struct{}),
+		}
+		go session.writeLoop()
+		// Subscribe before reading commands, so a switch armed by the first one can't fire unheard.
+		switchEvents := exh.Service.Events.Subscribe(session.id, 4, messaging.DropNewest, func(event messaging.Event) bool {
+			triggered, ok := event.(services.DeadMansSwitchTriggered)
+			return ok && triggered.SessionID == session.id
+		})
+		go session.forwardSwitchEvents(exh.Service.Events, switchEvents)
+		defer func() {
+			close(session.done)
+			if exh.Service.Switches.Armed(session.id) {
+				if _, err := exh.Service.Switches.Trigger(context.Background(), session.id); err != nil {
+					log.Printf("Dead man's switch of session %s failed: %v", session.id, err)
+				}
+			}
+		}()
+
+		apiKey, ip := request.Header.Get(middlewares.APIKeyHeader), limiter.ClientIP(request)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var command WSCommand
+			if err := json.Unmarshal(message, &command); err != nil {
+				session.reply(WSResponse{Error: "invalid command"})
+				continue
+			}
+			response := WSResponse{ID: command.ID, Op: command.Op}
+
+			switch command.Op {
+			case OpPing:
+			case OpCancelAll:
+				if !limiter.Allow(middlewares.ClassCancels, apiKey, ip).Allowed {
+					response.Error = "rate limit exceeded"
+					break
+				}
+				filter := services.CancelFilter{UserID: user.ID, Market: command.Market, Side: command.Side}
+				cancelled, _, err := exh.cancelAll(request.Context(), filter)
+				if err != nil {
+					response.Error = err.Error()
+					break
+				}
+				response.Cancelled = cancelled
+			case OpArm:
+				deadline, err := exh.Service.Switches.Arm(session.id, user.ID, time.Duration(command.TimeoutMs)*time.Millisecond)
+				if err != nil {
+					response.Error = err.Error()
+					break
+				}
+				response.Deadline = &deadline
+			case OpHeartbeat:
+				deadline, err := exh.Service.Switches.Heartbeat(session.id)
+				if err != nil {
+					response.Error = err.Error()
+					break
+				}
+				response.Deadline = &deadline
+			case OpDisarm:
+				exh.Service.Switches.Disarm(session.id)
+			default:
+				response.Error = "unknown op"
+			}
+			session.reply(response)
+		}
+	}
+}
+
+// forwardSwitchEvents tells the client when its dead man's switch fires.
+func (ws *wsSession) forwardSwitchEvents(events *messaging.Dispatcher, subscriber *messaging.Subscriber) {
+	defer events.Unsubscribe(subscriber)
+	for {
+		select {
+		case <-ws.done:
+			return
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				return
+			}
+			triggered := event.(services.DeadMansSwitchTriggered)
+			ws.reply(WSResponse{Op: OpDeadMansSwitch, Cancelled: triggered.Cancelled})
+		}
+	}
+}
+
+// reply queues a message for the write loop, dropping it once the connection is gone.
+func (ws *wsSession) reply(message interface{}) {
+	select {
+	case ws.send <- message:
+	case <-ws.done:
+	}
+}
+
+// writeLoop writes the queued messages and keeps the connection alive with pings.
+func (ws *wsSession) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		ws.conn.Close()
+	}()
+	for {
+		select {
+		case <-ws.done:
+			ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+			return
+		case message := <-ws.send:
+			ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := ws.conn.WriteJSON(message); err != nil {
+				log.Printf("WebSocket write to user %s failed: %v", ws.user.ID, err)
+				return
+			}
+		case <-ticker.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package fix
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+const (
+	// logonTimeout is how long a new connection has to send its Logon.
+	logonTimeout = 10 * time.Second
+	// logoutTimeout is how long sessions have to answer the Logout sent as the acceptor stops.
+	logoutTimeout = 2 * time.Second
+)
+
+var ErrSessionExists = errors.New("a session with this CompID already exists")
+
+// Acceptor listens for FIX connections and runs a session for each configured counterparty.
+type Acceptor struct {
+	// CompID is the gateway's own CompID, the TargetCompID of the messages it accepts.
+	CompID   string
+	Exchange *services.CryptoExchangeService
+
+	mu       sync.Mutex
+	sessions map[string]*Session // by the counterparty's CompID.
+	listener net.Listener
+	conns    sync.WaitGroup
+}
+
+func NewAcceptor(compID string, exchange *services.CryptoExchangeService) *Acceptor {
+	return &Acceptor{CompID: compID, Exchange: exchange, sessions: make(map[string]*Session)}
+}
+
+// AddSession lets a counterparty log on, keeping its sequence numbers and messages in store.
+// The session's orders are rebuilt from the execution reports already in store. Sessions are
+// added before Serve.
+func (a *Acceptor) AddSession(config SessionConfig, store MessageStore) (*Session, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sessions[config.CompID]; ok {
+		return nil, ErrSessionExists
+	}
+	s := &Session{
+		acceptor: a,
+		config:   config,
+		store:    store,
+		orders:   orders{byID: make(map[string]*order), clOrdIDs: make(map[string]string)},
+	}
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+	a.sessions[config.CompID] = s
+	return s, nil
+}
+
+// Listen opens the acceptor's TCP listener, so Addr is known before Serve.
+func (a *Acceptor) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.listener = listener
+	a.mu.Unlock()
+	return nil
+}
+
+// Addr returns the address the acceptor listens on, nil before Listen.
+func (a *Acceptor) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+// Serve accepts connections until ctx is done, then logs every session out and waits briefly
+// for them to answer before closing their connections. Execution reports are generated for as
+// long as Serve runs, whether or not their session is logged on.
+func (a *Acceptor) Serve(ctx context.Context) error {
+	a.mu.Lock()
+	listener := a.listener
+	sessions := make([]*Session, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		sessions = append(sessions, s)
+	}
+	a.mu.Unlock()
+	if listener == nil {
+		return fmt.Errorf("fix acceptor: Serve called before Listen")
+	}
+
+	runCtx, stopRunning := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	for _, s := range sessions {
+		running.Add(1)
+		go func(s *Session) {
+			defer running.Done()
+			s.run(runCtx, a.Exchange.Events)
+		}(s)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	var err error
+	for {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			if ctx.Err() == nil {
+				err = acceptErr
+			}
+			break
+		}
+		a.conns.Add(1)
+		go func() {
+			defer a.conns.Done()
+			a.serveConn(conn)
+		}()
+	}
+
+	for _, s := range sessions {
+		s.logout("gateway shutting down")
+	}
+	done := make(chan struct{})
+	go func() {
+		a.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(logoutTimeout):
+		for _, s := range sessions {
+			s.mu.Lock()
+			if s.conn != nil {
+				s.conn.Close()
+			}
+			s.mu.Unlock()
+		}
+		<-done
+	}
+	stopRunning()
+	running.Wait()
+	return err
+}
+
+// serveConn runs a connection: its first message must be a Logon from a configured
+// counterparty, addressed to the acceptor. Connections that fail that are dropped unanswered.
+func (a *Acceptor) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(logonTimeout))
+	logon, err := ReadMessage(reader)
+	if err != nil {
+		log.Printf("[fix] %s: reading logon: %v", conn.RemoteAddr(), err)
+		return
+	}
+	a.mu.Lock()
+	s, ok := a.sessions[logon.Get(TagSenderCompID)]
+	a.mu.Unlock()
+	if logon.Type() != MsgLogon || !ok || logon.Get(TagTargetCompID) != a.CompID {
+		log.Printf("[fix] %s: refusing %s from %q to %q", conn.RemoteAddr(), logon.Type(), logon.Get(TagSenderCompID), logon.Get(TagTargetCompID))
+		return
+	}
+	if !s.logon(conn, logon) {
+		return
+	}
+	defer s.disconnect(conn)
+	conn.SetReadDeadline(time.Time{})
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(conn, done)
+	for {
+		m, err := ReadMessage(reader)
+		if errors.Is(err, ErrBadChecksum) {
+			// The framing held; the message is dropped, and its number asked for again.
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if !s.receive(conn, m) {
+			return
+		}
+	}
+}
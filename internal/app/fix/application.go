@@ -0,0 +1,530 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// Field values of the order messages.
+const (
+	SideBuy  = "1"
+	SideSell = "2"
+
+	OrdTypeMarket = "1"
+	OrdTypeLimit  = "2"
+
+	TimeInForceGTC = "1"
+	TimeInForceGTD = "6"
+
+	ExecTypeNew      = "0"
+	ExecTypeCanceled = "4"
+	ExecTypeReplaced = "5"
+	ExecTypeRejected = "8"
+	ExecTypeTrade    = "F"
+
+	OrdStatusNew             = "0"
+	OrdStatusPartiallyFilled = "1"
+	OrdStatusFilled          = "2"
+	OrdStatusCanceled        = "4"
+	OrdStatusRejected        = "8"
+
+	// OrdRejReason values.
+	ordRejUnknownSymbol    = 1
+	ordRejExchangeClosed   = 2
+	ordRejDuplicateOrder   = 6
+	ordRejUnsupportedOrder = 11
+	ordRejOther            = 99
+
+	// CxlRejReason values.
+	cxlRejTooLate        = 0
+	cxlRejUnknownOrder   = 1
+	cxlRejAlreadyPending = 3
+	cxlRejOther          = 99
+
+	// CxlRejResponseTo values.
+	cxlRejResponseToCancel  = "1"
+	cxlRejResponseToReplace = "2"
+)
+
+// order is what a session knows of one of its orders, to fill in execution reports.
+type order struct {
+	orderID  string
+	clOrdID  string
+	symbol   string
+	side     string
+	ordType  string
+	price    float64
+	qty      float64
+	cumQty   float64
+	leaves   float64
+	notional float64 // price times size of every fill, for the average price.
+	// pending is the cancel or replace request waiting for the book, if any.
+	pending *Message
+	// done is the final status of an order that left the book, empty while it is live.
+	done string
+}
+
+func (o *order) status() string {
+	switch {
+	case o.done != "":
+		return o.done
+	case o.leaves == 0 && o.cumQty > 0:
+		return OrdStatusFilled
+	case o.cumQty > 0:
+		return OrdStatusPartiallyFilled
+	}
+	return OrdStatusNew
+}
+
+// orders are a session's orders by exchange order ID, and every ClOrdID it used. Orders are
+// kept once they are done, so ClOrdIDs stay unique and late cancels are told why they failed.
+type orders struct {
+	mu       sync.Mutex
+	byID     map[string]*order
+	clOrdIDs map[string]string // ClOrdID to exchange order ID.
+}
+
+// application carries out an order message through the exchange service. What the book does
+// with it comes back as events, which run turns into execution reports; only what is refused
+// before reaching the book is answered here.
+func (s *Session) application(m *Message) {
+	var err error
+	switch m.Type() {
+	case MsgNewOrderSingle:
+		err = s.newOrderSingle(m)
+	case MsgOrderCancelRequest:
+		err = s.cancelRequest(m)
+	case MsgOrderCancelReplaceRequest:
+		err = s.cancelReplaceRequest(m)
+	}
+	var field fieldError
+	if errors.As(err, &field) {
+		s.mu.Lock()
+		s.rejectLocked(m, err)
+		s.mu.Unlock()
+	} else if err != nil {
+		log.Printf("[fix] %s: %s: %v", s.config.CompID, m.Type(), err)
+	}
+}
+
+// newOrderSingle places a limit or market order. Good-till-date limit orders expire at their
+// ExpireTime; every other limit order is good till cancelled.
+func (s *Session) newOrderSingle(m *Message) error {
+	clOrdID, err := m.Required(TagClOrdID)
+	if err != nil {
+		return err
+	}
+	symbol, err := m.Required(TagSymbol)
+	if err != nil {
+		return err
+	}
+	side, err := m.Required(TagSide)
+	if err != nil {
+		return err
+	}
+	qty, err := m.Decimal(TagOrderQty)
+	if err != nil {
+		return err
+	}
+	ordType, err := m.Required(TagOrdType)
+	if err != nil {
+		return err
+	}
+	o := &order{clOrdID: clOrdID, symbol: symbol, side: side, ordType: ordType, qty: qty, leaves: qty}
+	reject := func(reason int, text string) error {
+		s.send(o.report(ExecTypeRejected, OrdStatusRejected, time.Now()).SetInt(TagOrdRejReason, reason).Set(TagText, text))
+		return nil
+	}
+
+	if side != SideBuy && side != SideSell {
+		return reject(ordRejUnsupportedOrder, "unsupported side "+side)
+	}
+	var expiresAt int64
+	switch ordType {
+	case OrdTypeLimit:
+		if o.price, err = m.Decimal(TagPrice); err != nil {
+			return err
+		}
+		switch tif := m.Get(TagTimeInForce); tif {
+		case "", TimeInForceGTC:
+		case TimeInForceGTD:
+			expireTime, err := m.Time(TagExpireTime)
+			if err != nil {
+				return err
+			}
+			expiresAt = expireTime.UnixNano()
+		default:
+			return reject(ordRejUnsupportedOrder, "unsupported time in force "+tif)
+		}
+	case OrdTypeMarket:
+	default:
+		return reject(ordRejUnsupportedOrder, "unsupported order type "+ordType)
+	}
+	market := services.Market(symbol)
+	if _, ok := s.acceptor.Exchange.OrderBooks[market]; !ok {
+		return reject(ordRejUnknownSymbol, services.ErrMarketNotFound.Error())
+	}
+
+	placed := services.NewOrder(side == SideBuy, services.Money(qty))
+	placed.UserID = s.config.UserID
+	placed.ExpiresAt = expiresAt
+
+	// The order is known before it reaches the book, so its events find it.
+	s.orders.mu.Lock()
+	if _, used := s.orders.clOrdIDs[clOrdID]; used {
+		s.orders.mu.Unlock()
+		return reject(ordRejDuplicateOrder, "duplicate ClOrdID "+clOrdID)
+	}
+	o.orderID = placed.ID
+	s.orders.byID[o.orderID] = o
+	s.orders.clOrdIDs[clOrdID] = o.orderID
+	s.orders.mu.Unlock()
+
+	// A refused order is published as rejected, and reported from its event.
+	ctx := context.Background()
+	if ordType == OrdTypeLimit {
+		err = s.acceptor.Exchange.PlaceLimitOrder(ctx, market, services.Money(o.price), placed)
+	} else {
+		_, err = s.acceptor.Exchange.PlaceMarketOrder(ctx, market, placed)
+	}
+	return err
+}
+
+// lookup finds the order a cancel or replace request is for, by OrigClOrdID, and marks the
+// request as pending on it. It answers the request itself when it cannot go to the book.
+func (s *Session) lookup(m *Message, responseTo string) (*order, error) {
+	if _, err := m.Required(TagClOrdID); err != nil {
+		return nil, err
+	}
+	origClOrdID, err := m.Required(TagOrigClOrdID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.orders.mu.Lock()
+	defer s.orders.mu.Unlock()
+	cancelReject := func(status string, reason int, text string) {
+		reject := NewMessage(MsgOrderCancelReject).Set(TagOrderID, "NONE").Set(TagClOrdID, m.Get(TagClOrdID)).
+			Set(TagOrigClOrdID, origClOrdID).Set(TagOrdStatus, status).Set(TagCxlRejResponseTo, responseTo).
+			SetInt(TagCxlRejReason, reason).Set(TagText, text)
+		if id, ok := s.orders.clOrdIDs[origClOrdID]; ok {
+			reject.Set(TagOrderID, id)
+		}
+		s.send(reject)
+	}
+	if _, used := s.orders.clOrdIDs[m.Get(TagClOrdID)]; used {
+		cancelReject(OrdStatusRejected, cxlRejOther, "duplicate ClOrdID "+m.Get(TagClOrdID))
+		return nil, nil
+	}
+	o, ok := s.orders.byID[s.orders.clOrdIDs[origClOrdID]]
+	switch {
+	case !ok:
+		cancelReject(OrdStatusRejected, cxlRejUnknownOrder, "unknown order "+origClOrdID)
+		return nil, nil
+	case o.done != "":
+		cancelReject(o.done, cxlRejTooLate, "order is no longer on the book")
+		return nil, nil
+	case o.clOrdID != origClOrdID:
+		cancelReject(o.status(), cxlRejUnknownOrder, "OrigClOrdID is not the order's latest ClOrdID")
+		return nil, nil
+	case o.pending != nil:
+		cancelReject(o.status(), cxlRejAlreadyPending, "order has a cancel or replace pending")
+		return nil, nil
+	}
+	o.pending = m
+	return o, nil
+}
+
+// refused answers a cancel or replace request the exchange service turned down.
+func (s *Session) refused(o *order, m *Message, responseTo string, err error) {
+	s.orders.mu.Lock()
+	if o.pending == m {
+		o.pending = nil
+	}
+	status := o.status()
+	s.orders.mu.Unlock()
+
+	reason := cxlRejOther
+	if errors.Is(err, services.ErrOrderNotResting) || errors.Is(err, services.ErrAmendNotResting) {
+		reason = cxlRejTooLate
+	}
+	s.send(NewMessage(MsgOrderCancelReject).Set(TagOrderID, o.orderID).Set(TagClOrdID, m.Get(TagClOrdID)).
+		Set(TagOrigClOrdID, m.Get(TagOrigClOrdID)).Set(TagOrdStatus, status).Set(TagCxlRejResponseTo, responseTo).
+		SetInt(TagCxlRejReason, reason).Set(TagText, err.Error()))
+}
+
+// cancelRequest pulls a resting order.
+func (s *Session) cancelRequest(m *Message) error {
+	o, err := s.lookup(m, cxlRejResponseToCancel)
+	if o == nil {
+		return err
+	}
+	if err := s.acceptor.Exchange.CancelUserOrder(context.Background(), s.config.UserID, o.orderID); err != nil {
+		s.refused(o, m, cxlRejResponseToCancel, err)
+	}
+	return nil
+}
+
+// cancelReplaceRequest amends a resting limit order's price and size. OrderQty is the new size
+// of the whole order, so what is left to fill is OrderQty less what has filled already. The side
+// and order type cannot change.
+func (s *Session) cancelReplaceRequest(m *Message) error {
+	qty, err := m.Decimal(TagOrderQty)
+	if err != nil {
+		return err
+	}
+	price, err := m.Decimal(TagPrice)
+	if err != nil {
+		return err
+	}
+	o, err := s.lookup(m, cxlRejResponseToReplace)
+	if o == nil {
+		return err
+	}
+
+	s.orders.mu.Lock()
+	leaves, market, side := qty-o.cumQty, services.Market(o.symbol), o.side
+	s.orders.mu.Unlock()
+	switch {
+	case o.ordType != OrdTypeLimit:
+		err = errors.New("only limit orders can be replaced")
+	case m.Get(TagSide) != "" && m.Get(TagSide) != side:
+		err = errors.New("the side of an order cannot change")
+	case leaves <= 0:
+		err = fmt.Errorf("OrderQty %v is not above the filled quantity", qty)
+	}
+	if err == nil {
+		amend := services.BatchOperation{Op: services.BatchAmend, OrderID: o.orderID, Price: services.Money(price), Size: services.Money(leaves)}
+		var results []services.BatchResult
+		results, _, err = s.acceptor.Exchange.ExecuteBatch(context.Background(), market, s.config.UserID, []services.BatchOperation{amend}, false)
+		if err == nil && results[0].Error != "" {
+			err = errors.New(results[0].Error)
+			if results[0].Error == services.ErrAmendNotResting.Error() {
+				err = services.ErrAmendNotResting
+			}
+		}
+	}
+	if err != nil {
+		s.refused(o, m, cxlRejResponseToReplace, err)
+	}
+	return nil
+}
+
+// report is an execution report of the order as it stands.
+func (o *order) report(execType, status string, at time.Time) *Message {
+	orderID := o.orderID
+	if orderID == "" {
+		orderID = "NONE"
+	}
+	m := NewMessage(MsgExecutionReport).Set(TagOrderID, orderID).Set(TagClOrdID, o.clOrdID).Set(TagExecID, services.NewID()).
+		Set(TagExecType, execType).Set(TagOrdStatus, status).Set(TagSymbol, o.symbol).Set(TagSide, o.side).
+		Set(TagOrdType, o.ordType).SetDecimal(TagOrderQty, o.qty)
+	if o.ordType == OrdTypeLimit {
+		m.SetDecimal(TagPrice, o.price)
+	}
+	avgPx := 0.0
+	if o.cumQty > 0 {
+		avgPx = o.notional / o.cumQty
+	}
+	if status == OrdStatusRejected || status == OrdStatusCanceled {
+		m.SetDecimal(TagLeavesQty, 0)
+	} else {
+		m.SetDecimal(TagLeavesQty, o.leaves)
+	}
+	return m.SetDecimal(TagCumQty, o.cumQty).SetDecimal(TagAvgPx, avgPx).SetTime(TagTransactTime, at)
+}
+
+// orderEvent returns the order and account of an order event.
+func orderEvent(event messaging.Event) (orderID, userID string, ok bool) {
+	switch ev := event.(type) {
+	case services.OrderAccepted:
+		return ev.OrderID, ev.UserID, true
+	case services.OrderRejected:
+		return ev.OrderID, ev.UserID, true
+	case services.OrderPartiallyFilled:
+		return ev.OrderID, ev.UserID, true
+	case services.OrderFilled:
+		return ev.OrderID, ev.UserID, true
+	case services.OrderCancelled:
+		return ev.OrderID, ev.UserID, true
+	case services.OrderAmended:
+		return ev.OrderID, ev.UserID, true
+	}
+	return "", "", false
+}
+
+// run turns the events of the session's orders into execution reports until ctx is done. The
+// bus is never held up by the session: events queue up behind it.
+func (s *Session) run(ctx context.Context, events *messaging.Dispatcher) {
+	subscriber := events.Subscribe("fix:"+s.config.CompID, 4096, messaging.Block, func(event messaging.Event) bool {
+		_, userID, ok := orderEvent(event)
+		return ok && userID == s.config.UserID
+	})
+	defer events.Unsubscribe(subscriber)
+
+	var (
+		queueMu sync.Mutex
+		queue   []messaging.Event
+		wake    = make(chan struct{}, 1)
+	)
+	go func() {
+		for event := range subscriber.Events() {
+			queueMu.Lock()
+			queue = append(queue, event)
+			queueMu.Unlock()
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			queueMu.Lock()
+			pending := queue
+			queue = nil
+			queueMu.Unlock()
+			for _, event := range pending {
+				if report := s.execution(event); report != nil {
+					s.send(report)
+				}
+			}
+		}
+	}
+}
+
+// execution updates the order an event is about and returns its execution report, or nil for
+// orders the session did not enter.
+func (s *Session) execution(event messaging.Event) *Message {
+	s.orders.mu.Lock()
+	defer s.orders.mu.Unlock()
+	header := event.(services.MarketEvent).Header()
+	at := time.Unix(0, header.Timestamp)
+	orderID, _, _ := orderEvent(event)
+	o, ok := s.orders.byID[orderID]
+	if !ok || o.done != "" {
+		return nil
+	}
+
+	switch ev := event.(type) {
+	case services.OrderAccepted:
+		return o.report(ExecTypeNew, OrdStatusNew, at)
+
+	case services.OrderRejected:
+		o.done = OrdStatusRejected
+		reason := ordRejOther
+		switch ev.Reason {
+		case services.ErrMarketHalted.Error(), services.ErrMarketCancelOnly.Error():
+			reason = ordRejExchangeClosed
+		}
+		return o.report(ExecTypeRejected, OrdStatusRejected, at).SetInt(TagOrdRejReason, reason).Set(TagText, ev.Reason)
+
+	case services.OrderPartiallyFilled:
+		o.fill(float64(ev.Price), float64(ev.FilledSize), float64(ev.Remaining))
+		return o.report(ExecTypeTrade, o.status(), at).SetDecimal(TagLastQty, float64(ev.FilledSize)).SetDecimal(TagLastPx, float64(ev.Price))
+
+	case services.OrderFilled:
+		o.fill(float64(ev.Price), float64(ev.FilledSize), 0)
+		o.done = OrdStatusFilled
+		return o.report(ExecTypeTrade, OrdStatusFilled, at).SetDecimal(TagLastQty, float64(ev.FilledSize)).SetDecimal(TagLastPx, float64(ev.Price))
+
+	case services.OrderCancelled:
+		o.leaves, o.done = 0, OrdStatusCanceled
+		report := o.report(ExecTypeCanceled, OrdStatusCanceled, at).Set(TagText, string(ev.Reason))
+		if o.pending != nil && o.pending.Type() == MsgOrderCancelRequest {
+			report.Set(TagClOrdID, o.pending.Get(TagClOrdID)).Set(TagOrigClOrdID, o.clOrdID)
+			s.orders.clOrdIDs[o.pending.Get(TagClOrdID)] = orderID
+		}
+		return report
+
+	case services.OrderAmended:
+		o.qty, o.price, o.leaves = float64(ev.Size), float64(ev.Price), float64(ev.Remaining)
+		report := o.report(ExecTypeReplaced, o.status(), at)
+		if o.pending != nil && o.pending.Type() == MsgOrderCancelReplaceRequest {
+			report.Set(TagClOrdID, o.pending.Get(TagClOrdID)).Set(TagOrigClOrdID, o.clOrdID)
+			o.clOrdID = o.pending.Get(TagClOrdID)
+			s.orders.clOrdIDs[o.clOrdID] = orderID
+			o.pending = nil
+		}
+		return report
+	}
+	return nil
+}
+
+// restore rebuilds what the session knew of its orders from the execution reports in its store,
+// so ClOrdIDs stay unique across a restart and orders entered before it can still be cancelled
+// or replaced.
+func (s *Session) restore() error {
+	stored, err := s.store.Messages(1, s.store.NextSenderSeq()-1)
+	if err != nil {
+		return err
+	}
+	seqs := make([]int, 0, len(stored))
+	for seq := range stored {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	s.orders.mu.Lock()
+	defer s.orders.mu.Unlock()
+	for _, seq := range seqs {
+		m, err := ReadMessage(bufio.NewReader(bytes.NewReader(stored[seq])))
+		if err != nil {
+			log.Printf("[fix] %s: stored message %d: %v", s.config.CompID, seq, err)
+			continue
+		}
+		if m.Type() == MsgExecutionReport {
+			s.orders.restore(m)
+		}
+	}
+	return nil
+}
+
+// restore applies one execution report to the order it is about. Reports of orders refused
+// before they had an exchange order ID used up no ClOrdID, and are skipped.
+func (known *orders) restore(m *Message) {
+	orderID, clOrdID := m.Get(TagOrderID), m.Get(TagClOrdID)
+	if orderID == "" || orderID == "NONE" {
+		return
+	}
+	o, ok := known.byID[orderID]
+	if !ok {
+		o = &order{orderID: orderID, clOrdID: clOrdID}
+		known.byID[orderID] = o
+	}
+	if m.Get(TagExecType) == ExecTypeReplaced {
+		o.clOrdID = clOrdID
+	}
+	known.clOrdIDs[clOrdID] = orderID
+	o.symbol, o.side, o.ordType = m.Get(TagSymbol), m.Get(TagSide), m.Get(TagOrdType)
+	o.qty, _ = m.Decimal(TagOrderQty)
+	o.price, _ = m.Decimal(TagPrice)
+	o.leaves, _ = m.Decimal(TagLeavesQty)
+	o.cumQty, _ = m.Decimal(TagCumQty)
+	avgPx, _ := m.Decimal(TagAvgPx)
+	o.notional = avgPx * o.cumQty
+	switch status := m.Get(TagOrdStatus); status {
+	case OrdStatusFilled, OrdStatusCanceled, OrdStatusRejected:
+		o.done = status
+	}
+}
+
+func (o *order) fill(price, size, leaves float64) {
+	o.cumQty += size
+	o.notional += price * size
+	o.leaves = leaves
+}
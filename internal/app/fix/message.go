@@ -0,0 +1,343 @@
+// Package fix is a FIX 4.4 acceptor: the session layer institutional clients log on to, and the
+// mapping of their order messages onto the exchange service.
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BeginString is the only version of the protocol spoken.
+const BeginString = "FIX.4.4"
+
+// soh separates the fields of a message.
+const soh = '\x01'
+
+// Tags of the fields the gateway reads or writes.
+const (
+	TagAvgPx                = 6
+	TagBeginSeqNo           = 7
+	TagBeginString          = 8
+	TagBodyLength           = 9
+	TagCheckSum             = 10
+	TagClOrdID              = 11
+	TagCumQty               = 14
+	TagEndSeqNo             = 16
+	TagExecID               = 17
+	TagLastPx               = 31
+	TagLastQty              = 32
+	TagMsgSeqNum            = 34
+	TagMsgType              = 35
+	TagNewSeqNo             = 36
+	TagOrderID              = 37
+	TagOrderQty             = 38
+	TagOrdStatus            = 39
+	TagOrdType              = 40
+	TagOrigClOrdID          = 41
+	TagPossDupFlag          = 43
+	TagPrice                = 44
+	TagRefSeqNum            = 45
+	TagSenderCompID         = 49
+	TagSendingTime          = 52
+	TagSide                 = 54
+	TagSymbol               = 55
+	TagTargetCompID         = 56
+	TagText                 = 58
+	TagTimeInForce          = 59
+	TagTransactTime         = 60
+	TagEncryptMethod        = 98
+	TagCxlRejReason         = 102
+	TagOrdRejReason         = 103
+	TagHeartBtInt           = 108
+	TagTestReqID            = 112
+	TagOrigSendingTime      = 122
+	TagGapFillFlag          = 123
+	TagExpireTime           = 126
+	TagResetSeqNumFlag      = 141
+	TagExecType             = 150
+	TagLeavesQty            = 151
+	TagRefTagID             = 371
+	TagRefMsgType           = 372
+	TagSessionRejectReason  = 373
+	TagBusinessRejectReason = 380
+	TagCxlRejResponseTo     = 434
+)
+
+// Message types.
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+	MsgBusinessMessageReject     = "j"
+)
+
+// TimestampFormat is the layout of UTCTimestamp fields.
+const TimestampFormat = "20060102-15:04:05.000"
+
+var (
+	ErrGarbled         = errors.New("garbled message")
+	ErrBadChecksum     = errors.New("checksum mismatch")
+	ErrFieldMissing    = errors.New("required field missing")
+	ErrIncorrectFormat = errors.New("incorrect data format")
+)
+
+// maxBodyLength bounds the body of a message read from the wire.
+const maxBodyLength = 64 << 10
+
+// Field is one tag=value pair.
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message as its fields, in the order they go on the wire. BeginString,
+// BodyLength and CheckSum are not kept: Bytes works them out, and ReadMessage checks them.
+type Message struct {
+	Fields []Field
+}
+
+// NewMessage returns an empty message of a type.
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{Tag: TagMsgType, Value: msgType}}}
+}
+
+// Type returns the message's MsgType.
+func (m *Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+// Get returns the value of a field, empty when the message lacks it.
+func (m *Message) Get(tag int) string {
+	value, _ := m.Lookup(tag)
+	return value
+}
+
+// Lookup returns the value of a field and whether the message has it.
+func (m *Message) Lookup(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of a field, or adds the field at the end.
+func (m *Message) Set(tag int, value string) *Message {
+	for i, f := range m.Fields {
+		if f.Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, Field{Tag: tag, Value: value})
+	return m
+}
+
+// SetInt sets an integer field.
+func (m *Message) SetInt(tag, value int) *Message {
+	return m.Set(tag, strconv.Itoa(value))
+}
+
+// SetDecimal sets a quantity or price field.
+func (m *Message) SetDecimal(tag int, value float64) *Message {
+	return m.Set(tag, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// SetTime sets a UTCTimestamp field.
+func (m *Message) SetTime(tag int, t time.Time) *Message {
+	return m.Set(tag, t.UTC().Format(TimestampFormat))
+}
+
+// Int returns an integer field.
+func (m *Message) Int(tag int) (int, error) {
+	value, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fieldError{tag, ErrFieldMissing}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fieldError{tag, ErrIncorrectFormat}
+	}
+	return n, nil
+}
+
+// Decimal returns a quantity or price field.
+func (m *Message) Decimal(tag int) (float64, error) {
+	value, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fieldError{tag, ErrFieldMissing}
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fieldError{tag, ErrIncorrectFormat}
+	}
+	return f, nil
+}
+
+// Time returns a UTCTimestamp field, with or without milliseconds.
+func (m *Message) Time(tag int) (time.Time, error) {
+	value, ok := m.Lookup(tag)
+	if !ok {
+		return time.Time{}, fieldError{tag, ErrFieldMissing}
+	}
+	for _, layout := range []string{TimestampFormat, "20060102-15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fieldError{tag, ErrIncorrectFormat}
+}
+
+// Required returns a field that must be present and not empty.
+func (m *Message) Required(tag int) (string, error) {
+	value, ok := m.Lookup(tag)
+	if !ok || value == "" {
+		return "", fieldError{tag, ErrFieldMissing}
+	}
+	return value, nil
+}
+
+// Bytes encodes the message with its BodyLength and CheckSum: MsgType first, then the rest of
+// the header, then the body.
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	writeField := func(f Field) {
+		body.WriteString(strconv.Itoa(f.Tag))
+		body.WriteByte('=')
+		body.WriteString(f.Value)
+		body.WriteByte(soh)
+	}
+	writeField(Field{Tag: TagMsgType, Value: m.Type()})
+	for _, header := range []bool{true, false} {
+		for _, f := range m.Fields {
+			switch f.Tag {
+			case TagBeginString, TagBodyLength, TagCheckSum, TagMsgType:
+				continue
+			}
+			if isHeader(f.Tag) == header {
+				writeField(f)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "8=%s%c9=%d%c", BeginString, soh, body.Len(), soh)
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "10=%03d%c", checksum(out.Bytes()), soh)
+	return out.Bytes()
+}
+
+// String shows the message with | between its fields, for logs.
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+func isHeader(tag int) bool {
+	switch tag {
+	case TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime:
+		return true
+	}
+	return false
+}
+
+// IsAdmin reports whether a message type belongs to the session layer rather than the application.
+func IsAdmin(msgType string) bool {
+	switch msgType {
+	case MsgHeartbeat, MsgTestRequest, MsgResendRequest, MsgReject, MsgSequenceReset, MsgLogout, MsgLogon:
+		return true
+	}
+	return false
+}
+
+func checksum(data []byte) int {
+	var sum int
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// ReadMessage reads the next message off a stream, checking its framing and checksum.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	begin, err := r.ReadString(soh)
+	if err != nil {
+		return nil, err
+	}
+	if begin != "8="+BeginString+string(soh) {
+		return nil, fmt.Errorf("%w: BeginString %q", ErrGarbled, begin)
+	}
+	length, err := r.ReadString(soh)
+	if err != nil {
+		return nil, err
+	}
+	if len(length) < 4 || length[:2] != "9=" {
+		return nil, fmt.Errorf("%w: BodyLength %q", ErrGarbled, length)
+	}
+	n, err := strconv.Atoi(length[2 : len(length)-1])
+	if err != nil || n <= 0 || n > maxBodyLength {
+		return nil, fmt.Errorf("%w: BodyLength %q", ErrGarbled, length)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, 7)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return nil, err
+	}
+	if string(trailer[:3]) != "10=" || trailer[6] != soh {
+		return nil, fmt.Errorf("%w: CheckSum %q", ErrGarbled, trailer)
+	}
+	sum, err := strconv.Atoi(string(trailer[3:6]))
+	if err != nil || sum != (checksum([]byte(begin+length))+checksum(body))%256 {
+		return nil, ErrBadChecksum
+	}
+	return ParseBody(body)
+}
+
+// ParseBody parses the fields between BodyLength and CheckSum.
+func ParseBody(body []byte) (*Message, error) {
+	if len(body) == 0 || body[len(body)-1] != soh {
+		return nil, ErrGarbled
+	}
+	m := &Message{}
+	for _, raw := range bytes.Split(body[:len(body)-1], []byte{soh}) {
+		eq := bytes.IndexByte(raw, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("%w: field %q", ErrGarbled, raw)
+		}
+		tag, err := strconv.Atoi(string(raw[:eq]))
+		if err != nil || tag <= 0 {
+			return nil, fmt.Errorf("%w: tag %q", ErrGarbled, raw[:eq])
+		}
+		m.Fields = append(m.Fields, Field{Tag: tag, Value: string(raw[eq+1:])})
+	}
+	if len(m.Fields) == 0 || m.Fields[0].Tag != TagMsgType {
+		return nil, fmt.Errorf("%w: MsgType is not the first field", ErrGarbled)
+	}
+	return m, nil
+}
+
+// fieldError is a problem with one field of a message, answered with a session Reject.
+type fieldError struct {
+	tag int
+	err error
+}
+
+func (e fieldError) Error() string { return fmt.Sprintf("tag %d: %v", e.tag, e.err) }
+func (e fieldError) Unwrap() error { return e.err }
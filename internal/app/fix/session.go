@@ -0,0 +1,413 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// writeTimeout bounds how long a write to a counterparty may block the session.
+	writeTimeout = 10 * time.Second
+
+	// SessionRejectReason values.
+	rejectRequiredTagMissing  = 1
+	rejectValueIncorrect      = 5
+	rejectIncorrectDataFormat = 6
+	rejectCompIDProblem       = 9
+	// BusinessRejectReason for a message type the gateway does not handle.
+	businessRejectUnsupported = 3
+)
+
+// SessionConfig is a counterparty allowed to log on, and the account its orders trade for.
+type SessionConfig struct {
+	// CompID is the counterparty's SenderCompID.
+	CompID string
+	// UserID is the exchange account the session's orders belong to.
+	UserID string
+}
+
+// Session is the state of one counterparty across its connections: its sequence numbers and
+// sent messages in the store, the connection it is logged on with if any, and its orders.
+// Execution reports for orders that change while it is logged out are stored and numbered as
+// usual, and reach the counterparty when it asks for a resend after its next logon.
+type Session struct {
+	acceptor *Acceptor
+	config   SessionConfig
+	store    MessageStore
+
+	// mu guards the fields below, and keeps the session's writes in sequence order.
+	mu           sync.Mutex
+	conn         net.Conn // nil while logged out.
+	heartBtInt   time.Duration
+	lastSent     time.Time
+	lastReceived time.Time
+	testReqID    string    // ID of the TestRequest waiting for an answer.
+	testReqSent  time.Time // when it was sent.
+	resendUntil  int       // highest sequence number seen past a gap, zero without a resend outstanding.
+	loggingOut   bool      // the gateway sent Logout and waits for the counterparty's.
+
+	orders orders
+}
+
+// Config returns the counterparty and account of the session.
+func (s *Session) Config() SessionConfig {
+	return s.config
+}
+
+// LoggedOn reports whether the counterparty is connected and logged on.
+func (s *Session) LoggedOn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// logon checks the Logon that opened a connection and answers it. It returns false when the
+// connection is refused, having said why.
+func (s *Session) logon(conn net.Conn, m *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		log.Printf("[fix] %s: refusing a second connection from %s", s.config.CompID, conn.RemoteAddr())
+		return false
+	}
+	heartBtInt, err := m.Int(TagHeartBtInt)
+	if err != nil || heartBtInt <= 0 {
+		log.Printf("[fix] %s: logon without a valid HeartBtInt", s.config.CompID)
+		return false
+	}
+	seq, err := m.Int(TagMsgSeqNum)
+	if err != nil {
+		log.Printf("[fix] %s: logon without a MsgSeqNum", s.config.CompID)
+		return false
+	}
+	reset := m.Get(TagResetSeqNumFlag) == "Y"
+	if reset {
+		if err := s.store.Reset(); err != nil {
+			log.Printf("[fix] %s: resetting the store: %v", s.config.CompID, err)
+			return false
+		}
+	}
+
+	s.conn = conn
+	s.heartBtInt = time.Duration(heartBtInt) * time.Second
+	s.lastReceived = time.Now()
+	s.testReqID, s.resendUntil, s.loggingOut = "", 0, false
+	expected := s.store.NextTargetSeq()
+	if seq < expected {
+		s.logoutLocked(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", expected, seq))
+		s.conn = nil
+		return false
+	}
+
+	reply := NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, heartBtInt)
+	if reset {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.sendLocked(reply)
+	if seq > expected {
+		s.requestResendLocked(expected, seq)
+	} else {
+		s.store.SetNextTargetSeq(seq + 1)
+	}
+	log.Printf("[fix] %s logged on from %s", s.config.CompID, conn.RemoteAddr())
+	return true
+}
+
+// disconnect forgets the connection, if it is still the session's, and closes it.
+func (s *Session) disconnect(conn net.Conn) {
+	s.mu.Lock()
+	if s.conn == conn {
+		s.conn = nil
+		log.Printf("[fix] %s logged out", s.config.CompID)
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// receive handles a message of a logged on connection: the session layer checks it and keeps
+// the sequence, then application messages are handed on. It returns false once the connection
+// should be closed.
+func (s *Session) receive(conn net.Conn, m *Message) bool {
+	s.mu.Lock()
+	if s.conn != conn {
+		s.mu.Unlock()
+		return false
+	}
+	// Anything from the counterparty shows it is alive.
+	s.lastReceived, s.testReqID = time.Now(), ""
+	if !s.inSequenceLocked(m) {
+		open := s.conn == conn
+		s.mu.Unlock()
+		return open
+	}
+
+	switch m.Type() {
+	case MsgHeartbeat:
+	case MsgTestRequest:
+		s.sendLocked(NewMessage(MsgHeartbeat).Set(TagTestReqID, m.Get(TagTestReqID)))
+	case MsgResendRequest:
+		begin, err := m.Int(TagBeginSeqNo)
+		if err != nil {
+			s.rejectLocked(m, err)
+			break
+		}
+		end, err := m.Int(TagEndSeqNo)
+		if err != nil {
+			s.rejectLocked(m, err)
+			break
+		}
+		s.resendLocked(begin, end)
+	case MsgReject:
+		log.Printf("[fix] %s rejected message %s: %s", s.config.CompID, m.Get(TagRefSeqNum), m.Get(TagText))
+	case MsgSequenceReset:
+		s.sequenceResetLocked(m)
+	case MsgLogout:
+		if !s.loggingOut {
+			s.sendLocked(NewMessage(MsgLogout))
+		}
+		s.mu.Unlock()
+		return false
+	case MsgLogon:
+		// Already logged on.
+	case MsgNewOrderSingle, MsgOrderCancelRequest, MsgOrderCancelReplaceRequest:
+		s.mu.Unlock()
+		s.application(m)
+		return true
+	default:
+		reject := NewMessage(MsgBusinessMessageReject).Set(TagRefSeqNum, m.Get(TagMsgSeqNum)).Set(TagRefMsgType, m.Type()).
+			SetInt(TagBusinessRejectReason, businessRejectUnsupported).Set(TagText, "unsupported message type")
+		s.sendLocked(reject)
+	}
+	open := s.conn == conn
+	s.mu.Unlock()
+	return open
+}
+
+// inSequenceLocked checks a message's CompIDs and sequence number. It returns true when the
+// message is the next one expected, and should be handled; anything else has already been dealt
+// with: a gap asks for a resend, and a number already seen ends the session unless it is a
+// possible duplicate. A SequenceReset in reset mode is applied whatever its number.
+func (s *Session) inSequenceLocked(m *Message) bool {
+	if m.Get(TagSenderCompID) != s.config.CompID || m.Get(TagTargetCompID) != s.acceptor.CompID {
+		s.sendLocked(NewMessage(MsgReject).Set(TagRefSeqNum, m.Get(TagMsgSeqNum)).Set(TagRefMsgType, m.Type()).
+			SetInt(TagSessionRejectReason, rejectCompIDProblem).Set(TagText, "CompID problem"))
+		s.logoutLocked("CompID problem")
+		return false
+	}
+	seq, err := m.Int(TagMsgSeqNum)
+	if err != nil {
+		s.logoutLocked("MsgSeqNum missing or invalid")
+		return false
+	}
+	if m.Type() == MsgSequenceReset && m.Get(TagGapFillFlag) != "Y" {
+		s.sequenceResetLocked(m)
+		return false
+	}
+
+	expected := s.store.NextTargetSeq()
+	switch {
+	case seq > expected:
+		s.requestResendLocked(expected, seq)
+		return false
+	case seq < expected:
+		if m.Get(TagPossDupFlag) != "Y" {
+			s.logoutLocked(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", expected, seq))
+		}
+		return false
+	}
+	s.store.SetNextTargetSeq(seq + 1)
+	if s.resendUntil != 0 && seq >= s.resendUntil {
+		s.resendUntil = 0
+	}
+	return true
+}
+
+// requestResendLocked asks for everything from expected on, once per gap. The messages past the
+// gap are dropped, as the counterparty sends them again.
+func (s *Session) requestResendLocked(expected, seen int) {
+	if s.resendUntil == 0 {
+		s.sendLocked(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, expected).SetInt(TagEndSeqNo, 0))
+	}
+	if seen > s.resendUntil {
+		s.resendUntil = seen
+	}
+}
+
+// sequenceResetLocked moves the expected sequence number forward. A gap fill is only applied in
+// sequence; a reset may not move the number back.
+func (s *Session) sequenceResetLocked(m *Message) {
+	next, err := m.Int(TagNewSeqNo)
+	if err != nil {
+		s.rejectLocked(m, err)
+		return
+	}
+	if next < s.store.NextTargetSeq() {
+		s.sendLocked(NewMessage(MsgReject).Set(TagRefSeqNum, m.Get(TagMsgSeqNum)).Set(TagRefMsgType, m.Type()).
+			SetInt(TagRefTagID, TagNewSeqNo).SetInt(TagSessionRejectReason, rejectValueIncorrect).
+			Set(TagText, "NewSeqNo would move the sequence back"))
+		return
+	}
+	s.store.SetNextTargetSeq(next)
+	if s.resendUntil != 0 && next > s.resendUntil {
+		s.resendUntil = 0
+	}
+}
+
+// resendLocked sends the stored messages numbered begin to end again, flagged as possible
+// duplicates. Session messages are not stored, and runs of them are skipped with a gap fill.
+func (s *Session) resendLocked(begin, end int) {
+	last := s.store.NextSenderSeq() - 1
+	if end == 0 || end > last {
+		end = last
+	}
+	stored, err := s.store.Messages(begin, end)
+	if err != nil {
+		log.Printf("[fix] %s: reading the store: %v", s.config.CompID, err)
+		return
+	}
+
+	gapFrom := 0
+	fillGap := func(next int) {
+		if gapFrom == 0 {
+			return
+		}
+		fill := NewMessage(MsgSequenceReset).Set(TagSenderCompID, s.acceptor.CompID).Set(TagTargetCompID, s.config.CompID).
+			SetInt(TagMsgSeqNum, gapFrom).Set(TagPossDupFlag, "Y").SetTime(TagSendingTime, time.Now()).
+			Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, next)
+		s.writeLocked(fill)
+		gapFrom = 0
+	}
+	for seq := begin; seq <= end; seq++ {
+		raw, ok := stored[seq]
+		if !ok {
+			if gapFrom == 0 {
+				gapFrom = seq
+			}
+			continue
+		}
+		fillGap(seq)
+		m, err := ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			log.Printf("[fix] %s: stored message %d: %v", s.config.CompID, seq, err)
+			continue
+		}
+		m.Set(TagPossDupFlag, "Y").Set(TagOrigSendingTime, m.Get(TagSendingTime)).SetTime(TagSendingTime, time.Now())
+		s.writeLocked(m)
+	}
+	fillGap(end + 1)
+}
+
+// rejectLocked answers a message with a bad field with a session Reject.
+func (s *Session) rejectLocked(m *Message, err error) {
+	reject := NewMessage(MsgReject).Set(TagRefSeqNum, m.Get(TagMsgSeqNum)).Set(TagRefMsgType, m.Type()).Set(TagText, err.Error())
+	var field fieldError
+	if errors.As(err, &field) {
+		reason := rejectIncorrectDataFormat
+		if errors.Is(err, ErrFieldMissing) {
+			reason = rejectRequiredTagMissing
+		}
+		reject.SetInt(TagRefTagID, field.tag).SetInt(TagSessionRejectReason, reason)
+	}
+	s.sendLocked(reject)
+}
+
+// logoutLocked sends a Logout and drops the connection without waiting for the answer.
+func (s *Session) logoutLocked(text string) {
+	log.Printf("[fix] %s: logging out: %s", s.config.CompID, text)
+	s.sendLocked(NewMessage(MsgLogout).Set(TagText, text))
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// logout asks the counterparty to log out, as the gateway shuts down. The connection closes once
+// it answers.
+func (s *Session) logout(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.loggingOut {
+		return
+	}
+	s.loggingOut = true
+	s.sendLocked(NewMessage(MsgLogout).Set(TagText, text))
+}
+
+// send numbers and sends a message.
+func (s *Session) send(m *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(m)
+}
+
+// sendLocked gives a message the session's header and next sequence number, stores it if it is
+// an application message, and writes it when the counterparty is logged on.
+func (s *Session) sendLocked(m *Message) {
+	seq := s.store.NextSenderSeq()
+	m.Set(TagSenderCompID, s.acceptor.CompID).Set(TagTargetCompID, s.config.CompID).
+		SetInt(TagMsgSeqNum, seq).SetTime(TagSendingTime, time.Now())
+	if !IsAdmin(m.Type()) {
+		if err := s.store.Save(seq, m.Bytes()); err != nil {
+			log.Printf("[fix] %s: storing message %d: %v", s.config.CompID, seq, err)
+		}
+	}
+	if err := s.store.SetNextSenderSeq(seq + 1); err != nil {
+		log.Printf("[fix] %s: storing the sequence number: %v", s.config.CompID, err)
+	}
+	s.writeLocked(m)
+}
+
+// writeLocked puts a message on the wire as it is. A failed write drops the connection.
+func (s *Session) writeLocked(m *Message) {
+	if s.conn == nil {
+		return
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.conn.Write(m.Bytes()); err != nil {
+		log.Printf("[fix] %s: write failed: %v", s.config.CompID, err)
+		s.conn.Close()
+		s.conn = nil
+		return
+	}
+	s.lastSent = time.Now()
+}
+
+// heartbeat keeps a connection alive until it closes: a Heartbeat goes out after a heartbeat
+// interval without sending anything, a TestRequest after a little longer without hearing from
+// the counterparty, and the connection is dropped when that goes unanswered for another interval.
+func (s *Session) heartbeat(conn net.Conn, done <-chan struct{}) {
+	s.mu.Lock()
+	interval := s.heartBtInt
+	s.mu.Unlock()
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			if s.conn != conn {
+				s.mu.Unlock()
+				return
+			}
+			switch {
+			case s.testReqID != "" && now.Sub(s.testReqSent) >= interval:
+				s.logoutLocked("TestRequest " + s.testReqID + " went unanswered")
+			case s.testReqID == "" && now.Sub(s.lastReceived) >= interval+interval/5:
+				s.testReqID, s.testReqSent = strconv.FormatInt(now.UnixNano(), 36), now
+				s.sendLocked(NewMessage(MsgTestRequest).Set(TagTestReqID, s.testReqID))
+			case now.Sub(s.lastSent) >= interval:
+				s.sendLocked(NewMessage(MsgHeartbeat))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package fix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MessageStore keeps a session's sequence numbers and the application messages it sent, so
+// they can be resent when the counterparty asks. Sequence numbers carry over across logons
+// until the counterparty logs on with ResetSeqNumFlag.
+type MessageStore interface {
+	NextSenderSeq() int
+	NextTargetSeq() int
+	SetNextSenderSeq(seq int) error
+	SetNextTargetSeq(seq int) error
+	// Save keeps a sent message under its sequence number.
+	Save(seq int, message []byte) error
+	// Messages returns the kept messages numbered begin to end, inclusive, by sequence number.
+	Messages(begin, end int) (map[int][]byte, error)
+	// Reset starts both sequences again at 1 and forgets the kept messages.
+	Reset() error
+	Close() error
+}
+
+// MemoryStore is a MessageStore that lasts as long as the process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	senderSeq int
+	targetSeq int
+	messages  map[int][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{senderSeq: 1, targetSeq: 1, messages: make(map[int][]byte)}
+}
+
+func (s *MemoryStore) NextSenderSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.senderSeq
+}
+
+func (s *MemoryStore) NextTargetSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.targetSeq
+}
+
+func (s *MemoryStore) SetNextSenderSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senderSeq = seq
+	return nil
+}
+
+func (s *MemoryStore) SetNextTargetSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targetSeq = seq
+	return nil
+}
+
+func (s *MemoryStore) Save(seq int, message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[seq] = append([]byte(nil), message...)
+	return nil
+}
+
+func (s *MemoryStore) Messages(begin, end int) (map[int][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return between(s.messages, begin, end), nil
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senderSeq, s.targetSeq = 1, 1
+	s.messages = make(map[int][]byte)
+	return nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+func between(messages map[int][]byte, begin, end int) map[int][]byte {
+	found := make(map[int][]byte)
+	for seq, message := range messages {
+		if seq >= begin && seq <= end {
+			found[seq] = message
+		}
+	}
+	return found
+}
+
+// FileStore is a MessageStore kept in two files of a directory: <session>.seqnums holds the next
+// sender and target sequence numbers, and <session>.body appends every saved message behind its
+// sequence number and length. A message cut short by a crash is dropped when the store is opened.
+type FileStore struct {
+	mu        sync.Mutex
+	seqPath   string
+	body      *os.File
+	senderSeq int
+	targetSeq int
+	messages  map[int][]byte
+}
+
+// OpenFileStore opens the store of a session in dir, creating it if needed.
+func OpenFileStore(dir, session string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		seqPath:   filepath.Join(dir, session+".seqnums"),
+		senderSeq: 1,
+		targetSeq: 1,
+		messages:  make(map[int][]byte),
+	}
+	if data, err := os.ReadFile(s.seqPath); err == nil {
+		if _, err := fmt.Sscanf(string(data), "%d %d", &s.senderSeq, &s.targetSeq); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", s.seqPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	body, err := os.OpenFile(filepath.Join(dir, session+".body"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	end, err := s.load(body)
+	if err == nil {
+		// Anything past the last whole message is a write cut short.
+		if err = body.Truncate(end); err == nil {
+			_, err = body.Seek(end, io.SeekStart)
+		}
+	}
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	s.body = body
+	return s, nil
+}
+
+// load reads the saved messages, returning the offset just past the last whole one.
+func (s *FileStore) load(body *os.File) (int64, error) {
+	r := bufio.NewReader(body)
+	var offset int64
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return offset, nil
+		}
+		message := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, message); err != nil {
+			return offset, nil
+		}
+		s.messages[int(binary.BigEndian.Uint32(header[:4]))] = message
+		offset += int64(len(header) + len(message))
+	}
+}
+
+func (s *FileStore) NextSenderSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.senderSeq
+}
+
+func (s *FileStore) NextTargetSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.targetSeq
+}
+
+func (s *FileStore) SetNextSenderSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senderSeq = seq
+	return s.writeSeqNums()
+}
+
+func (s *FileStore) SetNextTargetSeq(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targetSeq = seq
+	return s.writeSeqNums()
+}
+
+// writeSeqNums replaces the sequence file, so a crash leaves either the old numbers or the new.
+func (s *FileStore) writeSeqNums() error {
+	tmp := s.seqPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", s.senderSeq, s.targetSeq)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.seqPath)
+}
+
+func (s *FileStore) Save(seq int, message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := make([]byte, 8+len(message))
+	binary.BigEndian.PutUint32(record[:4], uint32(seq))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(message)))
+	copy(record[8:], message)
+	if _, err := s.body.Write(record); err != nil {
+		return err
+	}
+	s.messages[seq] = record[8:]
+	return nil
+}
+
+func (s *FileStore) Messages(begin, end int) (map[int][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return between(s.messages, begin, end), nil
+}
+
+func (s *FileStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.body.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.messages = make(map[int][]byte)
+	s.senderSeq, s.targetSeq = 1, 1
+	return s.writeSeqNums()
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.body.Close()
+}
@@ -0,0 +1,83 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/theghostmac/cryptex/internal/app/grpcapi/cryptexv1"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// public are the methods open without a session: market data.
+var public = map[string]bool{
+	cryptexv1.Exchange_GetBook_FullMethodName:         true,
+	cryptexv1.Exchange_SubscribeBook_FullMethodName:   true,
+	cryptexv1.Exchange_SubscribeTrades_FullMethodName: true,
+}
+
+// bearerToken extracts the session token from the authorization metadata.
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range md.Get("authorization") {
+		if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			return strings.TrimSpace(header[7:])
+		}
+	}
+	return ""
+}
+
+// authenticate stores the caller's user in the context, as RequireSession does for HTTP, unless
+// the method is public.
+func authenticate(ctx context.Context, users *services.UserService, method string) (context.Context, error) {
+	if public[method] {
+		return ctx, nil
+	}
+	user, _, err := users.Authenticate(bearerToken(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return context.WithValue(ctx, userContextKey, user), nil
+}
+
+// UnaryAuth authenticates unary calls with the session token of their metadata.
+func UnaryAuth(users *services.UserService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, users, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth authenticates streaming calls with the session token of their metadata.
+func StreamAuth(users *services.UserService) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), users, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context { return s.ctx }
+
+// userFromContext returns the user authenticated by the interceptors.
+func userFromContext(ctx context.Context) (*services.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*services.User)
+	return user, ok
+}
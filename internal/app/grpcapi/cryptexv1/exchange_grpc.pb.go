@@ -0,0 +1,439 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: cryptex/v1/exchange.proto
+
+package cryptexv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Exchange_PlaceOrder_FullMethodName      = "/cryptex.v1.Exchange/PlaceOrder"
+	Exchange_CancelOrder_FullMethodName     = "/cryptex.v1.Exchange/CancelOrder"
+	Exchange_AmendOrder_FullMethodName      = "/cryptex.v1.Exchange/AmendOrder"
+	Exchange_GetOrder_FullMethodName        = "/cryptex.v1.Exchange/GetOrder"
+	Exchange_ListOrders_FullMethodName      = "/cryptex.v1.Exchange/ListOrders"
+	Exchange_GetBook_FullMethodName         = "/cryptex.v1.Exchange/GetBook"
+	Exchange_SubscribeBook_FullMethodName   = "/cryptex.v1.Exchange/SubscribeBook"
+	Exchange_SubscribeTrades_FullMethodName = "/cryptex.v1.Exchange/SubscribeTrades"
+)
+
+// ExchangeClient is the client API for Exchange service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExchangeClient interface {
+	// PlaceOrder places a limit or market order.
+	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+	// CancelOrder cancels one of the caller's resting orders.
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error)
+	// AmendOrder changes the price and the size left to fill of one of the caller's resting orders.
+	AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*AmendOrderResponse, error)
+	// GetOrder returns the status and fills of one of the caller's orders.
+	GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	// ListOrders returns a page of the caller's orders, newest first.
+	ListOrders(ctx context.Context, in *ListOrdersRequest, opts ...grpc.CallOption) (*ListOrdersResponse, error)
+	// GetBook returns the price levels of a market, best first.
+	GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error)
+	// SubscribeBook streams a snapshot of a market's levels, then every level change after it.
+	SubscribeBook(ctx context.Context, in *SubscribeBookRequest, opts ...grpc.CallOption) (Exchange_SubscribeBookClient, error)
+	// SubscribeTrades streams the trades of a market as they happen.
+	SubscribeTrades(ctx context.Context, in *SubscribeTradesRequest, opts ...grpc.CallOption) (Exchange_SubscribeTradesClient, error)
+}
+
+type exchangeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExchangeClient(cc grpc.ClientConnInterface) ExchangeClient {
+	return &exchangeClient{cc}
+}
+
+func (c *exchangeClient) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error) {
+	out := new(PlaceOrderResponse)
+	err := c.cc.Invoke(ctx, Exchange_PlaceOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error) {
+	out := new(CancelOrderResponse)
+	err := c.cc.Invoke(ctx, Exchange_CancelOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*AmendOrderResponse, error) {
+	out := new(AmendOrderResponse)
+	err := c.cc.Invoke(ctx, Exchange_AmendOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	out := new(Order)
+	err := c.cc.Invoke(ctx, Exchange_GetOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) ListOrders(ctx context.Context, in *ListOrdersRequest, opts ...grpc.CallOption) (*ListOrdersResponse, error) {
+	out := new(ListOrdersResponse)
+	err := c.cc.Invoke(ctx, Exchange_ListOrders_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := c.cc.Invoke(ctx, Exchange_GetBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangeClient) SubscribeBook(ctx context.Context, in *SubscribeBookRequest, opts ...grpc.CallOption) (Exchange_SubscribeBookClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Exchange_ServiceDesc.Streams[0], Exchange_SubscribeBook_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &exchangeSubscribeBookClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Exchange_SubscribeBookClient interface {
+	Recv() (*BookUpdate, error)
+	grpc.ClientStream
+}
+
+type exchangeSubscribeBookClient struct {
+	grpc.ClientStream
+}
+
+func (x *exchangeSubscribeBookClient) Recv() (*BookUpdate, error) {
+	m := new(BookUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *exchangeClient) SubscribeTrades(ctx context.Context, in *SubscribeTradesRequest, opts ...grpc.CallOption) (Exchange_SubscribeTradesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Exchange_ServiceDesc.Streams[1], Exchange_SubscribeTrades_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &exchangeSubscribeTradesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Exchange_SubscribeTradesClient interface {
+	Recv() (*Trade, error)
+	grpc.ClientStream
+}
+
+type exchangeSubscribeTradesClient struct {
+	grpc.ClientStream
+}
+
+func (x *exchangeSubscribeTradesClient) Recv() (*Trade, error) {
+	m := new(Trade)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExchangeServer is the server API for Exchange service.
+// All implementations must embed UnimplementedExchangeServer
+// for forward compatibility
+type ExchangeServer interface {
+	// PlaceOrder places a limit or market order.
+	PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	// CancelOrder cancels one of the caller's resting orders.
+	CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error)
+	// AmendOrder changes the price and the size left to fill of one of the caller's resting orders.
+	AmendOrder(context.Context, *AmendOrderRequest) (*AmendOrderResponse, error)
+	// GetOrder returns the status and fills of one of the caller's orders.
+	GetOrder(context.Context, *GetOrderRequest) (*Order, error)
+	// ListOrders returns a page of the caller's orders, newest first.
+	ListOrders(context.Context, *ListOrdersRequest) (*ListOrdersResponse, error)
+	// GetBook returns the price levels of a market, best first.
+	GetBook(context.Context, *GetBookRequest) (*Book, error)
+	// SubscribeBook streams a snapshot of a market's levels, then every level change after it.
+	SubscribeBook(*SubscribeBookRequest, Exchange_SubscribeBookServer) error
+	// SubscribeTrades streams the trades of a market as they happen.
+	SubscribeTrades(*SubscribeTradesRequest, Exchange_SubscribeTradesServer) error
+	mustEmbedUnimplementedExchangeServer()
+}
+
+// UnimplementedExchangeServer must be embedded to have forward compatible implementations.
+type UnimplementedExchangeServer struct {
+}
+
+func (UnimplementedExchangeServer) PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceOrder not implemented")
+}
+func (UnimplementedExchangeServer) CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedExchangeServer) AmendOrder(context.Context, *AmendOrderRequest) (*AmendOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AmendOrder not implemented")
+}
+func (UnimplementedExchangeServer) GetOrder(context.Context, *GetOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedExchangeServer) ListOrders(context.Context, *ListOrdersRequest) (*ListOrdersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrders not implemented")
+}
+func (UnimplementedExchangeServer) GetBook(context.Context, *GetBookRequest) (*Book, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBook not implemented")
+}
+func (UnimplementedExchangeServer) SubscribeBook(*SubscribeBookRequest, Exchange_SubscribeBookServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeBook not implemented")
+}
+func (UnimplementedExchangeServer) SubscribeTrades(*SubscribeTradesRequest, Exchange_SubscribeTradesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeTrades not implemented")
+}
+func (UnimplementedExchangeServer) mustEmbedUnimplementedExchangeServer() {}
+
+// UnsafeExchangeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExchangeServer will
+// result in compilation errors.
+type UnsafeExchangeServer interface {
+	mustEmbedUnimplementedExchangeServer()
+}
+
+func RegisterExchangeServer(s grpc.ServiceRegistrar, srv ExchangeServer) {
+	s.RegisterService(&Exchange_ServiceDesc, srv)
+}
+
+func _Exchange_PlaceOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).PlaceOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_PlaceOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).PlaceOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_AmendOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AmendOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).AmendOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_AmendOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).AmendOrder(ctx, req.(*AmendOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).GetOrder(ctx, req.(*GetOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_ListOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOrdersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).ListOrders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_ListOrders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).ListOrders(ctx, req.(*ListOrdersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_GetBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangeServer).GetBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exchange_GetBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangeServer).GetBook(ctx, req.(*GetBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exchange_SubscribeBook_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeBookRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExchangeServer).SubscribeBook(m, &exchangeSubscribeBookServer{stream})
+}
+
+type Exchange_SubscribeBookServer interface {
+	Send(*BookUpdate) error
+	grpc.ServerStream
+}
+
+type exchangeSubscribeBookServer struct {
+	grpc.ServerStream
+}
+
+func (x *exchangeSubscribeBookServer) Send(m *BookUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Exchange_SubscribeTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeTradesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExchangeServer).SubscribeTrades(m, &exchangeSubscribeTradesServer{stream})
+}
+
+type Exchange_SubscribeTradesServer interface {
+	Send(*Trade) error
+	grpc.ServerStream
+}
+
+type exchangeSubscribeTradesServer struct {
+	grpc.ServerStream
+}
+
+func (x *exchangeSubscribeTradesServer) Send(m *Trade) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Exchange_ServiceDesc is the grpc.ServiceDesc for Exchange service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Exchange_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cryptex.v1.Exchange",
+	HandlerType: (*ExchangeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlaceOrder",
+			Handler:    _Exchange_PlaceOrder_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _Exchange_CancelOrder_Handler,
+		},
+		{
+			MethodName: "AmendOrder",
+			Handler:    _Exchange_AmendOrder_Handler,
+		},
+		{
+			MethodName: "GetOrder",
+			Handler:    _Exchange_GetOrder_Handler,
+		},
+		{
+			MethodName: "ListOrders",
+			Handler:    _Exchange_ListOrders_Handler,
+		},
+		{
+			MethodName: "GetBook",
+			Handler:    _Exchange_GetBook_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeBook",
+			Handler:       _Exchange_SubscribeBook_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeTrades",
+			Handler:       _Exchange_SubscribeTrades_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cryptex/v1/exchange.proto",
+}
@@ -0,0 +1,109 @@
+package grpcapi
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/grpcapi/cryptexv1"
+	"github.com/theghostmac/cryptex/web/middlewares"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// classes are the rate limit classes of the methods, the classes of their REST routes.
+var classes = map[string]middlewares.RouteClass{
+	cryptexv1.Exchange_PlaceOrder_FullMethodName:      middlewares.ClassOrders,
+	cryptexv1.Exchange_AmendOrder_FullMethodName:      middlewares.ClassOrders,
+	cryptexv1.Exchange_CancelOrder_FullMethodName:     middlewares.ClassCancels,
+	cryptexv1.Exchange_GetOrder_FullMethodName:        middlewares.ClassMarketData,
+	cryptexv1.Exchange_ListOrders_FullMethodName:      middlewares.ClassMarketData,
+	cryptexv1.Exchange_GetBook_FullMethodName:         middlewares.ClassMarketData,
+	cryptexv1.Exchange_SubscribeBook_FullMethodName:   middlewares.ClassMarketData,
+	cryptexv1.Exchange_SubscribeTrades_FullMethodName: middlewares.ClassMarketData,
+}
+
+// clientIP returns the address a call is accounted against, as ClientIP does for HTTP.
+func clientIP(ctx context.Context, limiter *middlewares.RateLimiter) string {
+	if limiter.Config().TrustForwardedFor {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 && forwarded[0] != "" {
+			return strings.TrimSpace(strings.Split(forwarded[0], ",")[0])
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// allow takes a token for the call from the buckets of its method's class, and returns the
+// metadata to send with its response. Throttled calls fail with RESOURCE_EXHAUSTED.
+func allow(ctx context.Context, limiter *middlewares.RateLimiter, method string) (metadata.MD, error) {
+	class, ok := classes[method]
+	if !ok {
+		return nil, nil
+	}
+	var apiKey string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(middlewares.APIKeyHeader); len(keys) > 0 {
+		apiKey = keys[0]
+	}
+	decision := limiter.Allow(class, apiKey, clientIP(ctx, limiter))
+	header := metadata.MD{}
+	if decision.Limit > 0 {
+		header.Set("x-ratelimit-limit", strconv.Itoa(decision.Limit))
+		header.Set("x-ratelimit-remaining", strconv.Itoa(decision.Remaining))
+		header.Set("x-ratelimit-reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	}
+	if !decision.Allowed {
+		retryAfter := ceilSeconds(decision.RetryAfter)
+		header.Set("retry-after", strconv.Itoa(retryAfter))
+		return header, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", retryAfter)
+	}
+	return header, nil
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// UnaryRateLimit holds unary calls to the limits of their method's class.
+func UnaryRateLimit(limiter *middlewares.RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		header, err := allow(ctx, limiter, info.FullMethod)
+		if len(header) > 0 {
+			grpc.SetHeader(ctx, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimit holds streaming calls to the limits of their method's class. A subscription
+// takes one token when it opens, as a WebSocket feed does.
+func StreamRateLimit(limiter *middlewares.RateLimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		header, err := allow(stream.Context(), limiter, info.FullMethod)
+		if len(header) > 0 {
+			stream.SetHeader(header)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
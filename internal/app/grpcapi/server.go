@@ -0,0 +1,322 @@
+// Package grpcapi serves the Exchange gRPC service defined in proto/cryptex/v1/exchange.proto, on
+// the same service layer as the REST handlers.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/theghostmac/cryptex/internal/app/grpcapi/cryptexv1"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+	"github.com/theghostmac/cryptex/web/middlewares"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultOrdersPageSize = 50
+	maxOrdersPageSize     = 500
+	// streamBuffer is how many events a subscription may fall behind before it is ended.
+	streamBuffer = 256
+)
+
+// Server implements the Exchange service.
+type Server struct {
+	cryptexv1.UnimplementedExchangeServer
+	Service *services.CryptoExchangeService
+}
+
+func NewServer(service *services.CryptoExchangeService) *Server {
+	return &Server{Service: service}
+}
+
+// NewGRPCServer returns a gRPC server with the Exchange service registered behind the rate
+// limits of the REST routes and session authentication.
+func NewGRPCServer(service *services.CryptoExchangeService, limiter *middlewares.RateLimiter, options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.ChainUnaryInterceptor(UnaryRateLimit(limiter), UnaryAuth(service.Users)),
+		grpc.ChainStreamInterceptor(StreamRateLimit(limiter), StreamAuth(service.Users)))
+	server := grpc.NewServer(options...)
+	cryptexv1.RegisterExchangeServer(server, NewServer(service))
+	return server
+}
+
+// orderError maps an error of the order service to a status, as the REST handlers map them to
+// HTTP statuses.
+func orderError(err error, action, orderID string) error {
+	switch {
+	case errors.Is(err, services.ErrMarketNotFound), errors.Is(err, services.ErrInsufficientLiquidity),
+		errors.Is(err, services.ErrInsufficientBalance), services.IsInvalidOrder(err):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrMarketHalted), errors.Is(err, services.ErrMarketCancelOnly), errors.Is(err, services.ErrMarketInAuction):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, services.ErrOrderNotResting), errors.Is(err, services.ErrAmendNotResting):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	log.Printf("Could not record %s of order %s: %v", action, orderID, err)
+	return status.Errorf(codes.Internal, "%s could not be recorded", action)
+}
+
+func (s *Server) PlaceOrder(ctx context.Context, req *cryptexv1.PlaceOrderRequest) (*cryptexv1.PlaceOrderResponse, error) {
+	user, _ := userFromContext(ctx)
+	if req.Side != cryptexv1.Side_SIDE_BUY && req.Side != cryptexv1.Side_SIDE_SELL {
+		return nil, status.Error(codes.InvalidArgument, "side must be buy or sell")
+	}
+	o := services.NewOrder(req.Side == cryptexv1.Side_SIDE_BUY, services.Money(req.Size))
+	o.UserID = user.ID
+	o.ExpiresAt = req.ExpiresAt
+	market := services.Market(req.Market)
+
+	var (
+		matches []services.MatchEngine
+		err     error
+	)
+	switch req.Type {
+	case cryptexv1.OrderType_ORDER_TYPE_LIMIT:
+		err = s.Service.PlaceLimitOrder(ctx, market, services.Money(req.Price), o)
+	case cryptexv1.OrderType_ORDER_TYPE_MARKET:
+		o.Protection = services.Protection{
+			Price:          services.Money(req.ProtectionPrice),
+			MaxSlippageBps: req.MaxSlippageBps,
+			Remainder:      remainder(req.Remainder),
+		}
+		o.Notional = services.Money(req.Notional)
+		matches, err = s.Service.PlaceMarketOrder(ctx, market, o)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "type must be limit or market")
+	}
+	if err != nil {
+		return nil, orderError(err, "order", o.ID)
+	}
+	response := &cryptexv1.PlaceOrderResponse{
+		OrderId:      o.ID,
+		Status:       orderStatus(o.Status()),
+		FilledSize:   float64(o.InitialSize - o.Size),
+		AveragePrice: float64(services.AveragePrice(matches)),
+	}
+	if o.Notional != 0 {
+		response.UnspentNotional = float64(services.Unspent(o, matches))
+	}
+	return response, nil
+}
+
+func (s *Server) CancelOrder(ctx context.Context, req *cryptexv1.CancelOrderRequest) (*cryptexv1.CancelOrderResponse, error) {
+	user, _ := userFromContext(ctx)
+	if err := s.Service.CancelUserOrder(ctx, user.ID, req.OrderId); err != nil {
+		return nil, orderError(err, "cancel", req.OrderId)
+	}
+	return &cryptexv1.CancelOrderResponse{OrderId: req.OrderId}, nil
+}
+
+func (s *Server) AmendOrder(ctx context.Context, req *cryptexv1.AmendOrderRequest) (*cryptexv1.AmendOrderResponse, error) {
+	user, _ := userFromContext(ctx)
+	orderStatusAfter, err := s.Service.AmendUserOrder(ctx, user.ID, req.OrderId, services.Money(req.Price), services.Money(req.Size))
+	if err != nil {
+		return nil, orderError(err, "amend", req.OrderId)
+	}
+	return &cryptexv1.AmendOrderResponse{OrderId: req.OrderId, Status: orderStatus(orderStatusAfter)}, nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *cryptexv1.GetOrderRequest) (*cryptexv1.Order, error) {
+	user, _ := userFromContext(ctx)
+	state, ok := s.Service.Orders.Get(req.OrderId)
+	// Someone else's order is reported as missing, so order IDs can't be probed.
+	if !ok || state.UserID != user.ID {
+		return nil, status.Error(codes.NotFound, "order not found")
+	}
+	return order(state), nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *cryptexv1.ListOrdersRequest) (*cryptexv1.ListOrdersResponse, error) {
+	user, _ := userFromContext(ctx)
+	limit := int(req.Limit)
+	switch {
+	case limit == 0:
+		limit = defaultOrdersPageSize
+	case limit < 0 || limit > maxOrdersPageSize:
+		return nil, status.Error(codes.InvalidArgument, "limit must be between 1 and 500")
+	}
+	filter := services.OrderFilter{Status: req.Status, Market: services.Market(req.Market)}
+	states, next, err := s.Service.Orders.List(user.ID, filter, req.After, limit)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response := &cryptexv1.ListOrdersResponse{NextCursor: next}
+	for _, state := range states {
+		response.Orders = append(response.Orders, order(state))
+	}
+	return response, nil
+}
+
+func (s *Server) GetBook(ctx context.Context, req *cryptexv1.GetBookRequest) (*cryptexv1.Book, error) {
+	return s.book(services.Market(req.Market))
+}
+
+// book returns the levels of a market as of its last event.
+func (s *Server) book(market services.Market) (*cryptexv1.Book, error) {
+	orderBook, ok := s.Service.OrderBooks[market]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "market not found")
+	}
+	book := &cryptexv1.Book{Market: string(market)}
+	orderBook.Exclusive(func() {
+		book.Sequence = orderBook.Sequence()
+		book.Bids = levels(orderBook.SortBids())
+		book.Asks = levels(orderBook.SortAsk())
+	})
+	return book, nil
+}
+
+// SubscribeBook sends a snapshot of the market's levels, then the level changes that follow it.
+// A subscriber that falls behind is cut off with ResourceExhausted, and resubscribes for a new
+// snapshot.
+func (s *Server) SubscribeBook(req *cryptexv1.SubscribeBookRequest, stream cryptexv1.Exchange_SubscribeBookServer) error {
+	market := services.Market(req.Market)
+	// Subscribe before taking the snapshot, so no change falls between the two.
+	subscriber := s.Service.Events.Subscribe("grpc:book:"+req.Market, streamBuffer, messaging.Disconnect, func(event messaging.Event) bool {
+		level, ok := event.(services.LevelChanged)
+		return ok && level.Market == market
+	})
+	defer s.Service.Events.Unsubscribe(subscriber)
+	snapshot, err := s.book(market)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&cryptexv1.BookUpdate{Update: &cryptexv1.BookUpdate_Snapshot{Snapshot: snapshot}}); err != nil {
+		return err
+	}
+	return forward(stream.Context(), subscriber, func(event messaging.Event) error {
+		level := event.(services.LevelChanged)
+		if level.Sequence <= snapshot.Sequence {
+			return nil
+		}
+		update := &cryptexv1.LevelUpdate{
+			Market:     string(level.Market),
+			Sequence:   level.Sequence,
+			Timestamp:  level.Timestamp,
+			Side:       side(level.Bid),
+			Price:      float64(level.Price),
+			Size:       float64(level.TotalVolume),
+			OrderCount: int32(level.OrderCount),
+		}
+		return stream.Send(&cryptexv1.BookUpdate{Update: &cryptexv1.BookUpdate_Level{Level: update}})
+	})
+}
+
+// SubscribeTrades sends the market's trades, without the orders and accounts behind them.
+func (s *Server) SubscribeTrades(req *cryptexv1.SubscribeTradesRequest, stream cryptexv1.Exchange_SubscribeTradesServer) error {
+	market := services.Market(req.Market)
+	if _, ok := s.Service.OrderBooks[market]; !ok {
+		return status.Error(codes.InvalidArgument, "market not found")
+	}
+	subscriber := s.Service.Events.Subscribe("grpc:trades:"+req.Market, streamBuffer, messaging.Disconnect, func(event messaging.Event) bool {
+		trade, ok := event.(services.TradeExecuted)
+		return ok && trade.Market == market
+	})
+	defer s.Service.Events.Unsubscribe(subscriber)
+	return forward(stream.Context(), subscriber, func(event messaging.Event) error {
+		trade := event.(services.TradeExecuted)
+		return stream.Send(&cryptexv1.Trade{
+			Market:    string(trade.Market),
+			Sequence:  trade.Sequence,
+			Timestamp: trade.Timestamp,
+			TradeId:   trade.TradeID,
+			Price:     float64(trade.Price),
+			Size:      float64(trade.Size),
+			TakerSide: side(trade.TakerBid),
+		})
+	})
+}
+
+// forward sends the subscriber's events until the client goes away or the subscriber is cut off.
+func forward(ctx context.Context, subscriber *messaging.Subscriber, send func(messaging.Event) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "too slow")
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func levels(limits []*services.Limit) []*cryptexv1.Level {
+	var out []*cryptexv1.Level
+	for _, limit := range limits {
+		out = append(out, &cryptexv1.Level{Price: float64(limit.Price), Size: float64(limit.TotalVolume), OrderCount: int32(len(limit.Orders))})
+	}
+	return out
+}
+
+func side(bid bool) cryptexv1.Side {
+	if bid {
+		return cryptexv1.Side_SIDE_BUY
+	}
+	return cryptexv1.Side_SIDE_SELL
+}
+
+// remainder leaves REMAINDER_UNSPECIFIED to the service's default, as an omitted remainder is
+// over REST. Values this server doesn't know are passed on for the service to reject.
+func remainder(r cryptexv1.Remainder) services.RemainderAction {
+	switch r {
+	case cryptexv1.Remainder_REMAINDER_UNSPECIFIED:
+		return ""
+	case cryptexv1.Remainder_REMAINDER_CANCEL:
+		return services.RemainderCancel
+	case cryptexv1.Remainder_REMAINDER_REST:
+		return services.RemainderRest
+	}
+	return services.RemainderAction(r.String())
+}
+
+var orderStatuses = map[services.OrderStatus]cryptexv1.OrderStatus{
+	services.StatusNew:             cryptexv1.OrderStatus_ORDER_STATUS_NEW,
+	services.StatusPartiallyFilled: cryptexv1.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED,
+	services.StatusFilled:          cryptexv1.OrderStatus_ORDER_STATUS_FILLED,
+	services.StatusCancelled:       cryptexv1.OrderStatus_ORDER_STATUS_CANCELLED,
+	services.StatusRejected:        cryptexv1.OrderStatus_ORDER_STATUS_REJECTED,
+	services.StatusExpired:         cryptexv1.OrderStatus_ORDER_STATUS_EXPIRED,
+}
+
+func orderStatus(s services.OrderStatus) cryptexv1.OrderStatus {
+	return orderStatuses[s]
+}
+
+func order(state services.OrderState) *cryptexv1.Order {
+	o := &cryptexv1.Order{
+		Id:           state.ID,
+		Market:       string(state.Market),
+		Side:         side(state.Bid),
+		Type:         cryptexv1.OrderType_ORDER_TYPE_LIMIT,
+		Price:        float64(state.Price),
+		Size:         float64(state.Size),
+		FilledSize:   float64(state.FilledSize),
+		AveragePrice: float64(state.AveragePrice),
+		Status:       orderStatus(state.Status),
+		Reason:       state.Reason,
+		CreatedAt:    state.CreatedAt,
+		UpdatedAt:    state.UpdatedAt,
+		ExpiresAt:    state.ExpiresAt,
+	}
+	if state.Type == services.OrderTypeMarket {
+		o.Type = cryptexv1.OrderType_ORDER_TYPE_MARKET
+	}
+	for _, fill := range state.Fills {
+		o.Fills = append(o.Fills, &cryptexv1.Fill{
+			TradeId:   fill.TradeID,
+			Price:     float64(fill.Price),
+			Size:      float64(fill.Size),
+			Liquidity: string(fill.Liquidity),
+			Timestamp: fill.Timestamp,
+		})
+	}
+	return o
+}
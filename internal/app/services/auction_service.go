@@ -0,0 +1,245 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// EventIndicativePriceChanged is published during an auction whenever the uncross it would produce changes.
+const EventIndicativePriceChanged = "auction.indicative_price_changed"
+
+// LiquidityAuction marks fills executed when an auction uncrossed, where neither side took liquidity.
+const LiquidityAuction Liquidity = "auction"
+
+// StateReasonAuctionUncrossed is the reason a market opens after its auction.
+const StateReasonAuctionUncrossed = "auction_uncrossed"
+
+var ErrMarketInAuction = errors.New("market orders are not accepted during an auction")
+
+// AuctionResult is what uncrossing the book would do right now. A zero Volume means nothing crosses.
+type AuctionResult struct {
+	Price  Money `json:"price"`
+	Volume Money `json:"volume"`
+	// Imbalance is the bid volume minus the ask volume willing to trade at Price.
+	Imbalance Money `json:"imbalance"`
+}
+
+// IndicativePriceChanged is published during an auction when its indicative price or volume changes.
+type IndicativePriceChanged struct {
+	EventHeader
+	AuctionResult
+}
+
+func (IndicativePriceChanged) EventType() string { return EventIndicativePriceChanged }
+
+// IndicativeAuction computes the clearing price of the book: the price executing the most volume.
+// Ties go to the smallest imbalance, then to market pressure (the highest price when every tied
+// price has more bids than asks, the lowest when it has more asks), then to the price closest
+// to the last trade, and finally to the lowest price.
+func (ob *CompleteOrderBook) IndicativeAuction() AuctionResult {
+	var candidates []AuctionResult
+	for _, limits := range [][]*Limit{ob.Bids, ob.Asks} {
+		for _, limit := range limits {
+			demand, supply := ob.volumeAt(limit.Price)
+			volume := math.Min(float64(demand), float64(supply))
+			if volume > 0 {
+				candidates = append(candidates, AuctionResult{Price: limit.Price, Volume: Money(volume), Imbalance: demand - supply})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return AuctionResult{}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Volume != b.Volume {
+			return a.Volume > b.Volume
+		}
+		if math.Abs(float64(a.Imbalance)) != math.Abs(float64(b.Imbalance)) {
+			return math.Abs(float64(a.Imbalance)) < math.Abs(float64(b.Imbalance))
+		}
+		return a.Price < b.Price
+	})
+	best := candidates[0]
+	tied := candidates[:1]
+	for _, candidate := range candidates[1:] {
+		if candidate.Volume != best.Volume || math.Abs(float64(candidate.Imbalance)) != math.Abs(float64(best.Imbalance)) {
+			break
+		}
+		if candidate.Price != tied[len(tied)-1].Price {
+			tied = append(tied, candidate)
+		}
+	}
+	if len(tied) == 1 {
+		return best
+	}
+
+	buyPressure, sellPressure := true, true
+	for _, candidate := range tied {
+		buyPressure = buyPressure && candidate.Imbalance > 0
+		sellPressure = sellPressure && candidate.Imbalance < 0
+	}
+	switch {
+	case buyPressure:
+		return tied[len(tied)-1]
+	case sellPressure:
+		return tied[0]
+	case ob.lastPrice > 0:
+		closest := tied[0]
+		for _, candidate := range tied[1:] {
+			if math.Abs(float64(candidate.Price-ob.lastPrice)) < math.Abs(float64(closest.Price-ob.lastPrice)) {
+				closest = candidate
+			}
+		}
+		return closest
+	}
+	return tied[0]
+}
+
+// volumeAt returns the bid volume willing to buy at or above the price and the ask volume willing to sell at or below it.
+func (ob *CompleteOrderBook) volumeAt(price Money) (demand, supply Money) {
+	for _, limit := range ob.Bids {
+		if limit.Price >= price {
+			demand += limit.TotalVolume
+		}
+	}
+	for _, limit := range ob.Asks {
+		if limit.Price <= price {
+			supply += limit.TotalVolume
+		}
+	}
+	return demand, supply
+}
+
+// Uncross matches every crossing order at the clearing price, in price-time priority on both sides.
+func (ob *CompleteOrderBook) Uncross(now time.Time) []MatchEngine {
+	result := ob.IndicativeAuction()
+	if result.Volume == 0 {
+		return nil
+	}
+
+	var bids, asks []*Order
+	for _, limit := range ob.SortBids() {
+		if limit.Price >= result.Price {
+			bids = append(bids, limit.Orders...)
+		}
+	}
+	for _, limit := range ob.SortAsk() {
+		if limit.Price <= result.Price {
+			asks = append(asks, limit.Orders...)
+		}
+	}
+
+	matches := []MatchEngine{}
+	// touched lists the limits the uncross filled, in the order it reached them.
+	var touched []*Limit
+	seen := map[*Limit]bool{}
+	touch := func(l *Limit) {
+		if !seen[l] {
+			seen[l] = true
+			touched = append(touched, l)
+		}
+	}
+	remaining := result.Volume
+	for i, j := 0, 0; remaining > 0 && i < len(bids) && j < len(asks); {
+		bid, ask := bids[i], asks[j]
+		size := Money(math.Min(math.Min(float64(bid.Size), float64(ask.Size)), float64(remaining)))
+		bid.Size -= size
+		ask.Size -= size
+		bid.Limit.TotalVolume -= size
+		ask.Limit.TotalVolume -= size
+		remaining -= size
+		touch(bid.Limit)
+		touch(ask.Limit)
+
+		match := MatchEngine{ID: NewID(), Bid: bid, Ask: ask, SizeFilled: size, Price: result.Price}
+		matches = append(matches, match)
+		ob.publishTrade(match, nil)
+		ob.publishFill(bid, match.Price, size)
+		ob.publishFill(ask, match.Price, size)
+
+		if bid.IsFilled() {
+			i++
+		}
+		if ask.IsFilled() {
+			j++
+		}
+	}
+
+	for _, limit := range touched {
+		bid := ob.BidLimits[limit.Price] == limit
+		for _, o := range append(Orders(nil), limit.Orders...) {
+			if o.IsFilled() {
+				limit.DeleteOrder(o)
+				delete(ob.resting, o.ID)
+			}
+		}
+		if len(limit.Orders) == 0 {
+			ob.ClearLimit(bid, limit)
+		}
+		ob.publishLevel(bid, limit)
+	}
+
+	ob.lastPrice = result.Price
+	ob.recordFill(result.Price, now)
+	return matches
+}
+
+// refreshIndicative publishes the indicative price and volume of an ongoing auction when they change.
+func (ob *CompleteOrderBook) refreshIndicative() {
+	if ob.status.State != MarketAuction {
+		return
+	}
+	result := ob.IndicativeAuction()
+	if result == ob.indicative {
+		return
+	}
+	ob.indicative = result
+	ob.emit(func(header EventHeader) messaging.Event {
+		return IndicativePriceChanged{EventHeader: header, AuctionResult: result}
+	})
+}
+
+// Indicative returns the last indicative price and volume of the ongoing auction. The caller holds the book's lock.
+func (ob *CompleteOrderBook) Indicative() AuctionResult {
+	return ob.indicative
+}
+
+// startAuctionLocked moves the market into a call auction ending after the market's auction duration,
+// or when an admin opens the market if it has none. The caller holds the book's lock.
+func startAuctionLocked(orderBook *CompleteOrderBook, now time.Time, reason string) {
+	status := MarketStatus{State: MarketAuction, Reason: reason, Since: now.UnixNano()}
+	if orderBook.Config.AuctionDuration > 0 {
+		status.ResumeAt = now.Add(orderBook.Config.AuctionDuration).UnixNano()
+	}
+	orderBook.indicative = AuctionResult{}
+	orderBook.SetStatus(status)
+	orderBook.refreshIndicative()
+}
+
+// uncrossLocked ends the auction: crossing orders trade at the clearing price, then continuous trading starts.
+// It returns the matches to settle and the records of the orders they filled. The caller holds the book's lock.
+func uncrossLocked(orderBook *CompleteOrderBook, now time.Time) ([]MatchEngine, []OrderRecord) {
+	matches := orderBook.Uncross(now)
+	var records []OrderRecord
+	for _, match := range matches {
+		records = append(records, orderRecord(match.Bid), orderRecord(match.Ask))
+	}
+	orderBook.indicative = AuctionResult{}
+	orderBook.SetStatus(MarketStatus{State: MarketOpen, Reason: StateReasonAuctionUncrossed, Since: now.UnixNano()})
+	return matches, records
+}
+
+// reopenLocked ends a halt, through a reopening auction when the market has one. The caller holds the book's lock.
+func reopenLocked(orderBook *CompleteOrderBook, now time.Time, reason string) {
+	if orderBook.Config.AuctionDuration > 0 {
+		startAuctionLocked(orderBook, now, reason)
+		return
+	}
+	orderBook.SetStatus(MarketStatus{State: MarketOpen, Reason: reason, Since: now.UnixNano()})
+}
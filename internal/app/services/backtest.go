@@ -0,0 +1,444 @@
+package services
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// FlowUserID owns the orders of the flow lines that name no user.
+const FlowUserID = "flow"
+
+// LatencyModel delays every batch of a strategy's instructions by Base plus a uniform draw below
+// Jitter, between the event the strategy saw and the book receiving them.
+type LatencyModel struct {
+	Base   time.Duration `json:"base"`
+	Jitter time.Duration `json:"jitter"`
+}
+
+// BacktestConfig is the market a backtest simulates.
+type BacktestConfig struct {
+	Market  Market
+	Config  MarketConfig
+	Latency LatencyModel
+	// Seed drives the latency draws: a backtest of the same flow, strategies and seed always gives
+	// the same report.
+	Seed int64
+}
+
+// Participant is a strategy trading in a backtest for an account.
+type Participant struct {
+	UserID   string
+	Strategy Strategy
+}
+
+// BacktestFill is a fill of a participant's order.
+type BacktestFill struct {
+	Time    int64  `json:"time"` // virtual unix nanoseconds.
+	OrderID string `json:"orderId"`
+	Bid     bool   `json:"bid"`
+	Price   Money  `json:"price"`
+	Size    Money  `json:"size"`
+	Maker   bool   `json:"maker"` // the order was resting.
+}
+
+// ParticipantReport is how a participant did over a backtest.
+type ParticipantReport struct {
+	UserID   string         `json:"userId"`
+	Fills    []BacktestFill `json:"fills"`
+	Position Position       `json:"position"`
+	// PnL is the realized profit plus the open position marked at the report's mark price.
+	PnL   Money      `json:"pnl"`
+	Stats MakerStats `json:"stats"`
+}
+
+// LatencyStats summarizes the latencies drawn from the model.
+type LatencyStats struct {
+	Samples int           `json:"samples"`
+	Mean    time.Duration `json:"mean"`
+	Max     time.Duration `json:"max"`
+}
+
+// BookMetrics describes the book, sampled after every event of the simulation.
+type BookMetrics struct {
+	Samples int `json:"samples"`
+	// AverageSpread and MaxSpread cover the samples with both sides quoted and not crossed.
+	AverageSpread Money `json:"averageSpread"`
+	MaxSpread     Money `json:"maxSpread"`
+	// AverageTopDepth is the mean size at the best bid and ask together.
+	AverageTopDepth Money `json:"averageTopDepth"`
+	OneSided        int   `json:"oneSided"` // samples with an empty side.
+	Crossed         int   `json:"crossed"`  // samples with the best bid at or above the best ask.
+	FinalBestBid    Money `json:"finalBestBid"`
+	FinalBestAsk    Money `json:"finalBestAsk"`
+}
+
+// BacktestReport is the outcome of a backtest.
+type BacktestReport struct {
+	Seed   int64 `json:"seed"`
+	Events int   `json:"events"` // lines of flow replayed.
+	// Rejected counts flow orders the market refused, and MissedCancels cancels of orders no
+	// longer resting.
+	Rejected      int                 `json:"rejected"`
+	MissedCancels int                 `json:"missedCancels"`
+	Trades        int                 `json:"trades"`
+	Volume        Money               `json:"volume"`
+	Mark          Money               `json:"mark"` // last trade, or the final mid without trades.
+	Participants  []ParticipantReport `json:"participants"`
+	Latency       LatencyStats        `json:"latency"`
+	Book          BookMetrics         `json:"book"`
+}
+
+// Backtest replays order flow through the matching code of a CompleteOrderBook on a virtual clock,
+// with strategies trading alongside. Strategy orders pass the checks of the exchange service: the
+// market state and the market's tick, lot and size rules. Everything runs on one goroutine, in
+// virtual time order, so runs are reproducible. The book runs on the virtual clock too, so order
+// and event timestamps and the circuit breaker follow the flow's time.
+type Backtest struct {
+	config       BacktestConfig
+	participants []Participant
+}
+
+// NewBacktest creates a backtest of the market for the participants.
+func NewBacktest(config BacktestConfig, participants ...Participant) *Backtest {
+	return &Backtest{config: config, participants: participants}
+}
+
+// Run replays the flow, in time order, and reports fills, profit, latencies and book metrics.
+// Participants start quoting at the time of the first event, before it is replayed. Every run
+// starts from an empty book.
+func (b *Backtest) Run(flow []FlowEvent) BacktestReport {
+	sim := newSimulation(b.config, b.participants)
+	if len(flow) > 0 {
+		sim.advance(flow[0].Time)
+		for _, p := range sim.participants {
+			sim.consult(p, nil)
+		}
+	}
+	for i, event := range flow {
+		i, event := i, event
+		sim.schedule(event.Time, func() { sim.replay(i, event) })
+	}
+	for sim.queue.Len() > 0 {
+		next := heap.Pop(&sim.queue).(scheduledAction)
+		sim.advance(next.at)
+		next.run()
+		sim.dispatch()
+		sim.sample()
+	}
+	return sim.report(len(flow))
+}
+
+// scheduledAction runs at a virtual time. Actions of the same time run in the order they were scheduled.
+type scheduledAction struct {
+	at  int64
+	seq uint64
+	run func()
+}
+
+type actionQueue []scheduledAction
+
+func (q actionQueue) Len() int { return len(q) }
+func (q actionQueue) Less(i, j int) bool {
+	return q[i].at < q[j].at || (q[i].at == q[j].at && q[i].seq < q[j].seq)
+}
+func (q actionQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *actionQueue) Push(x any)   { *q = append(*q, x.(scheduledAction)) }
+func (q *actionQueue) Pop() any {
+	last := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return last
+}
+
+// eventLog collects the events of the simulated book until they are dispatched.
+type eventLog struct {
+	events []messaging.Event
+}
+
+func (l *eventLog) Publish(event messaging.Event) {
+	l.events = append(l.events, event)
+}
+
+type simulatedParticipant struct {
+	Participant
+	position Position
+	fills    []BacktestFill
+	stats    MakerStats
+	orders   int
+	// inFlight is set while instructions are on their way to the book, and missed when events
+	// went by meanwhile.
+	inFlight, missed bool
+}
+
+// simulation is the state of one backtest run.
+type simulation struct {
+	book         *CompleteOrderBook
+	clock        *ManualClock
+	log          *eventLog
+	latency      LatencyModel
+	random       *rand.Rand
+	participants []*simulatedParticipant
+
+	now       int64
+	seq       uint64
+	queue     actionQueue
+	lastPrice Money
+
+	seed                            int64
+	rejected, missedCancels, trades int
+	volume                          Money
+	latencies                       []time.Duration
+	metrics                         BookMetrics
+	spreadSamples                   int
+	spreadTotal, depthTotal         Money
+}
+
+func newSimulation(config BacktestConfig, participants []Participant) *simulation {
+	recorded := &eventLog{}
+	clock := NewManualClock(time.Unix(0, 0))
+	book := NewOrderBook()
+	book.Market = config.Market
+	book.Config = config.Config
+	book.Clock = clock
+	book.Events = recorded
+	sim := &simulation{
+		book:    book,
+		clock:   clock,
+		log:     recorded,
+		latency: config.Latency,
+		random:  rand.New(rand.NewSource(config.Seed)),
+		seed:    config.Seed,
+	}
+	for _, p := range participants {
+		sim.participants = append(sim.participants, &simulatedParticipant{Participant: p})
+	}
+	return sim
+}
+
+// advance moves virtual time, and the book's clock, to at.
+func (s *simulation) advance(at int64) {
+	s.now = at
+	s.clock.Set(time.Unix(0, at))
+}
+
+func (s *simulation) schedule(at int64, run func()) {
+	s.seq++
+	heap.Push(&s.queue, scheduledAction{at: at, seq: s.seq, run: run})
+}
+
+// replay applies one line of flow to the book.
+func (s *simulation) replay(i int, event FlowEvent) {
+	userID := event.UserID
+	if userID == "" {
+		userID = FlowUserID
+	}
+	switch event.Action {
+	case FlowPlace, FlowTrade:
+		o := NewOrderAt(event.Bid, event.Size, s.now)
+		o.ID, o.UserID, o.Market = event.ID, userID, s.book.Market
+		if o.ID == "" {
+			o.ID = fmt.Sprintf("%s-%d", FlowUserID, i+1)
+		}
+		orderType, price := OrderTypeLimit, event.Price
+		if event.Action == FlowTrade || event.Price == 0 {
+			orderType, price = OrderTypeMarket, 0
+		}
+		if event.Action == FlowTrade && event.Price > 0 {
+			o.Protection = Protection{Price: event.Price, Remainder: RemainderCancel}
+			if event.Rest {
+				o.Protection.Remainder = RemainderRest
+			}
+		}
+		if _, err := s.book.submit(orderType, price, o); err != nil {
+			s.rejected++
+		}
+	case FlowCancel:
+		o, resting := s.book.GetOrder(event.ID)
+		if !resting {
+			s.missedCancels++
+			return
+		}
+		s.book.CancelOrder(o)
+	}
+}
+
+// dispatch hands the events of the last action to every participant, updating positions first.
+func (s *simulation) dispatch() {
+	events := s.log.events
+	s.log.events = nil
+	for _, event := range events {
+		trade, isTrade := event.(TradeExecuted)
+		if isTrade {
+			s.trades++
+			s.volume += trade.Size
+			s.lastPrice = trade.Price
+			for _, p := range s.participants {
+				if trade.BuyerID == p.UserID {
+					p.fill(s.now, trade, true)
+				}
+				if trade.SellerID == p.UserID {
+					p.fill(s.now, trade, false)
+				}
+			}
+		}
+		if isTrade || event.EventType() == EventLevelChanged {
+			for _, p := range s.participants {
+				s.consult(p, event)
+			}
+		}
+	}
+}
+
+func (p *simulatedParticipant) fill(now int64, trade TradeExecuted, bid bool) {
+	orderID := trade.SellOrderID
+	if bid {
+		orderID = trade.BuyOrderID
+	}
+	p.position.Apply(bid, trade.Price, trade.Size)
+	p.fills = append(p.fills, BacktestFill{
+		Time: now, OrderID: orderID, Bid: bid, Price: trade.Price, Size: trade.Size,
+		Maker: orderID != trade.TakerOrderID,
+	})
+}
+
+// consult asks a participant's strategy about an event and schedules its instructions to reach
+// the book after a latency drawn from the model. Like a client waiting for its acknowledgements,
+// a participant is not consulted while its instructions are in flight; once they land, it sees
+// the book again if events went by.
+func (s *simulation) consult(p *simulatedParticipant, event messaging.Event) {
+	if p.inFlight {
+		p.missed = true
+		return
+	}
+	view := s.book.viewLocked(p.UserID)
+	view.LastPrice, view.Position = s.lastPrice, p.position
+	instructions := p.Strategy.OnEvent(event, view)
+	if len(instructions) == 0 {
+		return
+	}
+	delay := s.latency.Base
+	if s.latency.Jitter > 0 {
+		delay += time.Duration(s.random.Int63n(int64(s.latency.Jitter)))
+	}
+	s.latencies = append(s.latencies, delay)
+	p.inFlight = true
+	s.schedule(s.now+int64(delay), func() {
+		for _, instruction := range instructions {
+			s.execute(p, instruction)
+		}
+		p.inFlight = false
+		if p.missed {
+			p.missed = false
+			s.consult(p, nil)
+		}
+	})
+}
+
+// execute carries out a strategy instruction the way the exchange service would.
+func (s *simulation) execute(p *simulatedParticipant, instruction QuoteInstruction) {
+	switch instruction.Action {
+	case QuotePlace:
+		p.orders++
+		o := NewOrderAt(instruction.Bid, instruction.Size, s.now)
+		o.ID, o.UserID, o.Market = fmt.Sprintf("%s-%d", p.UserID, p.orders), p.UserID, s.book.Market
+		if _, err := s.book.submit(OrderTypeLimit, instruction.Price, o); err != nil {
+			p.stats.Rejected++
+			return
+		}
+		p.stats.Placed++
+	case QuoteCancel:
+		o, err := userOrderLocked(s.book, p.UserID, instruction.OrderID)
+		if err != nil {
+			p.stats.Rejected++
+			return
+		}
+		s.book.CancelOrder(o)
+		p.stats.Cancelled++
+	case QuoteCancelAll:
+		for _, quote := range s.book.viewLocked(p.UserID).Quotes {
+			o, _ := s.book.GetOrder(quote.OrderID)
+			s.book.CancelOrder(o)
+			p.stats.Cancelled++
+		}
+	}
+}
+
+// sample adds the state of the book to the metrics.
+func (s *simulation) sample() {
+	s.metrics.Samples++
+	bestBid, hasBid := s.book.bestPrice(true)
+	bestAsk, hasAsk := s.book.bestPrice(false)
+	s.metrics.FinalBestBid, s.metrics.FinalBestAsk = bestBid, bestAsk
+	switch {
+	case !hasBid || !hasAsk:
+		s.metrics.OneSided++
+	case bestBid >= bestAsk:
+		s.metrics.Crossed++
+	default:
+		spread := bestAsk - bestBid
+		s.spreadSamples++
+		s.spreadTotal += spread
+		if spread > s.metrics.MaxSpread {
+			s.metrics.MaxSpread = spread
+		}
+	}
+	if hasBid {
+		s.depthTotal += s.book.BidLimits[bestBid].TotalVolume
+	}
+	if hasAsk {
+		s.depthTotal += s.book.AskLimits[bestAsk].TotalVolume
+	}
+}
+
+func (s *simulation) report(events int) BacktestReport {
+	report := BacktestReport{
+		Seed:          s.seed,
+		Events:        events,
+		Rejected:      s.rejected,
+		MissedCancels: s.missedCancels,
+		Trades:        s.trades,
+		Volume:        s.volume,
+		Mark:          s.lastPrice,
+		Participants:  []ParticipantReport{},
+		Book:          s.metrics,
+	}
+	if report.Mark == 0 && s.metrics.FinalBestBid > 0 && s.metrics.FinalBestAsk > 0 {
+		report.Mark = (s.metrics.FinalBestBid + s.metrics.FinalBestAsk) / 2
+	}
+	if s.spreadSamples > 0 {
+		report.Book.AverageSpread = s.spreadTotal / Money(s.spreadSamples)
+	}
+	if s.metrics.Samples > 0 {
+		report.Book.AverageTopDepth = s.depthTotal / Money(s.metrics.Samples)
+	}
+
+	report.Latency.Samples = len(s.latencies)
+	var total time.Duration
+	for _, latency := range s.latencies {
+		total += latency
+		if latency > report.Latency.Max {
+			report.Latency.Max = latency
+		}
+	}
+	if len(s.latencies) > 0 {
+		report.Latency.Mean = total / time.Duration(len(s.latencies))
+	}
+
+	for _, p := range s.participants {
+		fills := p.fills
+		if fills == nil {
+			fills = []BacktestFill{}
+		}
+		report.Participants = append(report.Participants, ParticipantReport{
+			UserID:   p.UserID,
+			Fills:    fills,
+			Position: p.position,
+			PnL:      p.position.PnL(report.Mark),
+			Stats:    p.stats,
+		})
+	}
+	return report
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// MaxBatchOperations caps the operations of a single batch.
+const MaxBatchOperations = 100
+
+var (
+	ErrEmptyBatch       = errors.New("batch has no operations")
+	ErrBatchTooLarge    = fmt.Errorf("batch has more than %d operations", MaxBatchOperations)
+	ErrUnknownBatchOp   = errors.New("unknown batch operation")
+	ErrBatchRolledBack  = errors.New("batch rolled back")
+	ErrUnknownOrderType = errors.New("unknown order type")
+	ErrAmendNotResting  = errors.New("only resting orders can be amended")
+)
+
+// BatchOp names an operation of a batch.
+type BatchOp string
+
+const (
+	BatchPlace  BatchOp = "place"
+	BatchCancel BatchOp = "cancel"
+	BatchAmend  BatchOp = "amend"
+)
+
+// BatchOperation is one step of a batch. Place uses Type, Bid, Price, Size and ExpiresAt;
+// cancel uses OrderID; amend uses OrderID with the new Price and Size, the size left to fill.
+type BatchOperation struct {
+	Op        BatchOp
+	OrderID   string
+	Type      OrderType
+	Bid       bool
+	Price     Money
+	Size      Money
+	ExpiresAt int64
+}
+
+// BatchResult is the outcome of one operation. Error is empty when the operation succeeded.
+type BatchResult struct {
+	Op      BatchOp     `json:"op"`
+	OrderID string      `json:"orderId,omitempty"`
+	Status  OrderStatus `json:"status,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// ExecuteBatch applies the operations to the market's book in order, without letting anything
+// else match in between, and returns a result per operation. When atomic is set, the first
+// failing operation rolls back the whole batch: the book is left as it was and no event is published.
+func (s *CryptoExchangeService) ExecuteBatch(ctx context.Context, market Market, userID string, operations []BatchOperation, atomic bool) ([]BatchResult, bool, error) {
+	orderBook, ok := s.OrderBooks[market]
+	if !ok {
+		return nil, false, ErrMarketNotFound
+	}
+	switch {
+	case len(operations) == 0:
+		return nil, false, ErrEmptyBatch
+	case len(operations) > MaxBatchOperations:
+		return nil, false, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(operations))
+	var (
+		matches    []MatchEngine
+		records    []OrderRecord
+		rolledBack bool
+	)
+	orderBook.Exclusive(func() {
+		var tx *bookTransaction
+		if atomic {
+			tx = beginBookTransaction(orderBook)
+		}
+
+		for i, operation := range operations {
+			result, opMatches, opRecords, err := s.applyLocked(orderBook, market, userID, operation)
+			results[i] = result
+			if err != nil {
+				results[i].Error = err.Error()
+				if atomic {
+					rolledBack = true
+					break
+				}
+				continue
+			}
+			matches = append(matches, opMatches...)
+			records = append(records, opRecords...)
+		}
+
+		if !atomic {
+			return
+		}
+		if rolledBack {
+			tx.rollback()
+			matches, records = nil, nil
+			for i := range results {
+				if results[i].Error == "" {
+					results[i] = BatchResult{Op: operations[i].Op, OrderID: operations[i].OrderID, Error: ErrBatchRolledBack.Error()}
+				}
+			}
+			return
+		}
+		tx.commit()
+	})
+
+	if err := s.settle(ctx, market, matches); err != nil {
+		return results, rolledBack, err
+	}
+	return results, rolledBack, s.saveRecords(ctx, records...)
+}
+
+// applyLocked runs one operation of a batch. The caller holds the book's lock.
+func (s *CryptoExchangeService) applyLocked(orderBook *CompleteOrderBook, market Market, userID string, operation BatchOperation) (BatchResult, []MatchEngine, []OrderRecord, error) {
+	result := BatchResult{Op: operation.Op, OrderID: operation.OrderID}
+	switch operation.Op {
+	case BatchPlace:
+		if operation.Type != OrderTypeLimit && operation.Type != OrderTypeMarket {
+			return result, nil, nil, ErrUnknownOrderType
+		}
+		o := NewOrder(operation.Bid, operation.Size)
+		o.UserID = userID
+		o.ExpiresAt = operation.ExpiresAt
+		result.OrderID = o.ID
+		matches, records, err := s.placeLocked(orderBook, market, operation.Type, operation.Price, o)
+		result.Status = o.Status()
+		if err != nil {
+			result.Status = StatusRejected
+		}
+		return result, matches, records, err
+
+	case BatchCancel:
+		record, err := s.cancelLocked(orderBook, userID, operation.OrderID)
+		if err != nil {
+			return result, nil, nil, err
+		}
+		result.Status = record.Status
+		return result, nil, []OrderRecord{record}, nil
+
+	case BatchAmend:
+		o, err := userOrderLocked(orderBook, userID, operation.OrderID)
+		if err != nil {
+			return result, nil, nil, ErrAmendNotResting
+		}
+		if err := orderBook.acceptsOrders(OrderTypeLimit); err != nil {
+			return result, nil, nil, err
+		}
+		if err := orderBook.Config.Validate(OrderTypeLimit, operation.Price, operation.Size); err != nil {
+			return result, nil, nil, err
+		}
+		price, size := orderBook.Config.Snap(operation.Price, operation.Size)
+		if err := orderBook.reserveAmend(o, price, size); err != nil {
+			return result, nil, nil, err
+		}
+		orderBook.AmendOrder(o, price, size)
+		result.Status = o.Status()
+		return result, nil, []OrderRecord{orderRecord(o)}, nil
+	}
+	return result, nil, nil, ErrUnknownBatchOp
+}
+
+// bookTransaction holds back the changes made to a book, and the events they publish, until they
+// are committed or rolled back. Wallets are settled as the changes are made, and put back on
+// rollback. The caller holds the book's lock from begin to end.
+type bookTransaction struct {
+	book     *CompleteOrderBook
+	snapshot bookSnapshot
+	events   messaging.Publisher
+	buffered *eventBuffer
+	journal  *fundsJournal
+}
+
+func beginBookTransaction(orderBook *CompleteOrderBook) *bookTransaction {
+	tx := &bookTransaction{book: orderBook, snapshot: orderBook.snapshot()}
+	if orderBook.Events != nil {
+		tx.events, tx.buffered = orderBook.Events, &eventBuffer{}
+		orderBook.Events = tx.buffered
+	}
+	if orderBook.funds != nil {
+		tx.journal = &fundsJournal{funds: orderBook.funds}
+		orderBook.funds = tx.journal
+	}
+	return tx
+}
+
+// commit keeps the changes and publishes the events held back.
+func (tx *bookTransaction) commit() {
+	if tx.journal != nil {
+		tx.book.funds = tx.journal.funds
+	}
+	if tx.buffered == nil {
+		return
+	}
+	tx.book.Events = tx.events
+	for _, event := range *tx.buffered {
+		tx.events.Publish(event)
+	}
+}
+
+// rollback puts the book and the wallets back as they were and drops the events held back.
+func (tx *bookTransaction) rollback() {
+	if tx.buffered != nil {
+		tx.book.Events = tx.events
+	}
+	if tx.journal != nil {
+		tx.book.funds = tx.journal.funds
+		tx.journal.rollback()
+	}
+	tx.book.restore(tx.snapshot)
+}
+
+// eventBuffer holds back the events of an all-or-nothing batch until it commits.
+type eventBuffer []messaging.Event
+
+func (b *eventBuffer) Publish(event messaging.Event) {
+	*b = append(*b, event)
+}
+
+// bookSnapshot is everything a batch can change on a book, so an all-or-nothing batch can be undone.
+// Orders and limits keep their identity: restoring puts the same pointers back with their old values.
+type bookSnapshot struct {
+	asks, bids           []*Limit
+	askLimits, bidLimits map[Money]*Limit
+	limits               map[*Limit]Limit
+	orders               map[*Order]Order
+	resting              map[string]*Order
+	sequence             uint64
+	status               MarketStatus
+	recentFills          []pricePoint
+	lastPrice            Money
+	indicative           AuctionResult
+}
+
+// snapshot copies the state of the book. The caller holds the book's lock.
+func (ob *CompleteOrderBook) snapshot() bookSnapshot {
+	snapshot := bookSnapshot{
+		asks:      append([]*Limit(nil), ob.Asks...),
+		bids:      append([]*Limit(nil), ob.Bids...),
+		askLimits: make(map[Money]*Limit, len(ob.AskLimits)),
+		bidLimits: make(map[Money]*Limit, len(ob.BidLimits)),
+		limits:    make(map[*Limit]Limit),
+		orders:    make(map[*Order]Order, len(ob.resting)),
+		resting:   make(map[string]*Order, len(ob.resting)),
+		sequence:  ob.sequence,
+		status:    ob.status,
+		// Fills are only ever appended or dropped from the front, so the slice can be shared.
+		recentFills: ob.recentFills,
+		lastPrice:   ob.lastPrice,
+		indicative:  ob.indicative,
+	}
+	for price, limit := range ob.AskLimits {
+		snapshot.askLimits[price] = limit
+	}
+	for price, limit := range ob.BidLimits {
+		snapshot.bidLimits[price] = limit
+	}
+	for _, limits := range [][]*Limit{ob.Asks, ob.Bids} {
+		for _, limit := range limits {
+			copied := *limit
+			copied.Orders = append(Orders(nil), limit.Orders...)
+			snapshot.limits[limit] = copied
+		}
+	}
+	for id, o := range ob.resting {
+		snapshot.resting[id] = o
+		snapshot.orders[o] = *o
+	}
+	return snapshot
+}
+
+// restore puts the book back as it was when the snapshot was taken. The caller holds the book's lock.
+func (ob *CompleteOrderBook) restore(snapshot bookSnapshot) {
+	ob.Asks, ob.Bids = snapshot.asks, snapshot.bids
+	ob.AskLimits, ob.BidLimits = snapshot.askLimits, snapshot.bidLimits
+	for limit, saved := range snapshot.limits {
+		*limit = saved
+	}
+	for o, saved := range snapshot.orders {
+		*o = saved
+	}
+	ob.resting = snapshot.resting
+	ob.sequence = snapshot.sequence
+	ob.status = snapshot.status
+	ob.recentFills = snapshot.recentFills
+	ob.lastPrice = snapshot.lastPrice
+	ob.indicative = snapshot.indicative
+}
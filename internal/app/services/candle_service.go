@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// CandleInterval is the width of an OHLCV bar.
+type CandleInterval string
+
+const (
+	Interval1m  CandleInterval = "1m"
+	Interval5m  CandleInterval = "5m"
+	Interval15m CandleInterval = "15m"
+	Interval1h  CandleInterval = "1h"
+	Interval4h  CandleInterval = "4h"
+	Interval1d  CandleInterval = "1d"
+)
+
+// CandleIntervals lists every interval the candle service aggregates.
+var CandleIntervals = []CandleInterval{Interval1m, Interval5m, Interval15m, Interval1h, Interval4h, Interval1d}
+
+var intervalDurations = map[CandleInterval]time.Duration{
+	Interval1m:  time.Minute,
+	Interval5m:  5 * time.Minute,
+	Interval15m: 15 * time.Minute,
+	Interval1h:  time.Hour,
+	Interval4h:  4 * time.Hour,
+	Interval1d:  24 * time.Hour,
+}
+
+var (
+	ErrUnknownInterval = errors.New("unknown candle interval")
+	ErrTooManyCandles  = errors.New("requested range holds too many candles")
+)
+
+// MaxCandlesPerQuery bounds the size of a single candles response.
+const MaxCandlesPerQuery = 5000
+
+// maxCandleHistory is how many completed candles per market and interval are kept without a repository.
+const maxCandleHistory = 10_000
+
+// Duration returns the width of the interval.
+func (ci CandleInterval) Duration() (time.Duration, error) {
+	duration, ok := intervalDurations[ci]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownInterval, ci)
+	}
+	return duration, nil
+}
+
+// Candle is an OHLCV bar. Volume is in the base asset, QuoteVolume in the quote asset.
+type Candle struct {
+	Market      Market         `json:"market"`
+	Interval    CandleInterval `json:"interval"`
+	OpenTime    time.Time      `json:"openTime"`
+	Open        Money          `json:"open"`
+	High        Money          `json:"high"`
+	Low         Money          `json:"low"`
+	Close       Money          `json:"close"`
+	Volume      Money          `json:"volume"`
+	QuoteVolume Money          `json:"quoteVolume"`
+	Trades      int            `json:"trades"`
+}
+
+// CandleRepository stores completed candles.
+type CandleRepository interface {
+	SaveCandle(ctx context.Context, candle Candle) error
+	// ListCandles returns the candles opening within [from, to), oldest first.
+	ListCandles(ctx context.Context, market Market, interval CandleInterval, from, to time.Time) ([]Candle, error)
+	// LastCandleBefore returns the most recent candle opening before the given time, or ErrNotFound.
+	LastCandleBefore(ctx context.Context, market Market, interval CandleInterval, before time.Time) (Candle, error)
+}
+
+type candleKey struct {
+	market   Market
+	interval CandleInterval
+}
+
+// CandleService aggregates executed trades into OHLCV candles for every interval.
+type CandleService struct {
+	mu sync.Mutex
+	// Repository persists completed candles. They are kept in memory when it is nil.
+	Repository CandleRepository
+	// Clock decides when Run closes candles no trade came in to close. The wall clock is used when it is nil.
+	Clock   Clock
+	current map[candleKey]*Candle
+	history map[candleKey][]Candle
+}
+
+// NewCandleService creates a CandleService without a repository.
+func NewCandleService() *CandleService {
+	return &CandleService{
+		current: make(map[candleKey]*Candle),
+		history: make(map[candleKey][]Candle),
+	}
+}
+
+// Run aggregates the trades published on the dispatcher until the context is done,
+// and closes candles whose interval has ended even when no trade comes in.
+func (cs *CandleService) Run(ctx context.Context, events *messaging.Dispatcher) {
+	subscriber := events.Subscribe("candles", 4096, messaging.Block, func(event messaging.Event) bool {
+		return event.EventType() == EventTradeExecuted
+	})
+	defer events.Unsubscribe(subscriber)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cs.Flush(ctx, readClock(cs.Clock))
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				return
+			}
+			trade := event.(TradeExecuted)
+			cs.AddTrade(ctx, trade.Market, trade.Price, trade.Size, time.Unix(0, trade.Timestamp))
+		}
+	}
+}
+
+// AddTrade folds a trade into the open candle of every interval.
+func (cs *CandleService) AddTrade(ctx context.Context, market Market, price, size Money, at time.Time) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, interval := range CandleIntervals {
+		duration := intervalDurations[interval]
+		key := candleKey{market, interval}
+		openTime := at.UTC().Truncate(duration)
+
+		candle := cs.current[key]
+		if candle != nil && candle.OpenTime.Before(openTime) {
+			cs.complete(ctx, *candle)
+			candle = nil
+		}
+		if candle == nil {
+			candle = &Candle{
+				Market:   market,
+				Interval: interval,
+				OpenTime: openTime,
+				Open:     price,
+				High:     price,
+				Low:      price,
+			}
+			cs.current[key] = candle
+		}
+		// Trades arriving late for an already closed candle land in the open one.
+		if price > candle.High {
+			candle.High = price
+		}
+		if price < candle.Low {
+			candle.Low = price
+		}
+		candle.Close = price
+		candle.Volume += size
+		candle.QuoteVolume += price * size
+		candle.Trades++
+	}
+}
+
+// Flush completes every open candle whose interval ended before now.
+func (cs *CandleService) Flush(ctx context.Context, now time.Time) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for key, candle := range cs.current {
+		if !now.Before(candle.OpenTime.Add(intervalDurations[key.interval])) {
+			cs.complete(ctx, *candle)
+			delete(cs.current, key)
+		}
+	}
+}
+
+// complete persists a finished candle. The caller holds the lock.
+func (cs *CandleService) complete(ctx context.Context, candle Candle) {
+	if cs.Repository != nil {
+		if err := cs.Repository.SaveCandle(ctx, candle); err == nil {
+			return
+		}
+		// Keep the candle in memory rather than losing it.
+	}
+	key := candleKey{candle.Market, candle.Interval}
+	history := append(cs.history[key], candle)
+	if len(history) > maxCandleHistory {
+		history = history[len(history)-maxCandleHistory:]
+	}
+	cs.history[key] = history
+}
+
+// Candles returns the candles opening within [from, to). Intervals without trades are filled
+// with flat candles carrying the previous close forward; nothing is returned before the first trade.
+func (cs *CandleService) Candles(ctx context.Context, market Market, interval CandleInterval, from, to time.Time) ([]Candle, error) {
+	duration, err := interval.Duration()
+	if err != nil {
+		return nil, err
+	}
+	from = from.UTC().Truncate(duration)
+	if to.Sub(from)/duration > MaxCandlesPerQuery {
+		return nil, ErrTooManyCandles
+	}
+
+	traded, previous, err := cs.tradedCandles(ctx, market, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := []Candle{}
+	next := 0
+	for openTime := from; openTime.Before(to); openTime = openTime.Add(duration) {
+		if next < len(traded) && traded[next].OpenTime.Equal(openTime) {
+			previous = &traded[next]
+			candles = append(candles, traded[next])
+			next++
+			continue
+		}
+		if previous == nil {
+			continue
+		}
+		lastClose := previous.Close
+		candles = append(candles, Candle{
+			Market:   market,
+			Interval: interval,
+			OpenTime: openTime,
+			Open:     lastClose,
+			High:     lastClose,
+			Low:      lastClose,
+			Close:    lastClose,
+		})
+	}
+	return candles, nil
+}
+
+// tradedCandles collects the candles with trades in the range, completed and open, along with
+// the last candle before the range, which seeds the carried forward close.
+func (cs *CandleService) tradedCandles(ctx context.Context, market Market, interval CandleInterval, from, to time.Time) ([]Candle, *Candle, error) {
+	key := candleKey{market, interval}
+	var (
+		traded   []Candle
+		previous *Candle
+	)
+
+	if cs.Repository != nil {
+		var err error
+		if traded, err = cs.Repository.ListCandles(ctx, market, interval, from, to); err != nil {
+			return nil, nil, err
+		}
+		last, err := cs.Repository.LastCandleBefore(ctx, market, interval, from)
+		if err == nil {
+			previous = &last
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for i, candle := range cs.history[key] {
+		if candle.OpenTime.Before(from) {
+			if previous == nil || candle.OpenTime.After(previous.OpenTime) {
+				previous = &cs.history[key][i]
+			}
+		} else if candle.OpenTime.Before(to) {
+			traded = insertCandle(traded, candle)
+		}
+	}
+	if current := cs.current[key]; current != nil {
+		if current.OpenTime.Before(from) {
+			previous = current
+		} else if current.OpenTime.Before(to) {
+			traded = insertCandle(traded, *current)
+		}
+	}
+	if previous != nil {
+		copied := *previous
+		previous = &copied
+	}
+	return traded, previous, nil
+}
+
+// insertCandle adds a candle keeping the slice ordered by open time, replacing one with the same open time.
+func insertCandle(candles []Candle, candle Candle) []Candle {
+	for i := range candles {
+		if candles[i].OpenTime.Equal(candle.OpenTime) {
+			candles[i] = candle
+			return candles
+		}
+		if candles[i].OpenTime.After(candle.OpenTime) {
+			candles = append(candles[:i+1], candles[i:]...)
+			candles[i] = candle
+			return candles
+		}
+	}
+	return append(candles, candle)
+}
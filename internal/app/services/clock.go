@@ -0,0 +1,76 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to everything that stamps, expires or closes something on the exchange:
+// order books, the exchange service, the expiry sweeper and the candles. Tests and replays swap
+// the wall clock for one they control.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the wall clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+// FixedClock always tells the same time.
+type FixedClock struct {
+	Time time.Time
+}
+
+func (c FixedClock) Now() time.Time { return c.Time }
+
+// ManualClock tells the time it was last set to, and only moves when told. It is safe for
+// concurrent use.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a ManualClock reading start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t, backwards as well as forwards.
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// readClock reads the clock, or the wall clock when there is none.
+func readClock(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+// now reads the book's clock.
+func (ob *CompleteOrderBook) now() time.Time {
+	return readClock(ob.Clock)
+}
+
+// now reads the exchange's clock.
+func (s *CryptoExchangeService) now() time.Time {
+	return readClock(s.Clock)
+}
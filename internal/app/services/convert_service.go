@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQuoteTTL is how long a convert quote can be accepted.
+	DefaultQuoteTTL = 10 * time.Second
+	// DefaultConvertMarkupBps is the spread kept on conversions, in basis points of what the books pay.
+	DefaultConvertMarkupBps = 50
+	// ConvertAccount is the ledger account collecting the markup of conversions.
+	ConvertAccount = "convert"
+)
+
+var (
+	ErrSameAsset            = errors.New("cannot convert an asset into itself")
+	ErrNoConvertRoute       = errors.New("no route between the assets")
+	ErrInvalidConvertAmount = errors.New("invalid convert amount")
+	ErrQuoteNotFound        = errors.New("quote not found")
+	ErrQuoteExpired         = errors.New("quote expired")
+	ErrQuoteMoved           = errors.New("the books moved away from the quote")
+)
+
+// ConvertLeg is a market order a conversion goes through.
+type ConvertLeg struct {
+	Market Market `json:"market"`
+	// Bid buys the market's base with quote currency when set, and sells it for quote currency otherwise.
+	Bid bool `json:"bid"`
+}
+
+// ConvertQuote is a firm offer to convert Amount of From into Receive of To, until ExpiresAt.
+type ConvertQuote struct {
+	ID        string       `json:"id"`
+	UserID    string       `json:"-"`
+	From      Asset        `json:"from"`
+	To        Asset        `json:"to"`
+	Amount    Money        `json:"amount"`
+	Receive   Money        `json:"receive"`
+	Rate      Money        `json:"rate"` // Receive per unit of Amount.
+	Route     []ConvertLeg `json:"route"`
+	ExpiresAt int64        `json:"expiresAt"` // unix nanoseconds.
+}
+
+// ConvertResult is an executed conversion.
+type ConvertResult struct {
+	QuoteID  string   `json:"quoteId"`
+	OrderIDs []string `json:"orderIds"` // the market order of each leg.
+	Received Money    `json:"received"`
+	// Unspent is the quote currency the buying leg could not spend in whole lots, left in the wallet.
+	Unspent Money `json:"unspent,omitempty"`
+}
+
+// ConvertService quotes and executes conversions between assets on top of the order books.
+// An asset converts through the market trading it against the other asset when there is one, and
+// otherwise through two legs, selling the first asset for quote currency and buying the second.
+type ConvertService struct {
+	exchange *CryptoExchangeService
+	// TTL is how long a quote can be accepted.
+	TTL time.Duration
+	// MarkupBps is the spread taken off what the books pay, in basis points.
+	MarkupBps float64
+	// Revenue collects the markup of executed conversions.
+	Revenue *Wallet
+
+	mu     sync.Mutex
+	quotes map[string]ConvertQuote
+}
+
+// NewConvertService creates a ConvertService quoting from the exchange's books.
+func NewConvertService(exchange *CryptoExchangeService) *ConvertService {
+	return &ConvertService{
+		exchange:  exchange,
+		TTL:       DefaultQuoteTTL,
+		MarkupBps: DefaultConvertMarkupBps,
+		Revenue:   NewWallet(),
+		quotes:    make(map[string]ConvertQuote),
+	}
+}
+
+// Quote prices the conversion of amount of from into to against the current books and holds the
+// offer for the service's TTL. The price is what the books pay, less the markup.
+func (s *ConvertService) Quote(userID string, from, to Asset, amount Money) (ConvertQuote, error) {
+	if amount <= 0 || math.IsNaN(float64(amount)) || math.IsInf(float64(amount), 0) {
+		return ConvertQuote{}, fmt.Errorf("%w: must be positive", ErrInvalidConvertAmount)
+	}
+	route, err := s.route(from, to)
+	if err != nil {
+		return ConvertQuote{}, err
+	}
+
+	var gross Money
+	lockBooks(s.books(route), func() {
+		gross, err = s.simulateLocked(route, amount)
+	})
+	if err != nil {
+		return ConvertQuote{}, err
+	}
+
+	receive := gross * Money(1-s.MarkupBps/10000)
+	now := s.exchange.now()
+	quote := ConvertQuote{
+		ID:        NewID(),
+		UserID:    userID,
+		From:      from,
+		To:        to,
+		Amount:    amount,
+		Receive:   receive,
+		Rate:      receive / amount,
+		Route:     route,
+		ExpiresAt: now.Add(s.TTL).UnixNano(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, held := range s.quotes {
+		if held.ExpiresAt <= now.UnixNano() {
+			delete(s.quotes, id)
+		}
+	}
+	s.quotes[quote.ID] = quote
+	return quote, nil
+}
+
+// Accept executes a quote of the user. Every leg runs as a market order of the user while all the
+// books of the route are locked, and the whole conversion is rolled back unless every leg fills
+// and the books pay at least the quoted amount. A quote is used at most once.
+func (s *ConvertService) Accept(ctx context.Context, userID, quoteID string) (ConvertResult, error) {
+	s.mu.Lock()
+	quote, ok := s.quotes[quoteID]
+	expired := ok && quote.ExpiresAt <= s.exchange.now().UnixNano()
+	if expired {
+		delete(s.quotes, quoteID)
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok || quote.UserID != userID:
+		return ConvertResult{}, ErrQuoteNotFound
+	case expired:
+		return ConvertResult{}, ErrQuoteExpired
+	}
+
+	user, err := s.exchange.Users.GetUser(userID)
+	if err != nil {
+		return ConvertResult{}, err
+	}
+	if user.Wallet.Available(quote.From) < quote.Amount {
+		return ConvertResult{}, ErrInsufficientBalance
+	}
+	s.mu.Lock()
+	_, ok = s.quotes[quoteID]
+	delete(s.quotes, quoteID)
+	s.mu.Unlock()
+	if !ok {
+		// Accepted concurrently.
+		return ConvertResult{}, ErrQuoteNotFound
+	}
+
+	var (
+		result  ConvertResult
+		records []OrderRecord
+		paid    Money
+	)
+	matches := make(map[Market][]MatchEngine)
+	lockBooks(s.books(quote.Route), func() {
+		var txs []*bookTransaction
+		for _, leg := range quote.Route {
+			txs = append(txs, beginBookTransaction(s.exchange.OrderBooks[leg.Market]))
+		}
+		result, records, paid, err = s.executeLocked(quote, matches)
+		for _, tx := range txs {
+			if err != nil {
+				tx.rollback()
+			} else {
+				tx.commit()
+			}
+		}
+	})
+	if err != nil {
+		return ConvertResult{}, err
+	}
+
+	for _, leg := range quote.Route {
+		if err := s.exchange.settle(ctx, leg.Market, matches[leg.Market]); err != nil {
+			return result, err
+		}
+	}
+	if err := s.exchange.saveRecords(ctx, records...); err != nil {
+		return result, err
+	}
+	return result, s.collectMarkup(ctx, user, quote, paid-quote.Receive)
+}
+
+// executeLocked places the legs of a quote and returns what the books paid. The caller holds the
+// lock of every book of the route.
+func (s *ConvertService) executeLocked(quote ConvertQuote, matches map[Market][]MatchEngine) (ConvertResult, []OrderRecord, Money, error) {
+	result := ConvertResult{QuoteID: quote.ID}
+	var records []OrderRecord
+	amount := quote.Amount
+	for _, leg := range quote.Route {
+		orderBook := s.exchange.OrderBooks[leg.Market]
+		o := NewOrder(leg.Bid, amount)
+		if leg.Bid {
+			o.Size, o.InitialSize, o.Notional = 0, 0, amount
+		}
+		o.UserID = quote.UserID
+		legMatches, legRecords, err := s.exchange.placeLocked(orderBook, leg.Market, OrderTypeMarket, 0, o)
+		if err != nil {
+			return result, nil, 0, fmt.Errorf("%w: %v", ErrQuoteMoved, err)
+		}
+		if !o.IsFilled() {
+			return result, nil, 0, ErrQuoteMoved
+		}
+		matches[leg.Market] = legMatches
+		records = append(records, legRecords...)
+		result.OrderIDs = append(result.OrderIDs, o.ID)
+
+		if leg.Bid {
+			amount = o.InitialSize
+			result.Unspent = Unspent(o, legMatches)
+		} else {
+			amount = FilledNotional(legMatches)
+		}
+	}
+	if amount < quote.Receive {
+		return result, nil, 0, ErrQuoteMoved
+	}
+	result.Received = quote.Receive
+	return result, records, amount, nil
+}
+
+// collectMarkup moves what the books paid over the quote from the user to the convert account.
+func (s *ConvertService) collectMarkup(ctx context.Context, user *User, quote ConvertQuote, markup Money) error {
+	if markup <= 0 {
+		return nil
+	}
+	if err := user.Wallet.Debit(quote.To, markup); err != nil {
+		return err
+	}
+	if s.exchange.Store != nil {
+		now := s.exchange.now().UnixNano()
+		err := s.exchange.Store.InTransaction(ctx, func(tx Repositories) error {
+			for _, entry := range []LedgerEntry{
+				{ID: NewID(), UserID: user.ID, Asset: quote.To, Amount: -markup, Reference: quote.ID, CreatedAt: now},
+				{ID: NewID(), UserID: ConvertAccount, Asset: quote.To, Amount: markup, Reference: quote.ID, CreatedAt: now},
+			} {
+				if err := tx.Balances().ApplyEntry(ctx, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			user.Wallet.Credit(quote.To, markup)
+			return err
+		}
+	}
+	s.Revenue.Credit(quote.To, markup)
+	return nil
+}
+
+// route returns the legs converting from into to: the market trading one asset against the other
+// when it is listed, and a sell then a buy through the quote currency otherwise.
+func (s *ConvertService) route(from, to Asset) ([]ConvertLeg, error) {
+	if from == to {
+		return nil, ErrSameAsset
+	}
+	if market := MarketFor(from, to); s.listed(market) {
+		return []ConvertLeg{{Market: market}}, nil
+	}
+	if market := MarketFor(to, from); s.listed(market) {
+		return []ConvertLeg{{Market: market, Bid: true}}, nil
+	}
+	noRoute := fmt.Errorf("%w: %s to %s", ErrNoConvertRoute, from, to)
+	if from == QuoteAsset || to == QuoteAsset {
+		return nil, noRoute
+	}
+	route := []ConvertLeg{{Market: MarketFor(from, QuoteAsset)}, {Market: MarketFor(to, QuoteAsset), Bid: true}}
+	for _, leg := range route {
+		if !s.listed(leg.Market) {
+			return nil, noRoute
+		}
+	}
+	return route, nil
+}
+
+func (s *ConvertService) listed(market Market) bool {
+	_, ok := s.exchange.OrderBooks[market]
+	return ok
+}
+
+// simulateLocked returns what the route pays for amount at the current books, without touching
+// them. The caller holds the lock of every book of the route.
+func (s *ConvertService) simulateLocked(route []ConvertLeg, amount Money) (Money, error) {
+	for _, leg := range route {
+		orderBook := s.exchange.OrderBooks[leg.Market]
+		if err := orderBook.acceptsOrders(OrderTypeMarket); err != nil {
+			return 0, err
+		}
+		size := amount
+		if leg.Bid {
+			size = orderBook.sizeForNotional(&Order{Bid: true, Notional: amount})
+			if size == 0 {
+				return 0, fmt.Errorf("%w: %s", ErrInsufficientLiquidity, leg.Market)
+			}
+		}
+		if err := orderBook.Config.Validate(OrderTypeMarket, 0, size); err != nil {
+			return 0, err
+		}
+		if leg.Bid {
+			amount = size
+			continue
+		}
+		proceeds, filled := orderBook.sweep(false, size)
+		if filled < size {
+			return 0, fmt.Errorf("%w: %s", ErrInsufficientLiquidity, leg.Market)
+		}
+		amount = proceeds
+	}
+	return amount, nil
+}
+
+// books returns the books of a route in market order, the order their locks are taken in.
+func (s *ConvertService) books(route []ConvertLeg) []*CompleteOrderBook {
+	markets := make([]string, 0, len(route))
+	for _, leg := range route {
+		markets = append(markets, string(leg.Market))
+	}
+	sort.Strings(markets)
+	books := make([]*CompleteOrderBook, 0, len(markets))
+	for _, market := range markets {
+		books = append(books, s.exchange.OrderBooks[Market(market)])
+	}
+	return books
+}
+
+// lockBooks runs fn while holding the lock of every book, taken in the given order.
+func lockBooks(books []*CompleteOrderBook, fn func()) {
+	if len(books) == 0 {
+		fn()
+		return
+	}
+	books[0].Exclusive(func() {
+		lockBooks(books[1:], fn)
+	})
+}
+
+// sweep returns the quote currency a market order of the size would trade for against the side
+// opposite to bid, and the size the book can fill, without touching the book.
+func (ob *CompleteOrderBook) sweep(bid bool, size Money) (notional, filled Money) {
+	limits := ob.SortBids()
+	if bid {
+		limits = ob.SortAsk()
+	}
+	for _, limit := range limits {
+		if filled >= size {
+			break
+		}
+		take := Money(math.Min(float64(size-filled), float64(limit.TotalVolume)))
+		filled += take
+		notional += take * limit.Price
+	}
+	return notional, filled
+}
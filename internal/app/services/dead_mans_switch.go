@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// EventDeadMansSwitchTriggered is published when a session's dead man's switch fires.
+const EventDeadMansSwitchTriggered = "session.dead_mans_switch_triggered"
+
+// Bounds of a dead man's switch timeout.
+const (
+	MinDeadMansSwitchTimeout = time.Second
+	MaxDeadMansSwitchTimeout = 10 * time.Minute
+)
+
+var (
+	ErrSwitchNotArmed       = errors.New("dead man's switch is not armed")
+	ErrInvalidSwitchTimeout = errors.New("dead man's switch timeout must be between 1s and 10m")
+)
+
+// DeadMansSwitchTriggered is published when a session missed its heartbeat, or dropped its
+// connection, while its switch was armed. Cancelled lists the account's orders pulled as a result.
+type DeadMansSwitchTriggered struct {
+	SessionID string   `json:"sessionId"`
+	UserID    string   `json:"userId"`
+	Cancelled []string `json:"cancelled"`
+	Timestamp int64    `json:"timestamp"` // unix nanoseconds.
+}
+
+func (DeadMansSwitchTriggered) EventType() string { return EventDeadMansSwitchTriggered }
+
+// armedSwitch is the deadline of one session, read on the exchange's clock.
+type armedSwitch struct {
+	userID   string
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// DeadMansSwitch cancels every open order of an account when one of its sessions stops sending heartbeats.
+// Sessions are identified by the caller: the session ID for REST, a connection ID for WebSockets.
+// Deadlines are kept on the exchange's clock, and switches past theirs fire when Expire is called.
+type DeadMansSwitch struct {
+	mu       sync.Mutex
+	exchange *CryptoExchangeService
+	armed    map[string]*armedSwitch
+}
+
+// NewDeadMansSwitch creates a switch board pulling orders from the given exchange.
+func NewDeadMansSwitch(exchange *CryptoExchangeService) *DeadMansSwitch {
+	return &DeadMansSwitch{
+		exchange: exchange,
+		armed:    make(map[string]*armedSwitch),
+	}
+}
+
+// Arm starts, or restarts with a new timeout, the session's timer and returns its deadline.
+func (d *DeadMansSwitch) Arm(sessionID, userID string, timeout time.Duration) (time.Time, error) {
+	if timeout < MinDeadMansSwitchTimeout || timeout > MaxDeadMansSwitchTimeout {
+		return time.Time{}, ErrInvalidSwitchTimeout
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sw, ok := d.armed[sessionID]
+	if !ok {
+		sw = &armedSwitch{userID: userID}
+		d.armed[sessionID] = sw
+	}
+	sw.timeout = timeout
+	d.reset(sw)
+	return sw.deadline, nil
+}
+
+// Heartbeat pushes the session's deadline back by its timeout and returns the new deadline.
+func (d *DeadMansSwitch) Heartbeat(sessionID string) (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sw, ok := d.armed[sessionID]
+	if !ok {
+		return time.Time{}, ErrSwitchNotArmed
+	}
+	d.reset(sw)
+	return sw.deadline, nil
+}
+
+// Disarm removes the session's switch without cancelling anything. It reports whether the switch was armed.
+func (d *DeadMansSwitch) Disarm(sessionID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.armed[sessionID]
+	delete(d.armed, sessionID)
+	return ok
+}
+
+// Armed reports whether the session's switch is armed.
+func (d *DeadMansSwitch) Armed(sessionID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.armed[sessionID]
+	return ok
+}
+
+// Trigger fires the session's switch now, as when its connection drops, and returns the cancelled order IDs.
+func (d *DeadMansSwitch) Trigger(ctx context.Context, sessionID string) ([]string, error) {
+	d.mu.Lock()
+	sw, ok := d.armed[sessionID]
+	delete(d.armed, sessionID)
+	d.mu.Unlock()
+	if !ok {
+		return nil, ErrSwitchNotArmed
+	}
+	return d.fire(ctx, sessionID, sw.userID)
+}
+
+// Expire fires every switch whose deadline is at or before now, and returns how many fired.
+func (d *DeadMansSwitch) Expire(ctx context.Context, now time.Time) (int, error) {
+	d.mu.Lock()
+	expired := make(map[string]string)
+	for sessionID, sw := range d.armed {
+		if !sw.deadline.After(now) {
+			expired[sessionID] = sw.userID
+			delete(d.armed, sessionID)
+		}
+	}
+	d.mu.Unlock()
+
+	var errs []error
+	for sessionID, userID := range expired {
+		if _, err := d.fire(ctx, sessionID, userID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(expired), errors.Join(errs...)
+}
+
+// reset pushes the deadline back by the timeout. d.mu must be held.
+func (d *DeadMansSwitch) reset(sw *armedSwitch) {
+	sw.deadline = d.exchange.now().Add(sw.timeout)
+}
+
+// fire pulls every open order of the account and reports it.
+func (d *DeadMansSwitch) fire(ctx context.Context, sessionID, userID string) ([]string, error) {
+	cancelled, err := d.exchange.CancelAll(ctx, CancelFilter{UserID: userID}, CancelReasonDeadMansSwitch)
+	if d.exchange.Events != nil {
+		d.exchange.Events.Publish(DeadMansSwitchTriggered{
+			SessionID: sessionID,
+			UserID:    userID,
+			Cancelled: cancelled,
+			Timestamp: d.exchange.now().UnixNano(),
+		})
+	}
+	return cancelled, err
+}
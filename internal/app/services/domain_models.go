@@ -1,9 +1,16 @@
 package services
 
+import (
+	"sync"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
 type Money float64
 
 // MatchEngine matches the ask with the bid.
 type MatchEngine struct {
+	ID         string // trade ID of the match.
 	Ask        *Order
 	Bid        *Order
 	SizeFilled Money // How much is this order being filled for?
@@ -12,17 +19,29 @@ type MatchEngine struct {
 
 // Order is the container for a buy order content.
 type Order struct {
-	Size      Money
-	Bid       bool
-	Limit     *Limit
-	TimeStamp int64
+	ID          string
+	UserID      string // the account that owns the order.
+	Market      Market
+	Price       Money // limit price, zero for market orders.
+	Size        Money // size left to fill.
+	InitialSize Money // size the order was placed with.
+	Bid         bool
+	Limit       *Limit
+	TimeStamp   int64
+	ExpiresAt   int64 // unix nanoseconds, zero for good-till-cancelled.
+	// Protection bounds the prices a market order fills at.
+	Protection Protection
+	// Notional sizes a market order in quote currency: what it spends buying, or the most it receives selling.
+	Notional Money
 }
 
 // Limit is a group of Orders at a certain price level with different sizes.
 type Limit struct {
 	Price       Money
-	Orders      Orders
+	Orders      Orders // in time priority.
 	TotalVolume Money
+	// Top is the order that made this level the best price of its side, until it leaves the level.
+	Top *Order
 }
 
 type CompleteOrderBook struct {
@@ -31,6 +50,30 @@ type CompleteOrderBook struct {
 
 	AskLimits map[Money]*Limit
 	BidLimits map[Money]*Limit
+
+	// Config holds the market's tick, lot and size rules, checked by the exchange service.
+	Config MarketConfig
+
+	// status is the market state, see Status. recentFills are the fill prices the circuit breaker compares against.
+	status      MarketStatus
+	recentFills []pricePoint
+	// lastPrice is the price of the last fill. indicative is the last published result of an ongoing auction.
+	lastPrice  Money
+	indicative AuctionResult
+
+	// mu serializes the operations changing the book, see Exclusive.
+	mu sync.Mutex
+	// resting indexes the orders sitting on the book by ID.
+	resting map[string]*Order
+
+	Market Market
+	// Clock stamps the book's orders, events and fills. The wall clock is used when it is nil.
+	Clock Clock
+	// Events receives the lifecycle events of the book's orders, when set.
+	Events   messaging.Publisher
+	sequence uint64
+	// funds holds what the orders of accounts could spend and settles their trades, when set.
+	funds fundsHolder
 }
 
 // Limits houses all limits to sort from.
@@ -0,0 +1,311 @@
+package services
+
+import "github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+
+// Event types published by the order books.
+const (
+	EventOrderAccepted        = "order.accepted"
+	EventOrderRejected        = "order.rejected"
+	EventOrderFilled          = "order.filled"
+	EventOrderPartiallyFilled = "order.partially_filled"
+	EventOrderCancelled       = "order.cancelled"
+	EventOrderAmended         = "order.amended"
+	EventTradeExecuted        = "trade.executed"
+	EventLevelChanged         = "level.changed"
+)
+
+// MarketEvent is implemented by every order book event.
+type MarketEvent interface {
+	messaging.Event
+	Header() EventHeader
+}
+
+// EventHeader is shared by every order book event. Sequence numbers are per market,
+// start at 1 and have no gaps, so consumers can detect missed events.
+type EventHeader struct {
+	Market    Market `json:"market"`
+	Sequence  uint64 `json:"sequence"`
+	Timestamp int64  `json:"timestamp"` // unix nanoseconds.
+}
+
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// OrderType tells limit orders, which rest, from market orders, which only take.
+type OrderType string
+
+const (
+	OrderTypeLimit  OrderType = "limit"
+	OrderTypeMarket OrderType = "market"
+)
+
+// CancelReason records why an order left the book without being filled.
+type CancelReason string
+
+const (
+	CancelReasonUser       CancelReason = "user"
+	CancelReasonExpired    CancelReason = "expired"
+	CancelReasonMassCancel CancelReason = "mass_cancel"
+	// CancelReasonDeadMansSwitch marks the orders pulled because a session stopped sending heartbeats.
+	CancelReasonDeadMansSwitch CancelReason = "dead_mans_switch"
+	// CancelReasonNoLiquidity marks the unfilled rest of an unbounded market order the book had no
+	// volume left for, such as a sub-lot remainder.
+	CancelReasonNoLiquidity CancelReason = "no_liquidity"
+)
+
+// Side selects bids, asks, or both when empty.
+type Side string
+
+const (
+	SideBid Side = "bid"
+	SideAsk Side = "ask"
+)
+
+// Valid reports whether the side is one of the known values or empty.
+func (s Side) Valid() bool {
+	return s == "" || s == SideBid || s == SideAsk
+}
+
+// Matches reports whether an order on the given side of the book is selected.
+func (s Side) Matches(bid bool) bool {
+	return s == "" || (s == SideBid) == bid
+}
+
+// OrderAccepted is published when an order enters the book, before it matches or rests.
+type OrderAccepted struct {
+	EventHeader
+	OrderID   string    `json:"orderId"`
+	UserID    string    `json:"userId"`
+	Type      OrderType `json:"type"`
+	Bid       bool      `json:"bid"`
+	Price     Money     `json:"price"` // zero for market orders.
+	Size      Money     `json:"size"`
+	Notional  Money     `json:"notional,omitempty"` // quote size of a market order sized by notional.
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	// Bound is the worst price a protected market order fills at, zero for none.
+	Bound Money `json:"bound,omitempty"`
+}
+
+// OrderRejected is published when an order is refused before touching the book.
+type OrderRejected struct {
+	EventHeader
+	OrderID string `json:"orderId"`
+	UserID  string `json:"userId"`
+	Bid     bool   `json:"bid"`
+	Size    Money  `json:"size"`
+	Reason  string `json:"reason"`
+}
+
+// OrderFilled is published when an order has no size left.
+type OrderFilled struct {
+	EventHeader
+	OrderID    string `json:"orderId"`
+	UserID     string `json:"userId"`
+	Price      Money  `json:"price"`
+	FilledSize Money  `json:"filledSize"` // size filled by the last match.
+}
+
+// OrderPartiallyFilled is published when a match leaves an order with size remaining.
+type OrderPartiallyFilled struct {
+	EventHeader
+	OrderID    string `json:"orderId"`
+	UserID     string `json:"userId"`
+	Price      Money  `json:"price"`
+	FilledSize Money  `json:"filledSize"`
+	Remaining  Money  `json:"remaining"`
+}
+
+// OrderCancelled is published when a resting order is removed from the book.
+type OrderCancelled struct {
+	EventHeader
+	OrderID   string       `json:"orderId"`
+	UserID    string       `json:"userId"`
+	Remaining Money        `json:"remaining"`
+	Reason    CancelReason `json:"reason"`
+}
+
+// OrderAmended is published when a resting order's price or size is changed in place.
+type OrderAmended struct {
+	EventHeader
+	OrderID   string `json:"orderId"`
+	UserID    string `json:"userId"`
+	Bid       bool   `json:"bid"`
+	Price     Money  `json:"price"`
+	Size      Money  `json:"size"` // new size of the order, including what was already filled.
+	Remaining Money  `json:"remaining"`
+}
+
+// TradeExecuted is published for every match between a taker and a maker.
+type TradeExecuted struct {
+	EventHeader
+	TradeID      string `json:"tradeId"`
+	Price        Money  `json:"price"`
+	Size         Money  `json:"size"`
+	TakerBid     bool   `json:"takerBid"`
+	BuyOrderID   string `json:"buyOrderId"`
+	SellOrderID  string `json:"sellOrderId"`
+	BuyerID      string `json:"buyerId"`
+	SellerID     string `json:"sellerId"`
+	TakerOrderID string `json:"takerOrderId"` // empty for auction trades.
+}
+
+// LevelChanged is published when the total volume at a price level changes. Zero volume means the level is gone.
+type LevelChanged struct {
+	EventHeader
+	Bid         bool  `json:"bid"`
+	Price       Money `json:"price"`
+	TotalVolume Money `json:"totalVolume"`
+	OrderCount  int   `json:"orderCount"`
+}
+
+func (OrderAccepted) EventType() string        { return EventOrderAccepted }
+func (OrderRejected) EventType() string        { return EventOrderRejected }
+func (OrderFilled) EventType() string          { return EventOrderFilled }
+func (OrderPartiallyFilled) EventType() string { return EventOrderPartiallyFilled }
+func (OrderCancelled) EventType() string       { return EventOrderCancelled }
+func (OrderAmended) EventType() string         { return EventOrderAmended }
+func (TradeExecuted) EventType() string        { return EventTradeExecuted }
+func (LevelChanged) EventType() string         { return EventLevelChanged }
+
+// nextHeader stamps the next event of this book.
+func (ob *CompleteOrderBook) nextHeader() EventHeader {
+	ob.sequence++
+	return EventHeader{
+		Market:    ob.Market,
+		Sequence:  ob.sequence,
+		Timestamp: ob.now().UnixNano(),
+	}
+}
+
+// Sequence returns the sequence number of the last event published by the book.
+func (ob *CompleteOrderBook) Sequence() uint64 {
+	return ob.sequence
+}
+
+// emit stamps an event, settles it against the funds of its accounts and publishes it. Nothing is
+// built, and no sequence number is used, without a publisher or funds.
+func (ob *CompleteOrderBook) emit(build func(header EventHeader) messaging.Event) {
+	if ob.Events == nil && ob.funds == nil {
+		return
+	}
+	event := build(ob.nextHeader())
+	if ob.funds != nil {
+		ob.funds.apply(event)
+	}
+	if ob.Events != nil {
+		ob.Events.Publish(event)
+	}
+}
+
+func (ob *CompleteOrderBook) publishLevel(bid bool, l *Limit) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		return LevelChanged{
+			EventHeader: header,
+			Bid:         bid,
+			Price:       l.Price,
+			TotalVolume: l.TotalVolume,
+			OrderCount:  len(l.Orders),
+		}
+	})
+}
+
+func (ob *CompleteOrderBook) publishAccepted(o *Order, orderType OrderType, price, bound Money) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		return OrderAccepted{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Type:        orderType,
+			Bid:         o.Bid,
+			Price:       price,
+			Size:        o.Size,
+			Notional:    o.Notional,
+			ExpiresAt:   o.ExpiresAt,
+			Bound:       bound,
+		}
+	})
+}
+
+// publishFill reports the effect of a match on one of its orders.
+func (ob *CompleteOrderBook) publishFill(o *Order, price, filled Money) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		if o.IsFilled() {
+			return OrderFilled{
+				EventHeader: header,
+				OrderID:     o.ID,
+				UserID:      o.UserID,
+				Price:       price,
+				FilledSize:  filled,
+			}
+		}
+		return OrderPartiallyFilled{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Price:       price,
+			FilledSize:  filled,
+			Remaining:   o.Size,
+		}
+	})
+}
+
+// publishTrade reports a match. Auction matches have no taker.
+func (ob *CompleteOrderBook) publishTrade(match MatchEngine, taker *Order) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		trade := TradeExecuted{
+			EventHeader: header,
+			TradeID:     match.ID,
+			Price:       match.Price,
+			Size:        match.SizeFilled,
+			BuyOrderID:  match.Bid.ID,
+			SellOrderID: match.Ask.ID,
+			BuyerID:     match.Bid.UserID,
+			SellerID:    match.Ask.UserID,
+		}
+		if taker != nil {
+			trade.TakerBid, trade.TakerOrderID = taker.Bid, taker.ID
+		}
+		return trade
+	})
+}
+
+func (ob *CompleteOrderBook) publishCancelled(o *Order, reason CancelReason) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		return OrderCancelled{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Remaining:   o.Size,
+			Reason:      reason,
+		}
+	})
+}
+
+func (ob *CompleteOrderBook) publishAmended(o *Order) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		return OrderAmended{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Bid:         o.Bid,
+			Price:       o.Price,
+			Size:        o.InitialSize,
+			Remaining:   o.Size,
+		}
+	})
+}
+
+// RejectOrder reports an order refused before it reached the book.
+func (ob *CompleteOrderBook) RejectOrder(o *Order, reason string) {
+	ob.emit(func(header EventHeader) messaging.Event {
+		return OrderRejected{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Bid:         o.Bid,
+			Size:        o.Size,
+			Reason:      reason,
+		}
+	})
+}
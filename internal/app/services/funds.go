@@ -0,0 +1,259 @@
+package services
+
+import (
+	"log"
+	"math"
+	"sync"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// Funds holds what the live orders of accounts could spend, so an account only trades what its
+// wallet holds. Books reserve an order's funds before it rests or matches, and settle the wallets
+// from their events as trades are made: fills spend what their order held, and cancels, rejects
+// and the end of a market order give back the rest. Orders of IDs without an account, such as
+// the exchange's own, hold nothing and settle to no wallet.
+type Funds struct {
+	users *UserService
+
+	mu    sync.Mutex
+	holds map[string]*hold // by order ID.
+}
+
+// hold is what an order has set aside of an asset. Rate is held per unit of size left to fill:
+// the price of a buy, one for a sell. A market buy without a bound has no rate: it holds what
+// filling it was estimated to cost, and each fill gives back what it cost.
+type hold struct {
+	wallet *Wallet
+	asset  Asset
+	rate   Money
+	amount Money
+}
+
+func NewFunds(users *UserService) *Funds {
+	return &Funds{users: users, holds: make(map[string]*hold)}
+}
+
+// fundsHolder reserves and settles the funds of a book's orders. Every change returns how to undo
+// it, so an all-or-nothing batch puts the wallets back along with the book.
+type fundsHolder interface {
+	reserve(o *Order, asset Asset, rate, amount Money) (undo func(), err error)
+	rereserve(o *Order, rate, amount Money) (undo func(), err error)
+	apply(event messaging.Event) (undo func())
+}
+
+func noUndo() {}
+
+// reserve holds amount of an asset for a new order, failing with ErrInsufficientBalance when the
+// order's account has less than that available.
+func (f *Funds) reserve(o *Order, asset Asset, rate, amount Money) (func(), error) {
+	user, err := f.users.GetUser(o.UserID)
+	if err != nil {
+		return noUndo, nil
+	}
+	if err := user.Wallet.Hold(asset, amount); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.holds[o.ID] = &hold{wallet: user.Wallet, asset: asset, rate: rate, amount: amount}
+	f.mu.Unlock()
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.holds, o.ID)
+		user.Wallet.Release(asset, amount)
+	}, nil
+}
+
+// rereserve changes what an amended order holds, failing with ErrInsufficientBalance when the
+// order needs more than its account has available.
+func (f *Funds) rereserve(o *Order, rate, amount Money) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.holds[o.ID]
+	if !ok {
+		return noUndo, nil
+	}
+	previous := *h
+	if amount > h.amount {
+		if err := h.wallet.Hold(h.asset, amount-h.amount); err != nil {
+			return nil, err
+		}
+	} else {
+		h.wallet.Release(h.asset, h.amount-amount)
+	}
+	h.rate, h.amount = rate, amount
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		h.wallet.adjust(h.asset, 0, previous.amount-h.amount)
+		*h = previous
+	}, nil
+}
+
+// apply settles a trade between the wallets of its buyer and seller, and gives back what an order
+// still holds once it has left the book.
+func (f *Funds) apply(event messaging.Event) func() {
+	switch e := event.(type) {
+	case TradeExecuted:
+		base, quote := e.Market.Assets()
+		notional := e.Price * e.Size
+		buyer := f.settle(e.TradeID, e.BuyOrderID, e.BuyerID, quote, notional, e.Size, base, e.Size)
+		seller := f.settle(e.TradeID, e.SellOrderID, e.SellerID, base, e.Size, e.Size, quote, notional)
+		return func() {
+			seller()
+			buyer()
+		}
+	case OrderFilled:
+		return f.close(e.OrderID)
+	case OrderCancelled:
+		return f.close(e.OrderID)
+	case OrderRejected:
+		return f.close(e.OrderID)
+	}
+	return noUndo
+}
+
+// settle applies one side of a trade of size to its account: the negative ledger entry, cost of
+// the spent asset, is debited from what the order held, and the positive one is credited.
+func (f *Funds) settle(tradeID, orderID, userID string, spent Asset, cost, size Money, received Asset, proceeds Money) func() {
+	user, err := f.users.GetUser(userID)
+	if err != nil {
+		return noUndo
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var released Money
+	h, held := f.holds[orderID]
+	if held {
+		released = cost
+		if h.rate > 0 {
+			released = size * h.rate
+		}
+		released = Money(math.Min(float64(released), float64(h.amount)))
+	}
+	if err := user.Wallet.Spend(spent, cost, released); err != nil {
+		// Only an order that reached the book without its funds held can get here.
+		log.Printf("Could not settle trade %s for %s: %v", tradeID, userID, err)
+		return noUndo
+	}
+	if held {
+		h.amount -= released
+	}
+	user.Wallet.Credit(received, proceeds)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if held {
+			h.amount += released
+		}
+		user.Wallet.adjust(spent, cost, released)
+		user.Wallet.adjust(received, -proceeds, 0)
+	}
+}
+
+// close gives back what an order still holds once it has left the book.
+func (f *Funds) close(orderID string) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.holds[orderID]
+	if !ok {
+		return noUndo
+	}
+	delete(f.holds, orderID)
+	h.wallet.Release(h.asset, h.amount)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		h.wallet.adjust(h.asset, 0, h.amount)
+		f.holds[orderID] = h
+	}
+}
+
+// fundsJournal passes the changes of a book transaction through and records how to undo them.
+type fundsJournal struct {
+	funds fundsHolder
+	undo  []func()
+}
+
+func (j *fundsJournal) reserve(o *Order, asset Asset, rate, amount Money) (func(), error) {
+	undo, err := j.funds.reserve(o, asset, rate, amount)
+	if err == nil {
+		j.undo = append(j.undo, undo)
+	}
+	return undo, err
+}
+
+func (j *fundsJournal) rereserve(o *Order, rate, amount Money) (func(), error) {
+	undo, err := j.funds.rereserve(o, rate, amount)
+	if err == nil {
+		j.undo = append(j.undo, undo)
+	}
+	return undo, err
+}
+
+func (j *fundsJournal) apply(event messaging.Event) func() {
+	undo := j.funds.apply(event)
+	j.undo = append(j.undo, undo)
+	return undo
+}
+
+// rollback undoes every change, last first.
+func (j *fundsJournal) rollback() {
+	for i := len(j.undo) - 1; i >= 0; i-- {
+		j.undo[i]()
+	}
+}
+
+// reserveFunds holds what an order could spend before it rests or matches: the size of a sell,
+// and the price of every unit of a buy. A market buy holds its bound for every unit or, without
+// one, what filling it against the book costs. The caller holds the book's lock.
+func (ob *CompleteOrderBook) reserveFunds(orderType OrderType, price Money, o *Order) error {
+	if ob.funds == nil {
+		return nil
+	}
+	base, quote := ob.Market.Assets()
+	var err error
+	switch {
+	case !o.Bid:
+		_, err = ob.funds.reserve(o, base, 1, o.Size)
+	case orderType == OrderTypeLimit:
+		_, err = ob.funds.reserve(o, quote, price, price*o.Size)
+	default:
+		if bound, bounded := ob.protectionBound(o); bounded {
+			_, err = ob.funds.reserve(o, quote, bound, bound*o.Size)
+		} else {
+			_, err = ob.funds.reserve(o, quote, 0, ob.costOfAsks(o.Size))
+		}
+	}
+	return err
+}
+
+// reserveAmend holds what a resting order needs at its amended price and size. The caller holds
+// the book's lock.
+func (ob *CompleteOrderBook) reserveAmend(o *Order, price, remaining Money) error {
+	if ob.funds == nil {
+		return nil
+	}
+	rate, amount := Money(1), remaining
+	if o.Bid {
+		rate, amount = price, price*remaining
+	}
+	_, err := ob.funds.rereserve(o, rate, amount)
+	return err
+}
+
+// costOfAsks returns what buying size from the best asks costs.
+func (ob *CompleteOrderBook) costOfAsks(size Money) Money {
+	var cost Money
+	left := size
+	for _, limit := range ob.SortAsk() {
+		if left <= 0 {
+			break
+		}
+		take := Money(math.Min(float64(left), float64(limit.TotalVolume)))
+		cost += take * limit.Price
+		left -= take
+	}
+	return cost
+}
@@ -1,10 +1,68 @@
 package services
 
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
 type Market string
 
+// QuoteAsset is the currency markets are priced in unless they name another one.
+const QuoteAsset Asset = "USD"
+
+// Assets returns the base and quote assets traded on the market. A market named after its base
+// alone, like "ETH", is priced in QuoteAsset; a cross market like "ETH-BTC" names both.
+func (m Market) Assets() (base, quote Asset) {
+	if i := strings.IndexByte(string(m), '-'); i >= 0 {
+		return Asset(m[:i]), Asset(m[i+1:])
+	}
+	return Asset(m), QuoteAsset
+}
+
+// MarketFor returns the name of the market trading base against quote.
+func MarketFor(base, quote Asset) Market {
+	if quote == QuoteAsset {
+		return Market(base)
+	}
+	return Market(base + "-" + quote)
+}
+
+var (
+	ErrMarketNotFound        = errors.New("market not found")
+	ErrInsufficientLiquidity = errors.New("not enough volume for market order")
+	ErrOrderNotResting       = errors.New("order is not resting on the book")
+	ErrMarketListed          = errors.New("market is already listed")
+)
+
 // CryptoExchangeService ✅ provides methods for interacting with the cryptoexchange.
 type CryptoExchangeService struct {
 	OrderBooks map[Market]*CompleteOrderBook
+	Users      *UserService
+	// Store persists orders, trades and the ledger. Nothing is persisted when it is nil.
+	Store Store
+	// Events carries the order lifecycle events of every market.
+	Events *messaging.Dispatcher
+	// Candles aggregates the trades published on Events into OHLCV bars.
+	Candles *CandleService
+	// Tickers keeps the rolling 24h statistics of every market.
+	Tickers *TickerService
+	// Orders tracks the state of every order placed on the books.
+	Orders *OrderStateStore
+	// Switches holds the dead man's switches armed by trading sessions.
+	Switches *DeadMansSwitch
+	// Convert quotes and executes conversions between assets.
+	Convert *ConvertService
+	// Router splits orders on a pair between its own market and synthetic routes.
+	Router *SmartRouter
+	// Funds holds what the orders of accounts could spend, and settles their wallets as they trade.
+	Funds *Funds
+	// Clock stamps orders, trades and quotes, and decides what has expired. Set it with UseClock.
+	Clock Clock
 }
 
 const (
@@ -13,10 +71,434 @@ const (
 
 // NewCryptoExchangeService ✅ creates a new CryptoExchangeService instance.
 func NewCryptoExchangeService() *CryptoExchangeService {
-	bookOfOrders := make(map[Market]*CompleteOrderBook)
-	bookOfOrders[MarketETH] = NewOrderBook()
+	events := messaging.NewDispatcher()
+	orders := NewOrderStateStore()
+	users := NewUserService()
+	exchange := &CryptoExchangeService{
+		OrderBooks: make(map[Market]*CompleteOrderBook),
+		Users:      users,
+		Events:     events,
+		Candles:    NewCandleService(),
+		Tickers:    NewTickerService(),
+		Orders:     orders,
+		Clock:      RealClock{},
+		Funds:      NewFunds(users),
+	}
+	exchange.Switches = NewDeadMansSwitch(exchange)
+	exchange.Convert = NewConvertService(exchange)
+	exchange.Router = NewSmartRouter(exchange)
+	// ETH was trading before auctions existed, so it opens straight into continuous trading.
+	exchange.addMarket(MarketETH, DefaultMarketConfigs[MarketETH])
+	return exchange
+}
+
+// ListMarket opens a new market with an opening call auction, when its config has an auction duration.
+// Markets are listed before the exchange starts serving.
+func (s *CryptoExchangeService) ListMarket(market Market, config MarketConfig) error {
+	if _, exists := s.OrderBooks[market]; exists {
+		return ErrMarketListed
+	}
+	if !config.validMatching() {
+		return ErrUnknownMatchingPolicy
+	}
+	orderBook := s.addMarket(market, config)
+	if config.AuctionDuration > 0 {
+		orderBook.Exclusive(func() {
+			startAuctionLocked(orderBook, s.now(), StateReasonListing)
+		})
+	}
+	return nil
+}
+
+// addMarket creates the book of a market, publishing to the exchange's order states and event bus.
+func (s *CryptoExchangeService) addMarket(market Market, config MarketConfig) *CompleteOrderBook {
+	orderBook := NewOrderBook()
+	orderBook.Market = market
+	orderBook.Config = config
+	orderBook.Clock = s.Clock
+	// Order states are updated before any subscriber hears about the event.
+	orderBook.Events = messaging.Publishers{s.Orders, s.Events}
+	if s.Funds != nil {
+		orderBook.funds = s.Funds
+	}
+	s.OrderBooks[market] = orderBook
+	return orderBook
+}
+
+// UseClock runs the exchange on the given clock: its books, order expiry, halts, convert quotes
+// and candles. Markets listed afterwards use it too.
+func (s *CryptoExchangeService) UseClock(clock Clock) {
+	s.Clock = clock
+	for _, orderBook := range s.OrderBooks {
+		orderBook.Exclusive(func() {
+			orderBook.Clock = clock
+		})
+	}
+	s.Candles.Clock = clock
+}
+
+// UseStore persists the exchange, including user accounts, to the given store.
+func (s *CryptoExchangeService) UseStore(store Store) {
+	s.Store = store
+	s.Users.Store = store
+	s.Candles.Repository = store.Candles()
+}
+
+// PlaceLimitOrder rests an order on the market's book and records it.
+func (s *CryptoExchangeService) PlaceLimitOrder(ctx context.Context, market Market, price Money, o *Order) error {
+	orderBook, ok := s.OrderBooks[market]
+	if !ok {
+		return ErrMarketNotFound
+	}
+
+	var (
+		records []OrderRecord
+		err     error
+	)
+	orderBook.Exclusive(func() {
+		_, records, err = s.placeLocked(orderBook, market, OrderTypeLimit, price, o)
+	})
+	if err != nil {
+		return err
+	}
+	return s.saveRecords(ctx, records...)
+}
+
+// PlaceMarketOrder matches an order against the market's book, then settles and records every fill.
+func (s *CryptoExchangeService) PlaceMarketOrder(ctx context.Context, market Market, o *Order) ([]MatchEngine, error) {
+	orderBook, ok := s.OrderBooks[market]
+	if !ok {
+		return nil, ErrMarketNotFound
+	}
+
+	var (
+		matches []MatchEngine
+		records []OrderRecord
+		err     error
+	)
+	orderBook.Exclusive(func() {
+		matches, records, err = s.placeLocked(orderBook, market, OrderTypeMarket, 0, o)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.settle(ctx, market, matches); err != nil {
+		return matches, err
+	}
+	return matches, s.saveRecords(ctx, records...)
+}
 
-	return &CryptoExchangeService{
-		OrderBooks: bookOfOrders,
+// placeLocked puts an order on the book and returns its matches and the records of every order it touched.
+// The caller holds the book's lock, settles the matches and saves the records once it is released.
+func (s *CryptoExchangeService) placeLocked(orderBook *CompleteOrderBook, market Market, orderType OrderType, price Money, o *Order) ([]MatchEngine, []OrderRecord, error) {
+	o.Market = market
+	matches, err := orderBook.submit(orderType, price, o)
+	if err != nil {
+		return nil, nil, err
 	}
-}
\ No newline at end of file
+	if orderType == OrderTypeLimit {
+		return nil, []OrderRecord{orderRecord(o)}, nil
+	}
+
+	record := orderRecord(o)
+	if _, resting := orderBook.GetOrder(o.ID); !o.IsFilled() && !resting {
+		record = cancelledRecord(o, orderBook.remainderReason(o))
+	}
+	records := []OrderRecord{record}
+	for _, match := range matches {
+		maker := match.Bid
+		if o.Bid {
+			maker = match.Ask
+		}
+		records = append(records, orderRecord(maker))
+	}
+	return matches, records, nil
+}
+
+// submit checks an order against the book's state and the market's rules, then rests or matches it.
+// Rejected orders are published as such. The caller holds the book's lock.
+func (ob *CompleteOrderBook) submit(orderType OrderType, price Money, o *Order) ([]MatchEngine, error) {
+	if err := ob.acceptsOrders(orderType); err != nil {
+		ob.RejectOrder(o, err.Error())
+		return nil, err
+	}
+	if orderType == OrderTypeMarket {
+		if err := o.Protection.validate(ob.Config.TickSize); err != nil {
+			ob.RejectOrder(o, err.Error())
+			return nil, err
+		}
+		o.Protection.Price = snap(o.Protection.Price, ob.Config.TickSize)
+		if o.Notional != 0 {
+			if err := ob.sizeByNotional(o); err != nil {
+				ob.RejectOrder(o, err.Error())
+				return nil, err
+			}
+		}
+	}
+	if err := ob.Config.Validate(orderType, price, o.Size); err != nil {
+		ob.RejectOrder(o, err.Error())
+		return nil, err
+	}
+	price, size := ob.Config.Snap(price, o.Size)
+	o.InitialSize += size - o.Size
+	o.Size = size
+	if orderType == OrderTypeLimit {
+		if err := ob.reserveFunds(orderType, price, o); err != nil {
+			ob.RejectOrder(o, err.Error())
+			return nil, err
+		}
+		ob.PlaceLimitOrder(price, o)
+		return nil, nil
+	}
+
+	if err := ob.reserveFunds(orderType, price, o); err != nil {
+		ob.RejectOrder(o, err.Error())
+		return nil, err
+	}
+	return ob.PlaceMarketOrder(o)
+}
+
+// CancelOrder removes a resting order from its book and records it as cancelled.
+func (s *CryptoExchangeService) CancelOrder(ctx context.Context, o *Order) error {
+	orderBook, ok := s.OrderBooks[o.Market]
+	if !ok {
+		return ErrMarketNotFound
+	}
+
+	var record OrderRecord
+	err := ErrOrderNotResting
+	orderBook.Exclusive(func() {
+		// The order may have been filled or pulled since the caller looked it up.
+		if _, resting := orderBook.GetOrder(o.ID); !resting {
+			return
+		}
+		orderBook.CancelOrder(o)
+		record = cancelledRecord(o, CancelReasonUser)
+		err = nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.saveRecords(ctx, record)
+}
+
+// CancelUserOrder cancels one of the user's resting orders by ID.
+// An order that is not resting, or belongs to someone else, is reported as ErrOrderNotResting.
+func (s *CryptoExchangeService) CancelUserOrder(ctx context.Context, userID, orderID string) error {
+	state, ok := s.Orders.Get(orderID)
+	if !ok {
+		return ErrOrderNotResting
+	}
+	orderBook, ok := s.OrderBooks[state.Market]
+	if !ok {
+		return ErrOrderNotResting
+	}
+
+	var (
+		record OrderRecord
+		err    error
+	)
+	orderBook.Exclusive(func() {
+		record, err = s.cancelLocked(orderBook, userID, orderID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.saveRecords(ctx, record)
+}
+
+// AmendUserOrder changes the price and the size left to fill of one of the user's resting orders,
+// as a batch amend does. An order that is not resting, or belongs to someone else, is reported as
+// ErrAmendNotResting.
+func (s *CryptoExchangeService) AmendUserOrder(ctx context.Context, userID, orderID string, price, size Money) (OrderStatus, error) {
+	state, ok := s.Orders.Get(orderID)
+	if !ok {
+		return "", ErrAmendNotResting
+	}
+	orderBook, ok := s.OrderBooks[state.Market]
+	if !ok {
+		return "", ErrAmendNotResting
+	}
+
+	var (
+		result  BatchResult
+		records []OrderRecord
+		err     error
+	)
+	amend := BatchOperation{Op: BatchAmend, OrderID: orderID, Price: price, Size: size}
+	orderBook.Exclusive(func() {
+		result, _, records, err = s.applyLocked(orderBook, state.Market, userID, amend)
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.Status, s.saveRecords(ctx, records...)
+}
+
+// userOrderLocked returns one of the user's resting orders. The caller holds the book's lock.
+func userOrderLocked(orderBook *CompleteOrderBook, userID, orderID string) (*Order, error) {
+	o, resting := orderBook.GetOrder(orderID)
+	// Someone else's order is reported as not resting, so order IDs can't be probed.
+	if !resting || o.UserID != userID {
+		return nil, ErrOrderNotResting
+	}
+	return o, nil
+}
+
+// cancelLocked cancels one of the user's resting orders. The caller holds the book's lock.
+func (s *CryptoExchangeService) cancelLocked(orderBook *CompleteOrderBook, userID, orderID string) (OrderRecord, error) {
+	o, err := userOrderLocked(orderBook, userID, orderID)
+	if err != nil {
+		return OrderRecord{}, err
+	}
+	orderBook.CancelOrder(o)
+	return cancelledRecord(o, CancelReasonUser), nil
+}
+
+// CancelFilter selects the resting orders of a mass cancel. Empty fields match everything.
+type CancelFilter struct {
+	UserID string
+	Market Market
+	Side   Side
+}
+
+func (f CancelFilter) matches(o *Order) bool {
+	return (f.UserID == "" || o.UserID == f.UserID) && f.Side.Matches(o.Bid)
+}
+
+// CancelAll pulls every resting order matching the filter and returns their IDs.
+// Each market's orders are pulled in one step, so no match can interleave with the cancellation.
+func (s *CryptoExchangeService) CancelAll(ctx context.Context, filter CancelFilter, reason CancelReason) ([]string, error) {
+	if filter.Market != "" {
+		if _, ok := s.OrderBooks[filter.Market]; !ok {
+			return nil, ErrMarketNotFound
+		}
+	}
+
+	cancelled := []string{}
+	var records []OrderRecord
+	for market, orderBook := range s.OrderBooks {
+		if filter.Market != "" && market != filter.Market {
+			continue
+		}
+		orderBook.Exclusive(func() {
+			for _, o := range orderBook.CancelWhere(filter.matches, reason) {
+				cancelled = append(cancelled, o.ID)
+				records = append(records, cancelledRecord(o, reason))
+			}
+		})
+	}
+	return cancelled, s.saveRecords(ctx, records...)
+}
+
+// ExpireOrders pulls every resting order whose expiry is at or before now, and returns how many were expired.
+func (s *CryptoExchangeService) ExpireOrders(ctx context.Context, now time.Time) (int, error) {
+	var records []OrderRecord
+	for _, orderBook := range s.OrderBooks {
+		orderBook.Exclusive(func() {
+			expired := orderBook.CancelWhere(func(o *Order) bool {
+				return o.ExpiresAt != 0 && o.ExpiresAt <= now.UnixNano()
+			}, CancelReasonExpired)
+			for _, o := range expired {
+				records = append(records, cancelledRecord(o, CancelReasonExpired))
+			}
+		})
+	}
+	return len(records), s.saveRecords(ctx, records...)
+}
+
+// RunExpirySweeper expires orders, fires dead man's switches, and lifts halts whose cooldown is
+// over, every interval until the context is done. The interval is wall time; what has expired is
+// decided by the exchange's clock.
+func (s *CryptoExchangeService) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := s.now()
+			if _, err := s.ExpireOrders(ctx, now); err != nil {
+				log.Printf("Expiry sweep failed: %v", err)
+			}
+			if _, err := s.Switches.Expire(ctx, now); err != nil {
+				log.Printf("Dead man's switch sweep failed: %v", err)
+			}
+			if err := s.ResumeMarkets(ctx, now); err != nil {
+				log.Printf("Resuming markets failed: %v", err)
+			}
+		}
+	}
+}
+
+// settle turns matches into trades and persists them with their ledger entries. The wallets were
+// settled by Funds as the trades were made.
+func (s *CryptoExchangeService) settle(ctx context.Context, market Market, matches []MatchEngine) error {
+	base, quote := market.Assets()
+	for _, match := range matches {
+		if match.SizeFilled == 0 {
+			continue
+		}
+		trade := Trade{
+			ID:          match.ID,
+			Market:      market,
+			Price:       match.Price,
+			Size:        match.SizeFilled,
+			BuyOrderID:  match.Bid.ID,
+			SellOrderID: match.Ask.ID,
+			BuyerID:     match.Bid.UserID,
+			SellerID:    match.Ask.UserID,
+			ExecutedAt:  s.now().UnixNano(),
+		}
+
+		if s.Store != nil {
+			if _, err := SettleTrade(ctx, s.Store, trade, base, quote); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveRecords persists order records, taken while the book was locked, in one transaction.
+func (s *CryptoExchangeService) saveRecords(ctx context.Context, records ...OrderRecord) error {
+	if s.Store == nil || len(records) == 0 {
+		return nil
+	}
+	updatedAt := s.now().UnixNano()
+	return s.Store.InTransaction(ctx, func(tx Repositories) error {
+		for _, record := range records {
+			record.UpdatedAt = updatedAt
+			if err := tx.Orders().SaveOrder(ctx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// cancelledRecord records an order as pulled from the book.
+func cancelledRecord(o *Order, reason CancelReason) OrderRecord {
+	record := orderRecord(o)
+	record.Status = StatusCancelled
+	if reason == CancelReasonExpired {
+		record.Status = StatusExpired
+	}
+	return record
+}
+
+func orderRecord(o *Order) OrderRecord {
+	return OrderRecord{
+		ID:        o.ID,
+		UserID:    o.UserID,
+		Market:    o.Market,
+		Bid:       o.Bid,
+		Price:     o.Price,
+		Size:      o.InitialSize,
+		Remaining: o.Size,
+		Status:    o.Status(),
+		CreatedAt: o.TimeStamp,
+	}
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// Event types of the level-3 feed.
+const (
+	EventL3Add     = "l3.add"
+	EventL3Modify  = "l3.modify"
+	EventL3Delete  = "l3.delete"
+	EventL3Execute = "l3.execute"
+)
+
+var (
+	ErrL3Gap          = errors.New("l3 sequence gap")
+	ErrL3UnknownOrder = errors.New("l3 message for an unknown order")
+)
+
+// L3Action is what an L3 message does to a resting order.
+type L3Action string
+
+const (
+	// L3Add puts a new order at the back of its level.
+	L3Add L3Action = "add"
+	// L3Modify changes an order's price or size. Unless Requeued is set, the order keeps its place.
+	L3Modify L3Action = "modify"
+	// L3Delete removes an order that was cancelled or expired.
+	L3Delete L3Action = "delete"
+	// L3Execute fills part of an order; the order leaves the book when nothing Remains.
+	L3Execute L3Action = "execute"
+)
+
+// L3Message is one change to one resting order. Orders are known by anonymized IDs, assigned by
+// the feed when they reach the book and kept until they leave it. Sequence numbers are per market,
+// start at 1 and have no gaps.
+type L3Message struct {
+	Market    Market   `json:"market"`
+	Sequence  uint64   `json:"sequence"`
+	Timestamp int64    `json:"timestamp"` // unix nanoseconds of the book event behind the message.
+	Action    L3Action `json:"action"`
+	OrderID   uint64   `json:"orderId"`
+	Bid       bool     `json:"bid"`
+	Price     Money    `json:"price"`
+	// Size is the order's size left to fill after an add or modify, and the size filled by an execute.
+	Size      Money `json:"size"`
+	Remaining Money `json:"remaining"` // size left after an execute.
+	// Requeued is set on a modify that sent the order to the back of its new level.
+	Requeued bool `json:"requeued,omitempty"`
+	// Top is set on an add or a requeue that made the order its level's top order, which the
+	// fifo_top_order policy fills first.
+	Top bool `json:"top,omitempty"`
+}
+
+func (m L3Message) EventType() string { return "l3." + string(m.Action) }
+
+// L3Order is a resting order of an L3 snapshot.
+type L3Order struct {
+	OrderID uint64 `json:"orderId"`
+	Size    Money  `json:"size"`
+}
+
+// L3Level is a price level of an L3 snapshot, its orders in time priority.
+type L3Level struct {
+	Price  Money     `json:"price"`
+	Orders []L3Order `json:"orders"`
+	// Top is the level's top order, zero when it has none.
+	Top uint64 `json:"top,omitempty"`
+}
+
+// L3Snapshot is every resting order of a market as of a sequence number, levels best first.
+type L3Snapshot struct {
+	Market   Market    `json:"market"`
+	Sequence uint64    `json:"sequence"`
+	Bids     []L3Level `json:"bids"`
+	Asks     []L3Level `json:"asks"`
+}
+
+type l3Order struct {
+	id    uint64
+	bid   bool
+	price Money
+	size  Money
+}
+
+type l3Level struct {
+	orders []*l3Order
+	top    *l3Order
+}
+
+// L3Book is a book rebuilt order by order: start it from a snapshot and apply the messages that
+// follow it. The feed keeps one per market to answer snapshots, and clients can do the same.
+type L3Book struct {
+	Market   Market
+	Sequence uint64
+	orders   map[uint64]*l3Order
+	bids     map[Money]*l3Level
+	asks     map[Money]*l3Level
+}
+
+// NewL3Book creates the book described by the snapshot.
+func NewL3Book(snapshot L3Snapshot) *L3Book {
+	b := &L3Book{
+		Market:   snapshot.Market,
+		Sequence: snapshot.Sequence,
+		orders:   make(map[uint64]*l3Order),
+		bids:     make(map[Money]*l3Level),
+		asks:     make(map[Money]*l3Level),
+	}
+	for _, side := range []struct {
+		bid    bool
+		levels []L3Level
+	}{{true, snapshot.Bids}, {false, snapshot.Asks}} {
+		for _, level := range side.levels {
+			for _, o := range level.Orders {
+				b.add(&l3Order{id: o.OrderID, bid: side.bid, price: level.Price, size: o.Size}, o.OrderID == level.Top)
+			}
+		}
+	}
+	return b
+}
+
+func (b *L3Book) side(bid bool) map[Money]*l3Level {
+	if bid {
+		return b.bids
+	}
+	return b.asks
+}
+
+func (b *L3Book) add(o *l3Order, top bool) {
+	levels := b.side(o.bid)
+	level := levels[o.price]
+	if level == nil {
+		level = &l3Level{}
+		levels[o.price] = level
+	}
+	level.orders = append(level.orders, o)
+	if top {
+		level.top = o
+	}
+	b.orders[o.id] = o
+}
+
+func (b *L3Book) remove(o *l3Order) {
+	levels := b.side(o.bid)
+	level := levels[o.price]
+	for i, queued := range level.orders {
+		if queued == o {
+			level.orders = append(level.orders[:i], level.orders[i+1:]...)
+			break
+		}
+	}
+	if level.top == o {
+		level.top = nil
+	}
+	if len(level.orders) == 0 {
+		delete(levels, o.price)
+	}
+	delete(b.orders, o.id)
+}
+
+// improves reports whether an order at the price would be the best of its side, as the
+// CompleteOrderBook decides when it picks a level's top order. The level of the leaving order
+// does not count when that order is alone in it.
+func (b *L3Book) improves(bid bool, price Money, leaving *l3Order) bool {
+	for p, level := range b.side(bid) {
+		if leaving != nil && p == leaving.price && len(level.orders) == 1 {
+			continue
+		}
+		if bid && p >= price || !bid && p <= price {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply applies the next message of the market. A message out of sequence returns ErrL3Gap and
+// changes nothing: the client has missed messages and starts again from a new snapshot.
+func (b *L3Book) Apply(m L3Message) error {
+	if m.Sequence != b.Sequence+1 {
+		return fmt.Errorf("%w: expected %d, got %d", ErrL3Gap, b.Sequence+1, m.Sequence)
+	}
+	o, known := b.orders[m.OrderID]
+	if m.Action != L3Add && !known {
+		return fmt.Errorf("%w: %d", ErrL3UnknownOrder, m.OrderID)
+	}
+	switch m.Action {
+	case L3Add:
+		b.add(&l3Order{id: m.OrderID, bid: m.Bid, price: m.Price, size: m.Size}, m.Top)
+	case L3Modify:
+		if !m.Requeued {
+			o.size = m.Size
+			break
+		}
+		b.remove(o)
+		o.price, o.size = m.Price, m.Size
+		b.add(o, m.Top)
+	case L3Delete:
+		b.remove(o)
+	case L3Execute:
+		o.size = m.Remaining
+		if o.size <= 0 {
+			b.remove(o)
+		}
+	default:
+		return fmt.Errorf("unknown l3 action %q", m.Action)
+	}
+	b.Sequence = m.Sequence
+	return nil
+}
+
+// Snapshot returns the book's orders, levels best first and orders in time priority.
+func (b *L3Book) Snapshot() L3Snapshot {
+	return L3Snapshot{Market: b.Market, Sequence: b.Sequence, Bids: b.levels(true), Asks: b.levels(false)}
+}
+
+func (b *L3Book) levels(bid bool) []L3Level {
+	side := b.side(bid)
+	prices := make([]Money, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if bid {
+			return prices[i] > prices[j]
+		}
+		return prices[i] < prices[j]
+	})
+	levels := make([]L3Level, 0, len(prices))
+	for _, price := range prices {
+		level := L3Level{Price: price, Orders: make([]L3Order, 0, len(side[price].orders))}
+		for _, o := range side[price].orders {
+			level.Orders = append(level.Orders, L3Order{OrderID: o.id, Size: o.size})
+		}
+		if top := side[price].top; top != nil {
+			level.Top = top.id
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// l3Market is the feed's state of one market: its rebuilt book and the anonymized IDs of the
+// orders resting on it.
+type l3Market struct {
+	book *L3Book
+	ids  map[string]uint64
+	last uint64
+	// after is the sequence number of the last book event already in the rebuilt book.
+	after uint64
+}
+
+// L3Feed turns the order events of the books into L3 messages, published on Messages.
+type L3Feed struct {
+	// Messages carries the L3 messages of every market, each market's in sequence.
+	Messages *messaging.Dispatcher
+
+	exchange   *CryptoExchangeService
+	events     *messaging.Dispatcher
+	subscriber *messaging.Subscriber
+	mu         sync.Mutex
+	markets    map[Market]*l3Market
+}
+
+// NewL3Feed starts following the exchange's books: it subscribes to their events, then copies
+// each book as it stands, so nothing is missed or counted twice. Run it to keep up, as the
+// subscription blocks the books when it falls behind.
+func NewL3Feed(exchange *CryptoExchangeService) *L3Feed {
+	f := &L3Feed{
+		Messages: messaging.NewDispatcher(),
+		exchange: exchange,
+		events:   exchange.Events,
+		markets:  make(map[Market]*l3Market),
+	}
+	f.subscriber = exchange.Events.Subscribe("l3", 4096, messaging.Block, func(event messaging.Event) bool {
+		_, ok := event.(MarketEvent)
+		return ok
+	})
+	for market, orderBook := range exchange.OrderBooks {
+		orderBook.Exclusive(func() {
+			f.markets[market] = newL3Market(market, orderBook)
+		})
+	}
+	return f
+}
+
+// newL3Market copies the resting orders of a book. The caller holds the book's lock.
+func newL3Market(market Market, orderBook *CompleteOrderBook) *l3Market {
+	m := &l3Market{book: NewL3Book(L3Snapshot{Market: market}), ids: make(map[string]uint64)}
+	if orderBook == nil {
+		return m
+	}
+	m.after = orderBook.Sequence()
+	for _, limits := range [][]*Limit{orderBook.SortBids(), orderBook.SortAsk()} {
+		for _, limit := range limits {
+			for _, o := range limit.Orders {
+				m.book.add(&l3Order{id: m.assign(o.ID), bid: o.Bid, price: limit.Price, size: o.Size}, limit.Top == o)
+			}
+		}
+	}
+	return m
+}
+
+func (m *l3Market) assign(orderID string) uint64 {
+	m.last++
+	m.ids[orderID] = m.last
+	return m.last
+}
+
+// Run keeps the feed up with the books until the context is done.
+func (f *L3Feed) Run(ctx context.Context) {
+	defer f.events.Unsubscribe(f.subscriber)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-f.subscriber.Events():
+			if !ok {
+				return
+			}
+			f.apply(event.(MarketEvent))
+		}
+	}
+}
+
+// Snapshot returns the resting orders of the market as of the feed's last message. Subscribe to
+// Messages first and apply the messages after the snapshot's sequence number to stay in sync.
+func (f *L3Feed) Snapshot(market Market) (L3Snapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, err := f.market(market)
+	if err != nil {
+		return L3Snapshot{}, err
+	}
+	return m.book.Snapshot(), nil
+}
+
+// market returns the state of a market, creating it for markets listed after the feed started,
+// whose books were empty. The caller holds f.mu.
+func (f *L3Feed) market(market Market) (*l3Market, error) {
+	if m, ok := f.markets[market]; ok {
+		return m, nil
+	}
+	if _, ok := f.exchange.OrderBooks[market]; !ok {
+		return nil, ErrMarketNotFound
+	}
+	m := newL3Market(market, nil)
+	f.markets[market] = m
+	return m, nil
+}
+
+func (f *L3Feed) apply(event MarketEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	header := event.Header()
+	m, err := f.market(header.Market)
+	if err != nil || header.Sequence <= m.after {
+		return
+	}
+	for _, message := range m.translate(event) {
+		message.Market, message.Timestamp = header.Market, header.Timestamp
+		message.Sequence = m.book.Sequence + 1
+		m.book.Apply(message)
+		f.Messages.Publish(message)
+	}
+}
+
+// translate returns the L3 messages of a book event. Only orders resting on the book have
+// messages: market orders, which take liquidity without resting, never appear.
+func (m *l3Market) translate(event MarketEvent) []L3Message {
+	add := func(orderID string, bid bool, price, size Money) []L3Message {
+		top := m.book.improves(bid, price, nil)
+		return []L3Message{{Action: L3Add, OrderID: m.assign(orderID), Bid: bid, Price: price, Size: size, Top: top}}
+	}
+	resting := func(orderID string) (*l3Order, bool) {
+		o, ok := m.book.orders[m.ids[orderID]]
+		return o, ok
+	}
+	leave := func(orderID string) {
+		delete(m.ids, orderID)
+	}
+
+	switch ev := event.(type) {
+	case OrderAccepted:
+		if ev.Type == OrderTypeLimit {
+			return add(ev.OrderID, ev.Bid, ev.Price, ev.Size)
+		}
+	case OrderRested:
+		return add(ev.OrderID, ev.Bid, ev.Price, ev.Remaining)
+	case OrderAmended:
+		o, ok := resting(ev.OrderID)
+		if !ok {
+			return nil
+		}
+		message := L3Message{Action: L3Modify, OrderID: o.id, Bid: o.bid, Price: ev.Price, Size: ev.Remaining}
+		if ev.Price != o.price || ev.Remaining > o.size {
+			// The book re-queues the order, keeping it top when it stays at the price it topped.
+			keepsTop := m.book.side(o.bid)[o.price].top == o && ev.Price == o.price
+			message.Requeued = true
+			message.Top = keepsTop || m.book.improves(o.bid, ev.Price, o)
+		}
+		return []L3Message{message}
+	case OrderCancelled:
+		if o, ok := resting(ev.OrderID); ok {
+			leave(ev.OrderID)
+			return []L3Message{{Action: L3Delete, OrderID: o.id, Bid: o.bid, Price: o.price}}
+		}
+	case OrderPartiallyFilled:
+		if o, ok := resting(ev.OrderID); ok {
+			return []L3Message{{Action: L3Execute, OrderID: o.id, Bid: o.bid, Price: ev.Price, Size: ev.FilledSize, Remaining: ev.Remaining}}
+		}
+	case OrderFilled:
+		if o, ok := resting(ev.OrderID); ok {
+			leave(ev.OrderID)
+			return []L3Message{{Action: L3Execute, OrderID: o.id, Bid: o.bid, Price: ev.Price, Size: ev.FilledSize}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrInvalidSize     = errors.New("invalid size")
+	ErrInvalidPrice    = errors.New("invalid price")
+	ErrInvalidNotional = errors.New("invalid notional")
+)
+
+// MarketConfig holds the trading rules of a market. A zero value disables its rule.
+type MarketConfig struct {
+	TickSize    Money `json:"tickSize"` // prices are multiples of it.
+	LotSize     Money `json:"lotSize"`  // sizes are multiples of it.
+	MinSize     Money `json:"minSize"`
+	MaxSize     Money `json:"maxSize"`
+	MinNotional Money `json:"minNotional"` // smallest price times size of a limit order.
+
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"`
+	// AuctionDuration is how long opening and reopening call auctions last. Without it, halted markets reopen straight away.
+	AuctionDuration time.Duration `json:"auctionDuration"`
+	// Matching is how an incoming order is shared among the orders of a level, FIFO when empty.
+	Matching MatchingAlgorithm `json:"matching,omitempty"`
+}
+
+// DefaultMarketConfigs are the rules markets are opened with.
+var DefaultMarketConfigs = map[Market]MarketConfig{
+	MarketETH: {
+		TickSize: 0.01, LotSize: 0.0001, MinSize: 0.0001, MaxSize: 10000, MinNotional: 1,
+		CircuitBreaker:  CircuitBreakerConfig{MaxMove: 0.1, Window: time.Minute, Cooldown: 5 * time.Minute},
+		AuctionDuration: 30 * time.Second,
+	},
+}
+
+// IsInvalidOrder reports whether err rejects an order for breaking its market's rules.
+func IsInvalidOrder(err error) bool {
+	return errors.Is(err, ErrInvalidSize) || errors.Is(err, ErrInvalidPrice) || errors.Is(err, ErrInvalidNotional) ||
+		errors.Is(err, ErrInvalidProtection)
+}
+
+// Validate checks an order against the market's rules. Market orders have no price, so only their size is checked.
+func (c MarketConfig) Validate(orderType OrderType, price, size Money) error {
+	if size <= 0 || math.IsNaN(float64(size)) || math.IsInf(float64(size), 0) {
+		return fmt.Errorf("%w: must be positive", ErrInvalidSize)
+	}
+	if !isMultiple(size, c.LotSize) {
+		return fmt.Errorf("%w: size %v is not a multiple of the lot size %v", ErrInvalidSize, size, c.LotSize)
+	}
+	if c.MinSize > 0 && size < c.MinSize {
+		return fmt.Errorf("%w: size %v is below the minimum of %v", ErrInvalidSize, size, c.MinSize)
+	}
+	if c.MaxSize > 0 && size > c.MaxSize {
+		return fmt.Errorf("%w: size %v is above the maximum of %v", ErrInvalidSize, size, c.MaxSize)
+	}
+	if orderType == OrderTypeMarket {
+		return nil
+	}
+
+	if price <= 0 || math.IsNaN(float64(price)) || math.IsInf(float64(price), 0) {
+		return fmt.Errorf("%w: must be positive", ErrInvalidPrice)
+	}
+	if !isMultiple(price, c.TickSize) {
+		return fmt.Errorf("%w: price %v is not a multiple of the tick size %v", ErrInvalidPrice, price, c.TickSize)
+	}
+	if notional := price * size; c.MinNotional > 0 && notional < c.MinNotional {
+		return fmt.Errorf("%w: price times size %v is below the minimum of %v", ErrInvalidNotional, notional, c.MinNotional)
+	}
+	return nil
+}
+
+// isMultiple reports whether value is a whole number of steps. The tolerance only absorbs
+// float rounding: 1999.9999999 is not a multiple of 0.01.
+func isMultiple(value, step Money) bool {
+	if step <= 0 {
+		return true
+	}
+	steps := float64(value / step)
+	return math.Abs(steps-math.Round(steps)) <= 1e-6
+}
+
+// Snap rounds a price and size that passed Validate to their exact tick and lot multiples, so
+// prices within float noise of each other rest on the same level.
+func (c MarketConfig) Snap(price, size Money) (Money, Money) {
+	return snap(price, c.TickSize), snap(size, c.LotSize)
+}
+
+// snap rounds value to a whole number of steps. Decimal steps such as 0.01 divide by their
+// inverse, which lands on the double closest to the decimal value: 2000.01, not 2000.0100000000002.
+func snap(value, step Money) Money {
+	if step <= 0 || value == 0 {
+		return value
+	}
+	steps := math.Round(float64(value / step))
+	if inverse := math.Round(1 / float64(step)); math.Abs(inverse*float64(step)-1) < 1e-9 {
+		return Money(steps / inverse)
+	}
+	return Money(steps * float64(step))
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// QuoteAction is what a quote instruction does to the maker's orders.
+type QuoteAction string
+
+const (
+	QuotePlace     QuoteAction = "place"
+	QuoteCancel    QuoteAction = "cancel"
+	QuoteCancelAll QuoteAction = "cancel_all"
+)
+
+// QuoteInstruction is a change a strategy asks for: a limit order to place, or orders to pull.
+type QuoteInstruction struct {
+	Action  QuoteAction
+	OrderID string // the order to cancel.
+	Bid     bool
+	Price   Money
+	Size    Money
+}
+
+// PlaceQuote asks for a limit order of size at price.
+func PlaceQuote(bid bool, price, size Money) QuoteInstruction {
+	return QuoteInstruction{Action: QuotePlace, Bid: bid, Price: price, Size: size}
+}
+
+// CancelQuote asks for one of the maker's orders to be pulled.
+func CancelQuote(orderID string) QuoteInstruction {
+	return QuoteInstruction{Action: QuoteCancel, OrderID: orderID}
+}
+
+// CancelAllQuotes asks for every order of the maker on its market to be pulled.
+func CancelAllQuotes() QuoteInstruction {
+	return QuoteInstruction{Action: QuoteCancelAll}
+}
+
+// MakerQuote is a resting order of the maker.
+type MakerQuote struct {
+	OrderID string
+	Bid     bool
+	Price   Money
+	Size    Money // size left to fill.
+}
+
+// MarketView is what a strategy sees of its market when it decides on its quotes.
+type MarketView struct {
+	Market Market
+	Config MarketConfig
+	// BestBid and BestAsk are the best prices of the other participants, zero when they have none,
+	// so a strategy never prices off its own quotes.
+	BestBid   Money
+	BestAsk   Money
+	LastPrice Money // price of the market's last trade, zero before the first.
+	Position  Position
+	Quotes    []MakerQuote
+}
+
+// Reference returns the price to quote around: the mid of the other participants' best prices,
+// the side they quote when only one, and the last trade when the book has neither.
+func (v MarketView) Reference() (Money, bool) {
+	switch {
+	case v.BestBid > 0 && v.BestAsk > 0:
+		return (v.BestBid + v.BestAsk) / 2, true
+	case v.BestBid > 0:
+		return v.BestBid, true
+	case v.BestAsk > 0:
+		return v.BestAsk, true
+	}
+	return v.LastPrice, v.LastPrice > 0
+}
+
+// Strategy decides a market maker's quotes. OnEvent is called with every trade and level change
+// of the maker's market, and with a nil event to requote without one, as when the maker starts,
+// and returns the changes to make to the maker's orders. Calls are never concurrent.
+type Strategy interface {
+	OnEvent(event messaging.Event, view MarketView) []QuoteInstruction
+}
+
+// Position is the inventory and profit of a trader in one market, at average cost.
+type Position struct {
+	Base        Money `json:"base"`        // inventory, negative when short.
+	AverageCost Money `json:"averageCost"` // price the open inventory was built at.
+	RealizedPnL Money `json:"realizedPnl"` // quote currency made closing inventory.
+	Volume      Money `json:"volume"`      // base traded.
+	Trades      int   `json:"trades"`
+}
+
+// Apply folds a fill into the position: a buy when bid is set, a sell otherwise. The part of a fill
+// closing inventory realizes its profit against the average cost; the rest opens at the fill price.
+func (p *Position) Apply(bid bool, price, size Money) {
+	signed := size
+	if !bid {
+		signed = -size
+	}
+	p.Volume += size
+	p.Trades++
+
+	if p.Base != 0 && (p.Base > 0) != bid {
+		closing := Money(math.Min(float64(size), math.Abs(float64(p.Base))))
+		if p.Base > 0 {
+			p.RealizedPnL += (price - p.AverageCost) * closing
+		} else {
+			p.RealizedPnL += (p.AverageCost - price) * closing
+		}
+		if closing == size {
+			p.Base += signed
+			if p.Base == 0 {
+				p.AverageCost = 0
+			}
+			return
+		}
+		// The fill flips the position: what is left over opens at the fill price.
+		p.Base, p.AverageCost = signed+p.Base, price
+		return
+	}
+	p.AverageCost = (p.AverageCost*p.Base + price*signed) / (p.Base + signed)
+	p.Base += signed
+}
+
+// UnrealizedPnL returns what closing the inventory at mark would make.
+func (p Position) UnrealizedPnL(mark Money) Money {
+	return (mark - p.AverageCost) * p.Base
+}
+
+// PnL returns the realized profit and the open inventory marked at mark.
+func (p Position) PnL(mark Money) Money {
+	return p.RealizedPnL + p.UnrealizedPnL(mark)
+}
+
+// MakerStats counts the instructions a market maker carried out.
+type MakerStats struct {
+	Placed    int `json:"placed"`
+	Cancelled int `json:"cancelled"`
+	Rejected  int `json:"rejected"`
+}
+
+// MarketMaker runs a strategy in process for an account on one market. Its orders go through the
+// exchange service like any client's, so they pass the same market state, tick, lot and size
+// checks, are recorded the same way, and settle into the account's wallet.
+type MarketMaker struct {
+	exchange *CryptoExchangeService
+	Market   Market
+	UserID   string
+	Strategy Strategy
+
+	mu        sync.Mutex
+	position  Position
+	lastPrice Money
+	stats     MakerStats
+}
+
+// NewMarketMaker creates a maker quoting the strategy's orders on the market for the account.
+func NewMarketMaker(exchange *CryptoExchangeService, market Market, userID string, strategy Strategy) *MarketMaker {
+	return &MarketMaker{exchange: exchange, Market: market, UserID: userID, Strategy: strategy}
+}
+
+// Run quotes until the context is done, then pulls the maker's orders. Book events are published
+// while the book is locked, so they are queued and handled apart from delivery: the maker's own
+// orders publish events too.
+func (m *MarketMaker) Run(ctx context.Context) {
+	if _, ok := m.exchange.OrderBooks[m.Market]; !ok {
+		log.Printf("[market maker] %s: %v", m.Market, ErrMarketNotFound)
+		return
+	}
+	subscriber := m.exchange.Events.Subscribe("market_maker:"+m.UserID, 4096, messaging.Block, func(event messaging.Event) bool {
+		e, ok := event.(MarketEvent)
+		if !ok || e.Header().Market != m.Market {
+			return false
+		}
+		return event.EventType() == EventTradeExecuted || event.EventType() == EventLevelChanged
+	})
+
+	var (
+		queueMu sync.Mutex
+		queue   []messaging.Event
+		wake    = make(chan struct{}, 1)
+	)
+	go func() {
+		for event := range subscriber.Events() {
+			queueMu.Lock()
+			queue = append(queue, event)
+			queueMu.Unlock()
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	defer func() {
+		m.exchange.Events.Unsubscribe(subscriber)
+		if _, err := m.exchange.CancelAll(context.Background(), CancelFilter{UserID: m.UserID, Market: m.Market}, CancelReasonUser); err != nil {
+			log.Printf("[market maker] %s: pulling quotes: %v", m.Market, err)
+		}
+	}()
+
+	if err := m.Handle(ctx, nil); err != nil {
+		log.Printf("[market maker] %s: %v", m.Market, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			queueMu.Lock()
+			events := queue
+			queue = nil
+			queueMu.Unlock()
+			for _, event := range events {
+				if err := m.Handle(ctx, event); err != nil {
+					log.Printf("[market maker] %s: %v", m.Market, err)
+				}
+			}
+		}
+	}
+}
+
+// Handle feeds one event of the maker's market to the strategy and carries out its instructions.
+// Trades of the maker's account update its position first. A nil event asks for fresh quotes.
+// Instructions the exchange refuses are counted as rejected and returned together.
+func (m *MarketMaker) Handle(ctx context.Context, event messaging.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if trade, ok := event.(TradeExecuted); ok {
+		m.lastPrice = trade.Price
+		if trade.BuyerID == m.UserID {
+			m.position.Apply(true, trade.Price, trade.Size)
+		}
+		if trade.SellerID == m.UserID {
+			m.position.Apply(false, trade.Price, trade.Size)
+		}
+	}
+
+	view, err := m.view()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, instruction := range m.Strategy.OnEvent(event, view) {
+		if err := m.execute(ctx, instruction); err != nil {
+			m.stats.Rejected++
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// view reads the maker's market and orders off the book.
+func (m *MarketMaker) view() (MarketView, error) {
+	orderBook, ok := m.exchange.OrderBooks[m.Market]
+	if !ok {
+		return MarketView{}, ErrMarketNotFound
+	}
+	var view MarketView
+	orderBook.Exclusive(func() {
+		view = orderBook.viewLocked(m.UserID)
+	})
+	view.LastPrice, view.Position = m.lastPrice, m.position
+	return view, nil
+}
+
+// viewLocked returns the book as a strategy trading for the account sees it. Quotes are listed
+// bids first, best price first, then in time priority. The caller holds the book's lock.
+func (ob *CompleteOrderBook) viewLocked(userID string) MarketView {
+	view := MarketView{Market: ob.Market, Config: ob.Config}
+	own := make(map[Money]Money)
+	for _, o := range ob.RestingOrders() {
+		if o.UserID != userID {
+			continue
+		}
+		view.Quotes = append(view.Quotes, MakerQuote{OrderID: o.ID, Bid: o.Bid, Price: o.Price, Size: o.Size})
+		own[o.Price] += o.Size
+	}
+	sort.Slice(view.Quotes, func(i, j int) bool {
+		a, b := view.Quotes[i], view.Quotes[j]
+		if a.Bid != b.Bid {
+			return a.Bid
+		}
+		if a.Price != b.Price {
+			return a.Bid == (a.Price > b.Price)
+		}
+		return ob.queuedBefore(a.OrderID, b.OrderID)
+	})
+	for _, limit := range ob.Bids {
+		if limit.TotalVolume > own[limit.Price] && limit.Price > view.BestBid {
+			view.BestBid = limit.Price
+		}
+	}
+	for _, limit := range ob.Asks {
+		if limit.TotalVolume > own[limit.Price] && (view.BestAsk == 0 || limit.Price < view.BestAsk) {
+			view.BestAsk = limit.Price
+		}
+	}
+	return view
+}
+
+// queuedBefore reports whether the first of two resting orders at the same price is ahead in the queue.
+func (ob *CompleteOrderBook) queuedBefore(first, second string) bool {
+	o := ob.resting[first]
+	for _, queued := range o.Limit.Orders {
+		switch queued.ID {
+		case first:
+			return true
+		case second:
+			return false
+		}
+	}
+	return first < second
+}
+
+func (m *MarketMaker) execute(ctx context.Context, instruction QuoteInstruction) error {
+	switch instruction.Action {
+	case QuotePlace:
+		o := NewOrder(instruction.Bid, instruction.Size)
+		o.UserID = m.UserID
+		if err := m.exchange.PlaceLimitOrder(ctx, m.Market, instruction.Price, o); err != nil {
+			return err
+		}
+		m.stats.Placed++
+	case QuoteCancel:
+		if err := m.exchange.CancelUserOrder(ctx, m.UserID, instruction.OrderID); err != nil {
+			return err
+		}
+		m.stats.Cancelled++
+	case QuoteCancelAll:
+		cancelled, err := m.exchange.CancelAll(ctx, CancelFilter{UserID: m.UserID, Market: m.Market}, CancelReasonUser)
+		m.stats.Cancelled += len(cancelled)
+		return err
+	}
+	return nil
+}
+
+// Position returns the maker's inventory and realized profit.
+func (m *MarketMaker) Position() Position {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.position
+}
+
+// Stats returns how many instructions the maker carried out, and how many the exchange refused.
+func (m *MarketMaker) Stats() MakerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// SymmetricSpread quotes one bid and one ask around the market's reference price, SpreadBps apart.
+// Inventory skews both quotes: long, they move down to sell more readily and buy less; short,
+// they move up. The skew grows with the position and reaches SkewBps at MaxInventory, where the
+// side adding to the position stops quoting.
+type SymmetricSpread struct {
+	SpreadBps    float64
+	Size         Money
+	SkewBps      float64
+	MaxInventory Money // zero for no limit and no skew.
+}
+
+// OnEvent keeps one bid and one ask at the prices the reference and position call for. A quote
+// already at its price is left alone, partially filled or not, so it keeps its time priority.
+func (s SymmetricSpread) OnEvent(_ messaging.Event, view MarketView) []QuoteInstruction {
+	reference, ok := view.Reference()
+	if !ok {
+		if len(view.Quotes) == 0 {
+			return nil
+		}
+		return []QuoteInstruction{CancelAllQuotes()}
+	}
+
+	var skew float64
+	if s.MaxInventory > 0 {
+		ratio := math.Max(-1, math.Min(1, float64(view.Position.Base/s.MaxInventory)))
+		skew = s.SkewBps * ratio
+	}
+	half := s.SpreadBps / 2
+	tick := view.Config.TickSize
+	bid := roundDown(reference*Money(1-(half+skew)/10000), tick)
+	ask := roundUp(reference*Money(1+(half-skew)/10000), tick)
+	// Never cross the other participants' quotes.
+	if view.BestAsk > 0 && bid >= view.BestAsk {
+		bid = view.BestAsk - tick
+	}
+	if view.BestBid > 0 && ask <= view.BestBid {
+		ask = view.BestBid + tick
+	}
+	// Snapped as the book snaps resting prices, so a quote is found at its price again.
+	bid, ask = snap(bid, tick), snap(ask, tick)
+
+	wantBid := bid > 0 && (s.MaxInventory <= 0 || view.Position.Base < s.MaxInventory)
+	wantAsk := s.MaxInventory <= 0 || view.Position.Base > -s.MaxInventory
+	var instructions []QuoteInstruction
+	keptBid, keptAsk := false, false
+	for _, quote := range view.Quotes {
+		switch {
+		case quote.Bid && wantBid && !keptBid && quote.Price == bid:
+			keptBid = true
+		case !quote.Bid && wantAsk && !keptAsk && quote.Price == ask:
+			keptAsk = true
+		default:
+			instructions = append(instructions, CancelQuote(quote.OrderID))
+		}
+	}
+	if wantBid && !keptBid {
+		instructions = append(instructions, PlaceQuote(true, bid, s.Size))
+	}
+	if wantAsk && !keptAsk {
+		instructions = append(instructions, PlaceQuote(false, ask, s.Size))
+	}
+	return instructions
+}
@@ -0,0 +1,725 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// A recording is one market's events in a file:
+//
+//	header   "CXMD", version byte, market (uvarint length and bytes)
+//	records  uvarint payload length, then the payload: kind byte, sequence uvarint,
+//	         timestamp zigzag varint and the event's fields
+//	index    fixed 24 byte entries: sequence, timestamp and file offset of every
+//	         recordingIndexInterval-th record, big endian
+//	trailer  index offset (8 bytes), entry count (4 bytes), "CXIX"
+//
+// Strings are uvarint length prefixed, prices and sizes are float64 bits, booleans a byte.
+// The index and trailer are written on Close. A file without them, cut short by a crash, is
+// still readable: opening it scans the records and stops at the first incomplete one.
+const (
+	recordingMagic         = "CXMD"
+	recordingIndexMagic    = "CXIX"
+	recordingVersion       = 1
+	recordingIndexInterval = 256
+	recordingIndexEntry    = 24
+	recordingTrailer       = 16
+	// maxRecordSize bounds a record's payload, so a corrupt length can't exhaust memory.
+	maxRecordSize = 1 << 20
+)
+
+var (
+	ErrInvalidRecording = errors.New("invalid market recording")
+	ErrUnrecordedEvent  = errors.New("event cannot be recorded")
+)
+
+// Kinds of recorded events.
+const (
+	recordOrderAccepted byte = iota + 1
+	recordOrderRejected
+	recordOrderFilled
+	recordOrderPartiallyFilled
+	recordOrderCancelled
+	recordOrderAmended
+	recordTradeExecuted
+	recordLevelChanged
+	recordMarketStateChanged
+	recordIndicativePriceChanged
+	recordOrderRested
+)
+
+// RecordingIndexEntry locates a record by its sequence number and time. Within a market both
+// only grow, so the same entries index the recording by either.
+type RecordingIndexEntry struct {
+	Sequence  uint64
+	Timestamp int64
+	Offset    int64
+}
+
+type recordEncoder struct {
+	buf []byte
+}
+
+func (e *recordEncoder) byte(b byte)      { e.buf = append(e.buf, b) }
+func (e *recordEncoder) uvarint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+func (e *recordEncoder) varint(v int64)   { e.buf = binary.AppendVarint(e.buf, v) }
+
+func (e *recordEncoder) money(m Money) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(float64(m)))
+}
+
+func (e *recordEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *recordEncoder) bool(b bool) {
+	if b {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *recordEncoder) header(kind byte, h EventHeader) {
+	e.byte(kind)
+	e.uvarint(h.Sequence)
+	e.varint(h.Timestamp)
+}
+
+// encodeEvent appends the payload of a record to buf.
+func encodeEvent(buf []byte, event MarketEvent) ([]byte, error) {
+	e := &recordEncoder{buf: buf}
+	switch ev := event.(type) {
+	case OrderAccepted:
+		e.header(recordOrderAccepted, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.string(string(ev.Type))
+		e.bool(ev.Bid)
+		e.money(ev.Price)
+		e.money(ev.Size)
+		e.money(ev.Notional)
+		e.varint(ev.ExpiresAt)
+		e.money(ev.Bound)
+	case OrderRejected:
+		e.header(recordOrderRejected, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.bool(ev.Bid)
+		e.money(ev.Size)
+		e.string(ev.Reason)
+	case OrderFilled:
+		e.header(recordOrderFilled, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.money(ev.Price)
+		e.money(ev.FilledSize)
+	case OrderPartiallyFilled:
+		e.header(recordOrderPartiallyFilled, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.money(ev.Price)
+		e.money(ev.FilledSize)
+		e.money(ev.Remaining)
+	case OrderCancelled:
+		e.header(recordOrderCancelled, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.money(ev.Remaining)
+		e.string(string(ev.Reason))
+	case OrderAmended:
+		e.header(recordOrderAmended, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.bool(ev.Bid)
+		e.money(ev.Price)
+		e.money(ev.Size)
+		e.money(ev.Remaining)
+	case TradeExecuted:
+		e.header(recordTradeExecuted, ev.EventHeader)
+		e.string(ev.TradeID)
+		e.money(ev.Price)
+		e.money(ev.Size)
+		e.bool(ev.TakerBid)
+		e.string(ev.BuyOrderID)
+		e.string(ev.SellOrderID)
+		e.string(ev.BuyerID)
+		e.string(ev.SellerID)
+		e.string(ev.TakerOrderID)
+	case LevelChanged:
+		e.header(recordLevelChanged, ev.EventHeader)
+		e.bool(ev.Bid)
+		e.money(ev.Price)
+		e.money(ev.TotalVolume)
+		e.uvarint(uint64(ev.OrderCount))
+	case MarketStateChanged:
+		e.header(recordMarketStateChanged, ev.EventHeader)
+		e.string(string(ev.State))
+		e.string(ev.Reason)
+		e.varint(ev.Since)
+		e.varint(ev.ResumeAt)
+	case IndicativePriceChanged:
+		e.header(recordIndicativePriceChanged, ev.EventHeader)
+		e.money(ev.Price)
+		e.money(ev.Volume)
+		e.money(ev.Imbalance)
+	case OrderRested:
+		e.header(recordOrderRested, ev.EventHeader)
+		e.string(ev.OrderID)
+		e.string(ev.UserID)
+		e.bool(ev.Bid)
+		e.money(ev.Price)
+		e.money(ev.Remaining)
+	default:
+		return buf, fmt.Errorf("%w: %s", ErrUnrecordedEvent, event.EventType())
+	}
+	return e.buf, nil
+}
+
+// recordDecoder reads a payload, remembering the first error.
+type recordDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *recordDecoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated record", ErrInvalidRecording)
+	}
+	d.buf = nil
+}
+
+func (d *recordDecoder) byte() byte {
+	if len(d.buf) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *recordDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *recordDecoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *recordDecoder) money() Money {
+	if len(d.buf) < 8 {
+		d.fail()
+		return 0
+	}
+	m := Money(math.Float64frombits(binary.LittleEndian.Uint64(d.buf)))
+	d.buf = d.buf[8:]
+	return m
+}
+
+func (d *recordDecoder) string() string {
+	n := d.uvarint()
+	if uint64(len(d.buf)) < n {
+		d.fail()
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *recordDecoder) bool() bool { return d.byte() == 1 }
+
+// decodeEvent reads the payload of a record of the market.
+func decodeEvent(payload []byte, market Market) (MarketEvent, error) {
+	d := &recordDecoder{buf: payload}
+	kind := d.byte()
+	h := EventHeader{Market: market, Sequence: d.uvarint(), Timestamp: d.varint()}
+	var event MarketEvent
+	switch kind {
+	case recordOrderAccepted:
+		accepted := OrderAccepted{EventHeader: h, OrderID: d.string(), UserID: d.string(), Type: OrderType(d.string()),
+			Bid: d.bool(), Price: d.money(), Size: d.money(), Notional: d.money(), ExpiresAt: d.varint()}
+		// The bound came later, and recordings made before it end the record here.
+		if len(d.buf) > 0 {
+			accepted.Bound = d.money()
+		}
+		event = accepted
+	case recordOrderRejected:
+		event = OrderRejected{EventHeader: h, OrderID: d.string(), UserID: d.string(), Bid: d.bool(), Size: d.money(), Reason: d.string()}
+	case recordOrderFilled:
+		event = OrderFilled{EventHeader: h, OrderID: d.string(), UserID: d.string(), Price: d.money(), FilledSize: d.money()}
+	case recordOrderPartiallyFilled:
+		event = OrderPartiallyFilled{EventHeader: h, OrderID: d.string(), UserID: d.string(), Price: d.money(),
+			FilledSize: d.money(), Remaining: d.money()}
+	case recordOrderCancelled:
+		event = OrderCancelled{EventHeader: h, OrderID: d.string(), UserID: d.string(), Remaining: d.money(), Reason: CancelReason(d.string())}
+	case recordOrderAmended:
+		event = OrderAmended{EventHeader: h, OrderID: d.string(), UserID: d.string(), Bid: d.bool(), Price: d.money(),
+			Size: d.money(), Remaining: d.money()}
+	case recordTradeExecuted:
+		event = TradeExecuted{EventHeader: h, TradeID: d.string(), Price: d.money(), Size: d.money(), TakerBid: d.bool(),
+			BuyOrderID: d.string(), SellOrderID: d.string(), BuyerID: d.string(), SellerID: d.string(), TakerOrderID: d.string()}
+	case recordLevelChanged:
+		event = LevelChanged{EventHeader: h, Bid: d.bool(), Price: d.money(), TotalVolume: d.money(), OrderCount: int(d.uvarint())}
+	case recordMarketStateChanged:
+		event = MarketStateChanged{EventHeader: h, MarketStatus: MarketStatus{State: MarketState(d.string()), Reason: d.string(),
+			Since: d.varint(), ResumeAt: d.varint()}}
+	case recordIndicativePriceChanged:
+		event = IndicativePriceChanged{EventHeader: h, AuctionResult: AuctionResult{Price: d.money(), Volume: d.money(), Imbalance: d.money()}}
+	case recordOrderRested:
+		event = OrderRested{EventHeader: h, OrderID: d.string(), UserID: d.string(), Bid: d.bool(), Price: d.money(), Remaining: d.money()}
+	default:
+		if d.err == nil {
+			return nil, fmt.Errorf("%w: unknown record kind %d", ErrInvalidRecording, kind)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) > 0 {
+		return nil, fmt.Errorf("%w: %d bytes left over in a record", ErrInvalidRecording, len(d.buf))
+	}
+	return event, nil
+}
+
+// MarketRecorder writes the events of one market to a recording. It is safe for concurrent use.
+type MarketRecorder struct {
+	Market Market
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	offset  int64
+	records uint64
+	index   []RecordingIndexEntry
+	payload []byte
+	closed  bool
+}
+
+// CreateRecording creates, or truncates, the file at path and records the market's events to it.
+func CreateRecording(path string, market Market) (*MarketRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &MarketRecorder{Market: market, file: file, writer: bufio.NewWriterSize(file, 64*1024)}
+	header := append([]byte(recordingMagic), recordingVersion)
+	header = binary.AppendUvarint(header, uint64(len(market)))
+	header = append(header, market...)
+	if err := r.write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *MarketRecorder) write(b []byte) error {
+	n, err := r.writer.Write(b)
+	r.offset += int64(n)
+	return err
+}
+
+// Record appends an event of the recorder's market.
+func (r *MarketRecorder) Record(event MarketEvent) error {
+	header := event.Header()
+	if header.Market != r.Market {
+		return fmt.Errorf("%w: %s event on a recording of %s", ErrUnrecordedEvent, header.Market, r.Market)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	payload, err := encodeEvent(r.payload[:0], event)
+	if err != nil {
+		return err
+	}
+	r.payload = payload
+
+	if r.records%recordingIndexInterval == 0 {
+		r.index = append(r.index, RecordingIndexEntry{Sequence: header.Sequence, Timestamp: header.Timestamp, Offset: r.offset})
+	}
+	if err := r.write(binary.AppendUvarint(nil, uint64(len(payload)))); err != nil {
+		return err
+	}
+	if err := r.write(payload); err != nil {
+		return err
+	}
+	r.records++
+	return nil
+}
+
+// Flush writes the buffered records to the file.
+func (r *MarketRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writer.Flush()
+}
+
+// Close writes the index and closes the file.
+func (r *MarketRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	indexOffset := r.offset
+	block := make([]byte, 0, len(r.index)*recordingIndexEntry+recordingTrailer)
+	for _, entry := range r.index {
+		block = binary.BigEndian.AppendUint64(block, entry.Sequence)
+		block = binary.BigEndian.AppendUint64(block, uint64(entry.Timestamp))
+		block = binary.BigEndian.AppendUint64(block, uint64(entry.Offset))
+	}
+	block = binary.BigEndian.AppendUint64(block, uint64(indexOffset))
+	block = binary.BigEndian.AppendUint32(block, uint32(len(r.index)))
+	block = append(block, recordingIndexMagic...)
+
+	err := r.write(block)
+	if flushErr := r.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Run records the market's events published on the bus until the context is done, then closes
+// the recording. The subscription blocks the books rather than lose an event, so a failing
+// write is logged and the events are still drained.
+func (r *MarketRecorder) Run(ctx context.Context, events *messaging.Dispatcher) error {
+	subscriber := events.Subscribe("recorder:"+string(r.Market), 1024, messaging.Block, func(event messaging.Event) bool {
+		marketEvent, ok := event.(MarketEvent)
+		return ok && marketEvent.Header().Market == r.Market
+	})
+	go func() {
+		<-ctx.Done()
+		events.Unsubscribe(subscriber)
+	}()
+
+	var failed error
+	for event := range subscriber.Events() {
+		if err := r.Record(event.(MarketEvent)); err != nil && failed == nil {
+			failed = err
+			log.Printf("Recording %s stopped: %v", r.Market, err)
+		}
+	}
+	if err := r.Close(); failed == nil {
+		failed = err
+	}
+	return failed
+}
+
+// RecordMarkets records the events of every market published on the bus, each market to the
+// recording create returns at its first event, so markets listed later are recorded too. Buffered
+// records are flushed every flushInterval, so a crash loses little, and once the context is done
+// every recording is closed with its index. It returns the first error, still draining the events.
+func RecordMarkets(ctx context.Context, events *messaging.Dispatcher, flushInterval time.Duration, create func(Market) (*MarketRecorder, error)) error {
+	subscriber := events.Subscribe("recorder", 1024, messaging.Block, func(event messaging.Event) bool {
+		_, ok := event.(MarketEvent)
+		return ok
+	})
+	go func() {
+		<-ctx.Done()
+		events.Unsubscribe(subscriber)
+	}()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	// A market whose recording could not be created keeps a nil recorder, and is not retried.
+	recorders := make(map[Market]*MarketRecorder)
+	var failed error
+	fail := func(market Market, err error) {
+		if failed == nil {
+			failed = err
+		}
+		log.Printf("Recording %s stopped: %v", market, err)
+	}
+	for {
+		select {
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				for market, recorder := range recorders {
+					if recorder == nil {
+						continue
+					}
+					if err := recorder.Close(); err != nil {
+						fail(market, err)
+					}
+				}
+				return failed
+			}
+			marketEvent := event.(MarketEvent)
+			market := marketEvent.Header().Market
+			recorder, known := recorders[market]
+			if !known {
+				var err error
+				if recorder, err = create(market); err != nil {
+					fail(market, err)
+				}
+				recorders[market] = recorder
+			}
+			if recorder == nil {
+				continue
+			}
+			if err := recorder.Record(marketEvent); err != nil {
+				fail(market, err)
+				recorder.Close()
+				recorders[market] = nil
+			}
+		case <-ticker.C:
+			for market, recorder := range recorders {
+				if recorder == nil {
+					continue
+				}
+				if err := recorder.Flush(); err != nil {
+					fail(market, err)
+					recorder.Close()
+					recorders[market] = nil
+				}
+			}
+		}
+	}
+}
+
+// Recording is a recorded market opened for reading.
+type Recording struct {
+	Market Market
+	// Index holds an entry every recordingIndexInterval records, in file order.
+	Index []RecordingIndexEntry
+	// Complete is false when the recorder never closed the file, which was then scanned up to
+	// its last complete record.
+	Complete bool
+
+	file       *os.File
+	start, end int64
+}
+
+// OpenRecording opens a recording written by a MarketRecorder.
+func OpenRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	recording, err := openRecording(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return recording, nil
+}
+
+func openRecording(file *os.File) (*Recording, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	reader := bufio.NewReader(io.NewSectionReader(file, 0, size))
+	header := make([]byte, len(recordingMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:4]) != recordingMagic {
+		return nil, fmt.Errorf("%w: not a recording", ErrInvalidRecording)
+	}
+	if header[4] != recordingVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidRecording, header[4])
+	}
+	length, err := binary.ReadUvarint(reader)
+	if err != nil || length > maxRecordSize {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidRecording)
+	}
+	market := make([]byte, length)
+	if _, err := io.ReadFull(reader, market); err != nil {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidRecording)
+	}
+	start := int64(len(header)) + int64(uvarintLen(length)) + int64(length)
+
+	recording := &Recording{Market: Market(market), file: file, start: start}
+	if recording.readIndex(size) {
+		return recording, nil
+	}
+	return recording, recording.scan(size)
+}
+
+func uvarintLen(v uint64) int {
+	return len(binary.AppendUvarint(nil, v))
+}
+
+// readIndex loads the index written on Close, and reports whether there was a valid one.
+func (r *Recording) readIndex(size int64) bool {
+	if size < r.start+recordingTrailer {
+		return false
+	}
+	trailer := make([]byte, recordingTrailer)
+	if _, err := r.file.ReadAt(trailer, size-recordingTrailer); err != nil || string(trailer[12:]) != recordingIndexMagic {
+		return false
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(trailer))
+	count := int64(binary.BigEndian.Uint32(trailer[8:]))
+	if indexOffset < r.start || indexOffset+count*recordingIndexEntry != size-recordingTrailer {
+		return false
+	}
+	block := make([]byte, count*recordingIndexEntry)
+	if _, err := r.file.ReadAt(block, indexOffset); err != nil {
+		return false
+	}
+	r.Index = make([]RecordingIndexEntry, count)
+	for i := range r.Index {
+		entry := block[i*recordingIndexEntry:]
+		r.Index[i] = RecordingIndexEntry{
+			Sequence:  binary.BigEndian.Uint64(entry),
+			Timestamp: int64(binary.BigEndian.Uint64(entry[8:])),
+			Offset:    int64(binary.BigEndian.Uint64(entry[16:])),
+		}
+	}
+	r.end, r.Complete = indexOffset, true
+	return true
+}
+
+// scan rebuilds the index of a recording that was not closed, ending it at the last complete record.
+func (r *Recording) scan(size int64) error {
+	r.end = size
+	reader := r.readerAt(r.start)
+	for records := 0; ; records++ {
+		offset := reader.offset
+		event, err := reader.Next()
+		if err != nil {
+			// Whatever follows the last complete record was being written when the recorder died.
+			r.end = offset
+			return nil
+		}
+		if records%recordingIndexInterval == 0 {
+			header := event.Header()
+			r.Index = append(r.Index, RecordingIndexEntry{Sequence: header.Sequence, Timestamp: header.Timestamp, Offset: offset})
+		}
+	}
+}
+
+// Close closes the file. Readers of the recording stop working.
+func (r *Recording) Close() error {
+	return r.file.Close()
+}
+
+// Events reads the recording from the start.
+func (r *Recording) Events() *RecordingReader {
+	return r.readerAt(r.start)
+}
+
+// FromSequence reads the recording from its first event with a sequence number of at least sequence.
+func (r *Recording) FromSequence(sequence uint64) *RecordingReader {
+	i := sort.Search(len(r.Index), func(i int) bool { return r.Index[i].Sequence > sequence })
+	return r.seek(i, func(h EventHeader) bool { return h.Sequence >= sequence })
+}
+
+// FromTime reads the recording from its first event at or after the given unix nanoseconds.
+func (r *Recording) FromTime(at int64) *RecordingReader {
+	i := sort.Search(len(r.Index), func(i int) bool { return r.Index[i].Timestamp >= at })
+	return r.seek(i, func(h EventHeader) bool { return h.Timestamp >= at })
+}
+
+// seek starts reading at the index entry before i, skipping the events before the first one
+// reached. Entries are sparse, so the event sought lies after the entry before the first past it.
+func (r *Recording) seek(i int, reached func(EventHeader) bool) *RecordingReader {
+	offset := r.start
+	if i > 0 {
+		offset = r.Index[i-1].Offset
+	}
+	reader := r.readerAt(offset)
+	reader.reached = reached
+	return reader
+}
+
+func (r *Recording) readerAt(offset int64) *RecordingReader {
+	section := io.NewSectionReader(r.file, offset, r.end-offset)
+	return &RecordingReader{market: r.Market, reader: bufio.NewReaderSize(section, 64*1024), offset: offset}
+}
+
+// RecordingReader reads a recording's events in order.
+type RecordingReader struct {
+	market  Market
+	reader  *bufio.Reader
+	offset  int64
+	payload []byte
+	// reached skips the events before a seek's target, and is cleared once it is found.
+	reached func(EventHeader) bool
+}
+
+// Next returns the next event, or io.EOF after the last one.
+func (rr *RecordingReader) Next() (MarketEvent, error) {
+	for {
+		event, err := rr.next()
+		if err != nil {
+			return nil, err
+		}
+		if rr.reached == nil || rr.reached(event.Header()) {
+			rr.reached = nil
+			return event, nil
+		}
+	}
+}
+
+func (rr *RecordingReader) next() (MarketEvent, error) {
+	length, err := binary.ReadUvarint(rr.reader)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || length > maxRecordSize {
+		return nil, fmt.Errorf("%w: bad record length at offset %d", ErrInvalidRecording, rr.offset)
+	}
+	if uint64(cap(rr.payload)) < length {
+		rr.payload = make([]byte, length)
+	}
+	payload := rr.payload[:length]
+	if _, err := io.ReadFull(rr.reader, payload); err != nil {
+		return nil, fmt.Errorf("%w: truncated record at offset %d", ErrInvalidRecording, rr.offset)
+	}
+	event, err := decodeEvent(payload, rr.market)
+	if err != nil {
+		return nil, fmt.Errorf("offset %d: %w", rr.offset, err)
+	}
+	rr.offset += int64(uvarintLen(length)) + int64(length)
+	return event, nil
+}
+
+// ReadAll reads the remaining events.
+func (rr *RecordingReader) ReadAll() ([]MarketEvent, error) {
+	var events []MarketEvent
+	for {
+		event, err := rr.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// EventMarketStateChanged is published when a market is halted, resumed or put into cancel-only mode.
+const EventMarketStateChanged = "market.state_changed"
+
+// CancelReasonCircuitBreaker marks the unfilled rest of a market order stopped by a circuit breaker.
+const CancelReasonCircuitBreaker CancelReason = "circuit_breaker"
+
+var (
+	ErrMarketHalted       = errors.New("market is halted")
+	ErrMarketCancelOnly   = errors.New("market only accepts cancels")
+	ErrUnknownMarketState = errors.New("unknown market state")
+)
+
+// MarketState tells which operations a market accepts.
+type MarketState string
+
+const (
+	// MarketOpen matches orders continuously.
+	MarketOpen MarketState = "open"
+	// MarketHalted rejects new orders and amends. Resting orders stay on the book and can be cancelled.
+	MarketHalted MarketState = "halted"
+	// MarketCancelOnly only accepts cancels.
+	MarketCancelOnly MarketState = "cancel_only"
+	// MarketAuction collects limit orders without matching them, until the auction uncrosses.
+	MarketAuction MarketState = "auction"
+)
+
+// Reasons a market changed state.
+const (
+	StateReasonAdmin          = "admin"
+	StateReasonCircuitBreaker = "circuit_breaker"
+	StateReasonCooldownOver   = "cooldown_over"
+	StateReasonListing        = "listing"
+)
+
+// MarketStatus is the state of a market and why it is in it.
+type MarketStatus struct {
+	State  MarketState `json:"state"`
+	Reason string      `json:"reason,omitempty"`
+	Since  int64       `json:"since,omitempty"` // unix nanoseconds.
+	// ResumeAt is when a halt or an auction ends on its own, zero when only an admin can lift a halt.
+	ResumeAt int64 `json:"resumeAt,omitempty"`
+}
+
+// CircuitBreakerConfig halts a market when a fill price moves too far from the reference price,
+// the price of the oldest fill within Window. A zero MaxMove disables the breaker.
+type CircuitBreakerConfig struct {
+	MaxMove  float64       `json:"maxMove"` // largest relative move, 0.1 is 10%.
+	Window   time.Duration `json:"window"`
+	Cooldown time.Duration `json:"cooldown"`
+}
+
+// MarketStateChanged is published when a market changes state.
+type MarketStateChanged struct {
+	EventHeader
+	MarketStatus
+}
+
+func (MarketStateChanged) EventType() string { return EventMarketStateChanged }
+
+// pricePoint is a fill price remembered by the circuit breaker.
+type pricePoint struct {
+	price Money
+	at    int64
+}
+
+// Status returns the state of the market. The caller holds the book's lock.
+func (ob *CompleteOrderBook) Status() MarketStatus {
+	if ob.status.State == "" {
+		return MarketStatus{State: MarketOpen}
+	}
+	return ob.status
+}
+
+// SetStatus moves the market to a new state and publishes it. The caller holds the book's lock.
+func (ob *CompleteOrderBook) SetStatus(status MarketStatus) {
+	ob.status = status
+	ob.emit(func(header EventHeader) messaging.Event {
+		return MarketStateChanged{EventHeader: header, MarketStatus: status}
+	})
+}
+
+// acceptsOrders returns why the market refuses an order or amend of the given type, if it does.
+// The caller holds the book's lock.
+func (ob *CompleteOrderBook) acceptsOrders(orderType OrderType) error {
+	switch ob.Status().State {
+	case MarketHalted:
+		return ErrMarketHalted
+	case MarketCancelOnly:
+		return ErrMarketCancelOnly
+	case MarketAuction:
+		if orderType == OrderTypeMarket {
+			return ErrMarketInAuction
+		}
+	}
+	return nil
+}
+
+// tripsBreaker reports whether filling at the price would move the market too far from the oldest
+// fill of the window, or the last trade when there is none, and halts it if so.
+func (ob *CompleteOrderBook) tripsBreaker(price Money, now time.Time) bool {
+	breaker := ob.Config.CircuitBreaker
+	if breaker.MaxMove <= 0 {
+		return false
+	}
+	cutoff := now.Add(-breaker.Window).UnixNano()
+	for len(ob.recentFills) > 0 && ob.recentFills[0].at < cutoff {
+		ob.recentFills = ob.recentFills[1:]
+	}
+	// After a quiet spell the window is empty, and the last trade is the reference instead.
+	reference := ob.lastPrice
+	if len(ob.recentFills) > 0 {
+		reference = ob.recentFills[0].price
+	}
+	if reference <= 0 {
+		return false
+	}
+	if math.Abs(float64(price-reference)/float64(reference)) <= breaker.MaxMove {
+		return false
+	}
+	ob.SetStatus(MarketStatus{
+		State:    MarketHalted,
+		Reason:   StateReasonCircuitBreaker,
+		Since:    now.UnixNano(),
+		ResumeAt: now.Add(breaker.Cooldown).UnixNano(),
+	})
+	return true
+}
+
+// recordFill remembers a fill price as a future reference for the circuit breaker.
+func (ob *CompleteOrderBook) recordFill(price Money, now time.Time) {
+	if ob.Config.CircuitBreaker.MaxMove <= 0 {
+		return
+	}
+	ob.recentFills = append(ob.recentFills, pricePoint{price: price, at: now.UnixNano()})
+}
+
+// MarketStatus returns the state of a market.
+func (s *CryptoExchangeService) MarketStatus(market Market) (MarketStatus, error) {
+	orderBook, ok := s.OrderBooks[market]
+	if !ok {
+		return MarketStatus{}, ErrMarketNotFound
+	}
+	var status MarketStatus
+	orderBook.Exclusive(func() {
+		status = orderBook.Status()
+	})
+	return status, nil
+}
+
+// SetMarketState changes a market's state on an admin's request. A positive duration ends a halt,
+// or an auction, on its own once it is over. Opening a halted market goes through a reopening
+// auction when the market has one, and opening a market in auction uncrosses it at once.
+func (s *CryptoExchangeService) SetMarketState(ctx context.Context, market Market, state MarketState, duration time.Duration) (MarketStatus, error) {
+	orderBook, ok := s.OrderBooks[market]
+	if !ok {
+		return MarketStatus{}, ErrMarketNotFound
+	}
+
+	now := s.now()
+	var (
+		status  MarketStatus
+		matches []MatchEngine
+		records []OrderRecord
+		err     error
+	)
+	orderBook.Exclusive(func() {
+		current := orderBook.Status().State
+		switch {
+		case state == MarketOpen && current == MarketAuction:
+			matches, records = uncrossLocked(orderBook, now)
+		case state == MarketOpen && current != MarketOpen:
+			reopenLocked(orderBook, now, StateReasonAdmin)
+		case state == MarketAuction:
+			startAuctionLocked(orderBook, now, StateReasonAdmin)
+		case state == MarketOpen, state == MarketHalted, state == MarketCancelOnly:
+			orderBook.SetStatus(MarketStatus{State: state, Reason: StateReasonAdmin, Since: now.UnixNano()})
+		default:
+			err = ErrUnknownMarketState
+			return
+		}
+		if duration > 0 && (state == MarketHalted || state == MarketAuction) {
+			orderBook.status.ResumeAt = now.Add(duration).UnixNano()
+		}
+		status = orderBook.Status()
+	})
+	if err != nil {
+		return MarketStatus{}, err
+	}
+	if err := s.settle(ctx, market, matches); err != nil {
+		return status, err
+	}
+	return status, s.saveRecords(ctx, records...)
+}
+
+// ResumeMarkets ends the halts and auctions whose time is over: a halt moves to its reopening
+// auction, or straight to continuous trading, and an auction uncrosses.
+func (s *CryptoExchangeService) ResumeMarkets(ctx context.Context, now time.Time) error {
+	for market, orderBook := range s.OrderBooks {
+		var (
+			matches []MatchEngine
+			records []OrderRecord
+		)
+		orderBook.Exclusive(func() {
+			status := orderBook.Status()
+			if status.ResumeAt == 0 || status.ResumeAt > now.UnixNano() {
+				return
+			}
+			switch status.State {
+			case MarketHalted:
+				reopenLocked(orderBook, now, StateReasonCooldownOver)
+			case MarketAuction:
+				matches, records = uncrossLocked(orderBook, now)
+			}
+		})
+		if err := s.settle(ctx, market, matches); err != nil {
+			return err
+		}
+		if err := s.saveRecords(ctx, records...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrUnknownMatchingPolicy = errors.New("unknown matching policy")
+
+// MatchingAlgorithm names the policy a market shares an incoming order among the orders of a level with.
+type MatchingAlgorithm string
+
+const (
+	// MatchFIFO fills the orders of a level in strict time priority. It is the default.
+	MatchFIFO MatchingAlgorithm = "fifo"
+	// MatchProRata shares the incoming size among the orders of a level by their size.
+	MatchProRata MatchingAlgorithm = "pro_rata"
+	// MatchTopOrderFIFO fills the level's top order first, then the rest in time priority.
+	MatchTopOrderFIFO MatchingAlgorithm = "fifo_top_order"
+)
+
+// MatchingPolicies are the policies markets can be configured with.
+var MatchingPolicies = map[MatchingAlgorithm]MatchingPolicy{
+	MatchFIFO:         FIFO{},
+	MatchProRata:      ProRata{},
+	MatchTopOrderFIFO: TopOrderFIFO{},
+}
+
+// MatchingPolicy decides how much of an incoming order each order resting at a level receives.
+type MatchingPolicy interface {
+	// Allocate returns the share of size given to each of the level's orders, in the order of
+	// level.Orders, which is time priority. A share never exceeds its order's size and the shares
+	// add up to size, or to the level's volume when size is larger. lot is the market's lot size,
+	// zero when any size trades.
+	Allocate(level *Limit, size, lot Money) []Money
+}
+
+// MatchingPolicy returns the policy of the market, strict FIFO unless another one is configured.
+func (c MarketConfig) MatchingPolicy() MatchingPolicy {
+	if policy, ok := MatchingPolicies[c.Matching]; ok {
+		return policy
+	}
+	return FIFO{}
+}
+
+// validMatching reports whether the market's matching algorithm is known. An empty one means FIFO.
+func (c MarketConfig) validMatching() bool {
+	_, ok := MatchingPolicies[c.Matching]
+	return c.Matching == "" || ok
+}
+
+// FIFO fills the orders of a level one after the other, oldest first.
+type FIFO struct{}
+
+func (FIFO) Allocate(level *Limit, size, lot Money) []Money {
+	return fifoAllocate(level.Orders, make([]Money, len(level.Orders)), size)
+}
+
+// fifoAllocate hands size out in time priority on top of the shares already given, up to each order's size.
+func fifoAllocate(orders Orders, shares []Money, size Money) []Money {
+	for i, o := range orders {
+		if size <= 0 {
+			break
+		}
+		extra := Money(math.Min(float64(o.Size-shares[i]), float64(size)))
+		shares[i] += extra
+		size -= extra
+	}
+	return shares
+}
+
+// ProRata shares the incoming size among the orders of a level in proportion to their size.
+// Each share is rounded down to the lot size, so no order receives a fraction of a lot; what the
+// rounding leaves over goes to the orders in time priority, up to their size.
+type ProRata struct{}
+
+func (ProRata) Allocate(level *Limit, size, lot Money) []Money {
+	shares := make([]Money, len(level.Orders))
+	var total Money
+	for _, o := range level.Orders {
+		total += o.Size
+	}
+	if total <= 0 {
+		return shares
+	}
+	if size >= total {
+		for i, o := range level.Orders {
+			shares[i] = o.Size
+		}
+		return shares
+	}
+
+	left := size
+	for i, o := range level.Orders {
+		share := Money(math.Min(float64(roundDown(size*o.Size/total, lot)), float64(o.Size)))
+		shares[i] = share
+		left -= share
+	}
+	return fifoAllocate(level.Orders, shares, roundDown(left, lot))
+}
+
+// TopOrderFIFO fills the level's top order first, then the other orders in time priority.
+// The top order is the one that made its level the best price of its side. It keeps that
+// status when an amend sends it to the back of the queue without changing its price, and
+// loses it once filled or cancelled; a level that never led the book has no top order.
+type TopOrderFIFO struct{}
+
+func (TopOrderFIFO) Allocate(level *Limit, size, lot Money) []Money {
+	shares := make([]Money, len(level.Orders))
+	for i, o := range level.Orders {
+		if o == level.Top {
+			shares[i] = Money(math.Min(float64(o.Size), float64(size)))
+			size -= shares[i]
+		}
+	}
+	return fifoAllocate(level.Orders, shares, size)
+}
+
+// roundDown rounds value down to a whole number of lots. The tolerance only absorbs float rounding.
+func roundDown(value, lot Money) Money {
+	if lot <= 0 {
+		return value
+	}
+	return Money(math.Floor(float64(value/lot)+1e-9)) * lot
+}
+
+// improvesBest reports whether a new order at the price would become the best price of its side.
+func (ob *CompleteOrderBook) improvesBest(bid bool, price Money) bool {
+	if bid {
+		for _, limit := range ob.Bids {
+			if limit.Price >= price {
+				return false
+			}
+		}
+		return true
+	}
+	for _, limit := range ob.Asks {
+		if limit.Price <= price {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFlow = errors.New("invalid order flow")
+
+// FlowAction is what a line of order flow does to the book.
+type FlowAction string
+
+const (
+	// FlowPlace rests a limit order, or takes liquidity as a market order without a price.
+	FlowPlace FlowAction = "place"
+	// FlowCancel pulls an order placed earlier in the flow.
+	FlowCancel FlowAction = "cancel"
+	// FlowTrade is a print of the tape: an aggressive order of the size that trades no worse
+	// than the price, and whose rest is dropped, or left on the book at the price with Rest.
+	FlowTrade FlowAction = "trade"
+)
+
+// FlowEvent is one line of recorded or synthetic order flow.
+type FlowEvent struct {
+	Time   int64      `json:"time"` // unix nanoseconds.
+	Action FlowAction `json:"type"`
+	ID     string     `json:"id,omitempty"`
+	UserID string     `json:"user,omitempty"`
+	Bid    bool       `json:"bid"`
+	Price  Money      `json:"price,omitempty"`
+	Size   Money      `json:"size,omitempty"`
+	// Rest leaves the unfilled rest of a trade on the book at its price, under the event's ID.
+	Rest bool `json:"rest,omitempty"`
+}
+
+func (e FlowEvent) validate() error {
+	switch e.Action {
+	case FlowPlace, FlowTrade:
+		if e.Size <= 0 || math.IsNaN(float64(e.Size)) || e.Price < 0 || math.IsNaN(float64(e.Price)) {
+			return fmt.Errorf("%w: %s needs a positive size and no negative price", ErrInvalidFlow, e.Action)
+		}
+		if e.Rest && (e.Action != FlowTrade || e.Price == 0) {
+			return fmt.Errorf("%w: only a trade with a price can rest", ErrInvalidFlow)
+		}
+	case FlowCancel:
+		if e.ID == "" {
+			return fmt.Errorf("%w: cancel needs an order ID", ErrInvalidFlow)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidFlow, e.Action)
+	}
+	return nil
+}
+
+// ReadFlowFile reads order flow from a CSV file when its name ends in .csv, and JSONL otherwise.
+func ReadFlowFile(path string) ([]FlowEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return ReadFlowCSV(file)
+	}
+	return ReadFlowJSONL(file)
+}
+
+// ReadFlowJSONL reads one FlowEvent per line, skipping blank lines, and sorts them by time.
+func ReadFlowJSONL(r io.Reader) ([]FlowEvent, error) {
+	var flow []FlowEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var event FlowEvent
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFlow, line, err)
+		}
+		if err := event.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		flow = append(flow, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sortFlow(flow)
+	return flow, nil
+}
+
+// ReadFlowCSV reads order flow with a header line naming the columns time, type, id, user, bid,
+// price, size and rest, in any order. Only time and type are required. Events are sorted by time.
+func ReadFlowCSV(r io.Reader) ([]FlowEvent, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading the header: %v", ErrInvalidFlow, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"time", "type"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing the %s column", ErrInvalidFlow, required)
+		}
+	}
+
+	var flow []FlowEvent
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFlow, line, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		event := FlowEvent{Action: FlowAction(field("type")), ID: field("id"), UserID: field("user")}
+		if event.Time, err = strconv.ParseInt(field("time"), 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: line %d: time: %v", ErrInvalidFlow, line, err)
+		}
+		for name, target := range map[string]*bool{"bid": &event.Bid, "rest": &event.Rest} {
+			if value := field(name); value != "" {
+				if *target, err = strconv.ParseBool(value); err != nil {
+					return nil, fmt.Errorf("%w: line %d: %s: %v", ErrInvalidFlow, line, name, err)
+				}
+			}
+		}
+		for name, target := range map[string]*Money{"price": &event.Price, "size": &event.Size} {
+			if value := field(name); value != "" {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w: line %d: %s: %v", ErrInvalidFlow, line, name, err)
+				}
+				*target = Money(parsed)
+			}
+		}
+		if err := event.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		flow = append(flow, event)
+	}
+	sortFlow(flow)
+	return flow, nil
+}
+
+// sortFlow orders events by time, keeping the file's order between events of the same time.
+func sortFlow(flow []FlowEvent) {
+	sort.SliceStable(flow, func(i, j int) bool { return flow[i].Time < flow[j].Time })
+}
+
+// SyntheticFlowConfig shapes generated order flow.
+type SyntheticFlowConfig struct {
+	Events   int
+	Start    int64         // unix nanoseconds of the first event.
+	Interval time.Duration // mean time between events, exponentially distributed.
+	Price    Money         // the mid the flow starts around.
+	// VolatilityBps is the standard deviation of the mid's move between events, in basis points.
+	VolatilityBps float64
+	// DepthBps is how far from the mid limit orders are placed, at most, in basis points.
+	DepthBps float64
+	MaxSize  Money
+	// CancelRate and TradeRate are the shares of events cancelling a live order and printing
+	// a trade. Every other event places a limit order.
+	CancelRate float64
+	TradeRate  float64
+	TickSize   Money
+	LotSize    Money
+}
+
+// SyntheticFlow generates order flow around a random walk. Orders the mid moves through are
+// cancelled, as their owners would, so the book does not cross. The same config and seed always
+// give the same flow.
+func SyntheticFlow(config SyntheticFlowConfig, seed int64) []FlowEvent {
+	random := rand.New(rand.NewSource(seed))
+	flow := make([]FlowEvent, 0, config.Events)
+	mid := float64(config.Price)
+	now := config.Start
+	var live []FlowEvent
+	size := func() Money {
+		s := roundDown(Money(random.Float64())*config.MaxSize, config.LotSize)
+		return Money(math.Max(float64(s), float64(config.LotSize)))
+	}
+
+	for len(flow) < config.Events {
+		now += int64(random.ExpFloat64() * float64(config.Interval))
+		mid *= 1 + random.NormFloat64()*config.VolatilityBps/10000
+		kept := live[:0]
+		for _, o := range live {
+			if len(flow) < config.Events && (o.Bid && float64(o.Price) >= mid || !o.Bid && float64(o.Price) <= mid) {
+				flow = append(flow, FlowEvent{Time: now, Action: FlowCancel, ID: o.ID})
+				continue
+			}
+			kept = append(kept, o)
+		}
+		live = kept
+		if len(flow) == config.Events {
+			break
+		}
+
+		bid := random.Intn(2) == 0
+		offset := random.Float64() * config.DepthBps / 10000
+		event := FlowEvent{Time: now, Bid: bid}
+		switch roll := random.Float64(); {
+		case roll < config.TradeRate:
+			event.Action, event.Size = FlowTrade, size()
+			// A buy prints up to the depth above the mid, a sell down to the depth below.
+			event.Price = roundDown(Money(mid*(1-offset)), config.TickSize)
+			if bid {
+				event.Price = roundUp(Money(mid*(1+offset)), config.TickSize)
+			}
+		case roll < config.TradeRate+config.CancelRate && len(live) > 0:
+			pick := random.Intn(len(live))
+			event.Action, event.ID = FlowCancel, live[pick].ID
+			live = append(live[:pick], live[pick+1:]...)
+		default:
+			event.Action, event.ID, event.Size = FlowPlace, fmt.Sprintf("flow-%d", len(flow)+1), size()
+			event.Price = roundUp(Money(mid*(1+offset)), config.TickSize)
+			if bid {
+				event.Price = roundDown(Money(mid*(1-offset)), config.TickSize)
+			}
+			live = append(live, event)
+		}
+		flow = append(flow, event)
+	}
+	return flow
+}
@@ -1,5 +1,7 @@
 package services
 
+import "math"
+
 // TotalVolumeOfBid calculates and returns the total volume of all bids in the order book.
 func (ob *CompleteOrderBook) TotalVolumeOfBid() Money {
 	totalVolume := Money(0.0)
@@ -20,21 +22,58 @@ func (ob *CompleteOrderBook) TotalVolumeOfAsks() Money {
 	return totalVolume
 }
 
+// OrdersByUser returns the resting orders owned by the given user, bids first.
+func (ob *CompleteOrderBook) OrdersByUser(userID string) []*Order {
+	var owned []*Order
+	for _, limits := range [][]*Limit{ob.Bids, ob.Asks} {
+		for _, limit := range limits {
+			for _, order := range limit.Orders {
+				if order.UserID == userID {
+					owned = append(owned, order)
+				}
+			}
+		}
+	}
+	return owned
+}
+
 // IsFilled checks if an order is filled (size equals 0.0) and returns true if it is, false otherwise.
 func (o *Order) IsFilled() bool {
 	return o.Size == 0.0
 }
 
-// Fill fills a given limit order based on the provided order.
+// Status derives the lifecycle state of a live order from how much of it is filled.
+func (o *Order) Status() OrderStatus {
+	switch {
+	case o.IsFilled():
+		return StatusFilled
+	case o.Size < o.InitialSize:
+		return StatusPartiallyFilled
+	default:
+		return StatusNew
+	}
+}
+
+// Fill fills a given limit order based on the provided order, in time priority.
 // It returns a slice of MatchEngine containing the matches made during the order execution.
 func (l *Limit) Fill(o *Order) []MatchEngine {
+	return l.FillWith(FIFO{}, o, 0)
+}
+
+// FillWith fills the order against the limit, sharing it among the resting orders as the policy
+// allocates. lot is the market's lot size. Filled resting orders leave the limit.
+func (l *Limit) FillWith(policy MatchingPolicy, o *Order, lot Money) []MatchEngine {
 	var (
 		matches        []MatchEngine
 		OrdersToDelete []*Order
 	)
 
-	for _, order := range l.Orders {
-		match := l.FillOrder(order, o)
+	shares := policy.Allocate(l, o.Size, lot)
+	for i, order := range l.Orders {
+		if i >= len(shares) || shares[i] <= 0 {
+			continue
+		}
+		match := l.fillShare(order, o, shares[i])
 		matches = append(matches, match)
 
 		l.TotalVolume -= match.SizeFilled
@@ -42,11 +81,6 @@ func (l *Limit) Fill(o *Order) []MatchEngine {
 		if order.IsFilled() {
 			OrdersToDelete = append(OrdersToDelete, order)
 		}
-
-		// end a possible infinity loop.
-		if o.IsFilled() {
-			break
-		}
 	}
 
 	for _, order := range OrdersToDelete {
@@ -56,6 +90,31 @@ func (l *Limit) Fill(o *Order) []MatchEngine {
 	return matches
 }
 
+// fillShare trades size between a resting order and the incoming one, at the limit's price.
+func (l *Limit) fillShare(resting, incoming *Order, size Money) MatchEngine {
+	size = Money(math.Min(float64(size), math.Min(float64(resting.Size), float64(incoming.Size))))
+	// A share a float rounding away from either order's size fills it, rather than leaving dust behind.
+	for _, left := range []Money{resting.Size, incoming.Size} {
+		if math.Abs(float64(left-size)) <= 1e-9*float64(left) {
+			size = left
+		}
+	}
+	resting.Size -= size
+	incoming.Size -= size
+
+	bid, ask := resting, incoming
+	if incoming.Bid {
+		bid, ask = incoming, resting
+	}
+	return MatchEngine{
+		ID:         NewID(),
+		Ask:        ask,
+		Bid:        bid,
+		SizeFilled: size,
+		Price:      l.Price,
+	}
+}
+
 // FillOrder fills an order based on two provided orders.
 // It calculates the size filled, updates the orders' sizes, and returns the MatchEngine.
 func (l *Limit) FillOrder(a, b *Order) MatchEngine {
@@ -86,8 +145,9 @@ func (l *Limit) FillOrder(a, b *Order) MatchEngine {
 
 	// Who has the bid or ask, and the size, and at what price the order is executed?
 	return MatchEngine{
-		Ask:        bid,
-		Bid:        ask,
+		ID:         NewID(),
+		Ask:        ask,
+		Bid:        bid,
 		SizeFilled: SizeFilled,
 		Price:      l.Price,
 	}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultRouteSlices is how many pieces the router cuts an order into when splitting it between paths.
+const DefaultRouteSlices = 20
+
+var ErrNoRoute = errors.New("no market trades the pair, directly or through the quote currency")
+
+// RoutedFill is the part of a routed order traded along one path.
+type RoutedFill struct {
+	Legs  []ConvertLeg `json:"legs"`
+	Size  Money        `json:"size"`  // base traded along the path.
+	Quote Money        `json:"quote"` // quote asset paid on a buy, received on a sell.
+	// Price is the effective price of the path, Quote per unit of Size.
+	Price    Money    `json:"price"`
+	OrderIDs []string `json:"orderIds,omitempty"` // the market order of each leg, once executed.
+}
+
+// RoutedOrder is an order of the router and how it was split between paths.
+type RoutedOrder struct {
+	Base         Asset        `json:"base"`
+	Quote        Asset        `json:"quote"`
+	Bid          bool         `json:"bid"`
+	Size         Money        `json:"size"`
+	Fills        []RoutedFill `json:"fills"`
+	AveragePrice Money        `json:"averagePrice"`
+}
+
+// SmartRouter trades a pair across every path the books offer: the pair's own market, such as
+// "ETH-BTC", and the synthetic route through two markets priced in QuoteAsset, such as ETH and BTC.
+// An order is cut into slices, each going to the path with the best marginal price given the
+// depth of its books and the slices it already took.
+type SmartRouter struct {
+	exchange *CryptoExchangeService
+	// Slices is how many pieces an order is cut into.
+	Slices int
+}
+
+// NewSmartRouter creates a SmartRouter trading on the exchange's books.
+func NewSmartRouter(exchange *CryptoExchangeService) *SmartRouter {
+	return &SmartRouter{exchange: exchange, Slices: DefaultRouteSlices}
+}
+
+// routePath is a way of trading a pair.
+type routePath struct {
+	legs []ConvertLeg
+	// quote returns the quote asset trading size base along the path pays on a buy, or receives
+	// on a sell, and whether the books are deep enough. The caller holds the locks of the books.
+	quote func(size Money) (Money, bool)
+}
+
+// Route buys, or sells, size of base against quote for the user, split between the paths with
+// the best prices. All the books involved are locked while the order is planned and executed, and
+// nothing is executed unless every leg fills. A dry run only plans the split.
+func (r *SmartRouter) Route(ctx context.Context, userID string, base, quote Asset, bid bool, size Money, dryRun bool) (RoutedOrder, error) {
+	order := RoutedOrder{Base: base, Quote: quote, Bid: bid, Size: size, Fills: []RoutedFill{}}
+	if size <= 0 || math.IsNaN(float64(size)) || math.IsInf(float64(size), 0) {
+		return order, fmt.Errorf("%w: must be positive", ErrInvalidSize)
+	}
+	paths, err := r.paths(base, quote, bid)
+	if err != nil {
+		return order, err
+	}
+
+	matches := make(map[Market][]MatchEngine)
+	var records []OrderRecord
+	lockBooks(r.books(paths), func() {
+		var allocation []Money
+		if allocation, err = r.planLocked(paths, base, bid, size); err != nil {
+			return
+		}
+		var txs []*bookTransaction
+		if !dryRun {
+			for _, orderBook := range r.books(paths) {
+				txs = append(txs, beginBookTransaction(orderBook))
+			}
+		}
+		for i, path := range paths {
+			if allocation[i] == 0 {
+				continue
+			}
+			var fill RoutedFill
+			if dryRun {
+				paid, _ := path.quote(allocation[i])
+				fill = RoutedFill{Legs: path.legs, Size: allocation[i], Quote: paid}
+			} else {
+				var pathRecords []OrderRecord
+				if fill, pathRecords, err = r.executeLocked(userID, path, bid, allocation[i], matches); err != nil {
+					break
+				}
+				records = append(records, pathRecords...)
+			}
+			fill.Price = fill.Quote / fill.Size
+			order.Fills = append(order.Fills, fill)
+		}
+		for _, tx := range txs {
+			if err != nil {
+				tx.rollback()
+			} else {
+				tx.commit()
+			}
+		}
+	})
+	if err != nil {
+		return RoutedOrder{Base: base, Quote: quote, Bid: bid, Size: size, Fills: []RoutedFill{}}, err
+	}
+
+	var total Money
+	for _, fill := range order.Fills {
+		total += fill.Quote
+	}
+	order.AveragePrice = total / size
+	if dryRun {
+		return order, nil
+	}
+
+	markets := make([]string, 0, len(matches))
+	for market := range matches {
+		markets = append(markets, string(market))
+	}
+	sort.Strings(markets)
+	for _, market := range markets {
+		if err := r.exchange.settle(ctx, Market(market), matches[Market(market)]); err != nil {
+			return order, err
+		}
+	}
+	return order, r.exchange.saveRecords(ctx, records...)
+}
+
+// paths returns the ways the books can trade base against quote, the direct market first.
+func (r *SmartRouter) paths(base, quote Asset, bid bool) ([]routePath, error) {
+	if base == quote {
+		return nil, ErrSameAsset
+	}
+	var paths []routePath
+	if direct, ok := r.exchange.OrderBooks[MarketFor(base, quote)]; ok {
+		paths = append(paths, routePath{
+			legs: []ConvertLeg{{Market: MarketFor(base, quote), Bid: bid}},
+			quote: func(size Money) (Money, bool) {
+				notional, filled := direct.sweep(bid, size)
+				return notional, filled >= size
+			},
+		})
+	}
+
+	baseBook, baseListed := r.exchange.OrderBooks[Market(base)]
+	quoteBook, quoteListed := r.exchange.OrderBooks[Market(quote)]
+	if quote != QuoteAsset && base != QuoteAsset && baseListed && quoteListed {
+		if bid {
+			// Sell the quote asset for enough QuoteAsset to buy the base.
+			paths = append(paths, routePath{
+				legs: []ConvertLeg{{Market: Market(quote)}, {Market: Market(base), Bid: true}},
+				quote: func(size Money) (Money, bool) {
+					cost, filled := baseBook.sweep(true, size)
+					if filled < size {
+						return 0, false
+					}
+					return quoteBook.sizeToReceive(cost)
+				},
+			})
+		} else {
+			// Sell the base for QuoteAsset and buy the quote asset with it.
+			paths = append(paths, routePath{
+				legs: []ConvertLeg{{Market: Market(base)}, {Market: Market(quote), Bid: true}},
+				quote: func(size Money) (Money, bool) {
+					proceeds, filled := baseBook.sweep(false, size)
+					if filled < size {
+						return 0, false
+					}
+					received := quoteBook.sizeForNotional(&Order{Bid: true, Notional: proceeds})
+					return received, received > 0
+				},
+			})
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNoRoute, base, quote)
+	}
+	return paths, nil
+}
+
+// planLocked splits size between the paths, one slice at a time, each slice going to the path
+// paying the least for it on a buy, or the most on a sell. Ties go to the earlier path. Slices,
+// the last one included, are whole lots of every market trading the base, so a size that is not
+// is rejected. The caller holds the locks of the books.
+func (r *SmartRouter) planLocked(paths []routePath, base Asset, bid bool, size Money) ([]Money, error) {
+	var lot Money
+	for _, path := range paths {
+		for _, leg := range path.legs {
+			if legBase, _ := leg.Market.Assets(); legBase == base {
+				lot = Money(math.Max(float64(lot), float64(r.exchange.OrderBooks[leg.Market].Config.LotSize)))
+			}
+		}
+	}
+	slices := r.Slices
+	if slices < 1 {
+		slices = 1
+	}
+	slice := size / Money(slices)
+	if lot > 0 {
+		if !isMultiple(size, lot) {
+			return nil, fmt.Errorf("%w: size %v is not a multiple of the lot size %v", ErrInvalidSize, size, lot)
+		}
+		slice = Money(math.Max(float64(roundDown(slice, lot)), float64(lot)))
+	}
+
+	allocation := make([]Money, len(paths))
+	quoted := make([]Money, len(paths))
+	for left := size; left > 1e-9*size; {
+		// What is left is whole lots, give or take the float noise of the subtractions.
+		step := snap(Money(math.Min(float64(slice), float64(left))), lot)
+		best := -1
+		var bestQuote, bestMarginal Money
+		for i, path := range paths {
+			q, ok := path.quote(allocation[i] + step)
+			if !ok {
+				continue
+			}
+			marginal := q - quoted[i]
+			if best < 0 || (bid && marginal < bestMarginal) || (!bid && marginal > bestMarginal) {
+				best, bestQuote, bestMarginal = i, q, marginal
+			}
+		}
+		if best < 0 {
+			return nil, ErrInsufficientLiquidity
+		}
+		allocation[best] = snap(allocation[best]+step, lot)
+		quoted[best] = bestQuote
+		left -= step
+	}
+	return allocation, nil
+}
+
+// executeLocked trades size along a path as market orders of the user, each of which must fill.
+// The caller holds the locks of the books.
+func (r *SmartRouter) executeLocked(userID string, path routePath, bid bool, size Money, matches map[Market][]MatchEngine) (RoutedFill, []OrderRecord, error) {
+	fill := RoutedFill{Legs: path.legs, Size: size}
+	var records []OrderRecord
+	place := func(leg ConvertLeg, size, notional Money) (*Order, error) {
+		o := NewOrder(leg.Bid, size)
+		o.UserID = userID
+		o.Notional = notional
+		legMatches, legRecords, err := r.exchange.placeLocked(r.exchange.OrderBooks[leg.Market], leg.Market, OrderTypeMarket, 0, o)
+		if err != nil {
+			return nil, err
+		}
+		if !o.IsFilled() {
+			return nil, fmt.Errorf("%w: %s", ErrInsufficientLiquidity, leg.Market)
+		}
+		matches[leg.Market] = append(matches[leg.Market], legMatches...)
+		records = append(records, legRecords...)
+		fill.OrderIDs = append(fill.OrderIDs, o.ID)
+		fill.Quote = FilledNotional(legMatches)
+		return o, nil
+	}
+
+	switch {
+	case len(path.legs) == 1:
+		if _, err := place(path.legs[0], size, 0); err != nil {
+			return fill, nil, err
+		}
+	case bid:
+		paid, ok := path.quote(size)
+		if !ok {
+			return fill, nil, ErrInsufficientLiquidity
+		}
+		if _, err := place(path.legs[0], paid, 0); err != nil {
+			return fill, nil, err
+		}
+		if _, err := place(path.legs[1], size, 0); err != nil {
+			return fill, nil, err
+		}
+		fill.Quote = paid
+	default:
+		if _, err := place(path.legs[0], size, 0); err != nil {
+			return fill, nil, err
+		}
+		o, err := place(path.legs[1], 0, fill.Quote)
+		if err != nil {
+			return fill, nil, err
+		}
+		fill.Quote = o.InitialSize
+	}
+	return fill, records, nil
+}
+
+// books returns the books of every path in market order, the order their locks are taken in.
+func (r *SmartRouter) books(paths []routePath) []*CompleteOrderBook {
+	seen := map[Market]bool{}
+	var markets []string
+	for _, path := range paths {
+		for _, leg := range path.legs {
+			if !seen[leg.Market] {
+				seen[leg.Market] = true
+				markets = append(markets, string(leg.Market))
+			}
+		}
+	}
+	sort.Strings(markets)
+	books := make([]*CompleteOrderBook, 0, len(markets))
+	for _, market := range markets {
+		books = append(books, r.exchange.OrderBooks[Market(market)])
+	}
+	return books
+}
+
+// sizeToReceive returns the base size a market sell needs to receive the notional, rounded up to
+// whole lots, and whether the bids are deep enough. The caller holds the book's lock.
+func (ob *CompleteOrderBook) sizeToReceive(notional Money) (Money, bool) {
+	var size Money
+	left := notional
+	for _, limit := range ob.SortBids() {
+		if left <= 1e-9*notional {
+			break
+		}
+		take := Money(math.Min(float64(left/limit.Price), float64(limit.TotalVolume)))
+		size += take
+		left -= take * limit.Price
+	}
+	if left > 1e-9*notional {
+		return 0, false
+	}
+	size = roundUp(size, ob.Config.LotSize)
+	return size, size <= ob.TotalVolumeOfBid()
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// Liquidity tells whether a fill added liquidity to the book or took it.
+type Liquidity string
+
+const (
+	LiquidityMaker Liquidity = "maker"
+	LiquidityTaker Liquidity = "taker"
+)
+
+// OrderFill is a single execution of an order.
+type OrderFill struct {
+	TradeID   string    `json:"tradeId"`
+	Price     Money     `json:"price"`
+	Size      Money     `json:"size"`
+	Liquidity Liquidity `json:"liquidity"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// OrderState is everything known about an order, from acceptance to its final state.
+type OrderState struct {
+	ID           string      `json:"id"`
+	UserID       string      `json:"userId"`
+	Market       Market      `json:"market"`
+	Type         OrderType   `json:"type"`
+	Bid          bool        `json:"bid"`
+	Price        Money       `json:"price"`
+	Size         Money       `json:"size"`
+	Notional     Money       `json:"notional,omitempty"`
+	FilledSize   Money       `json:"filledSize"`
+	AveragePrice Money       `json:"averagePrice"`
+	Status       OrderStatus `json:"status"`
+	Reason       string      `json:"reason,omitempty"`
+	Fills        []OrderFill `json:"fills"`
+	CreatedAt    int64       `json:"createdAt"`
+	UpdatedAt    int64       `json:"updatedAt"`
+	ExpiresAt    int64       `json:"expiresAt,omitempty"`
+}
+
+// Open reports whether the order can still be filled.
+func (st *OrderState) Open() bool {
+	return st.Status == StatusNew || st.Status == StatusPartiallyFilled
+}
+
+// OrderFilter selects orders in an order listing.
+type OrderFilter struct {
+	// Status is a single status, "open" for new and partially filled orders,
+	// "closed" for every other status, or empty for all of them.
+	Status string
+	Market Market
+}
+
+const (
+	StatusFilterOpen   = "open"
+	StatusFilterClosed = "closed"
+)
+
+func (f OrderFilter) matches(state *OrderState) bool {
+	if f.Market != "" && state.Market != f.Market {
+		return false
+	}
+	switch f.Status {
+	case "":
+		return true
+	case StatusFilterOpen:
+		return state.Open()
+	case StatusFilterClosed:
+		return !state.Open()
+	default:
+		return string(state.Status) == f.Status
+	}
+}
+
+// OrderStateStore tracks the state of every order by listening to the order books' events.
+// It is attached to the books synchronously, so an order can be queried as soon as the call placing it returns.
+type OrderStateStore struct {
+	mu     sync.RWMutex
+	orders map[string]*OrderState
+	// byUser lists each user's order IDs oldest first, and position is the index of an order in it.
+	byUser   map[string][]string
+	position map[string]int
+}
+
+// NewOrderStateStore creates an empty OrderStateStore.
+func NewOrderStateStore() *OrderStateStore {
+	return &OrderStateStore{
+		orders:   make(map[string]*OrderState),
+		byUser:   make(map[string][]string),
+		position: make(map[string]int),
+	}
+}
+
+// Publish applies an order book event.
+func (s *OrderStateStore) Publish(event messaging.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch e := event.(type) {
+	case OrderAccepted:
+		s.insert(&OrderState{
+			ID:        e.OrderID,
+			UserID:    e.UserID,
+			Market:    e.Market,
+			Type:      e.Type,
+			Bid:       e.Bid,
+			Price:     e.Price,
+			Size:      e.Size,
+			Notional:  e.Notional,
+			Status:    StatusNew,
+			Fills:     []OrderFill{},
+			CreatedAt: e.Timestamp,
+			UpdatedAt: e.Timestamp,
+			ExpiresAt: e.ExpiresAt,
+		})
+	case OrderRejected:
+		s.insert(&OrderState{
+			ID:        e.OrderID,
+			UserID:    e.UserID,
+			Market:    e.Market,
+			Bid:       e.Bid,
+			Size:      e.Size,
+			Status:    StatusRejected,
+			Reason:    e.Reason,
+			Fills:     []OrderFill{},
+			CreatedAt: e.Timestamp,
+			UpdatedAt: e.Timestamp,
+		})
+	case TradeExecuted:
+		buyLiquidity, sellLiquidity := LiquidityMaker, LiquidityTaker
+		switch {
+		case e.TakerOrderID == "":
+			buyLiquidity, sellLiquidity = LiquidityAuction, LiquidityAuction
+		case e.TakerBid:
+			buyLiquidity, sellLiquidity = LiquidityTaker, LiquidityMaker
+		}
+		s.addFill(e.BuyOrderID, e, buyLiquidity)
+		s.addFill(e.SellOrderID, e, sellLiquidity)
+	case OrderFilled:
+		s.setStatus(e.OrderID, StatusFilled, e.Timestamp)
+	case OrderPartiallyFilled:
+		s.setStatus(e.OrderID, StatusPartiallyFilled, e.Timestamp)
+	case OrderCancelled:
+		status := StatusCancelled
+		if e.Reason == CancelReasonExpired {
+			status = StatusExpired
+		}
+		if state, ok := s.orders[e.OrderID]; ok {
+			state.Reason = string(e.Reason)
+		}
+		s.setStatus(e.OrderID, status, e.Timestamp)
+	case OrderRested:
+		if state, ok := s.orders[e.OrderID]; ok {
+			state.Price = e.Price
+			state.UpdatedAt = e.Timestamp
+		}
+	case OrderAmended:
+		if state, ok := s.orders[e.OrderID]; ok {
+			state.Price = e.Price
+			state.Size = e.Size
+			state.UpdatedAt = e.Timestamp
+		}
+	}
+}
+
+// insert records a new order. The caller holds the lock.
+func (s *OrderStateStore) insert(state *OrderState) {
+	if _, exists := s.orders[state.ID]; exists {
+		return
+	}
+	s.orders[state.ID] = state
+	s.position[state.ID] = len(s.byUser[state.UserID])
+	s.byUser[state.UserID] = append(s.byUser[state.UserID], state.ID)
+}
+
+func (s *OrderStateStore) addFill(orderID string, trade TradeExecuted, liquidity Liquidity) {
+	state, ok := s.orders[orderID]
+	if !ok {
+		return
+	}
+	notional := state.AveragePrice*state.FilledSize + trade.Price*trade.Size
+	state.FilledSize += trade.Size
+	state.AveragePrice = notional / state.FilledSize
+	state.Fills = append(state.Fills, OrderFill{
+		TradeID:   trade.TradeID,
+		Price:     trade.Price,
+		Size:      trade.Size,
+		Liquidity: liquidity,
+		Timestamp: trade.Timestamp,
+	})
+	state.UpdatedAt = trade.Timestamp
+}
+
+func (s *OrderStateStore) setStatus(orderID string, status OrderStatus, at int64) {
+	if state, ok := s.orders[orderID]; ok {
+		state.Status = status
+		state.UpdatedAt = at
+	}
+}
+
+// Get returns a copy of the state of an order.
+func (s *OrderStateStore) Get(orderID string) (OrderState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.orders[orderID]
+	if !ok {
+		return OrderState{}, false
+	}
+	return state.copy(), true
+}
+
+func (st *OrderState) copy() OrderState {
+	copied := *st
+	copied.Fills = append([]OrderFill{}, st.Fills...)
+	return copied
+}
+
+// List returns up to limit of a user's orders matching the filter, newest first.
+// Pass the returned cursor back as after to get the next page; it is empty on the last page.
+func (s *OrderStateStore) List(userID string, filter OrderFilter, after string, limit int) ([]OrderState, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byUser[userID]
+	next := len(ids) - 1
+	if after != "" {
+		position, ok := s.position[after]
+		if !ok || s.orders[after].UserID != userID {
+			return nil, "", ErrInvalidCursor
+		}
+		next = position - 1
+	}
+
+	orders := []OrderState{}
+	for ; next >= 0; next-- {
+		state := s.orders[ids[next]]
+		if !filter.matches(state) {
+			continue
+		}
+		if len(orders) == limit {
+			return orders, orders[len(orders)-1].ID, nil
+		}
+		orders = append(orders, state.copy())
+	}
+	return orders, "", nil
+}
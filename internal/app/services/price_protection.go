@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// EventOrderRested is published when the unfilled rest of a protected market order is left on the book.
+const EventOrderRested = "order.rested"
+
+// CancelReasonPriceProtection marks the unfilled rest of a market order that reached its price bound.
+const CancelReasonPriceProtection CancelReason = "price_protection"
+
+var ErrInvalidProtection = errors.New("invalid price protection")
+
+// RemainderAction tells what happens to the part of a protected market order its bound leaves unfilled.
+type RemainderAction string
+
+const (
+	// RemainderCancel cancels the unfilled rest. It is the default.
+	RemainderCancel RemainderAction = "cancel"
+	// RemainderRest leaves the unfilled rest on the book as a limit order at the bound.
+	RemainderRest RemainderAction = "rest"
+)
+
+// Protection bounds the prices a market order fills at. With both a price and a slippage,
+// the tighter bound applies; with neither, the order walks the book until it is filled.
+type Protection struct {
+	// Price is the worst price the order fills at, zero for none.
+	Price Money
+	// MaxSlippageBps is how far the order goes from the best opposite price, in basis points, zero for none.
+	MaxSlippageBps float64
+	Remainder      RemainderAction
+}
+
+// Active reports whether the protection bounds the order at all.
+func (p Protection) Active() bool {
+	return p.Price != 0 || p.MaxSlippageBps != 0
+}
+
+// validate checks the protection of an order on a market with the given tick size. A price bound
+// can become the price of a resting order, so it is held to the tick like one.
+func (p Protection) validate(tickSize Money) error {
+	switch {
+	case p.Price < 0 || math.IsNaN(float64(p.Price)) || math.IsInf(float64(p.Price), 0):
+		return fmt.Errorf("%w: price must be positive", ErrInvalidProtection)
+	case !isMultiple(p.Price, tickSize):
+		return fmt.Errorf("%w: price %v is not a multiple of the tick size %v", ErrInvalidProtection, p.Price, tickSize)
+	case p.MaxSlippageBps < 0 || math.IsNaN(p.MaxSlippageBps) || math.IsInf(p.MaxSlippageBps, 0):
+		return fmt.Errorf("%w: slippage must be positive", ErrInvalidProtection)
+	case p.Remainder != "" && p.Remainder != RemainderCancel && p.Remainder != RemainderRest:
+		return fmt.Errorf("%w: unknown remainder action %q", ErrInvalidProtection, p.Remainder)
+	case p.Remainder == RemainderRest && !p.Active():
+		return fmt.Errorf("%w: only a bounded order has a remainder to rest", ErrInvalidProtection)
+	}
+	return nil
+}
+
+// OrderRested is published when the rest of a protected market order is put on the book at its bound.
+type OrderRested struct {
+	EventHeader
+	OrderID   string `json:"orderId"`
+	UserID    string `json:"userId"`
+	Bid       bool   `json:"bid"`
+	Price     Money  `json:"price"`
+	Remaining Money  `json:"remaining"`
+}
+
+func (OrderRested) EventType() string { return EventOrderRested }
+
+// protectionBound returns the worst price a market order fills at. ok is false when the order
+// is unbounded; a zero bound with ok set means it cannot fill at all, as its slippage has no
+// best price to start from. A slippage bound is rounded to the tick, towards the best price.
+func (ob *CompleteOrderBook) protectionBound(o *Order) (bound Money, ok bool) {
+	p := o.Protection
+	if !p.Active() {
+		return 0, false
+	}
+	bound = p.Price
+	if p.MaxSlippageBps > 0 {
+		best, found := ob.bestPrice(!o.Bid)
+		if !found {
+			return 0, true
+		}
+		move := Money(p.MaxSlippageBps / 10000)
+		slipped := roundDown(best*(1+move), ob.Config.TickSize)
+		if !o.Bid {
+			slipped = roundUp(best*(1-move), ob.Config.TickSize)
+		}
+		slipped = snap(slipped, ob.Config.TickSize)
+		if bound == 0 || (o.Bid && slipped < bound) || (!o.Bid && slipped > bound) {
+			bound = slipped
+		}
+	}
+	return bound, true
+}
+
+// beyond reports whether a price is worse than the bound for an order on the given side.
+func beyond(bid bool, price, bound Money) bool {
+	if bid {
+		return price > bound
+	}
+	return price < bound
+}
+
+// bestPrice returns the best price of one side of the book.
+func (ob *CompleteOrderBook) bestPrice(bid bool) (Money, bool) {
+	limits := ob.Asks
+	if bid {
+		limits = ob.Bids
+	}
+	var best Money
+	for _, limit := range limits {
+		if best == 0 || (bid && limit.Price > best) || (!bid && limit.Price < best) {
+			best = limit.Price
+		}
+	}
+	return best, best != 0
+}
+
+// restRemainder leaves the unfilled rest of a market order on the book as a limit order at the price.
+func (ob *CompleteOrderBook) restRemainder(o *Order, price Money) {
+	o.Price = price
+	limit := ob.rest(o, price)
+	ob.emit(func(header EventHeader) messaging.Event {
+		return OrderRested{
+			EventHeader: header,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Bid:         o.Bid,
+			Price:       price,
+			Remaining:   o.Size,
+		}
+	})
+	ob.publishLevel(o.Bid, limit)
+}
+
+// AveragePrice returns the size-weighted price of the matches, zero when there are none.
+func AveragePrice(matches []MatchEngine) Money {
+	var size Money
+	for _, match := range matches {
+		size += match.SizeFilled
+	}
+	if size == 0 {
+		return 0
+	}
+	return FilledNotional(matches) / size
+}
+
+// FilledNotional returns the quote currency the matches traded.
+func FilledNotional(matches []MatchEngine) Money {
+	var notional Money
+	for _, match := range matches {
+		notional += match.Price * match.SizeFilled
+	}
+	return notional
+}
+
+// roundUp rounds value up to a whole number of steps. The tolerance only absorbs float rounding.
+func roundUp(value, step Money) Money {
+	if step <= 0 {
+		return value
+	}
+	return Money(math.Ceil(float64(value/step)-1e-9)) * step
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"math"
+)
+
+// sizeForNotional returns the base size a market order sized in quote currency fills: what its
+// notional buys, or sells for, walking the opposite side from the best price in whole lots, up to
+// the order's protection bound. Levels are consumed until the notional left is worth less than a
+// lot at the next price. The caller holds the book's lock.
+func (ob *CompleteOrderBook) sizeForNotional(o *Order) Money {
+	bound, bounded := ob.protectionBound(o)
+	limits := ob.SortBids()
+	if o.Bid {
+		limits = ob.SortAsk()
+	}
+
+	var size Money
+	left := o.Notional
+	for _, limit := range limits {
+		if bounded && (bound == 0 || beyond(o.Bid, limit.Price, bound)) {
+			break
+		}
+		take := Money(math.Min(float64(roundDown(left/limit.Price, ob.Config.LotSize)), float64(limit.TotalVolume)))
+		if take <= 0 {
+			break
+		}
+		size += take
+		left -= take * limit.Price
+		if take < limit.TotalVolume {
+			break
+		}
+	}
+	return size
+}
+
+// sizeByNotional turns a market order sized in quote currency into a base size, or returns why it cannot.
+func (ob *CompleteOrderBook) sizeByNotional(o *Order) error {
+	switch {
+	case o.Size != 0:
+		return fmt.Errorf("%w: an order has a size or a notional, not both", ErrInvalidNotional)
+	case o.Notional < 0 || math.IsNaN(float64(o.Notional)) || math.IsInf(float64(o.Notional), 0):
+		return fmt.Errorf("%w: must be positive", ErrInvalidNotional)
+	}
+	size := ob.sizeForNotional(o)
+	if size == 0 {
+		return fmt.Errorf("%w: notional %v does not fill a single lot", ErrInvalidNotional, o.Notional)
+	}
+	o.Size, o.InitialSize = size, size
+	return nil
+}
+
+// Unspent returns the part of a quote-sized order's notional its matches did not use.
+func Unspent(o *Order, matches []MatchEngine) Money {
+	return Money(math.Max(float64(o.Notional-FilledNotional(matches)), 0))
+}
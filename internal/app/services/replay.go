@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// ReplayMaxSpeed replays a recording as fast as it can be read.
+const ReplayMaxSpeed = 0
+
+// Replayer publishes recorded events again, spaced as they were recorded.
+type Replayer struct {
+	// Speed divides the recorded gaps between events: 1 replays in real time, 10 ten times
+	// faster. ReplayMaxSpeed does not wait at all.
+	Speed float64
+}
+
+// Replay publishes the reader's events until it runs out or the context is done, and returns
+// how many were published. The first event goes out at once, and the others keep their distance
+// to it in recorded time, so a slow publisher does not make the replay drift.
+func (r Replayer) Replay(ctx context.Context, events *RecordingReader, publisher messaging.Publisher) (int, error) {
+	var (
+		published int
+		first     int64
+		started   time.Time
+		timer     *time.Timer
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		event, err := events.Next()
+		if err == io.EOF {
+			return published, nil
+		}
+		if err != nil {
+			return published, err
+		}
+
+		at := event.Header().Timestamp
+		if published == 0 {
+			first, started = at, time.Now()
+		}
+		if r.Speed > 0 {
+			due := started.Add(time.Duration(float64(at-first) / r.Speed))
+			if wait := time.Until(due); wait > 0 {
+				if timer == nil {
+					timer = time.NewTimer(wait)
+				} else {
+					timer.Reset(wait)
+				}
+				select {
+				case <-ctx.Done():
+					return published, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+		publisher.Publish(event)
+		published++
+	}
+}
+
+// RecordedFlow turns a recording into order flow for a backtest: the orders that reached the
+// book, their amends and their cancels. Trades, fills and level changes are left out, as the
+// backtest recomputes them. An amend becomes a cancel and a new order under the same ID, so the
+// order loses its place in the queue even when the original amend kept it. A protected market
+// order becomes a trade bounded by its protection, whose rest stays on the book when it did live.
+// Market orders sized by notional and rejected orders are skipped.
+func RecordedFlow(events *RecordingReader) ([]FlowEvent, error) {
+	var flow []FlowEvent
+	// resting holds the orders placed in the flow that are still on the book.
+	resting := make(map[string]bool)
+	// markets holds where the market orders are in the flow, until their rest is known.
+	markets := make(map[string]int)
+	place := func(h EventHeader, id, userID string, bid bool, price, size Money) {
+		flow = append(flow, FlowEvent{Time: h.Timestamp, Action: FlowPlace, ID: id, UserID: userID, Bid: bid, Price: price, Size: size})
+		resting[id] = price > 0
+	}
+	cancel := func(h EventHeader, id string) {
+		if resting[id] {
+			flow = append(flow, FlowEvent{Time: h.Timestamp, Action: FlowCancel, ID: id})
+		}
+		delete(resting, id)
+	}
+
+	for {
+		event, err := events.Next()
+		if err == io.EOF {
+			return flow, nil
+		}
+		if err != nil {
+			return flow, err
+		}
+		switch ev := event.(type) {
+		case OrderAccepted:
+			if ev.Size <= 0 {
+				continue
+			}
+			if ev.Type == OrderTypeMarket {
+				markets[ev.OrderID] = len(flow)
+			}
+			place(ev.EventHeader, ev.OrderID, ev.UserID, ev.Bid, ev.Price, ev.Size)
+			if ev.Bound > 0 {
+				flow[len(flow)-1].Action, flow[len(flow)-1].Price = FlowTrade, ev.Bound
+			}
+		case OrderRested:
+			// The rest of a protected market order stayed on the book at its bound, which
+			// recordings made before bounds were recorded only tell here.
+			i, ok := markets[ev.OrderID]
+			if !ok {
+				continue
+			}
+			flow[i].Action, flow[i].Price, flow[i].Rest = FlowTrade, ev.Price, true
+			resting[ev.OrderID] = true
+			delete(markets, ev.OrderID)
+		case OrderAmended:
+			cancel(ev.EventHeader, ev.OrderID)
+			place(ev.EventHeader, ev.OrderID, ev.UserID, ev.Bid, ev.Price, ev.Remaining)
+		case OrderCancelled:
+			cancel(ev.EventHeader, ev.OrderID)
+			delete(markets, ev.OrderID)
+		case OrderFilled:
+			delete(resting, ev.OrderID)
+			delete(markets, ev.OrderID)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// OrderStatus is the lifecycle state of an order.
+type OrderStatus string
+
+const (
+	StatusNew             OrderStatus = "new"
+	StatusPartiallyFilled OrderStatus = "partially_filled"
+	StatusFilled          OrderStatus = "filled"
+	StatusCancelled       OrderStatus = "cancelled"
+	StatusRejected        OrderStatus = "rejected"
+	StatusExpired         OrderStatus = "expired"
+)
+
+// OrderRecord is the persisted state of an order.
+type OrderRecord struct {
+	ID        string
+	UserID    string
+	Market    Market
+	Bid       bool
+	Price     Money // zero for market orders.
+	Size      Money // original size.
+	Remaining Money
+	Status    OrderStatus
+	CreatedAt int64 // unix nanoseconds.
+	UpdatedAt int64
+}
+
+// Trade is a settled fill between a buy and a sell order.
+type Trade struct {
+	ID          string
+	Market      Market
+	Price       Money
+	Size        Money
+	BuyOrderID  string
+	SellOrderID string
+	BuyerID     string
+	SellerID    string
+	ExecutedAt  int64 // unix nanoseconds.
+}
+
+// AccountRecord is the persisted state of a user account.
+type AccountRecord struct {
+	ID           string
+	Email        string
+	PasswordHash []byte
+	TOTPSecret   string
+	CreatedAt    int64
+}
+
+// LedgerEntry is a single balance movement. Balances are the sum of their entries.
+type LedgerEntry struct {
+	ID        string
+	UserID    string
+	Asset     Asset
+	Amount    Money  // positive for credits, negative for debits.
+	Reference string // what caused the movement, e.g. a trade ID.
+	CreatedAt int64
+}
+
+// OrderRepository stores orders.
+type OrderRepository interface {
+	SaveOrder(ctx context.Context, order OrderRecord) error
+	GetOrder(ctx context.Context, id string) (OrderRecord, error)
+	ListOrdersByUser(ctx context.Context, userID string) ([]OrderRecord, error)
+}
+
+// TradeRepository stores executed trades.
+type TradeRepository interface {
+	SaveTrade(ctx context.Context, trade Trade) error
+	ListTrades(ctx context.Context, market Market, from, to int64) ([]Trade, error)
+}
+
+// AccountRepository stores user accounts.
+type AccountRepository interface {
+	SaveAccount(ctx context.Context, account AccountRecord) error
+	GetAccount(ctx context.Context, id string) (AccountRecord, error)
+	GetAccountByEmail(ctx context.Context, email string) (AccountRecord, error)
+}
+
+// BalanceRepository stores the ledger and the balances derived from it.
+type BalanceRepository interface {
+	// ApplyEntry appends a ledger entry and updates the matching balance.
+	ApplyEntry(ctx context.Context, entry LedgerEntry) error
+	GetBalances(ctx context.Context, userID string) (map[Asset]Money, error)
+	ListLedger(ctx context.Context, userID string) ([]LedgerEntry, error)
+}
+
+// Repositories groups the repositories that can take part in one transaction.
+type Repositories interface {
+	Orders() OrderRepository
+	Trades() TradeRepository
+	Accounts() AccountRepository
+	Balances() BalanceRepository
+	Candles() CandleRepository
+}
+
+// Store is a persistence backend.
+type Store interface {
+	Repositories
+	// InTransaction runs fn against repositories bound to a single transaction.
+	// Every write made through them is committed if fn returns nil, and discarded otherwise.
+	InTransaction(ctx context.Context, fn func(tx Repositories) error) error
+	// Migrate brings the storage schema up to date.
+	Migrate(ctx context.Context) error
+	Close() error
+}
+
+// SettlementEntries returns the ledger entries moving funds between buyer and seller for a trade.
+func SettlementEntries(trade Trade, base, quote Asset) []LedgerEntry {
+	notional := trade.Price * trade.Size
+	entry := func(userID string, asset Asset, amount Money) LedgerEntry {
+		return LedgerEntry{
+			ID:        NewID(),
+			UserID:    userID,
+			Asset:     asset,
+			Amount:    amount,
+			Reference: trade.ID,
+			CreatedAt: trade.ExecutedAt,
+		}
+	}
+	return []LedgerEntry{
+		entry(trade.BuyerID, base, trade.Size),
+		entry(trade.BuyerID, quote, -notional),
+		entry(trade.SellerID, base, -trade.Size),
+		entry(trade.SellerID, quote, notional),
+	}
+}
+
+// SettleTrade persists a trade together with its ledger entries in one transaction,
+// so a trade is never recorded without the balance movements it caused.
+func SettleTrade(ctx context.Context, store Store, trade Trade, base, quote Asset) ([]LedgerEntry, error) {
+	entries := SettlementEntries(trade, base, quote)
+	err := store.InTransaction(ctx, func(tx Repositories) error {
+		if err := tx.Trades().SaveTrade(ctx, trade); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := tx.Balances().ApplyEntry(ctx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+const (
+	// TickerWindow is the span of the rolling statistics.
+	TickerWindow = 24 * time.Hour
+	// tickerBucketWidth is the granularity the window slides by.
+	tickerBucketWidth = time.Minute
+)
+
+// Ticker is the rolling 24h summary of a market along with its current top of book.
+type Ticker struct {
+	Market             Market    `json:"market"`
+	LastPrice          Money     `json:"lastPrice"`
+	BestBid            Money     `json:"bestBid"`
+	BestBidSize        Money     `json:"bestBidSize"`
+	BestAsk            Money     `json:"bestAsk"`
+	BestAskSize        Money     `json:"bestAskSize"`
+	Open               Money     `json:"open"`
+	High               Money     `json:"high"`
+	Low                Money     `json:"low"`
+	Close              Money     `json:"close"`
+	Volume             Money     `json:"volume"`      // base asset.
+	QuoteVolume        Money     `json:"quoteVolume"` // quote asset.
+	VWAP               Money     `json:"vwap"`
+	PriceChange        Money     `json:"priceChange"`
+	PriceChangePercent float64   `json:"priceChangePercent"`
+	Trades             int       `json:"trades"`
+	WindowStart        time.Time `json:"windowStart"`
+	WindowEnd          time.Time `json:"windowEnd"`
+}
+
+// tickerBucket aggregates the trades of one minute.
+type tickerBucket struct {
+	start       int64 // unix nanoseconds.
+	open        Money
+	high        Money
+	low         Money
+	close       Money
+	volume      Money
+	quoteVolume Money
+	trades      int
+}
+
+// extremum is an entry of the monotonic queues tracking the window high and low.
+type extremum struct {
+	start int64
+	price Money
+}
+
+// rollingWindow keeps running totals over the last TickerWindow of trades.
+// Volumes are added and subtracted as buckets enter and leave, and the high and low
+// come from monotonic queues, so no update ever rescans the window.
+type rollingWindow struct {
+	buckets     []*tickerBucket
+	highs       []extremum // decreasing prices.
+	lows        []extremum // increasing prices.
+	volume      Money
+	quoteVolume Money
+	trades      int
+	lastPrice   Money
+}
+
+func (w *rollingWindow) add(price, size Money, at time.Time) {
+	start := at.Truncate(tickerBucketWidth).UnixNano()
+	var bucket *tickerBucket
+	if n := len(w.buckets); n > 0 && w.buckets[n-1].start >= start {
+		// Late trades are counted in the newest bucket.
+		bucket = w.buckets[n-1]
+	} else {
+		bucket = &tickerBucket{start: start, open: price, high: price, low: price}
+		w.buckets = append(w.buckets, bucket)
+	}
+
+	if price > bucket.high {
+		bucket.high = price
+	}
+	if price < bucket.low {
+		bucket.low = price
+	}
+	bucket.close = price
+	bucket.volume += size
+	bucket.quoteVolume += price * size
+	bucket.trades++
+
+	w.volume += size
+	w.quoteVolume += price * size
+	w.trades++
+	w.lastPrice = price
+
+	for len(w.highs) > 0 && w.highs[len(w.highs)-1].price <= price {
+		w.highs = w.highs[:len(w.highs)-1]
+	}
+	w.highs = append(w.highs, extremum{bucket.start, price})
+	for len(w.lows) > 0 && w.lows[len(w.lows)-1].price >= price {
+		w.lows = w.lows[:len(w.lows)-1]
+	}
+	w.lows = append(w.lows, extremum{bucket.start, price})
+}
+
+// evict drops the buckets that started before the window.
+func (w *rollingWindow) evict(windowStart int64) {
+	expired := 0
+	for expired < len(w.buckets) && w.buckets[expired].start < windowStart {
+		bucket := w.buckets[expired]
+		w.volume -= bucket.volume
+		w.quoteVolume -= bucket.quoteVolume
+		w.trades -= bucket.trades
+		expired++
+	}
+	if expired > 0 {
+		w.buckets = append(w.buckets[:0:0], w.buckets[expired:]...)
+	}
+	for len(w.highs) > 0 && w.highs[0].start < windowStart {
+		w.highs = w.highs[1:]
+	}
+	for len(w.lows) > 0 && w.lows[0].start < windowStart {
+		w.lows = w.lows[1:]
+	}
+	if len(w.buckets) == 0 {
+		// Avoid floating point residue once the window is empty.
+		w.volume, w.quoteVolume, w.trades = 0, 0, 0
+	}
+}
+
+// TickerService maintains the rolling 24h statistics of every market from executed trades.
+type TickerService struct {
+	mu      sync.Mutex
+	windows map[Market]*rollingWindow
+}
+
+// NewTickerService creates an empty TickerService.
+func NewTickerService() *TickerService {
+	return &TickerService{windows: make(map[Market]*rollingWindow)}
+}
+
+// Run feeds the trades published on the dispatcher into the rolling windows until the context is done.
+func (ts *TickerService) Run(ctx context.Context, events *messaging.Dispatcher) {
+	subscriber := events.Subscribe("tickers", 4096, messaging.Block, func(event messaging.Event) bool {
+		return event.EventType() == EventTradeExecuted
+	})
+	defer events.Unsubscribe(subscriber)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				return
+			}
+			trade := event.(TradeExecuted)
+			ts.AddTrade(trade.Market, trade.Price, trade.Size, time.Unix(0, trade.Timestamp))
+		}
+	}
+}
+
+// AddTrade adds a trade to the market's rolling window.
+func (ts *TickerService) AddTrade(market Market, price, size Money, at time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	window, ok := ts.windows[market]
+	if !ok {
+		window = &rollingWindow{}
+		ts.windows[market] = window
+	}
+	window.add(price, size, at)
+	window.evict(at.Add(-TickerWindow).Truncate(tickerBucketWidth).UnixNano())
+}
+
+// Ticker returns the statistics of the market at the given time, with the top of book taken from the order book.
+func (ts *TickerService) Ticker(market Market, orderBook *CompleteOrderBook, now time.Time) Ticker {
+	windowStart := now.Add(-TickerWindow).Truncate(tickerBucketWidth)
+	ticker := Ticker{
+		Market:      market,
+		WindowStart: windowStart.UTC(),
+		WindowEnd:   now.UTC(),
+	}
+
+	ts.mu.Lock()
+	if window, ok := ts.windows[market]; ok {
+		window.evict(windowStart.UnixNano())
+		ticker.LastPrice = window.lastPrice
+		ticker.Open, ticker.High, ticker.Low, ticker.Close = window.lastPrice, window.lastPrice, window.lastPrice, window.lastPrice
+		if len(window.buckets) > 0 {
+			ticker.Open = window.buckets[0].open
+			ticker.High = window.highs[0].price
+			ticker.Low = window.lows[0].price
+			ticker.Volume = window.volume
+			ticker.QuoteVolume = window.quoteVolume
+			ticker.Trades = window.trades
+		}
+	}
+	ts.mu.Unlock()
+
+	if ticker.Volume > 0 {
+		ticker.VWAP = ticker.QuoteVolume / ticker.Volume
+	}
+	ticker.PriceChange = ticker.Close - ticker.Open
+	if ticker.Open != 0 {
+		ticker.PriceChangePercent = float64(ticker.PriceChange/ticker.Open) * 100
+	}
+
+	if orderBook != nil {
+		orderBook.Exclusive(func() {
+			if bids := orderBook.SortBids(); len(bids) > 0 {
+				ticker.BestBid, ticker.BestBidSize = bids[0].Price, bids[0].TotalVolume
+			}
+			if asks := orderBook.SortAsk(); len(asks) > 0 {
+				ticker.BestAsk, ticker.BestAskSize = asks[0].Price, asks[0].TotalVolume
+			}
+		})
+	}
+	return ticker
+}
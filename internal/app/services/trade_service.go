@@ -14,6 +14,7 @@ func NewOrderBook() *CompleteOrderBook {
 		Bids:      []*Limit{},
 		AskLimits: make(map[Money]*Limit),
 		BidLimits: make(map[Money]*Limit),
+		resting:   make(map[string]*Order),
 	}
 }
 
@@ -41,11 +42,20 @@ func (o *Order) OrderString() string {
 
 // NewOrder creates and returns a new Order instance with the specified bid (true for bid, false for ask) and size.
 // An Order represents an individual order in the order book, with its size, bid status, and timestamp.
+// The timestamp is the wall clock's until a book takes the order and stamps it with its own clock.
 func NewOrder(bid bool, size Money) *Order {
+	return NewOrderAt(bid, size, time.Now().UnixNano())
+}
+
+// NewOrderAt creates an order stamped with the given time, in unix nanoseconds, for simulations
+// running on their own clock.
+func NewOrderAt(bid bool, size Money, timestamp int64) *Order {
 	return &Order{
-		Size:      size,
-		Bid:       bid,
-		TimeStamp: time.Now().UnixNano(),
+		ID:          NewID(),
+		Size:        size,
+		InitialSize: size,
+		Bid:         bid,
+		TimeStamp:   timestamp,
 	}
 }
 
@@ -61,31 +71,56 @@ func (l *Limit) AddOrder(o *Order) {
 }
 
 // DeleteOrder removes an order from the Limit instance.
-// It updates the limit's total volume and removes the order from the orders slice.
-// After removing the order, it needs to resort the orders to maintain the correct order based on price and timestamp.
+// It updates the limit's total volume and removes the order from the orders slice,
+// keeping the other orders in time priority.
 func (l *Limit) DeleteOrder(o *Order) {
 	// Find the index of the order in the orders slice.
 	for i := 0; i < len(l.Orders); i++ {
 		if l.Orders[i] == o {
-			// Move the last order to the position of the removed order and truncate the slice.
-			l.Orders[i] = l.Orders[len(l.Orders)-1]
-			l.Orders = l.Orders[:len(l.Orders)-1]
+			// Shift the later orders forward so they keep their place in the queue.
+			l.Orders = append(l.Orders[:i], l.Orders[i+1:]...)
+			break
 		}
 	}
+	if l.Top == o {
+		l.Top = nil
+	}
 
 	// Clear the limit reference in the removed order.
 	o.Limit = nil
 	// Update the total volume of the limit.
 	l.TotalVolume -= o.Size
-
-	sort.Sort(l.Orders)
 }
 
 // PlaceLimitOrder places a limit order in the order book based on the provided price and order.
 // It creates a new limit if it doesn't exist and adds the order to the corresponding bids or asks list.
 func (ob *CompleteOrderBook) PlaceLimitOrder(price Money, o *Order) {
+	o.Price = price
+	o.TimeStamp = ob.now().UnixNano()
+	ob.publishAccepted(o, OrderTypeLimit, price, 0)
+
+	limit := ob.rest(o, price)
+	ob.publishLevel(o.Bid, limit)
+	ob.refreshIndicative()
+}
+
+// rest puts an order on the book at the price and returns its limit. The order becomes the
+// limit's top order when it makes the price the best of its side.
+func (ob *CompleteOrderBook) rest(o *Order, price Money) *Limit {
+	improves := ob.improvesBest(o.Bid, price)
+	limit := ob.limitFor(o.Bid, price)
+	limit.AddOrder(o)
+	if improves {
+		limit.Top = o
+	}
+	ob.resting[o.ID] = o
+	return limit
+}
+
+// limitFor returns the limit at the given price on one side, creating it if it doesn't exist.
+func (ob *CompleteOrderBook) limitFor(bid bool, price Money) *Limit {
 	var limit *Limit
-	if o.Bid {
+	if bid {
 		limit = ob.BidLimits[price]
 	} else {
 		limit = ob.AskLimits[price]
@@ -93,16 +128,30 @@ func (ob *CompleteOrderBook) PlaceLimitOrder(price Money, o *Order) {
 	if limit == nil {
 		// Create a new limit if it doesn't exist
 		limit = NewLimit(price)
-		if o.Bid {
+		if bid {
 			ob.Bids = append(ob.Bids, limit)
 			ob.BidLimits[price] = limit
 		} else {
 			ob.Asks = append(ob.Asks, limit)
 			ob.AskLimits[price] = limit
 		}
+	}
+	return limit
+}
 
+// GetOrder returns the resting order with the given ID.
+func (ob *CompleteOrderBook) GetOrder(id string) (*Order, bool) {
+	o, ok := ob.resting[id]
+	return o, ok
+}
+
+// RestingOrders returns every order sitting on the book, in no particular order.
+func (ob *CompleteOrderBook) RestingOrders() []*Order {
+	orders := make([]*Order, 0, len(ob.resting))
+	for _, o := range ob.resting {
+		orders = append(orders, o)
 	}
-	limit.AddOrder(o)
+	return orders
 }
 
 // SortAsk sorts the asks list in ascending order based on the price and returns it.
@@ -118,44 +167,187 @@ func (ob *CompleteOrderBook) SortBids() []*Limit {
 }
 
 // PlaceMarketOrder places a market order in the order book based on the provided price and order.
-// It tries to match the order with existing limit orders and fills them accordingly.
-// It returns a slice of MatchEngine containing the matches made during the order execution.
-func (ob *CompleteOrderBook) PlaceMarketOrder(o *Order) []MatchEngine {
-	matches := []MatchEngine{}
+// It tries to match the order with existing limit orders and fills them accordingly, up to the
+// order's protection bound when it has one. An order sized by notional first gets the base size
+// its notional fills. The unfilled rest of a bounded order is cancelled, or
+// rested at the bound when the order asks for it.
+// It returns a slice of MatchEngine containing the matches made during the order execution. An
+// unbounded order larger than the opposite side is rejected with ErrInsufficientLiquidity.
+func (ob *CompleteOrderBook) PlaceMarketOrder(o *Order) ([]MatchEngine, error) {
+	o.TimeStamp = ob.now().UnixNano()
+	if o.Notional > 0 && o.Size == 0 {
+		o.Size = ob.sizeForNotional(o)
+		o.InitialSize = o.Size
+	}
+	bound, bounded := ob.protectionBound(o)
 	// Order can be bid or ask (buy or sell)
+	available := ob.TotalVolumeOfBid()
 	if o.Bid {
-		if o.Size > ob.TotalVolumeOfAsks() {
-			panic(fmt.Errorf("not enough volume for market order. \task size [%.2f], market size [%.2f].", ob.TotalVolumeOfAsks(), o.Size))
-		}
+		available = ob.TotalVolumeOfAsks()
+	}
+	// A bounded order may stop short of the book's volume anyway.
+	if !bounded && o.Size > available {
+		ob.RejectOrder(o, ErrInsufficientLiquidity.Error())
+		return nil, fmt.Errorf("%w: size [%.2f], market size [%.2f]", ErrInsufficientLiquidity, o.Size, available)
+	}
 
-		for _, limit := range ob.SortAsk() {
-			// if it's a bid, check for asks/offers.
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
+	ob.publishAccepted(o, OrderTypeMarket, 0, bound)
+	var matches []MatchEngine
+	if o.Bid {
+		matches = ob.fillAgainst(false, ob.SortAsk(), o, bound, bounded)
+	} else {
+		matches = ob.fillAgainst(true, ob.SortBids(), o, bound, bounded)
+	}
+	switch {
+	case o.IsFilled():
+	case bound > 0 && o.Protection.Remainder == RemainderRest && ob.Status().State != MarketHalted &&
+		ob.Config.Validate(OrderTypeLimit, bound, o.Size) == nil:
+		ob.restRemainder(o, bound)
+	default:
+		// A remainder too small to rest under the market's rules is cancelled too.
+		ob.publishCancelled(o, ob.remainderReason(o))
+	}
+	return matches, nil
+}
+
+// remainderReason returns why the unfilled rest of a market order was cancelled: the circuit
+// breaker halted the market, the order reached its bound, or, unbounded, the book ran out of volume
+// it could fill, as with a remainder smaller than the lot.
+func (ob *CompleteOrderBook) remainderReason(o *Order) CancelReason {
+	switch {
+	case ob.Status().State == MarketHalted:
+		return CancelReasonCircuitBreaker
+	case o.Protection.Active():
+		return CancelReasonPriceProtection
+	default:
+		return CancelReasonNoLiquidity
+	}
+}
 
-			if len(limit.Orders) == 0 {
-				ob.ClearLimit(true, limit)
+// fillAgainst walks the sorted limits of one side until the order is filled, a limit's price is
+// beyond the order's bound or trips the circuit breaker, then clears the limits it emptied. Each
+// limit shares the order among its resting orders with the market's matching policy.
+func (ob *CompleteOrderBook) fillAgainst(bid bool, limits []*Limit, o *Order, bound Money, bounded bool) []MatchEngine {
+	matches := []MatchEngine{}
+	var emptiedLimits []*Limit
+	now := ob.now()
+	for _, limit := range limits {
+		if bounded && (bound == 0 || beyond(o.Bid, limit.Price, bound)) {
+			break
+		}
+		if ob.tripsBreaker(limit.Price, now) {
+			break
+		}
+		limitMatches := limit.FillWith(ob.Config.MatchingPolicy(), o, ob.Config.LotSize)
+		ob.recordFill(limit.Price, now)
+		ob.lastPrice = limit.Price
+		matches = append(matches, limitMatches...)
+		for _, match := range limitMatches {
+			maker := match.Bid
+			if o.Bid {
+				maker = match.Ask
 			}
+			if maker.IsFilled() {
+				delete(ob.resting, maker.ID)
+			}
+			ob.publishTrade(match, o)
+			ob.publishFill(maker, match.Price, match.SizeFilled)
+			ob.publishFill(o, match.Price, match.SizeFilled)
 		}
-	} else {
-		if o.Size > ob.TotalVolumeOfBid() {
-			panic(fmt.Errorf("not enough volume for market order. \task size [%.2f], market size [%.2f].", ob.TotalVolumeOfBid(), o.Size))
+		ob.publishLevel(bid, limit)
+
+		if len(limit.Orders) == 0 {
+			emptiedLimits = append(emptiedLimits, limit)
+		}
+		if o.IsFilled() {
+			break
 		}
+	}
+	for _, limit := range emptiedLimits {
+		ob.ClearLimit(bid, limit)
+	}
+	return matches
+}
 
-		for _, limit := range ob.SortBids() {
-			// if it's a bid, check for asks/offers.
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
+// Exclusive runs fn while holding the book's lock. Book methods don't lock themselves;
+// callers sharing a book between goroutines run every read and write through Exclusive,
+// which also makes a sequence of operations atomic with respect to matching.
+func (ob *CompleteOrderBook) Exclusive(fn func()) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	fn()
+}
 
-			if len(limit.Orders) == 0 {
-				ob.ClearLimit(true, limit)
-			}
+// CancelWhere pulls every resting order the predicate selects and returns them, oldest first.
+func (ob *CompleteOrderBook) CancelWhere(selected func(o *Order) bool, reason CancelReason) []*Order {
+	var cancelled []*Order
+	for _, o := range ob.resting {
+		if selected(o) {
+			cancelled = append(cancelled, o)
 		}
 	}
-	return matches
+	sort.Slice(cancelled, func(i, j int) bool {
+		if cancelled[i].TimeStamp != cancelled[j].TimeStamp {
+			return cancelled[i].TimeStamp < cancelled[j].TimeStamp
+		}
+		return cancelled[i].ID < cancelled[j].ID
+	})
+	for _, o := range cancelled {
+		ob.CancelOrderWithReason(o, reason)
+	}
+	return cancelled
+}
+
+// AmendOrder changes the price and remaining size of a resting order. Shrinking an order at the
+// same price keeps its place in the queue; any other change sends it to the back of its new level.
+func (ob *CompleteOrderBook) AmendOrder(o *Order, price, remaining Money) {
+	limit := o.Limit
+	o.InitialSize += remaining - o.Size
+	if price == o.Price && remaining <= o.Size {
+		limit.TotalVolume -= o.Size - remaining
+		o.Size = remaining
+		ob.publishAmended(o)
+		ob.publishLevel(o.Bid, limit)
+		ob.refreshIndicative()
+		return
+	}
+
+	// An order re-queued at its own price stays its level's top order.
+	keepsTop := limit.Top == o && price == o.Price
+	limit.DeleteOrder(o)
+	if len(limit.Orders) == 0 {
+		ob.ClearLimit(o.Bid, limit)
+	}
+	ob.publishLevel(o.Bid, limit)
+
+	o.Size = remaining
+	o.TimeStamp = ob.now().UnixNano()
+	o.Price = price
+	improves := ob.improvesBest(o.Bid, price)
+	newLimit := ob.limitFor(o.Bid, price)
+	newLimit.AddOrder(o)
+	if keepsTop || improves {
+		newLimit.Top = o
+	}
+	ob.publishAmended(o)
+	ob.publishLevel(o.Bid, newLimit)
+	ob.refreshIndicative()
 }
 
+// CancelOrder removes a resting order from the book, and its limit once the limit is empty.
 func (ob *CompleteOrderBook) CancelOrder(o *Order) {
+	ob.CancelOrderWithReason(o, CancelReasonUser)
+}
+
+// CancelOrderWithReason removes a resting order from the book, recording why it was pulled.
+func (ob *CompleteOrderBook) CancelOrderWithReason(o *Order, reason CancelReason) {
 	limit := o.Limit
 	limit.DeleteOrder(o)
+	if len(limit.Orders) == 0 {
+		ob.ClearLimit(o.Bid, limit)
+	}
+	delete(ob.resting, o.ID)
+	ob.publishCancelled(o, reason)
+	ob.publishLevel(o.Bid, limit)
+	ob.refreshIndicative()
 }
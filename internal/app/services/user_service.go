@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmailTaken         = errors.New("email already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrTOTPRequired       = errors.New("two-factor code required")
+	ErrInvalidTOTP        = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not being enrolled")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+	ErrInvalidDeposit     = errors.New("deposit amount must be positive")
+)
+
+const (
+	totpIssuer      = "Cryptex"
+	minPasswordSize = 8
+	// DefaultSessionTTL is how long a login stays valid.
+	DefaultSessionTTL = 24 * time.Hour
+)
+
+// User is an exchange account. It owns a wallet and the orders placed with its sessions.
+type User struct {
+	ID           string
+	Email        string
+	PasswordHash []byte
+	Wallet       *Wallet
+	CreatedAt    time.Time
+
+	// TOTPSecret is set once two-factor authentication has been confirmed.
+	TOTPSecret string
+	// pendingTOTPSecret holds the secret between enrollment and its first verification.
+	pendingTOTPSecret string
+}
+
+// TwoFactorEnabled reports whether logins require a TOTP code.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// Session is an authenticated login. Only the hash of its token is kept.
+type Session struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// UserService registers users, authenticates them and manages their sessions.
+type UserService struct {
+	mu         sync.RWMutex
+	users      map[string]*User    // by ID
+	emails     map[string]string   // email -> user ID
+	sessions   map[string]*Session // token hash -> session
+	SessionTTL time.Duration
+	// Store persists accounts and balances. Users only live in memory when it is nil.
+	Store Store
+}
+
+// NewUserService creates an empty UserService.
+func NewUserService() *UserService {
+	return &UserService{
+		users:      make(map[string]*User),
+		emails:     make(map[string]string),
+		sessions:   make(map[string]*Session),
+		SessionTTL: DefaultSessionTTL,
+	}
+}
+
+// NewID returns a random identifier for users, sessions and orders.
+func NewID() string {
+	return randomHex(16)
+}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Register creates a user with a bcrypt hashed password and an empty wallet.
+func (us *UserService) Register(email, password string) (*User, error) {
+	email = normalizeEmail(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, ErrInvalidCredentials
+	}
+	if len(password) < minPasswordSize {
+		return nil, ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := us.lookupEmail(email); err == nil {
+		return nil, ErrEmailTaken
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if _, taken := us.emails[email]; taken {
+		return nil, ErrEmailTaken
+	}
+	user := &User{
+		ID:           NewID(),
+		Email:        email,
+		PasswordHash: hash,
+		Wallet:       NewWallet(),
+		CreatedAt:    time.Now(),
+	}
+	if err := us.saveAccount(user); err != nil {
+		return nil, err
+	}
+	us.users[user.ID] = user
+	us.emails[email] = user.ID
+	return user, nil
+}
+
+// GetUser returns the user with the given ID.
+func (us *UserService) GetUser(userID string) (*User, error) {
+	us.mu.RLock()
+	user, ok := us.users[userID]
+	us.mu.RUnlock()
+	if ok {
+		return user, nil
+	}
+	return us.loadAccount(func(accounts AccountRepository) (AccountRecord, error) {
+		return accounts.GetAccount(context.Background(), userID)
+	})
+}
+
+// Deposit credits an amount of an asset to a user's wallet, recording it in the ledger first.
+func (us *UserService) Deposit(ctx context.Context, userID string, asset Asset, amount Money) (*User, error) {
+	if amount <= 0 || asset == "" {
+		return nil, ErrInvalidDeposit
+	}
+	user, err := us.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if us.Store != nil {
+		entry := LedgerEntry{
+			ID:        NewID(),
+			UserID:    userID,
+			Asset:     asset,
+			Amount:    amount,
+			Reference: "deposit",
+			CreatedAt: time.Now().UnixNano(),
+		}
+		if err := us.Store.Balances().ApplyEntry(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+	user.Wallet.Credit(asset, amount)
+	return user, nil
+}
+
+// lookupEmail finds a user by email, in memory first and then in the store.
+func (us *UserService) lookupEmail(email string) (*User, error) {
+	us.mu.RLock()
+	user, ok := us.users[us.emails[email]]
+	us.mu.RUnlock()
+	if ok {
+		return user, nil
+	}
+	return us.loadAccount(func(accounts AccountRepository) (AccountRecord, error) {
+		return accounts.GetAccountByEmail(context.Background(), email)
+	})
+}
+
+// loadAccount brings a persisted account and its balances back into memory.
+func (us *UserService) loadAccount(find func(accounts AccountRepository) (AccountRecord, error)) (*User, error) {
+	if us.Store == nil {
+		return nil, ErrUserNotFound
+	}
+	record, err := find(us.Store.Accounts())
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	balances, err := us.Store.Balances().GetBalances(context.Background(), record.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	// Another caller may have loaded it meanwhile.
+	if user, ok := us.users[record.ID]; ok {
+		return user, nil
+	}
+	user := &User{
+		ID:           record.ID,
+		Email:        record.Email,
+		PasswordHash: record.PasswordHash,
+		TOTPSecret:   record.TOTPSecret,
+		Wallet:       NewWallet(),
+		CreatedAt:    time.Unix(0, record.CreatedAt),
+	}
+	for asset, amount := range balances {
+		user.Wallet.Credit(asset, amount)
+	}
+	us.users[user.ID] = user
+	us.emails[user.Email] = user.ID
+	return user, nil
+}
+
+// saveAccount persists the account fields of a user. The caller holds the lock.
+func (us *UserService) saveAccount(user *User) error {
+	if us.Store == nil {
+		return nil
+	}
+	return us.Store.Accounts().SaveAccount(context.Background(), AccountRecord{
+		ID:           user.ID,
+		Email:        user.Email,
+		PasswordHash: user.PasswordHash,
+		TOTPSecret:   user.TOTPSecret,
+		CreatedAt:    user.CreatedAt.UnixNano(),
+	})
+}
+
+// Login checks the password, and the TOTP code when 2FA is enabled, and opens a session.
+// It returns the session token, which is only ever handed out once.
+func (us *UserService) Login(email, password, totpCode string) (string, *Session, error) {
+	user, err := us.lookupEmail(normalizeEmail(email))
+	if err != nil {
+		// Spend the same time as a real comparison so unknown emails can't be told apart.
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	us.mu.RLock()
+	secret := user.TOTPSecret
+	us.mu.RUnlock()
+	if secret != "" {
+		if totpCode == "" {
+			return "", nil, ErrTOTPRequired
+		}
+		if !VerifyTOTP(secret, totpCode, time.Now()) {
+			return "", nil, ErrInvalidTOTP
+		}
+	}
+
+	token := randomHex(32)
+	now := time.Now()
+	session := &Session{
+		ID:        NewID(),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(us.SessionTTL),
+	}
+	us.mu.Lock()
+	us.sessions[hashToken(token)] = session
+	us.mu.Unlock()
+	return token, session, nil
+}
+
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("cryptex-dummy-password"), bcrypt.DefaultCost)
+
+// Authenticate resolves a session token to its user.
+func (us *UserService) Authenticate(token string) (*User, *Session, error) {
+	key := hashToken(token)
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	session, ok := us.sessions[key]
+	if !ok {
+		return nil, nil, ErrSessionNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(us.sessions, key)
+		return nil, nil, ErrSessionNotFound
+	}
+	user, ok := us.users[session.UserID]
+	if !ok {
+		return nil, nil, ErrUserNotFound
+	}
+	return user, session, nil
+}
+
+// RevokeSession logs out the session owning the token.
+func (us *UserService) RevokeSession(token string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	key := hashToken(token)
+	if _, ok := us.sessions[key]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(us.sessions, key)
+	return nil
+}
+
+// RevokeAllSessions logs a user out everywhere and returns how many sessions were closed.
+func (us *UserService) RevokeAllSessions(userID string) int {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	revoked := 0
+	for key, session := range us.sessions {
+		if session.UserID == userID {
+			delete(us.sessions, key)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// EnrollTOTP starts two-factor enrollment and returns the secret and its provisioning URI.
+// 2FA is only enforced once ConfirmTOTP has verified a first code.
+func (us *UserService) EnrollTOTP(userID string) (string, string, error) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	user, ok := us.users[userID]
+	if !ok {
+		return "", "", ErrUserNotFound
+	}
+	user.pendingTOTPSecret = secret
+	return secret, TOTPProvisioningURI(totpIssuer, user.Email, secret), nil
+}
+
+// ConfirmTOTP verifies the first code generated from the enrolled secret and turns 2FA on.
+func (us *UserService) ConfirmTOTP(userID, code string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	user, ok := us.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.pendingTOTPSecret == "" {
+		return ErrTOTPNotEnrolled
+	}
+	if !VerifyTOTP(user.pendingTOTPSecret, code, time.Now()) {
+		return ErrInvalidTOTP
+	}
+	user.TOTPSecret = user.pendingTOTPSecret
+	user.pendingTOTPSecret = ""
+	return us.saveAccount(user)
+}
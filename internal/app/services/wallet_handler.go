@@ -1 +1,103 @@
 package services
+
+import (
+	"errors"
+	"sync"
+)
+
+// Asset is a currency held in a wallet, e.g. "ETH" or "USD".
+type Asset string
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// balanceTolerance absorbs the rounding of amounts computed from prices and sizes, so an order
+// costing exactly what is available is not refused by float error.
+const balanceTolerance = 1e-9
+
+// Wallet holds the balances of a single user account, and what its live orders hold of them.
+type Wallet struct {
+	mu       sync.Mutex
+	balances map[Asset]Money
+	held     map[Asset]Money
+}
+
+// NewWallet creates an empty wallet.
+func NewWallet() *Wallet {
+	return &Wallet{balances: make(map[Asset]Money), held: make(map[Asset]Money)}
+}
+
+// Balance returns the balance held for an asset.
+func (w *Wallet) Balance(asset Asset) Money {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.balances[asset]
+}
+
+// Available returns the balance of an asset that no live order holds.
+func (w *Wallet) Available(asset Asset) Money {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.balances[asset] - w.held[asset]
+}
+
+// Balances returns a copy of all non-zero balances.
+func (w *Wallet) Balances() map[Asset]Money {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	balances := make(map[Asset]Money, len(w.balances))
+	for asset, amount := range w.balances {
+		if amount != 0 {
+			balances[asset] = amount
+		}
+	}
+	return balances
+}
+
+// Credit adds amount to the balance of an asset.
+func (w *Wallet) Credit(asset Asset, amount Money) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.balances[asset] += amount
+}
+
+// Debit removes amount from the balance of an asset, failing if less than that is available.
+func (w *Wallet) Debit(asset Asset, amount Money) error {
+	return w.Spend(asset, amount, 0)
+}
+
+// Hold sets amount of an asset aside for an order, failing if less than that is available.
+func (w *Wallet) Hold(asset Asset, amount Money) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.balances[asset]-w.held[asset] < amount-balanceTolerance {
+		return ErrInsufficientBalance
+	}
+	w.held[asset] += amount
+	return nil
+}
+
+// Release gives back amount of an asset held for an order.
+func (w *Wallet) Release(asset Asset, amount Money) {
+	w.adjust(asset, 0, -amount)
+}
+
+// Spend releases what an order held of an asset and debits amount, the order's cost, in one
+// step. It fails, changing nothing, if less than amount is available once released.
+func (w *Wallet) Spend(asset Asset, amount, released Money) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.balances[asset]-w.held[asset]+released < amount-balanceTolerance {
+		return ErrInsufficientBalance
+	}
+	w.held[asset] -= released
+	w.balances[asset] -= amount
+	return nil
+}
+
+// adjust moves the balance and the held amount of an asset without any check, to undo a change.
+func (w *Wallet) adjust(asset Asset, balance, held Money) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.balances[asset] += balance
+	w.held[asset] += held
+}
@@ -0,0 +1,81 @@
+package messaging
+
+import "sync"
+
+// Event is anything published on the bus.
+type Event interface {
+	EventType() string
+}
+
+// Publisher is the side of the bus producers depend on.
+type Publisher interface {
+	Publish(event Event)
+}
+
+// Publishers fans an event out to several publishers, in order.
+type Publishers []Publisher
+
+func (p Publishers) Publish(event Event) {
+	for _, publisher := range p {
+		publisher.Publish(event)
+	}
+}
+
+// Dispatcher is an in-process pub/sub bus. Every subscriber gets its own buffer,
+// and its overflow policy decides what happens when it falls behind.
+type Dispatcher struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+}
+
+// NewDispatcher creates a Dispatcher without subscribers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{subscribers: make(map[*Subscriber]struct{})}
+}
+
+// Publish delivers the event to every subscriber whose filter accepts it.
+// Each subscriber sees events in the order they were published.
+func (d *Dispatcher) Publish(event Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for subscriber := range d.subscribers {
+		if subscriber.filter != nil && !subscriber.filter(event) {
+			continue
+		}
+		if !subscriber.deliver(event) {
+			// Can't take the write lock while publishing, so detach in the background.
+			go d.Unsubscribe(subscriber)
+		}
+	}
+}
+
+// Subscribe registers a subscriber with a buffer of the given size.
+// A nil filter receives every event.
+func (d *Dispatcher) Subscribe(name string, bufferSize int, policy OverflowPolicy, filter func(Event) bool) *Subscriber {
+	subscriber := newSubscriber(name, bufferSize, policy, filter)
+	d.mu.Lock()
+	d.subscribers[subscriber] = struct{}{}
+	d.mu.Unlock()
+	return subscriber
+}
+
+// Unsubscribe detaches the subscriber and closes its channel. It is safe to call more than once.
+func (d *Dispatcher) Unsubscribe(subscriber *Subscriber) {
+	// Unblock a publisher waiting on this subscriber before taking the write lock.
+	subscriber.stop()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subscribers[subscriber]; !ok {
+		return
+	}
+	delete(d.subscribers, subscriber)
+	close(subscriber.events)
+}
+
+// SubscriberCount returns the number of attached subscribers.
+func (d *Dispatcher) SubscriberCount() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.subscribers)
+}
@@ -0,0 +1,111 @@
+package messaging
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what a subscriber does when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block makes the publisher wait until there is room. Use it for consumers that must not
+	// miss anything, like the ledger, and keep them fast: they slow down matching.
+	Block OverflowPolicy = iota
+	// DropNewest discards the event that doesn't fit.
+	DropNewest
+	// DropOldest discards the oldest buffered event to make room, so the consumer stays current.
+	DropOldest
+	// Disconnect detaches the subscriber and closes its channel; it must resubscribe and resync.
+	Disconnect
+)
+
+// Subscriber receives events from a Dispatcher on a buffered channel.
+type Subscriber struct {
+	Name     string
+	events   chan Event
+	policy   OverflowPolicy
+	filter   func(Event) bool
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Uint64
+	cut      atomic.Bool
+}
+
+func newSubscriber(name string, bufferSize int, policy OverflowPolicy, filter func(Event) bool) *Subscriber {
+	return &Subscriber{
+		Name:   name,
+		events: make(chan Event, bufferSize),
+		policy: policy,
+		filter: filter,
+		done:   make(chan struct{}),
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed once the subscriber is detached.
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were discarded because the buffer was full.
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Disconnected reports whether the subscriber was cut off for falling behind.
+func (s *Subscriber) Disconnected() bool {
+	return s.cut.Load()
+}
+
+func (s *Subscriber) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// deliver hands the event over according to the overflow policy.
+// It returns false when the subscriber must be detached.
+func (s *Subscriber) deliver(event Event) bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.events <- event:
+		case <-s.done:
+		}
+		return true
+	case DropNewest:
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+		}
+		return true
+	case DropOldest:
+		for {
+			select {
+			case s.events <- event:
+				return true
+			default:
+			}
+			select {
+			case <-s.events:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default: // Disconnect
+		select {
+		case s.events <- event:
+			return true
+		default:
+			s.dropped.Add(1)
+			s.cut.Store(true)
+			s.stop()
+			return false
+		}
+	}
+}
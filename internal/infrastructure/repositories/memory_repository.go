@@ -0,0 +1,408 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// memoryData is the full content of a MemoryStore. Transactions write to it in place and undo
+// their writes when they fail.
+type memoryData struct {
+	version  int
+	orders   map[string]services.OrderRecord
+	trades   []services.Trade
+	accounts map[string]services.AccountRecord
+	emails   map[string]string
+	balances map[string]map[services.Asset]services.Money
+	ledger   []services.LedgerEntry
+	candles  map[candleKey]map[int64]services.Candle
+}
+
+type candleKey struct {
+	market   services.Market
+	interval services.CandleInterval
+}
+
+// memoryMigrations mirror the SQL migrations, each creating the collections of its tables.
+var memoryMigrations = []func(data *memoryData){
+	func(data *memoryData) {
+		data.orders = make(map[string]services.OrderRecord)
+		data.accounts = make(map[string]services.AccountRecord)
+		data.emails = make(map[string]string)
+	},
+	func(data *memoryData) {
+		data.balances = make(map[string]map[services.Asset]services.Money)
+	},
+	func(data *memoryData) {
+		data.candles = make(map[candleKey]map[int64]services.Candle)
+	},
+}
+
+// MemoryStore is an in-memory services.Store for tests and ephemeral deployments.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+// NewMemoryStore creates a migrated, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{data: &memoryData{}}
+	store.Migrate(context.Background())
+	return store
+}
+
+// Migrate applies the migrations the store hasn't seen yet.
+func (ms *MemoryStore) Migrate(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for ms.data.version < len(memoryMigrations) {
+		memoryMigrations[ms.data.version](ms.data)
+		ms.data.version++
+	}
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// InTransaction runs fn on the store, logging how to undo each write, and undoes them all if fn
+// fails. Transactions are serialized, so they never observe each other's partial writes.
+func (ms *MemoryStore) InTransaction(ctx context.Context, fn func(tx services.Repositories) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tx := &memoryRepositories{data: ms.data, logging: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// Every single call outside a transaction is a transaction of its own.
+func (ms *MemoryStore) Orders() services.OrderRepository     { return memoryAutoCommit{ms} }
+func (ms *MemoryStore) Trades() services.TradeRepository     { return memoryAutoCommit{ms} }
+func (ms *MemoryStore) Accounts() services.AccountRepository { return memoryAutoCommit{ms} }
+func (ms *MemoryStore) Balances() services.BalanceRepository { return memoryAutoCommit{ms} }
+func (ms *MemoryStore) Candles() services.CandleRepository   { return memoryAutoCommit{ms} }
+
+// memoryAutoCommit runs each call in its own transaction.
+type memoryAutoCommit struct {
+	store *MemoryStore
+}
+
+func (m memoryAutoCommit) run(ctx context.Context, fn func(tx *memoryRepositories) error) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	return fn(&memoryRepositories{data: m.store.data})
+}
+
+func (m memoryAutoCommit) SaveOrder(ctx context.Context, order services.OrderRecord) error {
+	return m.run(ctx, func(tx *memoryRepositories) error { return tx.SaveOrder(ctx, order) })
+}
+
+func (m memoryAutoCommit) GetOrder(ctx context.Context, id string) (order services.OrderRecord, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		order, err = tx.GetOrder(ctx, id)
+		return err
+	})
+	return order, err
+}
+
+func (m memoryAutoCommit) ListOrdersByUser(ctx context.Context, userID string) (orders []services.OrderRecord, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		orders, err = tx.ListOrdersByUser(ctx, userID)
+		return err
+	})
+	return orders, err
+}
+
+func (m memoryAutoCommit) SaveTrade(ctx context.Context, trade services.Trade) error {
+	return m.run(ctx, func(tx *memoryRepositories) error { return tx.SaveTrade(ctx, trade) })
+}
+
+func (m memoryAutoCommit) ListTrades(ctx context.Context, market services.Market, from, to int64) (trades []services.Trade, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		trades, err = tx.ListTrades(ctx, market, from, to)
+		return err
+	})
+	return trades, err
+}
+
+func (m memoryAutoCommit) SaveAccount(ctx context.Context, account services.AccountRecord) error {
+	return m.run(ctx, func(tx *memoryRepositories) error { return tx.SaveAccount(ctx, account) })
+}
+
+func (m memoryAutoCommit) GetAccount(ctx context.Context, id string) (account services.AccountRecord, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		account, err = tx.GetAccount(ctx, id)
+		return err
+	})
+	return account, err
+}
+
+func (m memoryAutoCommit) GetAccountByEmail(ctx context.Context, email string) (account services.AccountRecord, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		account, err = tx.GetAccountByEmail(ctx, email)
+		return err
+	})
+	return account, err
+}
+
+func (m memoryAutoCommit) ApplyEntry(ctx context.Context, entry services.LedgerEntry) error {
+	return m.run(ctx, func(tx *memoryRepositories) error { return tx.ApplyEntry(ctx, entry) })
+}
+
+func (m memoryAutoCommit) GetBalances(ctx context.Context, userID string) (balances map[services.Asset]services.Money, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		balances, err = tx.GetBalances(ctx, userID)
+		return err
+	})
+	return balances, err
+}
+
+func (m memoryAutoCommit) ListLedger(ctx context.Context, userID string) (entries []services.LedgerEntry, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		entries, err = tx.ListLedger(ctx, userID)
+		return err
+	})
+	return entries, err
+}
+
+func (m memoryAutoCommit) SaveCandle(ctx context.Context, candle services.Candle) error {
+	return m.run(ctx, func(tx *memoryRepositories) error { return tx.SaveCandle(ctx, candle) })
+}
+
+func (m memoryAutoCommit) ListCandles(ctx context.Context, market services.Market, interval services.CandleInterval, from, to time.Time) (candles []services.Candle, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		candles, err = tx.ListCandles(ctx, market, interval, from, to)
+		return err
+	})
+	return candles, err
+}
+
+func (m memoryAutoCommit) LastCandleBefore(ctx context.Context, market services.Market, interval services.CandleInterval, before time.Time) (candle services.Candle, err error) {
+	err = m.run(ctx, func(tx *memoryRepositories) error {
+		candle, err = tx.LastCandleBefore(ctx, market, interval, before)
+		return err
+	})
+	return candle, err
+}
+
+// memoryRepositories implements every repository directly on a memoryData.
+// The caller is responsible for holding the store lock.
+type memoryRepositories struct {
+	data *memoryData
+	// undo restores what the writes of a transaction touched, when logging.
+	logging bool
+	undo    []func()
+}
+
+// logUndo records how to restore what a write is about to change.
+func (r *memoryRepositories) logUndo(fn func()) {
+	if r.logging {
+		r.undo = append(r.undo, fn)
+	}
+}
+
+// rollback undoes the logged writes, last first.
+func (r *memoryRepositories) rollback() {
+	for i := len(r.undo) - 1; i >= 0; i-- {
+		r.undo[i]()
+	}
+	r.undo = nil
+}
+
+func (r *memoryRepositories) Orders() services.OrderRepository     { return r }
+func (r *memoryRepositories) Trades() services.TradeRepository     { return r }
+func (r *memoryRepositories) Accounts() services.AccountRepository { return r }
+func (r *memoryRepositories) Balances() services.BalanceRepository { return r }
+func (r *memoryRepositories) Candles() services.CandleRepository   { return r }
+
+func (r *memoryRepositories) SaveOrder(ctx context.Context, order services.OrderRecord) error {
+	previous, existed := r.data.orders[order.ID]
+	r.logUndo(func() {
+		if existed {
+			r.data.orders[order.ID] = previous
+		} else {
+			delete(r.data.orders, order.ID)
+		}
+	})
+	r.data.orders[order.ID] = order
+	return nil
+}
+
+func (r *memoryRepositories) GetOrder(ctx context.Context, id string) (services.OrderRecord, error) {
+	order, ok := r.data.orders[id]
+	if !ok {
+		return services.OrderRecord{}, services.ErrNotFound
+	}
+	return order, nil
+}
+
+func (r *memoryRepositories) ListOrdersByUser(ctx context.Context, userID string) ([]services.OrderRecord, error) {
+	var orders []services.OrderRecord
+	for _, order := range r.data.orders {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].CreatedAt != orders[j].CreatedAt {
+			return orders[i].CreatedAt < orders[j].CreatedAt
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders, nil
+}
+
+func (r *memoryRepositories) SaveTrade(ctx context.Context, trade services.Trade) error {
+	count := len(r.data.trades)
+	r.logUndo(func() { r.data.trades = r.data.trades[:count] })
+	r.data.trades = append(r.data.trades, trade)
+	return nil
+}
+
+func (r *memoryRepositories) ListTrades(ctx context.Context, market services.Market, from, to int64) ([]services.Trade, error) {
+	var trades []services.Trade
+	for _, trade := range r.data.trades {
+		if trade.Market == market && trade.ExecutedAt >= from && trade.ExecutedAt < to {
+			trades = append(trades, trade)
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].ExecutedAt < trades[j].ExecutedAt })
+	return trades, nil
+}
+
+func (r *memoryRepositories) SaveAccount(ctx context.Context, account services.AccountRecord) error {
+	if id, taken := r.data.emails[account.Email]; taken && id != account.ID {
+		return services.ErrEmailTaken
+	}
+	previous, existed := r.data.accounts[account.ID]
+	r.logUndo(func() {
+		delete(r.data.emails, account.Email)
+		if existed {
+			r.data.accounts[account.ID] = previous
+			r.data.emails[previous.Email] = previous.ID
+		} else {
+			delete(r.data.accounts, account.ID)
+		}
+	})
+	if existed {
+		delete(r.data.emails, previous.Email)
+	}
+	r.data.accounts[account.ID] = account
+	r.data.emails[account.Email] = account.ID
+	return nil
+}
+
+func (r *memoryRepositories) GetAccount(ctx context.Context, id string) (services.AccountRecord, error) {
+	account, ok := r.data.accounts[id]
+	if !ok {
+		return services.AccountRecord{}, services.ErrNotFound
+	}
+	return account, nil
+}
+
+func (r *memoryRepositories) GetAccountByEmail(ctx context.Context, email string) (services.AccountRecord, error) {
+	id, ok := r.data.emails[email]
+	if !ok {
+		return services.AccountRecord{}, services.ErrNotFound
+	}
+	return r.data.accounts[id], nil
+}
+
+func (r *memoryRepositories) ApplyEntry(ctx context.Context, entry services.LedgerEntry) error {
+	balances, ok := r.data.balances[entry.UserID]
+	if !ok {
+		balances = make(map[services.Asset]services.Money)
+		r.data.balances[entry.UserID] = balances
+	}
+	previous, held := balances[entry.Asset]
+	count := len(r.data.ledger)
+	r.logUndo(func() {
+		r.data.ledger = r.data.ledger[:count]
+		switch {
+		case !ok:
+			delete(r.data.balances, entry.UserID)
+		case held:
+			balances[entry.Asset] = previous
+		default:
+			delete(balances, entry.Asset)
+		}
+	})
+	balances[entry.Asset] += entry.Amount
+	r.data.ledger = append(r.data.ledger, entry)
+	return nil
+}
+
+func (r *memoryRepositories) GetBalances(ctx context.Context, userID string) (map[services.Asset]services.Money, error) {
+	balances := make(map[services.Asset]services.Money)
+	for asset, amount := range r.data.balances[userID] {
+		balances[asset] = amount
+	}
+	return balances, nil
+}
+
+func (r *memoryRepositories) ListLedger(ctx context.Context, userID string) ([]services.LedgerEntry, error) {
+	var entries []services.LedgerEntry
+	for _, entry := range r.data.ledger {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r *memoryRepositories) SaveCandle(ctx context.Context, candle services.Candle) error {
+	key := candleKey{candle.Market, candle.Interval}
+	candles, ok := r.data.candles[key]
+	if !ok {
+		candles = make(map[int64]services.Candle)
+		r.data.candles[key] = candles
+	}
+	openTime := candle.OpenTime.UnixNano()
+	previous, existed := candles[openTime]
+	r.logUndo(func() {
+		if existed {
+			candles[openTime] = previous
+		} else {
+			delete(candles, openTime)
+		}
+	})
+	candles[openTime] = candle
+	return nil
+}
+
+func (r *memoryRepositories) ListCandles(ctx context.Context, market services.Market, interval services.CandleInterval, from, to time.Time) ([]services.Candle, error) {
+	var candles []services.Candle
+	for _, candle := range r.data.candles[candleKey{market, interval}] {
+		if !candle.OpenTime.Before(from) && candle.OpenTime.Before(to) {
+			candles = append(candles, candle)
+		}
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].OpenTime.Before(candles[j].OpenTime) })
+	return candles, nil
+}
+
+func (r *memoryRepositories) LastCandleBefore(ctx context.Context, market services.Market, interval services.CandleInterval, before time.Time) (services.Candle, error) {
+	var (
+		last  services.Candle
+		found bool
+	)
+	for _, candle := range r.data.candles[candleKey{market, interval}] {
+		if candle.OpenTime.Before(before) && (!found || candle.OpenTime.After(last.OpenTime)) {
+			last, found = candle, true
+		}
+	}
+	if !found {
+		return last, services.ErrNotFound
+	}
+	return last, nil
+}
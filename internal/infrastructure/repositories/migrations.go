@@ -0,0 +1,74 @@
+package repositories
+
+// sqlMigrations are applied in order; the index + 1 is the schema version.
+// Never edit a released migration, append a new one instead.
+var sqlMigrations = []string{
+	// 1: orders, trades and accounts.
+	`CREATE TABLE orders (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		market     TEXT NOT NULL,
+		bid        INTEGER NOT NULL,
+		price      REAL NOT NULL,
+		size       REAL NOT NULL,
+		remaining  REAL NOT NULL,
+		status     TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX orders_by_user ON orders (user_id, created_at);
+
+	CREATE TABLE trades (
+		id            TEXT PRIMARY KEY,
+		market        TEXT NOT NULL,
+		price         REAL NOT NULL,
+		size          REAL NOT NULL,
+		buy_order_id  TEXT NOT NULL,
+		sell_order_id TEXT NOT NULL,
+		buyer_id      TEXT NOT NULL,
+		seller_id     TEXT NOT NULL,
+		executed_at   INTEGER NOT NULL
+	);
+	CREATE INDEX trades_by_market ON trades (market, executed_at);
+
+	CREATE TABLE accounts (
+		id            TEXT PRIMARY KEY,
+		email         TEXT NOT NULL UNIQUE,
+		password_hash BLOB NOT NULL,
+		totp_secret   TEXT NOT NULL DEFAULT '',
+		created_at    INTEGER NOT NULL
+	);`,
+
+	// 2: ledger and balances.
+	`CREATE TABLE ledger_entries (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		asset      TEXT NOT NULL,
+		amount     REAL NOT NULL,
+		reference  TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX ledger_by_user ON ledger_entries (user_id, created_at);
+
+	CREATE TABLE balances (
+		user_id TEXT NOT NULL,
+		asset   TEXT NOT NULL,
+		amount  REAL NOT NULL,
+		PRIMARY KEY (user_id, asset)
+	);`,
+
+	// 3: candles.
+	`CREATE TABLE candles (
+		market       TEXT NOT NULL,
+		interval     TEXT NOT NULL,
+		open_time    INTEGER NOT NULL,
+		open         REAL NOT NULL,
+		high         REAL NOT NULL,
+		low          REAL NOT NULL,
+		close        REAL NOT NULL,
+		volume       REAL NOT NULL,
+		quote_volume REAL NOT NULL,
+		trades       INTEGER NOT NULL,
+		PRIMARY KEY (market, interval, open_time)
+	);`,
+}
@@ -0,0 +1,354 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver.
+)
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SQLStore is a services.Store backed by an embedded SQLite database.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (or creates) the SQLite database at path and migrates it.
+// Use ":memory:" for a throwaway database.
+func OpenSQLite(ctx context.Context, path string) (*SQLStore, error) {
+	dsn := path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection also keeps ":memory:" databases shared.
+	db.SetMaxOpenConns(1)
+
+	store := &SQLStore{db: db}
+	if err := store.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies pending migrations, each inside its own transaction.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqlMigrations); version++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, sqlMigrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, version+1)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// InTransaction runs fn against repositories bound to a single SQL transaction.
+func (s *SQLStore) InTransaction(ctx context.Context, fn func(tx services.Repositories) error) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return fn(&sqlRepositories{exec: tx})
+	})
+}
+
+// autoCommit returns repositories running each call outside of any explicit transaction.
+func (s *SQLStore) autoCommit() *sqlRepositories {
+	return &sqlRepositories{exec: s.db, store: s}
+}
+
+func (s *SQLStore) Orders() services.OrderRepository     { return s.autoCommit() }
+func (s *SQLStore) Trades() services.TradeRepository     { return s.autoCommit() }
+func (s *SQLStore) Accounts() services.AccountRepository { return s.autoCommit() }
+func (s *SQLStore) Balances() services.BalanceRepository { return s.autoCommit() }
+func (s *SQLStore) Candles() services.CandleRepository   { return s.autoCommit() }
+
+// sqlRepositories implements every repository on top of a database or a transaction.
+type sqlRepositories struct {
+	exec sqlExecutor
+	// store is set when running outside a transaction, for writes spanning several statements.
+	store *SQLStore
+}
+
+func (r *sqlRepositories) Orders() services.OrderRepository     { return r }
+func (r *sqlRepositories) Trades() services.TradeRepository     { return r }
+func (r *sqlRepositories) Accounts() services.AccountRepository { return r }
+func (r *sqlRepositories) Balances() services.BalanceRepository { return r }
+func (r *sqlRepositories) Candles() services.CandleRepository   { return r }
+
+func (r *sqlRepositories) SaveOrder(ctx context.Context, order services.OrderRecord) error {
+	_, err := r.exec.ExecContext(ctx, `
+		INSERT INTO orders (id, user_id, market, bid, price, size, remaining, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			price = excluded.price,
+			size = excluded.size,
+			remaining = excluded.remaining,
+			status = excluded.status,
+			updated_at = excluded.updated_at`,
+		order.ID, order.UserID, string(order.Market), order.Bid, float64(order.Price), float64(order.Size),
+		float64(order.Remaining), string(order.Status), order.CreatedAt, order.UpdatedAt)
+	return err
+}
+
+const orderColumns = `id, user_id, market, bid, price, size, remaining, status, created_at, updated_at`
+
+func scanOrder(row interface{ Scan(...any) error }) (services.OrderRecord, error) {
+	var order services.OrderRecord
+	err := row.Scan(&order.ID, &order.UserID, &order.Market, &order.Bid, &order.Price, &order.Size,
+		&order.Remaining, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, services.ErrNotFound
+	}
+	return order, err
+}
+
+func (r *sqlRepositories) GetOrder(ctx context.Context, id string) (services.OrderRecord, error) {
+	return scanOrder(r.exec.QueryRowContext(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = ?`, id))
+}
+
+func (r *sqlRepositories) ListOrdersByUser(ctx context.Context, userID string) ([]services.OrderRecord, error) {
+	rows, err := r.exec.QueryContext(ctx,
+		`SELECT `+orderColumns+` FROM orders WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []services.OrderRecord
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+func (r *sqlRepositories) SaveTrade(ctx context.Context, trade services.Trade) error {
+	_, err := r.exec.ExecContext(ctx, `
+		INSERT INTO trades (id, market, price, size, buy_order_id, sell_order_id, buyer_id, seller_id, executed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		trade.ID, string(trade.Market), float64(trade.Price), float64(trade.Size), trade.BuyOrderID,
+		trade.SellOrderID, trade.BuyerID, trade.SellerID, trade.ExecutedAt)
+	return err
+}
+
+func (r *sqlRepositories) ListTrades(ctx context.Context, market services.Market, from, to int64) ([]services.Trade, error) {
+	rows, err := r.exec.QueryContext(ctx, `
+		SELECT id, market, price, size, buy_order_id, sell_order_id, buyer_id, seller_id, executed_at
+		FROM trades WHERE market = ? AND executed_at >= ? AND executed_at < ?
+		ORDER BY executed_at, rowid`, string(market), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []services.Trade
+	for rows.Next() {
+		var trade services.Trade
+		if err := rows.Scan(&trade.ID, &trade.Market, &trade.Price, &trade.Size, &trade.BuyOrderID,
+			&trade.SellOrderID, &trade.BuyerID, &trade.SellerID, &trade.ExecutedAt); err != nil {
+			return nil, err
+		}
+		trades = append(trades, trade)
+	}
+	return trades, rows.Err()
+}
+
+func (r *sqlRepositories) SaveAccount(ctx context.Context, account services.AccountRecord) error {
+	_, err := r.exec.ExecContext(ctx, `
+		INSERT INTO accounts (id, email, password_hash, totp_secret, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			email = excluded.email,
+			password_hash = excluded.password_hash,
+			totp_secret = excluded.totp_secret`,
+		account.ID, account.Email, account.PasswordHash, account.TOTPSecret, account.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: accounts.email") {
+		return services.ErrEmailTaken
+	}
+	return err
+}
+
+func (r *sqlRepositories) getAccountWhere(ctx context.Context, column, value string) (services.AccountRecord, error) {
+	var account services.AccountRecord
+	err := r.exec.QueryRowContext(ctx, `
+		SELECT id, email, password_hash, totp_secret, created_at FROM accounts WHERE `+column+` = ?`, value).
+		Scan(&account.ID, &account.Email, &account.PasswordHash, &account.TOTPSecret, &account.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, services.ErrNotFound
+	}
+	return account, err
+}
+
+func (r *sqlRepositories) GetAccount(ctx context.Context, id string) (services.AccountRecord, error) {
+	return r.getAccountWhere(ctx, "id", id)
+}
+
+func (r *sqlRepositories) GetAccountByEmail(ctx context.Context, email string) (services.AccountRecord, error) {
+	return r.getAccountWhere(ctx, "email", email)
+}
+
+func (r *sqlRepositories) ApplyEntry(ctx context.Context, entry services.LedgerEntry) error {
+	// The ledger row and the balance update must land together.
+	if r.store != nil {
+		return r.store.InTransaction(ctx, func(tx services.Repositories) error {
+			return tx.Balances().ApplyEntry(ctx, entry)
+		})
+	}
+	if _, err := r.exec.ExecContext(ctx, `
+		INSERT INTO ledger_entries (id, user_id, asset, amount, reference, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.UserID, string(entry.Asset), float64(entry.Amount), entry.Reference, entry.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.exec.ExecContext(ctx, `
+		INSERT INTO balances (user_id, asset, amount) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, asset) DO UPDATE SET amount = amount + excluded.amount`,
+		entry.UserID, string(entry.Asset), float64(entry.Amount))
+	return err
+}
+
+func (r *sqlRepositories) GetBalances(ctx context.Context, userID string) (map[services.Asset]services.Money, error) {
+	rows, err := r.exec.QueryContext(ctx, `SELECT asset, amount FROM balances WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[services.Asset]services.Money)
+	for rows.Next() {
+		var (
+			asset  services.Asset
+			amount services.Money
+		)
+		if err := rows.Scan(&asset, &amount); err != nil {
+			return nil, err
+		}
+		balances[asset] = amount
+	}
+	return balances, rows.Err()
+}
+
+func (r *sqlRepositories) ListLedger(ctx context.Context, userID string) ([]services.LedgerEntry, error) {
+	rows, err := r.exec.QueryContext(ctx, `
+		SELECT id, user_id, asset, amount, reference, created_at
+		FROM ledger_entries WHERE user_id = ? ORDER BY created_at, rowid`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []services.LedgerEntry
+	for rows.Next() {
+		var entry services.LedgerEntry
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Asset, &entry.Amount, &entry.Reference, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (r *sqlRepositories) SaveCandle(ctx context.Context, candle services.Candle) error {
+	_, err := r.exec.ExecContext(ctx, `
+		INSERT INTO candles (market, interval, open_time, open, high, low, close, volume, quote_volume, trades)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (market, interval, open_time) DO UPDATE SET
+			high = excluded.high,
+			low = excluded.low,
+			close = excluded.close,
+			volume = excluded.volume,
+			quote_volume = excluded.quote_volume,
+			trades = excluded.trades`,
+		string(candle.Market), string(candle.Interval), candle.OpenTime.UnixNano(), float64(candle.Open),
+		float64(candle.High), float64(candle.Low), float64(candle.Close), float64(candle.Volume),
+		float64(candle.QuoteVolume), candle.Trades)
+	return err
+}
+
+const candleColumns = `market, interval, open_time, open, high, low, close, volume, quote_volume, trades`
+
+func scanCandle(row interface{ Scan(...any) error }) (services.Candle, error) {
+	var (
+		candle   services.Candle
+		openTime int64
+	)
+	err := row.Scan(&candle.Market, &candle.Interval, &openTime, &candle.Open, &candle.High, &candle.Low,
+		&candle.Close, &candle.Volume, &candle.QuoteVolume, &candle.Trades)
+	if errors.Is(err, sql.ErrNoRows) {
+		return candle, services.ErrNotFound
+	}
+	candle.OpenTime = time.Unix(0, openTime).UTC()
+	return candle, err
+}
+
+func (r *sqlRepositories) ListCandles(ctx context.Context, market services.Market, interval services.CandleInterval, from, to time.Time) ([]services.Candle, error) {
+	rows, err := r.exec.QueryContext(ctx, `
+		SELECT `+candleColumns+` FROM candles
+		WHERE market = ? AND interval = ? AND open_time >= ? AND open_time < ?
+		ORDER BY open_time`, string(market), string(interval), from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []services.Candle
+	for rows.Next() {
+		candle, err := scanCandle(rows)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, rows.Err()
+}
+
+func (r *sqlRepositories) LastCandleBefore(ctx context.Context, market services.Market, interval services.CandleInterval, before time.Time) (services.Candle, error) {
+	return scanCandle(r.exec.QueryRowContext(ctx, `
+		SELECT `+candleColumns+` FROM candles
+		WHERE market = ? AND interval = ? AND open_time < ?
+		ORDER BY open_time DESC LIMIT 1`, string(market), string(interval), before.UnixNano()))
+}
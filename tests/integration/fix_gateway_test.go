@@ -0,0 +1,372 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/fix"
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// Assert function replaces the testify framework for me.
+func Assert(t *testing.T, firstParam, secondParam any) {
+	t.Helper()
+	if !reflect.DeepEqual(firstParam, secondParam) {
+		t.Errorf("%+v != %+v", firstParam, secondParam)
+	}
+}
+
+const (
+	gatewayCompID = "CRYPTEX"
+	clientCompID  = "FUND"
+	clientUserID  = "fund"
+)
+
+// gateway runs an acceptor with one session for the FUND counterparty until it is stopped, or
+// the test ends.
+func gateway(t *testing.T, exchange *services.CryptoExchangeService, store fix.MessageStore) (*fix.Acceptor, *fix.Session, func()) {
+	t.Helper()
+	acceptor := fix.NewAcceptor(gatewayCompID, exchange)
+	session, err := acceptor.AddSession(fix.SessionConfig{CompID: clientCompID, UserID: clientUserID}, store)
+	Assert(t, err, nil)
+	Assert(t, acceptor.Listen("127.0.0.1:0"), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- acceptor.Serve(ctx) }()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			Assert(t, <-stopped, nil)
+		})
+	}
+	t.Cleanup(stop)
+	return acceptor, session, stop
+}
+
+// initiator is the client end of a FIX session, driven by hand so tests can break the rules.
+type initiator struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    int // MsgSeqNum of the next message sent.
+}
+
+func dial(t *testing.T, acceptor *fix.Acceptor, seq int) *initiator {
+	t.Helper()
+	conn, err := net.Dial("tcp", acceptor.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &initiator{t: t, conn: conn, reader: bufio.NewReader(conn), seq: seq}
+}
+
+// send stamps the header, numbering the message unless it already has a MsgSeqNum.
+func (i *initiator) send(m *fix.Message) {
+	i.t.Helper()
+	if _, numbered := m.Lookup(fix.TagMsgSeqNum); !numbered {
+		m.SetInt(fix.TagMsgSeqNum, i.seq)
+		i.seq++
+	}
+	m.Set(fix.TagSenderCompID, clientCompID).Set(fix.TagTargetCompID, gatewayCompID).SetTime(fix.TagSendingTime, time.Now())
+	if _, err := i.conn.Write(m.Bytes()); err != nil {
+		i.t.Fatal(err)
+	}
+}
+
+func (i *initiator) receive() (*fix.Message, error) {
+	i.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return fix.ReadMessage(i.reader)
+}
+
+// expect reads the next message, which must be of the type.
+func (i *initiator) expect(msgType string) *fix.Message {
+	i.t.Helper()
+	m, err := i.receive()
+	if err != nil {
+		i.t.Fatalf("waiting for %s: %v", msgType, err)
+	}
+	if m.Type() != msgType {
+		i.t.Fatalf("expected %s, received %s", msgType, m)
+	}
+	return m
+}
+
+func (i *initiator) logon(heartBtInt int, reset bool) *fix.Message {
+	i.t.Helper()
+	logon := fix.NewMessage(fix.MsgLogon).Set(fix.TagEncryptMethod, "0").SetInt(fix.TagHeartBtInt, heartBtInt)
+	if reset {
+		logon.Set(fix.TagResetSeqNumFlag, "Y")
+	}
+	i.send(logon)
+	return i.expect(fix.MsgLogon)
+}
+
+func newOrderSingle(clOrdID, side, ordType string, qty, price float64) *fix.Message {
+	m := fix.NewMessage(fix.MsgNewOrderSingle).Set(fix.TagClOrdID, clOrdID).Set(fix.TagSymbol, "ETH").Set(fix.TagSide, side).
+		SetDecimal(fix.TagOrderQty, qty).Set(fix.TagOrdType, ordType).SetTime(fix.TagTransactTime, time.Now())
+	if ordType == fix.OrdTypeLimit {
+		m.SetDecimal(fix.TagPrice, price)
+	}
+	return m
+}
+
+// report reads an execution report and returns the fields the tests look at.
+func (i *initiator) report() map[int]string {
+	i.t.Helper()
+	return reportFields(i.expect(fix.MsgExecutionReport))
+}
+
+func reportFields(m *fix.Message) map[int]string {
+	fields := make(map[int]string)
+	for _, tag := range []int{fix.TagClOrdID, fix.TagOrigClOrdID, fix.TagExecType, fix.TagOrdStatus, fix.TagOrderQty,
+		fix.TagPrice, fix.TagLeavesQty, fix.TagCumQty, fix.TagAvgPx, fix.TagLastQty, fix.TagLastPx, fix.TagOrdRejReason} {
+		if value, ok := m.Lookup(tag); ok {
+			fields[tag] = value
+		}
+	}
+	return fields
+}
+
+func takeAsks(t *testing.T, exchange *services.CryptoExchangeService, size services.Money) {
+	t.Helper()
+	taker := services.NewOrder(true, size)
+	taker.UserID = "taker"
+	if _, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, taker); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixOrderLifecycle(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	acceptor, _, _ := gateway(t, exchange, fix.NewMemoryStore())
+	client := dial(t, acceptor, 1)
+	logon := client.logon(30, true)
+	Assert(t, logon.Get(fix.TagMsgSeqNum), "1")
+	Assert(t, logon.Get(fix.TagHeartBtInt), "30")
+
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 2, 101))
+	accepted := client.expect(fix.MsgExecutionReport)
+	orderID := accepted.Get(fix.TagOrderID)
+	Assert(t, reportFields(accepted), map[int]string{fix.TagClOrdID: "sell-1", fix.TagExecType: fix.ExecTypeNew, fix.TagOrdStatus: fix.OrdStatusNew,
+		fix.TagOrderQty: "2", fix.TagPrice: "101", fix.TagLeavesQty: "2", fix.TagCumQty: "0", fix.TagAvgPx: "0"})
+
+	// Someone else's market order fills part of it.
+	takeAsks(t, exchange, 0.5)
+	Assert(t, client.report(), map[int]string{fix.TagClOrdID: "sell-1", fix.TagExecType: fix.ExecTypeTrade, fix.TagOrdStatus: fix.OrdStatusPartiallyFilled,
+		fix.TagOrderQty: "2", fix.TagPrice: "101", fix.TagLeavesQty: "1.5", fix.TagCumQty: "0.5", fix.TagAvgPx: "101",
+		fix.TagLastQty: "0.5", fix.TagLastPx: "101"})
+
+	// OrderQty is the size of the whole order: 3 leaves 2.5 to fill.
+	replace := fix.NewMessage(fix.MsgOrderCancelReplaceRequest).Set(fix.TagOrigClOrdID, "sell-1").Set(fix.TagClOrdID, "sell-2").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell).SetDecimal(fix.TagOrderQty, 3).Set(fix.TagOrdType, fix.OrdTypeLimit).
+		SetDecimal(fix.TagPrice, 102).SetTime(fix.TagTransactTime, time.Now())
+	client.send(replace)
+	Assert(t, client.report(), map[int]string{fix.TagClOrdID: "sell-2", fix.TagOrigClOrdID: "sell-1", fix.TagExecType: fix.ExecTypeReplaced,
+		fix.TagOrdStatus: fix.OrdStatusPartiallyFilled, fix.TagOrderQty: "3", fix.TagPrice: "102", fix.TagLeavesQty: "2.5",
+		fix.TagCumQty: "0.5", fix.TagAvgPx: "101"})
+	state, _ := exchange.Orders.Get(orderID)
+	Assert(t, state.Price, services.Money(102))
+
+	cancel := fix.NewMessage(fix.MsgOrderCancelRequest).Set(fix.TagOrigClOrdID, "sell-2").Set(fix.TagClOrdID, "sell-3").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell).SetTime(fix.TagTransactTime, time.Now())
+	client.send(cancel)
+	Assert(t, client.report(), map[int]string{fix.TagClOrdID: "sell-3", fix.TagOrigClOrdID: "sell-2", fix.TagExecType: fix.ExecTypeCanceled,
+		fix.TagOrdStatus: fix.OrdStatusCanceled, fix.TagOrderQty: "3", fix.TagPrice: "102", fix.TagLeavesQty: "0",
+		fix.TagCumQty: "0.5", fix.TagAvgPx: "101"})
+
+	// Cancelling it again is too late, and ClOrdIDs cannot be reused.
+	cancel = fix.NewMessage(fix.MsgOrderCancelRequest).Set(fix.TagOrigClOrdID, "sell-3").Set(fix.TagClOrdID, "sell-4").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell)
+	client.send(cancel)
+	reject := client.expect(fix.MsgOrderCancelReject)
+	Assert(t, []string{reject.Get(fix.TagCxlRejReason), reject.Get(fix.TagOrdStatus), reject.Get(fix.TagCxlRejResponseTo)},
+		[]string{"0", fix.OrdStatusCanceled, "1"})
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 1, 101))
+	Assert(t, client.report()[fix.TagOrdRejReason], "6")
+
+	// Orders the book refuses are reported as rejected.
+	client.send(newOrderSingle("buy-1", fix.SideBuy, fix.OrdTypeMarket, 1, 0))
+	Assert(t, client.report(), map[int]string{fix.TagClOrdID: "buy-1", fix.TagExecType: fix.ExecTypeRejected, fix.TagOrdStatus: fix.OrdStatusRejected,
+		fix.TagOrderQty: "1", fix.TagLeavesQty: "0", fix.TagCumQty: "0", fix.TagAvgPx: "0", fix.TagOrdRejReason: "99"})
+	unknown := newOrderSingle("doge-1", fix.SideBuy, fix.OrdTypeLimit, 1, 1).Set(fix.TagSymbol, "DOGE")
+	client.send(unknown)
+	Assert(t, client.report()[fix.TagOrdRejReason], "1")
+}
+
+func TestFixSessionKeepsTheLineAlive(t *testing.T) {
+	acceptor, session, _ := gateway(t, services.NewCryptoExchangeService(), fix.NewMemoryStore())
+	client := dial(t, acceptor, 1)
+	client.logon(1, true)
+
+	client.send(fix.NewMessage(fix.MsgTestRequest).Set(fix.TagTestReqID, "ping"))
+	Assert(t, client.expect(fix.MsgHeartbeat).Get(fix.TagTestReqID), "ping")
+
+	// A required field missing is a session reject naming it; an unknown message type is a
+	// business reject.
+	client.send(fix.NewMessage(fix.MsgOrderCancelRequest).Set(fix.TagClOrdID, "c1"))
+	reject := client.expect(fix.MsgReject)
+	Assert(t, []string{reject.Get(fix.TagRefSeqNum), reject.Get(fix.TagRefTagID), reject.Get(fix.TagSessionRejectReason)},
+		[]string{"3", "41", "1"})
+	client.send(fix.NewMessage("AE"))
+	Assert(t, client.expect(fix.MsgBusinessMessageReject).Get(fix.TagRefMsgType), "AE")
+
+	// A quiet line gets heartbeats and a test request, and is dropped when one goes unanswered.
+	until := func(msgType string) *fix.Message {
+		for {
+			m, err := client.receive()
+			if err != nil {
+				t.Fatalf("waiting for %s: %v", msgType, err)
+			}
+			if m.Type() == msgType {
+				return m
+			}
+			if m.Type() != fix.MsgHeartbeat && m.Type() != fix.MsgTestRequest {
+				t.Fatalf("waiting for %s, received %s", msgType, m)
+			}
+		}
+	}
+	testReqID := until(fix.MsgTestRequest).Get(fix.TagTestReqID)
+	client.send(fix.NewMessage(fix.MsgHeartbeat).Set(fix.TagTestReqID, testReqID))
+	Assert(t, session.LoggedOn(), true)
+	until(fix.MsgLogout)
+	_, err := client.receive()
+	Assert(t, err != nil, true)
+	Assert(t, session.LoggedOn(), false)
+}
+
+func TestFixSessionResendsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	exchange := services.NewCryptoExchangeService()
+	store, err := fix.OpenFileStore(dir, "CRYPTEX-FUND")
+	Assert(t, err, nil)
+	acceptor, session, stop := gateway(t, exchange, store)
+	client := dial(t, acceptor, 1)
+	client.logon(30, true)
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 1, 101))
+	Assert(t, client.expect(fix.MsgExecutionReport).Get(fix.TagMsgSeqNum), "2")
+	client.send(fix.NewMessage(fix.MsgLogout))
+	client.expect(fix.MsgLogout)
+	for session.LoggedOn() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The fill while the client is away is still numbered and stored.
+	takeAsks(t, exchange, 1)
+	for store.NextSenderSeq() != 5 {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	Assert(t, store.Close(), nil)
+
+	// A new gateway process picks up the sequence numbers and messages from disk.
+	store, err = fix.OpenFileStore(dir, "CRYPTEX-FUND")
+	Assert(t, err, nil)
+	Assert(t, []int{store.NextSenderSeq(), store.NextTargetSeq()}, []int{5, 4})
+	t.Cleanup(func() { store.Close() })
+	acceptor, _, _ = gateway(t, exchange, store)
+	client = dial(t, acceptor, client.seq)
+	Assert(t, client.logon(30, false).Get(fix.TagMsgSeqNum), "5")
+
+	// The client asks for everything since the order: the reports are resent, and the session
+	// messages around them are gap filled.
+	client.send(fix.NewMessage(fix.MsgResendRequest).SetInt(fix.TagBeginSeqNo, 2).SetInt(fix.TagEndSeqNo, 0))
+	resent := client.expect(fix.MsgExecutionReport)
+	Assert(t, []string{resent.Get(fix.TagMsgSeqNum), resent.Get(fix.TagPossDupFlag), resent.Get(fix.TagExecType)}, []string{"2", "Y", fix.ExecTypeNew})
+	if _, ok := resent.Lookup(fix.TagOrigSendingTime); !ok {
+		t.Fatal("resent message lacks OrigSendingTime")
+	}
+	gapFill := client.expect(fix.MsgSequenceReset)
+	Assert(t, []string{gapFill.Get(fix.TagMsgSeqNum), gapFill.Get(fix.TagGapFillFlag), gapFill.Get(fix.TagNewSeqNo)}, []string{"3", "Y", "4"})
+	resent = client.expect(fix.MsgExecutionReport)
+	Assert(t, []string{resent.Get(fix.TagMsgSeqNum), resent.Get(fix.TagExecType), resent.Get(fix.TagOrdStatus)},
+		[]string{"4", fix.ExecTypeTrade, fix.OrdStatusFilled})
+	gapFill = client.expect(fix.MsgSequenceReset)
+	Assert(t, []string{gapFill.Get(fix.TagMsgSeqNum), gapFill.Get(fix.TagNewSeqNo)}, []string{"5", "6"})
+}
+
+func TestFixSessionKeepsOrdersAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	exchange := services.NewCryptoExchangeService()
+	store, err := fix.OpenFileStore(dir, "CRYPTEX-FUND")
+	Assert(t, err, nil)
+	acceptor, session, stop := gateway(t, exchange, store)
+	client := dial(t, acceptor, 1)
+	client.logon(30, true)
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 2, 101))
+	client.report()
+	takeAsks(t, exchange, 0.5)
+	client.report()
+	replace := fix.NewMessage(fix.MsgOrderCancelReplaceRequest).Set(fix.TagOrigClOrdID, "sell-1").Set(fix.TagClOrdID, "sell-2").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell).SetDecimal(fix.TagOrderQty, 3).Set(fix.TagOrdType, fix.OrdTypeLimit).
+		SetDecimal(fix.TagPrice, 102).SetTime(fix.TagTransactTime, time.Now())
+	client.send(replace)
+	Assert(t, client.report()[fix.TagExecType], fix.ExecTypeReplaced)
+	client.send(fix.NewMessage(fix.MsgLogout))
+	client.expect(fix.MsgLogout)
+	for session.LoggedOn() {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	Assert(t, store.Close(), nil)
+
+	// A new gateway process rebuilds the session's orders from the stored reports.
+	store, err = fix.OpenFileStore(dir, "CRYPTEX-FUND")
+	Assert(t, err, nil)
+	t.Cleanup(func() { store.Close() })
+	acceptor, _, _ = gateway(t, exchange, store)
+	client = dial(t, acceptor, client.seq)
+	client.logon(30, false)
+
+	// ClOrdIDs used before the restart are still taken.
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 1, 101))
+	Assert(t, client.report()[fix.TagOrdRejReason], "6")
+	client.send(newOrderSingle("sell-2", fix.SideSell, fix.OrdTypeLimit, 1, 101))
+	Assert(t, client.report()[fix.TagOrdRejReason], "6")
+
+	// Only the latest ClOrdID of the order can cancel it, and the report carries its fills.
+	cancel := fix.NewMessage(fix.MsgOrderCancelRequest).Set(fix.TagOrigClOrdID, "sell-1").Set(fix.TagClOrdID, "sell-3").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell).SetTime(fix.TagTransactTime, time.Now())
+	client.send(cancel)
+	Assert(t, client.expect(fix.MsgOrderCancelReject).Get(fix.TagCxlRejReason), "1")
+	cancel = fix.NewMessage(fix.MsgOrderCancelRequest).Set(fix.TagOrigClOrdID, "sell-2").Set(fix.TagClOrdID, "sell-4").
+		Set(fix.TagSymbol, "ETH").Set(fix.TagSide, fix.SideSell).SetTime(fix.TagTransactTime, time.Now())
+	client.send(cancel)
+	Assert(t, client.report(), map[int]string{fix.TagClOrdID: "sell-4", fix.TagOrigClOrdID: "sell-2", fix.TagExecType: fix.ExecTypeCanceled,
+		fix.TagOrdStatus: fix.OrdStatusCanceled, fix.TagOrderQty: "3", fix.TagPrice: "102", fix.TagLeavesQty: "0",
+		fix.TagCumQty: "0.5", fix.TagAvgPx: "101"})
+}
+
+func TestFixSessionRecoversFromGaps(t *testing.T) {
+	acceptor, session, _ := gateway(t, services.NewCryptoExchangeService(), fix.NewMemoryStore())
+	client := dial(t, acceptor, 1)
+	client.logon(30, true)
+
+	// Message 2 is lost: 3 is dropped and everything from 2 on asked for again.
+	client.seq = 3
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 1, 101))
+	request := client.expect(fix.MsgResendRequest)
+	Assert(t, []string{request.Get(fix.TagBeginSeqNo), request.Get(fix.TagEndSeqNo)}, []string{"2", "0"})
+
+	// 2 was a heartbeat, so it is gap filled; 3 goes again as a possible duplicate.
+	client.send(fix.NewMessage(fix.MsgSequenceReset).SetInt(fix.TagMsgSeqNum, 2).Set(fix.TagPossDupFlag, "Y").
+		Set(fix.TagGapFillFlag, "Y").SetInt(fix.TagNewSeqNo, 3))
+	client.send(newOrderSingle("sell-1", fix.SideSell, fix.OrdTypeLimit, 1, 101).SetInt(fix.TagMsgSeqNum, 3).Set(fix.TagPossDupFlag, "Y"))
+	Assert(t, client.report()[fix.TagExecType], fix.ExecTypeNew)
+
+	// A number already seen without PossDupFlag ends the session.
+	client.send(fix.NewMessage(fix.MsgHeartbeat).SetInt(fix.TagMsgSeqNum, 3))
+	logout := client.expect(fix.MsgLogout)
+	Assert(t, logout.Get(fix.TagText), "MsgSeqNum too low, expecting 4 but received 3")
+	_, err := client.receive()
+	Assert(t, err != nil, true)
+	for session.LoggedOn() {
+		time.Sleep(time.Millisecond)
+	}
+}
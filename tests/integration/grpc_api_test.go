@@ -0,0 +1,269 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/grpcapi"
+	"github.com/theghostmac/cryptex/internal/app/grpcapi/cryptexv1"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/web/middlewares"
+	"github.com/theghostmac/cryptex/web/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// exchangeServers serves HTTP and gRPC on the exchange under the rate limits, as main does, until
+// the test ends. It returns a gRPC client and a function that shuts both servers down and returns
+// Serve's error.
+func exchangeServers(t *testing.T, exchange *services.CryptoExchangeService, limits middlewares.RateLimitConfig) (cryptexv1.ExchangeClient, func() error) {
+	t.Helper()
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err, nil)
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	Assert(t, err, nil)
+	servers := &server.GracefulShutdown{
+		BaseHandler:     http.NotFoundHandler(),
+		GRPCServer:      grpcapi.NewGRPCServer(exchange, middlewares.NewRateLimiter(limits)),
+		ShutdownTimeout: time.Second,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- servers.Serve(ctx, httpListener, grpcListener) }()
+	var (
+		once    sync.Once
+		stopErr error
+	)
+	stop := func() error {
+		once.Do(func() {
+			cancel()
+			stopErr = <-stopped
+		})
+		return stopErr
+	}
+
+	conn, err := grpc.Dial(grpcListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	Assert(t, err, nil)
+	t.Cleanup(func() {
+		conn.Close()
+		stop()
+	})
+	return cryptexv1.NewExchangeClient(conn), stop
+}
+
+// session registers a funded user and returns a context carrying their session token.
+func session(t *testing.T, exchange *services.CryptoExchangeService, email string) (context.Context, *services.User) {
+	t.Helper()
+	user, err := exchange.Users.Register(email, "long enough")
+	Assert(t, err, nil)
+	for _, asset := range []services.Asset{"ETH", services.QuoteAsset} {
+		_, err = exchange.Users.Deposit(context.Background(), user.ID, asset, 1_000_000)
+		Assert(t, err, nil)
+	}
+	token, _, err := exchange.Users.Login(email, "long enough", "")
+	Assert(t, err, nil)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token), user
+}
+
+func TestGRPCOrderLifecycle(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	client, _ := exchangeServers(t, exchange, middlewares.DefaultRateLimitConfig())
+	ctx, _ := session(t, exchange, "bot@example.com")
+
+	placed, err := client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_SELL, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: 101, Size: 2})
+	Assert(t, err, nil)
+	Assert(t, placed.Status, cryptexv1.OrderStatus_ORDER_STATUS_NEW)
+
+	amended, err := client.AmendOrder(ctx, &cryptexv1.AmendOrderRequest{OrderId: placed.OrderId, Price: 102, Size: 3})
+	Assert(t, err, nil)
+	Assert(t, amended.Status, cryptexv1.OrderStatus_ORDER_STATUS_NEW)
+
+	// Someone else's market order fills part of it.
+	takeAsks(t, exchange, 1)
+
+	order, err := client.GetOrder(ctx, &cryptexv1.GetOrderRequest{OrderId: placed.OrderId})
+	Assert(t, err, nil)
+	Assert(t, order.Price, 102.0)
+	Assert(t, order.FilledSize, 1.0)
+	Assert(t, order.Status, cryptexv1.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED)
+	Assert(t, len(order.Fills), 1)
+	Assert(t, order.Fills[0].Liquidity, "maker")
+
+	_, err = client.CancelOrder(ctx, &cryptexv1.CancelOrderRequest{OrderId: placed.OrderId})
+	Assert(t, err, nil)
+	_, err = client.CancelOrder(ctx, &cryptexv1.CancelOrderRequest{OrderId: placed.OrderId})
+	Assert(t, status.Code(err), codes.NotFound)
+
+	listed, err := client.ListOrders(ctx, &cryptexv1.ListOrdersRequest{Status: "closed"})
+	Assert(t, err, nil)
+	Assert(t, len(listed.Orders), 1)
+	Assert(t, listed.Orders[0].Status, cryptexv1.OrderStatus_ORDER_STATUS_CANCELLED)
+	_, err = client.ListOrders(ctx, &cryptexv1.ListOrdersRequest{Limit: 501})
+	Assert(t, status.Code(err), codes.InvalidArgument)
+
+	// The order can't be seen or touched by anyone else.
+	other, _ := session(t, exchange, "other@example.com")
+	_, err = client.GetOrder(other, &cryptexv1.GetOrderRequest{OrderId: placed.OrderId})
+	Assert(t, status.Code(err), codes.NotFound)
+
+	// A market order that finds nothing to take is refused.
+	_, err = client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_MARKET, Size: 1})
+	Assert(t, status.Code(err), codes.InvalidArgument)
+	_, err = client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "DOGE", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: 1, Size: 1})
+	Assert(t, status.Code(err), codes.InvalidArgument)
+}
+
+func TestGRPCProtectedMarketOrders(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	client, _ := exchangeServers(t, exchange, middlewares.DefaultRateLimitConfig())
+	ctx, _ := session(t, exchange, "bot@example.com")
+	for _, price := range []float64{101, 103} {
+		_, err := client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_SELL, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: price, Size: 1})
+		Assert(t, err, nil)
+	}
+
+	// The bound stops the order at 102, and what it leaves rests there.
+	placed, err := client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_MARKET, Size: 2,
+		ProtectionPrice: 102, Remainder: cryptexv1.Remainder_REMAINDER_REST})
+	Assert(t, err, nil)
+	Assert(t, []float64{placed.FilledSize, placed.AveragePrice}, []float64{1, 101})
+	order, err := client.GetOrder(ctx, &cryptexv1.GetOrderRequest{OrderId: placed.OrderId})
+	Assert(t, err, nil)
+	Assert(t, []float64{order.Price, order.FilledSize}, []float64{102, 1})
+	Assert(t, order.Status, cryptexv1.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED)
+
+	// A notional order spends what it can, and reports the rest.
+	placed, err = client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_MARKET, Notional: 206})
+	Assert(t, err, nil)
+	Assert(t, []float64{placed.FilledSize, placed.AveragePrice, placed.UnspentNotional}, []float64{1, 103, 103})
+
+	_, err = client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_MARKET, Size: 1,
+		Remainder: cryptexv1.Remainder_REMAINDER_REST})
+	Assert(t, status.Code(err), codes.InvalidArgument)
+}
+
+func TestGRPCRateLimitsByMethodClass(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	oneCall := middlewares.ClassLimits{PerIP: middlewares.Limit{Rate: 0.001, Burst: 1}}
+	client, _ := exchangeServers(t, exchange, middlewares.RateLimitConfig{Classes: map[middlewares.RouteClass]middlewares.ClassLimits{
+		middlewares.ClassOrders: oneCall, middlewares.ClassCancels: oneCall, middlewares.ClassMarketData: oneCall,
+	}})
+	ctx, _ := session(t, exchange, "bot@example.com")
+
+	var header metadata.MD
+	placed, err := client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_SELL, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: 101, Size: 1}, grpc.Header(&header))
+	Assert(t, err, nil)
+	Assert(t, header.Get("x-ratelimit-remaining"), []string{"0"})
+	_, err = client.AmendOrder(ctx, &cryptexv1.AmendOrderRequest{OrderId: placed.OrderId, Price: 102, Size: 1}, grpc.Header(&header))
+	Assert(t, status.Code(err), codes.ResourceExhausted)
+	Assert(t, header.Get("retry-after"), []string{"1000"})
+
+	// Cancels and market data have budgets of their own.
+	_, err = client.CancelOrder(ctx, &cryptexv1.CancelOrderRequest{OrderId: placed.OrderId})
+	Assert(t, err, nil)
+	_, err = client.GetBook(context.Background(), &cryptexv1.GetBookRequest{Market: "ETH"})
+	Assert(t, err, nil)
+	books, err := client.SubscribeBook(context.Background(), &cryptexv1.SubscribeBookRequest{Market: "ETH"})
+	Assert(t, err, nil)
+	_, err = books.Recv()
+	Assert(t, status.Code(err), codes.ResourceExhausted)
+}
+
+func TestGRPCRequiresSession(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	client, _ := exchangeServers(t, exchange, middlewares.DefaultRateLimitConfig())
+
+	_, err := client.PlaceOrder(context.Background(), &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_BUY, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: 100, Size: 1})
+	Assert(t, status.Code(err), codes.Unauthenticated)
+	bogus := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer bogus")
+	_, err = client.ListOrders(bogus, &cryptexv1.ListOrdersRequest{})
+	Assert(t, status.Code(err), codes.Unauthenticated)
+
+	// Market data is public.
+	book, err := client.GetBook(context.Background(), &cryptexv1.GetBookRequest{Market: "ETH"})
+	Assert(t, err, nil)
+	Assert(t, book.Market, "ETH")
+}
+
+func TestGRPCBookAndTradeStreams(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	client, _ := exchangeServers(t, exchange, middlewares.DefaultRateLimitConfig())
+	ctx, _ := session(t, exchange, "bot@example.com")
+
+	_, err := client.PlaceOrder(ctx, &cryptexv1.PlaceOrderRequest{Market: "ETH", Side: cryptexv1.Side_SIDE_SELL, Type: cryptexv1.OrderType_ORDER_TYPE_LIMIT, Price: 101, Size: 2})
+	Assert(t, err, nil)
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscribers := exchange.Events.SubscriberCount()
+	books, err := client.SubscribeBook(streamCtx, &cryptexv1.SubscribeBookRequest{Market: "ETH"})
+	Assert(t, err, nil)
+	trades, err := client.SubscribeTrades(streamCtx, &cryptexv1.SubscribeTradesRequest{Market: "ETH"})
+	Assert(t, err, nil)
+
+	first, err := books.Recv()
+	Assert(t, err, nil)
+	snapshot := first.GetSnapshot()
+	Assert(t, len(snapshot.Asks), 1)
+	Assert(t, snapshot.Asks[0].Price, 101.0)
+	Assert(t, snapshot.Asks[0].Size, 2.0)
+	Assert(t, snapshot.Asks[0].OrderCount, int32(1))
+
+	// The trade stream has no snapshot, so wait until the server subscribed it before trading.
+	waitForSubscribers(t, exchange, subscribers+2)
+	takeAsks(t, exchange, 1)
+
+	update, err := books.Recv()
+	Assert(t, err, nil)
+	level := update.GetLevel()
+	Assert(t, level.Side, cryptexv1.Side_SIDE_SELL)
+	Assert(t, level.Price, 101.0)
+	Assert(t, level.Size, 1.0)
+	Assert(t, level.Sequence > snapshot.Sequence, true)
+
+	trade, err := trades.Recv()
+	Assert(t, err, nil)
+	Assert(t, trade.Price, 101.0)
+	Assert(t, trade.Size, 1.0)
+	Assert(t, trade.TakerSide, cryptexv1.Side_SIDE_BUY)
+
+	unknown, err := client.SubscribeTrades(streamCtx, &cryptexv1.SubscribeTradesRequest{Market: "DOGE"})
+	Assert(t, err, nil)
+	_, err = unknown.Recv()
+	Assert(t, status.Code(err), codes.InvalidArgument)
+}
+
+func TestGRPCShutsDownWithHTTP(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	client, stop := exchangeServers(t, exchange, middlewares.DefaultRateLimitConfig())
+
+	// An open subscription doesn't hold the shutdown up past its timeout.
+	books, err := client.SubscribeBook(context.Background(), &cryptexv1.SubscribeBookRequest{Market: "ETH"})
+	Assert(t, err, nil)
+	_, err = books.Recv()
+	Assert(t, err, nil)
+
+	started := time.Now()
+	Assert(t, stop(), nil)
+	Assert(t, time.Since(started) < 5*time.Second, true)
+	_, err = books.Recv()
+	Assert(t, err != nil, true)
+}
+
+func waitForSubscribers(t *testing.T, exchange *services.CryptoExchangeService, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for exchange.Events.SubscriberCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers, want %d", exchange.Events.SubscriberCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
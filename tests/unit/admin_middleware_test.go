@@ -0,0 +1,31 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
+	cases := []struct {
+		configured, given string
+		want              int
+	}{
+		{"secret", "secret", http.StatusOK},
+		{"secret", "wrong", http.StatusForbidden},
+		{"secret", "", http.StatusForbidden},
+		{"", "", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPut, "/admin/markets/ETH/state", nil)
+		if c.given != "" {
+			request.Header.Set(middlewares.AdminTokenHeader, c.given)
+		}
+		recorder := httptest.NewRecorder()
+		middlewares.RequireAdmin(c.configured)(ok).ServeHTTP(recorder, request)
+		Assert(t, recorder.Code, c.want)
+	}
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+const marketBTC services.Market = "BTC"
+
+func auctionBook(bids, asks map[services.Money]services.Money) *services.CompleteOrderBook {
+	book := services.NewOrderBook()
+	for price, size := range bids {
+		book.PlaceLimitOrder(price, services.NewOrder(true, size))
+	}
+	for price, size := range asks {
+		book.PlaceLimitOrder(price, services.NewOrder(false, size))
+	}
+	return book
+}
+
+func TestAuctionClearingPriceMaximizesVolume(t *testing.T) {
+	book := auctionBook(
+		map[services.Money]services.Money{102: 1, 101: 2, 100: 1},
+		map[services.Money]services.Money{99: 1, 100: 2, 101: 2},
+	)
+	// 100 and 101 both execute 3, 100 leaves the smaller imbalance.
+	Assert(t, book.IndicativeAuction(), services.AuctionResult{Price: 100, Volume: 3, Imbalance: 1})
+}
+
+func TestAuctionTieBreaks(t *testing.T) {
+	buyPressure := auctionBook(map[services.Money]services.Money{101: 5}, map[services.Money]services.Money{100: 2})
+	Assert(t, buyPressure.IndicativeAuction().Price, services.Money(101))
+
+	sellPressure := auctionBook(map[services.Money]services.Money{101: 2}, map[services.Money]services.Money{100: 5})
+	Assert(t, sellPressure.IndicativeAuction().Price, services.Money(100))
+
+	balanced := auctionBook(map[services.Money]services.Money{101: 2}, map[services.Money]services.Money{100: 2})
+	Assert(t, balanced.IndicativeAuction().Price, services.Money(100))
+
+	// With a last trade at 110, the balanced tie goes to the closest price.
+	referenced := services.NewOrderBook()
+	referenced.PlaceLimitOrder(110, services.NewOrder(false, 1))
+	_, err := referenced.PlaceMarketOrder(services.NewOrder(true, 1))
+	Assert(t, err, nil)
+	referenced.PlaceLimitOrder(101, services.NewOrder(true, 2))
+	referenced.PlaceLimitOrder(100, services.NewOrder(false, 2))
+	Assert(t, referenced.IndicativeAuction().Price, services.Money(101))
+
+	Assert(t, auctionBook(map[services.Money]services.Money{99: 1}, map[services.Money]services.Money{100: 1}).IndicativeAuction(), services.AuctionResult{})
+}
+
+func TestOpeningAuctionUncrosses(t *testing.T) {
+	ctx := context.Background()
+	exchange, clock := clockedExchange()
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{AuctionDuration: time.Minute}), nil)
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{}), services.ErrMarketListed)
+	events := exchange.Events.Subscribe("auction", 256, messaging.Block, func(event messaging.Event) bool {
+		return event.EventType() == services.EventIndicativePriceChanged
+	})
+
+	status, _ := exchange.MarketStatus(marketBTC)
+	Assert(t, status.State, services.MarketAuction)
+	Assert(t, status.Reason, services.StateReasonListing)
+
+	place := func(userID string, bid bool, price, size services.Money) *services.Order {
+		o := services.NewOrder(bid, size)
+		o.UserID = userID
+		Assert(t, exchange.PlaceLimitOrder(ctx, marketBTC, price, o), nil)
+		return o
+	}
+	first := place("a", true, 102, 1)
+	second := place("b", true, 101, 2)
+	last := place("c", true, 100, 1)
+	cheap := place("d", false, 99, 1)
+	place("e", false, 100, 2)
+	dear := place("f", false, 101, 2)
+
+	_, err := exchange.PlaceMarketOrder(ctx, marketBTC, services.NewOrder(true, 1))
+	Assert(t, err, services.ErrMarketInAuction)
+
+	// The latest indicative result is what the uncross will do.
+	var indicative services.IndicativePriceChanged
+	for len(events.Events()) > 0 {
+		indicative = (<-events.Events()).(services.IndicativePriceChanged)
+	}
+	Assert(t, indicative.AuctionResult, services.AuctionResult{Price: 100, Volume: 3, Imbalance: 1})
+
+	Assert(t, exchange.ResumeMarkets(ctx, clock.Advance(time.Minute-time.Nanosecond)), nil)
+	status, _ = exchange.MarketStatus(marketBTC)
+	Assert(t, status.State, services.MarketAuction)
+	Assert(t, exchange.ResumeMarkets(ctx, clock.Advance(time.Nanosecond)), nil)
+	status, _ = exchange.MarketStatus(marketBTC)
+	Assert(t, status.State, services.MarketOpen)
+
+	for _, o := range []*services.Order{first, second, cheap} {
+		state, _ := exchange.Orders.Get(o.ID)
+		Assert(t, state.Status, services.StatusFilled)
+		Assert(t, state.AveragePrice, services.Money(100))
+		Assert(t, state.Fills[0].Liquidity, services.LiquidityAuction)
+	}
+	book := exchange.OrderBooks[marketBTC]
+	_, resting := book.GetOrder(last.ID)
+	Assert(t, resting, true)
+	Assert(t, dear.Size, services.Money(2))
+	Assert(t, book.TotalVolumeOfBid(), services.Money(1))
+	Assert(t, book.TotalVolumeOfAsks(), services.Money(2))
+}
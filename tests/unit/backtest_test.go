@@ -0,0 +1,129 @@
+package unit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func backtestConfig(latency services.LatencyModel, seed int64) services.BacktestConfig {
+	return services.BacktestConfig{Market: services.MarketETH, Config: services.DefaultMarketConfigs[services.MarketETH], Latency: latency, Seed: seed}
+}
+
+func syntheticFlow(seed int64) []services.FlowEvent {
+	return services.SyntheticFlow(services.SyntheticFlowConfig{
+		Events: 500, Interval: time.Millisecond, Price: 100, VolatilityBps: 5, DepthBps: 50, MaxSize: 2,
+		CancelRate: 0.2, TradeRate: 0.2, TickSize: 0.01, LotSize: 0.0001,
+	}, seed)
+}
+
+func TestReadFlowFormats(t *testing.T) {
+	jsonl := `{"time": 2, "type": "cancel", "id": "a"}
+
+{"time": 1, "type": "place", "id": "a", "user": "u", "bid": true, "price": 99.5, "size": 2}
+{"time": 2, "type": "trade", "price": 101, "size": 1}
+`
+	csv := `time,type,id,user,bid,price,size
+2,cancel,a,,,,
+1,place,a,u,true,99.5,2
+2,trade,,,false,101,1
+`
+	want := []services.FlowEvent{
+		{Time: 1, Action: services.FlowPlace, ID: "a", UserID: "u", Bid: true, Price: 99.5, Size: 2},
+		{Time: 2, Action: services.FlowCancel, ID: "a"},
+		{Time: 2, Action: services.FlowTrade, Price: 101, Size: 1},
+	}
+
+	flow, err := services.ReadFlowJSONL(strings.NewReader(jsonl))
+	Assert(t, err, nil)
+	Assert(t, flow, want)
+	flow, err = services.ReadFlowCSV(strings.NewReader(csv))
+	Assert(t, err, nil)
+	Assert(t, flow, want)
+
+	_, err = services.ReadFlowJSONL(strings.NewReader(`{"time": 1, "type": "place", "size": 0}`))
+	Assert(t, errors.Is(err, services.ErrInvalidFlow), true)
+	_, err = services.ReadFlowCSV(strings.NewReader("time,type\n1,modify\n"))
+	Assert(t, errors.Is(err, services.ErrInvalidFlow), true)
+	_, err = services.ReadFlowCSV(strings.NewReader("type,id\ncancel,a\n"))
+	Assert(t, errors.Is(err, services.ErrInvalidFlow), true)
+}
+
+func TestBacktestBookMetrics(t *testing.T) {
+	flow := []services.FlowEvent{
+		{Time: 1, Action: services.FlowPlace, ID: "b", Bid: true, Price: 99, Size: 2},
+		{Time: 2, Action: services.FlowPlace, ID: "a", Price: 101, Size: 3},
+		{Time: 3, Action: services.FlowTrade, Bid: true, Price: 101, Size: 1},
+		{Time: 4, Action: services.FlowCancel, ID: "gone"},
+		// Not a multiple of the lot size.
+		{Time: 5, Action: services.FlowPlace, ID: "odd", Bid: true, Price: 99, Size: 0.00001},
+	}
+
+	report := services.NewBacktest(backtestConfig(services.LatencyModel{}, 1)).Run(flow)
+	Assert(t, report.Events, 5)
+	Assert(t, report.Trades, 1)
+	Assert(t, report.Volume, services.Money(1))
+	Assert(t, report.Mark, services.Money(101))
+	Assert(t, report.Rejected, 1)
+	Assert(t, report.MissedCancels, 1)
+	Assert(t, report.Book, services.BookMetrics{
+		Samples: 5, AverageSpread: 2, MaxSpread: 2, AverageTopDepth: 3.8, OneSided: 1,
+		FinalBestBid: 99, FinalBestAsk: 101,
+	})
+}
+
+func TestBacktestStrategyFillsAndPnL(t *testing.T) {
+	flow := []services.FlowEvent{
+		{Time: 0, Action: services.FlowPlace, ID: "b", Bid: true, Price: 99, Size: 5},
+		{Time: 0, Action: services.FlowPlace, ID: "a", Price: 101, Size: 5},
+		// A buyer lifts the maker's ask, then a seller hits its bid.
+		{Time: int64(time.Second), Action: services.FlowTrade, Bid: true, Price: 101, Size: 1},
+		{Time: int64(2 * time.Second), Action: services.FlowTrade, Price: 99, Size: 0.5},
+	}
+	strategy := services.SymmetricSpread{SpreadBps: 100, Size: 1, SkewBps: 20, MaxInventory: 10}
+	backtest := services.NewBacktest(backtestConfig(services.LatencyModel{Base: time.Millisecond}, 1),
+		services.Participant{UserID: "mm", Strategy: strategy})
+
+	report := backtest.Run(flow)
+	Assert(t, len(report.Participants), 1)
+	mm := report.Participants[0]
+	Assert(t, len(mm.Fills), 2)
+	Assert(t, []services.Money{mm.Fills[0].Price, mm.Fills[0].Size}, []services.Money{100.5, 1})
+	Assert(t, []bool{mm.Fills[0].Bid, mm.Fills[0].Maker}, []bool{false, true})
+	Assert(t, mm.Fills[0].Time, int64(time.Second))
+	// Short 1, the maker's quotes moved up 2 bps: its bid rests at 99.52.
+	Assert(t, []services.Money{mm.Fills[1].Price, mm.Fills[1].Size}, []services.Money{99.52, 0.5})
+	Assert(t, rounded(mm.Position.Base), services.Money(-0.5))
+	Assert(t, rounded(mm.Position.RealizedPnL), services.Money(0.49))
+	Assert(t, report.Mark, services.Money(99.52))
+	Assert(t, rounded(mm.PnL), services.Money(0.98))
+	Assert(t, mm.Stats.Rejected, 0)
+	Assert(t, report.Latency.Max, time.Millisecond)
+}
+
+func TestBacktestIsReproducible(t *testing.T) {
+	Assert(t, syntheticFlow(7), syntheticFlow(7))
+	if len(syntheticFlow(7)) != 500 || syntheticFlow(7)[499] == syntheticFlow(8)[499] {
+		t.Fatal("synthetic flow does not depend on its seed")
+	}
+
+	run := func(seed int64) services.BacktestReport {
+		strategy := services.SymmetricSpread{SpreadBps: 40, Size: 0.5, SkewBps: 10, MaxInventory: 5}
+		latency := services.LatencyModel{Base: 200 * time.Microsecond, Jitter: time.Millisecond}
+		return services.NewBacktest(backtestConfig(latency, seed), services.Participant{UserID: "mm", Strategy: strategy}).Run(syntheticFlow(7))
+	}
+	first := run(42)
+	Assert(t, run(42), first)
+	if first.Trades == 0 || len(first.Participants[0].Fills) == 0 {
+		t.Fatalf("expected the flow to trade with the maker: %+v", first)
+	}
+	if first.Latency.Max >= 1200*time.Microsecond || first.Latency.Mean < 200*time.Microsecond {
+		t.Fatalf("latencies outside the model: %+v", first.Latency)
+	}
+	if run(43).Latency == first.Latency {
+		t.Fatal("latencies do not depend on the seed")
+	}
+}
@@ -0,0 +1,118 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+func TestBatchAppliesOperationsInOrder(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	stale := placeFor(t, exchange, "maker", false, 110, 1)
+	amended := placeFor(t, exchange, "maker", false, 120, 1)
+
+	results, rolledBack, err := exchange.ExecuteBatch(ctx, services.MarketETH, "maker", []services.BatchOperation{
+		{Op: services.BatchCancel, OrderID: stale.ID},
+		{Op: services.BatchAmend, OrderID: amended.ID, Price: 115, Size: 3},
+		{Op: services.BatchPlace, Type: services.OrderTypeLimit, Bid: true, Price: 90, Size: 2},
+		{Op: services.BatchCancel, OrderID: "missing"},
+	}, false)
+	Assert(t, err, nil)
+	Assert(t, rolledBack, false)
+	Assert(t, len(results), 4)
+	Assert(t, results[0].Status, services.StatusCancelled)
+	Assert(t, results[1].Error, "")
+	Assert(t, results[2].Status, services.StatusNew)
+	Assert(t, results[3].Error, services.ErrOrderNotResting.Error())
+
+	book := exchange.OrderBooks[services.MarketETH]
+	Assert(t, book.TotalVolumeOfAsks(), services.Money(3))
+	Assert(t, book.TotalVolumeOfBid(), services.Money(2))
+	Assert(t, amended.Price, services.Money(115))
+	Assert(t, book.AskLimits[services.Money(120)] == nil, true)
+
+	state, _ := exchange.Orders.Get(amended.ID)
+	Assert(t, state.Price, services.Money(115))
+	Assert(t, state.Size, services.Money(3))
+}
+
+func TestBatchAllOrNothingRollsBack(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	events := exchange.Events.Subscribe("batch", 64, messaging.Block, nil)
+	maker := placeFor(t, exchange, "maker", false, 110, 1)
+	<-events.Events()
+	<-events.Events()
+	book := exchange.OrderBooks[services.MarketETH]
+	sequence := book.Sequence()
+
+	results, rolledBack, err := exchange.ExecuteBatch(ctx, services.MarketETH, "taker", []services.BatchOperation{
+		{Op: services.BatchPlace, Type: services.OrderTypeMarket, Bid: true, Size: 1},
+		{Op: services.BatchPlace, Type: services.OrderTypeLimit, Bid: true, Price: 90, Size: 1},
+		{Op: services.BatchPlace, Type: services.OrderTypeLimit, Bid: true, Price: 90, Size: 0},
+	}, true)
+	Assert(t, err, nil)
+	Assert(t, rolledBack, true)
+	Assert(t, results[0].Error, services.ErrBatchRolledBack.Error())
+	Assert(t, results[1].Error, services.ErrBatchRolledBack.Error())
+	Assert(t, results[2].Error, "invalid size: must be positive")
+
+	Assert(t, book.Sequence(), sequence)
+	Assert(t, maker.Size, services.Money(1))
+	Assert(t, book.TotalVolumeOfAsks(), services.Money(1))
+	Assert(t, len(book.Bids), 0)
+	_, resting := book.GetOrder(maker.ID)
+	Assert(t, resting, true)
+	select {
+	case event := <-events.Events():
+		t.Fatalf("rolled back batch published %s", event.EventType())
+	default:
+	}
+
+	state, _ := exchange.Orders.Get(maker.ID)
+	Assert(t, state.Status, services.StatusNew)
+}
+
+func TestBatchAllOrNothingCommits(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	maker := placeFor(t, exchange, "maker", false, 110, 1)
+
+	results, rolledBack, err := exchange.ExecuteBatch(ctx, services.MarketETH, "taker", []services.BatchOperation{
+		{Op: services.BatchPlace, Type: services.OrderTypeMarket, Bid: true, Size: 1},
+	}, true)
+	Assert(t, err, nil)
+	Assert(t, rolledBack, false)
+	Assert(t, results[0].Status, services.StatusFilled)
+
+	state, _ := exchange.Orders.Get(maker.ID)
+	Assert(t, state.Status, services.StatusFilled)
+}
+
+func TestAmendKeepsPriorityWhenShrinking(t *testing.T) {
+	book := services.NewOrderBook()
+	first := services.NewOrder(false, 5)
+	second := services.NewOrder(false, 5)
+	book.PlaceLimitOrder(100, first)
+	book.PlaceLimitOrder(100, second)
+
+	book.AmendOrder(first, 100, 2)
+	Assert(t, book.AskLimits[100].Orders[0], first)
+	Assert(t, book.AskLimits[100].TotalVolume, services.Money(7))
+
+	book.AmendOrder(first, 100, 4)
+	Assert(t, book.AskLimits[100].Orders[1], first)
+	Assert(t, book.AskLimits[100].TotalVolume, services.Money(9))
+}
+
+func TestBatchRejectsEmptyAndUnknownMarket(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	_, _, err := exchange.ExecuteBatch(ctx, services.MarketETH, "maker", nil, false)
+	Assert(t, err, services.ErrEmptyBatch)
+	_, _, err = exchange.ExecuteBatch(ctx, "DOGE", "maker", []services.BatchOperation{{Op: services.BatchCancel}}, false)
+	Assert(t, err, services.ErrMarketNotFound)
+}
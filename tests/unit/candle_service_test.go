@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/repositories"
+)
+
+func TestCandleAggregation(t *testing.T) {
+	ctx := context.Background()
+	candles := services.NewCandleService()
+	start := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	candles.AddTrade(ctx, services.MarketETH, 100, 1, start.Add(5*time.Second))
+	candles.AddTrade(ctx, services.MarketETH, 110, 2, start.Add(20*time.Second))
+	candles.AddTrade(ctx, services.MarketETH, 95, 1, start.Add(40*time.Second))
+	// Nothing trades in the second minute.
+	candles.AddTrade(ctx, services.MarketETH, 105, 3, start.Add(2*time.Minute+time.Second))
+
+	bars, err := candles.Candles(ctx, services.MarketETH, services.Interval1m, start, start.Add(3*time.Minute))
+	Assert(t, err, nil)
+	Assert(t, len(bars), 3)
+
+	first := bars[0]
+	Assert(t, []services.Money{first.Open, first.High, first.Low, first.Close}, []services.Money{100, 110, 95, 95})
+	Assert(t, first.Volume, services.Money(4))
+	Assert(t, first.QuoteVolume, services.Money(100+220+95))
+	Assert(t, first.Trades, 3)
+
+	// The empty minute carries the previous close forward.
+	gap := bars[1]
+	Assert(t, gap.OpenTime, start.Add(time.Minute))
+	Assert(t, []services.Money{gap.Open, gap.High, gap.Low, gap.Close}, []services.Money{95, 95, 95, 95})
+	Assert(t, gap.Volume, services.Money(0))
+
+	Assert(t, bars[2].Open, services.Money(105))
+
+	hourly, _ := candles.Candles(ctx, services.MarketETH, services.Interval1h, start, start.Add(time.Hour))
+	Assert(t, len(hourly), 1)
+	Assert(t, hourly[0].Trades, 4)
+	Assert(t, hourly[0].Close, services.Money(105))
+}
+
+func TestCandlesArePersistedWhenCompleted(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	candles := services.NewCandleService()
+	candles.Repository = store.Candles()
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	candles.AddTrade(ctx, services.MarketETH, 100, 1, start)
+	candles.Flush(ctx, start.Add(30*time.Second))
+	saved, _ := store.Candles().ListCandles(ctx, services.MarketETH, services.Interval1m, start, start.Add(time.Hour))
+	Assert(t, len(saved), 0)
+
+	candles.Flush(ctx, start.Add(time.Minute))
+	saved, _ = store.Candles().ListCandles(ctx, services.MarketETH, services.Interval1m, start, start.Add(time.Hour))
+	Assert(t, len(saved), 1)
+	Assert(t, saved[0].Close, services.Money(100))
+
+	// A range starting after the last trade is still filled from the persisted close.
+	later, err := candles.Candles(ctx, services.MarketETH, services.Interval1m, start.Add(10*time.Minute), start.Add(12*time.Minute))
+	Assert(t, err, nil)
+	Assert(t, len(later), 2)
+	Assert(t, later[1].Close, services.Money(100))
+
+	_, err = candles.Candles(ctx, services.MarketETH, "2m", start, start.Add(time.Hour))
+	if err == nil {
+		t.Error("Expected an error for an unknown interval")
+	}
+}
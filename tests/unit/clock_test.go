@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// epoch is the time the manual clocks of the tests start at.
+var epoch = time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// clockedExchange creates an exchange running on a manual clock reading epoch.
+func clockedExchange() (*services.CryptoExchangeService, *services.ManualClock) {
+	clock := services.NewManualClock(epoch)
+	exchange := services.NewCryptoExchangeService()
+	exchange.UseClock(clock)
+	return exchange, clock
+}
+
+func TestClocks(t *testing.T) {
+	clock := services.NewManualClock(epoch)
+	Assert(t, clock.Now(), epoch)
+	Assert(t, clock.Advance(time.Second), epoch.Add(time.Second))
+	Assert(t, clock.Now(), epoch.Add(time.Second))
+	clock.Set(epoch)
+	Assert(t, clock.Now(), epoch)
+
+	Assert(t, services.FixedClock{Time: epoch}.Now(), epoch)
+	if (services.RealClock{}).Now().Before(epoch) {
+		t.Fatal("the real clock is behind the test epoch")
+	}
+}
+
+func TestBookStampsArrivalOnItsClock(t *testing.T) {
+	clock := services.NewManualClock(epoch)
+	book := services.NewOrderBook()
+	book.Clock = clock
+
+	// Created in one order, placed in another: arrival on the book decides priority.
+	orders := []*services.Order{services.NewOrder(false, 1), services.NewOrder(false, 1), services.NewOrder(false, 1)}
+	for _, i := range []int{2, 0, 1} {
+		book.PlaceLimitOrder(100, orders[i])
+		clock.Advance(time.Millisecond)
+	}
+	Assert(t, orders[2].TimeStamp, epoch.UnixNano())
+	Assert(t, orders[0].TimeStamp, epoch.Add(time.Millisecond).UnixNano())
+	Assert(t, orders[1].TimeStamp, epoch.Add(2*time.Millisecond).UnixNano())
+	Assert(t, book.AskLimits[100].Orders, services.Orders{orders[2], orders[0], orders[1]})
+
+	matches, err := book.PlaceMarketOrder(services.NewOrder(true, 1.5))
+	Assert(t, err, nil)
+	Assert(t, []*services.Order{matches[0].Ask, matches[1].Ask}, []*services.Order{orders[2], orders[0]})
+	Assert(t, []services.Money{matches[0].SizeFilled, matches[1].SizeFilled}, []services.Money{1, 0.5})
+
+	// Growing an order sends it to the back, stamped with the time of the amend.
+	amendedAt := clock.Advance(time.Millisecond)
+	book.AmendOrder(orders[0], 100, 1)
+	Assert(t, orders[0].TimeStamp, amendedAt.UnixNano())
+	Assert(t, book.AskLimits[100].Orders, services.Orders{orders[1], orders[0]})
+}
+
+func TestExchangeEventsFollowItsClock(t *testing.T) {
+	exchange, clock := clockedExchange()
+	events := exchange.Events.Subscribe("clock", 16, messaging.Block, func(event messaging.Event) bool {
+		return event.EventType() == services.EventTradeExecuted
+	})
+	placeFor(t, exchange, "maker", false, 100, 1)
+
+	clock.Advance(time.Second)
+	taker := services.NewOrder(true, 1)
+	_, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, taker)
+	Assert(t, err, nil)
+	trade := (<-events.Events()).(services.TradeExecuted)
+	Assert(t, trade.Timestamp, epoch.Add(time.Second).UnixNano())
+
+	state, _ := exchange.Orders.Get(taker.ID)
+	Assert(t, state.CreatedAt, epoch.Add(time.Second).UnixNano())
+
+	// Markets listed later run on the same clock.
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{}), nil)
+	Assert(t, exchange.OrderBooks[marketBTC].Clock, services.Clock(clock))
+}
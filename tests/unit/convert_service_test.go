@@ -0,0 +1,178 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// convertExchange lists BTC next to ETH, with an ETH bid at 100 and a BTC ask at 1000 from a
+// funded market maker, and gives the trader 2 ETH.
+func convertExchange(t *testing.T) (*services.CryptoExchangeService, *services.User, *services.User) {
+	exchange := services.NewCryptoExchangeService()
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	maker, _ := exchange.Users.Register("maker@example.com", "long enough")
+	trader, _ := exchange.Users.Register("trader@example.com", "long enough")
+	for _, asset := range []services.Asset{"ETH", "BTC", "SOL", services.QuoteAsset} {
+		maker.Wallet.Credit(asset, 1_000_000)
+	}
+	trader.Wallet.Credit(services.Asset(services.MarketETH), 2)
+
+	placeFor(t, exchange, maker.ID, true, 100, 5)
+	ask := services.NewOrder(false, 1)
+	ask.UserID = maker.ID
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), marketBTC, 1000, ask), nil)
+	return exchange, maker, trader
+}
+
+func TestConvertDirect(t *testing.T) {
+	exchange, _, trader := convertExchange(t)
+
+	quote, err := exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 2)
+	Assert(t, err, nil)
+	Assert(t, quote.Route, []services.ConvertLeg{{Market: services.MarketETH}})
+	// The books pay 200, less the 50 bps markup.
+	Assert(t, rounded(quote.Receive), services.Money(199))
+
+	result, err := exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, nil)
+	Assert(t, len(result.OrderIDs), 1)
+	Assert(t, rounded(trader.Wallet.Balance(services.QuoteAsset)), services.Money(199))
+	Assert(t, trader.Wallet.Balance("ETH"), services.Money(0))
+	Assert(t, rounded(exchange.Convert.Revenue.Balance(services.QuoteAsset)), services.Money(1))
+}
+
+func TestConvertTwoLegs(t *testing.T) {
+	exchange, _, trader := convertExchange(t)
+
+	quote, err := exchange.Convert.Quote(trader.ID, "ETH", "BTC", 2)
+	Assert(t, err, nil)
+	Assert(t, quote.Route, []services.ConvertLeg{{Market: services.MarketETH}, {Market: marketBTC, Bid: true}})
+	// 2 ETH sell for 200, which buys 0.2 BTC.
+	Assert(t, rounded(quote.Receive), services.Money(0.199))
+
+	result, err := exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, nil)
+	Assert(t, len(result.OrderIDs), 2)
+	Assert(t, rounded(trader.Wallet.Balance("BTC")), services.Money(0.199))
+	Assert(t, trader.Wallet.Balance("ETH"), services.Money(0))
+	Assert(t, rounded(trader.Wallet.Balance(services.QuoteAsset)), services.Money(0))
+	Assert(t, rounded(exchange.Convert.Revenue.Balance("BTC")), services.Money(0.001))
+
+	state, _ := exchange.Orders.Get(result.OrderIDs[1])
+	Assert(t, state.Status, services.StatusFilled)
+}
+
+func TestConvertPrefersCrossMarket(t *testing.T) {
+	exchange, maker, trader := convertExchange(t)
+	const marketETHBTC services.Market = "ETH-BTC"
+	Assert(t, exchange.ListMarket(marketETHBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	bid := services.NewOrder(true, 2)
+	bid.UserID = maker.ID
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), marketETHBTC, 0.11, bid), nil)
+
+	quote, err := exchange.Convert.Quote(trader.ID, "ETH", "BTC", 2)
+	Assert(t, err, nil)
+	Assert(t, quote.Route, []services.ConvertLeg{{Market: marketETHBTC}})
+	// 2 ETH sell for 0.22 BTC on the cross market, where the two legs through USD pay 0.2.
+	Assert(t, rounded(quote.Receive), services.Money(0.2189))
+
+	result, err := exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, nil)
+	Assert(t, len(result.OrderIDs), 1)
+	Assert(t, rounded(trader.Wallet.Balance("BTC")), services.Money(0.2189))
+	Assert(t, trader.Wallet.Balance("ETH"), services.Money(0))
+
+	// The other way round buys on the same market.
+	ask := services.NewOrder(false, 1)
+	ask.UserID = maker.ID
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), marketETHBTC, 0.12, ask), nil)
+	quote, err = exchange.Convert.Quote(trader.ID, "BTC", "ETH", 0.11)
+	Assert(t, err, nil)
+	Assert(t, quote.Route, []services.ConvertLeg{{Market: marketETHBTC, Bid: true}})
+}
+
+func TestConvertWithOnlyCrossMarket(t *testing.T) {
+	exchange, maker, trader := convertExchange(t)
+	const marketSOLBTC services.Market = "SOL-BTC"
+	Assert(t, exchange.ListMarket(marketSOLBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	ask := services.NewOrder(false, 10)
+	ask.UserID = maker.ID
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), marketSOLBTC, 0.01, ask), nil)
+
+	// SOL has no USD market, so only SOL-BTC converts between them.
+	quote, err := exchange.Convert.Quote(trader.ID, "BTC", "SOL", 0.05)
+	Assert(t, err, nil)
+	Assert(t, quote.Route, []services.ConvertLeg{{Market: marketSOLBTC, Bid: true}})
+	Assert(t, rounded(quote.Receive), services.Money(4.975))
+
+	_, err = exchange.Convert.Quote(trader.ID, "SOL", services.QuoteAsset, 1)
+	Assert(t, errors.Is(err, services.ErrNoConvertRoute), true)
+}
+
+func TestConvertRollsBackBothLegs(t *testing.T) {
+	exchange, maker, trader := convertExchange(t)
+	quote, err := exchange.Convert.Quote(trader.ID, "ETH", "BTC", 2)
+	Assert(t, err, nil)
+
+	// The BTC ask moves up before the quote is accepted: 200 now buys less than quoted.
+	btc := exchange.OrderBooks[marketBTC]
+	for _, o := range btc.RestingOrders() {
+		Assert(t, exchange.CancelOrder(context.Background(), o), nil)
+	}
+	ask := services.NewOrder(false, 1)
+	ask.UserID = maker.ID
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), marketBTC, 1100, ask), nil)
+
+	_, err = exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, errors.Is(err, services.ErrQuoteMoved), true)
+	Assert(t, exchange.OrderBooks[services.MarketETH].BidLimits[100].TotalVolume, services.Money(5))
+	Assert(t, btc.AskLimits[1100].TotalVolume, services.Money(1))
+	Assert(t, trader.Wallet.Balance("ETH"), services.Money(2))
+	Assert(t, trader.Wallet.Available("ETH"), services.Money(2))
+	orders, _, _ := exchange.Orders.List(trader.ID, services.OrderFilter{}, "", 10)
+	Assert(t, len(orders), 0)
+}
+
+func TestConvertQuoteIsUsedOnce(t *testing.T) {
+	exchange, maker, trader := convertExchange(t)
+	quote, _ := exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 1)
+
+	_, err := exchange.Convert.Accept(context.Background(), maker.ID, quote.ID)
+	Assert(t, err, services.ErrQuoteNotFound)
+	_, err = exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, nil)
+	_, err = exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, services.ErrQuoteNotFound)
+}
+
+func TestConvertQuoteExpires(t *testing.T) {
+	exchange, _, trader := convertExchange(t)
+	clock := services.NewManualClock(epoch)
+	exchange.UseClock(clock)
+	quote, _ := exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 1)
+	Assert(t, quote.ExpiresAt, epoch.Add(services.DefaultQuoteTTL).UnixNano())
+	clock.Advance(services.DefaultQuoteTTL)
+
+	_, err := exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, services.ErrQuoteExpired)
+}
+
+func TestConvertRejections(t *testing.T) {
+	exchange, _, trader := convertExchange(t)
+
+	_, err := exchange.Convert.Quote(trader.ID, "ETH", "ETH", 1)
+	Assert(t, err, services.ErrSameAsset)
+	_, err = exchange.Convert.Quote(trader.ID, "SOL", services.QuoteAsset, 1)
+	Assert(t, errors.Is(err, services.ErrNoConvertRoute), true)
+	_, err = exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 0)
+	Assert(t, errors.Is(err, services.ErrInvalidConvertAmount), true)
+	_, err = exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 6)
+	Assert(t, errors.Is(err, services.ErrInsufficientLiquidity), true)
+
+	quote, _ := exchange.Convert.Quote(trader.ID, "ETH", services.QuoteAsset, 3)
+	_, err = exchange.Convert.Accept(context.Background(), trader.ID, quote.ID)
+	Assert(t, err, services.ErrInsufficientBalance)
+}
@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+func switchEvents(exchange *services.CryptoExchangeService) *messaging.Subscriber {
+	return exchange.Events.Subscribe("switch", 16, messaging.Block, func(event messaging.Event) bool {
+		return event.EventType() == services.EventDeadMansSwitchTriggered
+	})
+}
+
+func TestDeadMansSwitchFiresWithoutHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	clock := services.NewManualClock(time.Unix(1_700_000_000, 0))
+	exchange.UseClock(clock)
+	events := switchEvents(exchange)
+	o := placeFor(t, exchange, "bot", false, 110, 1)
+	placeFor(t, exchange, "other", false, 120, 1)
+
+	deadline, err := exchange.Switches.Arm("session", "bot", services.MinDeadMansSwitchTimeout)
+	Assert(t, err, nil)
+	Assert(t, deadline, clock.Now().Add(time.Second))
+
+	clock.Advance(999 * time.Millisecond)
+	fired, err := exchange.Switches.Expire(ctx, clock.Now())
+	Assert(t, err, nil)
+	Assert(t, fired, 0)
+	Assert(t, exchange.Switches.Armed("session"), true)
+
+	now := clock.Advance(time.Millisecond)
+	fired, err = exchange.Switches.Expire(ctx, now)
+	Assert(t, err, nil)
+	Assert(t, fired, 1)
+	select {
+	case event := <-events.Events():
+		triggered := event.(services.DeadMansSwitchTriggered)
+		Assert(t, triggered.SessionID, "session")
+		Assert(t, triggered.UserID, "bot")
+		Assert(t, triggered.Cancelled, []string{o.ID})
+		Assert(t, triggered.Timestamp, now.UnixNano())
+	case <-time.After(5 * time.Second):
+		t.Fatal("switch did not fire")
+	}
+	Assert(t, exchange.Switches.Armed("session"), false)
+	Assert(t, len(exchange.OrderBooks[services.MarketETH].RestingOrders()), 1)
+
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+}
+
+func TestDeadMansSwitchHeartbeatPostponesDeadline(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	clock := services.NewManualClock(time.Unix(1_700_000_000, 0))
+	exchange.UseClock(clock)
+	o := placeFor(t, exchange, "bot", false, 110, 1)
+
+	first, err := exchange.Switches.Arm("session", "bot", time.Minute)
+	Assert(t, err, nil)
+	clock.Advance(50 * time.Second)
+	next, err := exchange.Switches.Heartbeat("session")
+	Assert(t, err, nil)
+	Assert(t, next, first.Add(50*time.Second))
+
+	// The first deadline has passed, the postponed one hasn't.
+	clock.Advance(time.Minute - time.Second)
+	fired, _ := exchange.Switches.Expire(ctx, clock.Now())
+	Assert(t, fired, 0)
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Status, services.StatusNew)
+
+	Assert(t, exchange.Switches.Disarm("session"), true)
+	_, err = exchange.Switches.Heartbeat("session")
+	Assert(t, err, services.ErrSwitchNotArmed)
+	fired, _ = exchange.Switches.Expire(ctx, clock.Advance(time.Hour))
+	Assert(t, fired, 0)
+}
+
+func TestDeadMansSwitchRejectsTimeout(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	_, err := exchange.Switches.Arm("session", "bot", 0)
+	Assert(t, err, services.ErrInvalidSwitchTimeout)
+	_, err = exchange.Switches.Arm("session", "bot", time.Hour)
+	Assert(t, err, services.ErrInvalidSwitchTimeout)
+}
+
+func TestDeadMansSwitchTrigger(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	o := placeFor(t, exchange, "bot", true, 90, 1)
+	_, err := exchange.Switches.Arm("connection", "bot", time.Minute)
+	Assert(t, err, nil)
+
+	cancelled, err := exchange.Switches.Trigger(context.Background(), "connection")
+	Assert(t, err, nil)
+	Assert(t, cancelled, []string{o.ID})
+
+	_, err = exchange.Switches.Trigger(context.Background(), "connection")
+	Assert(t, err, services.ErrSwitchNotArmed)
+}
@@ -0,0 +1,94 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+type testEvent int
+
+func (testEvent) EventType() string { return "test" }
+
+func drain(subscriber *messaging.Subscriber) []messaging.Event {
+	var events []messaging.Event
+	for {
+		select {
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestDispatcherOverflowPolicies(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	newest := dispatcher.Subscribe("newest", 2, messaging.DropNewest, nil)
+	oldest := dispatcher.Subscribe("oldest", 2, messaging.DropOldest, nil)
+	for i := 1; i <= 3; i++ {
+		dispatcher.Publish(testEvent(i))
+	}
+
+	Assert(t, drain(newest), []messaging.Event{testEvent(1), testEvent(2)})
+	Assert(t, newest.Dropped(), uint64(1))
+	Assert(t, drain(oldest), []messaging.Event{testEvent(2), testEvent(3)})
+	Assert(t, oldest.Dropped(), uint64(1))
+}
+
+func TestDispatcherDisconnectsSlowSubscriber(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	slow := dispatcher.Subscribe("slow", 1, messaging.Disconnect, nil)
+	dispatcher.Publish(testEvent(1))
+	dispatcher.Publish(testEvent(2))
+
+	Assert(t, slow.Disconnected(), true)
+	dispatcher.Unsubscribe(slow)
+	Assert(t, dispatcher.SubscriberCount(), 0)
+	Assert(t, drain(slow), []messaging.Event{testEvent(1)})
+}
+
+func TestDispatcherFilter(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	odd := dispatcher.Subscribe("odd", 4, messaging.Block, func(event messaging.Event) bool {
+		return event.(testEvent)%2 == 1
+	})
+	for i := 1; i <= 4; i++ {
+		dispatcher.Publish(testEvent(i))
+	}
+	Assert(t, drain(odd), []messaging.Event{testEvent(1), testEvent(3)})
+}
+
+func TestOrderBookPublishesLifecycleEvents(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	subscriber := dispatcher.Subscribe("test", 64, messaging.Block, nil)
+	orderBook := services.NewOrderBook()
+	orderBook.Market = services.MarketETH
+	orderBook.Events = dispatcher
+
+	ask := services.NewOrder(false, 5)
+	orderBook.PlaceLimitOrder(2_000, ask)
+	bid := services.NewOrder(true, 2)
+	_, err := orderBook.PlaceMarketOrder(bid)
+	Assert(t, err, nil)
+	orderBook.CancelOrder(ask)
+
+	var types []string
+	for i, event := range drain(subscriber) {
+		marketEvent := event.(services.MarketEvent)
+		Assert(t, marketEvent.Header().Sequence, uint64(i+1))
+		Assert(t, marketEvent.Header().Market, services.MarketETH)
+		types = append(types, event.EventType())
+	}
+	Assert(t, types, []string{
+		services.EventOrderAccepted, services.EventLevelChanged, // limit order rests
+		services.EventOrderAccepted, services.EventTradeExecuted, // market order takes
+		services.EventOrderPartiallyFilled, services.EventOrderFilled, services.EventLevelChanged,
+		services.EventOrderCancelled, services.EventLevelChanged, // rest of the ask is pulled
+	})
+	Assert(t, len(orderBook.Asks), 0)
+}
@@ -0,0 +1,195 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/theghostmac/cryptex/internal/app/api"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+const marketL3 services.Market = "L3"
+
+// bookLevel is a price level reduced to what an L3 client should be able to rebuild.
+type bookLevel struct {
+	Price services.Money
+	Sizes []services.Money
+	Top   int // index of the top order, -1 without one.
+}
+
+// exchangeLevels reads the levels of a side of the exchange's book, best first.
+func exchangeLevels(exchange *services.CryptoExchangeService, market services.Market, bid bool) []bookLevel {
+	var levels []bookLevel
+	book := exchange.OrderBooks[market]
+	book.Exclusive(func() {
+		limits := book.SortAsk()
+		if bid {
+			limits = book.SortBids()
+		}
+		for _, limit := range limits {
+			level := bookLevel{Price: limit.Price, Top: -1}
+			for i, o := range limit.Orders {
+				level.Sizes = append(level.Sizes, o.Size)
+				if limit.Top == o {
+					level.Top = i
+				}
+			}
+			levels = append(levels, level)
+		}
+	})
+	return levels
+}
+
+// snapshotLevels reduces the levels of an L3 snapshot the same way.
+func snapshotLevels(levels []services.L3Level) []bookLevel {
+	var reduced []bookLevel
+	for _, level := range levels {
+		r := bookLevel{Price: level.Price, Top: -1}
+		for i, o := range level.Orders {
+			r.Sizes = append(r.Sizes, o.Size)
+			if o.OrderID == level.Top {
+				r.Top = i
+			}
+		}
+		reduced = append(reduced, r)
+	}
+	return reduced
+}
+
+func placeOn(t *testing.T, exchange *services.CryptoExchangeService, market services.Market, bid bool, price, size services.Money) *services.Order {
+	t.Helper()
+	o := services.NewOrder(bid, size)
+	o.UserID = "maker"
+	if err := exchange.PlaceLimitOrder(context.Background(), market, price, o); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestL3FeedRebuildsTheExactBook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exchange, clock := clockedExchange()
+	Assert(t, exchange.ListMarket(marketL3, services.MarketConfig{Matching: services.MatchTopOrderFIFO}), nil)
+
+	// Orders resting before the feed starts are in its first snapshot.
+	first := placeOn(t, exchange, marketL3, false, 101, 1)
+	placeOn(t, exchange, marketL3, true, 99, 2)
+
+	feed := services.NewL3Feed(exchange)
+	go feed.Run(ctx)
+	messages := feed.Messages.Subscribe("test", 1024, messaging.Block, nil)
+	start, err := feed.Snapshot(marketL3)
+	Assert(t, err, nil)
+	Assert(t, start.Sequence, uint64(0))
+	Assert(t, start.Asks, []services.L3Level{{Price: 101, Orders: []services.L3Order{{OrderID: 2, Size: 1}}, Top: 2}})
+	_, err = feed.Snapshot("DOGE")
+	Assert(t, errors.Is(err, services.ErrMarketNotFound), true)
+
+	second := placeOn(t, exchange, marketL3, false, 101, 2)
+	third := placeOn(t, exchange, marketL3, false, 102, 3)
+	gtt := services.NewOrder(true, 1)
+	gtt.ExpiresAt = epoch.Add(time.Minute).UnixNano()
+	Assert(t, exchange.PlaceLimitOrder(ctx, marketL3, 98, gtt), nil)
+	placeOn(t, exchange, marketL3, true, 99, 1)
+
+	amends := []services.BatchOperation{
+		// Shrinking keeps the place in the queue and growing loses it, though the level's top order
+		// stays on top. A new price makes a new level.
+		{Op: services.BatchAmend, OrderID: second.ID, Price: 101, Size: 1.5},
+		{Op: services.BatchAmend, OrderID: first.ID, Price: 101, Size: 3},
+		{Op: services.BatchAmend, OrderID: third.ID, Price: 100.5, Size: 3},
+	}
+	_, _, err = exchange.ExecuteBatch(ctx, marketL3, "maker", amends, true)
+	Assert(t, err, nil)
+
+	// 100.5 fills first, then the top order of 101.
+	taker := services.NewOrder(true, 4)
+	_, err = exchange.PlaceMarketOrder(ctx, marketL3, taker)
+	Assert(t, err, nil)
+	clock.Advance(time.Minute)
+	_, err = exchange.ExpireOrders(ctx, clock.Now())
+	Assert(t, err, nil)
+
+	var latest services.L3Snapshot
+	eventually(t, func() bool {
+		latest, _ = feed.Snapshot(marketL3)
+		return reflect.DeepEqual(snapshotLevels(latest.Asks), exchangeLevels(exchange, marketL3, false)) &&
+			reflect.DeepEqual(snapshotLevels(latest.Bids), exchangeLevels(exchange, marketL3, true))
+	})
+	Assert(t, snapshotLevels(latest.Asks), []bookLevel{{Price: 101, Sizes: []services.Money{1.5, 2}, Top: 1}})
+
+	// A client starting from the first snapshot rebuilds the same book from the messages.
+	replica := services.NewL3Book(start)
+	var actions []services.L3Action
+	for replica.Sequence < latest.Sequence {
+		message := (<-messages.Events()).(services.L3Message)
+		actions = append(actions, message.Action)
+		Assert(t, replica.Apply(message), nil)
+	}
+	Assert(t, replica.Snapshot(), latest)
+	Assert(t, actions, []services.L3Action{
+		services.L3Add, services.L3Add, services.L3Add, services.L3Add,
+		services.L3Modify, services.L3Modify, services.L3Modify,
+		services.L3Execute, services.L3Execute,
+		services.L3Delete,
+	})
+}
+
+func TestL3BookRejectsGaps(t *testing.T) {
+	book := services.NewL3Book(services.L3Snapshot{Market: marketL3, Sequence: 4})
+	add := services.L3Message{Market: marketL3, Sequence: 6, Action: services.L3Add, OrderID: 9, Price: 100, Size: 1}
+	Assert(t, errors.Is(book.Apply(add), services.ErrL3Gap), true)
+	add.Sequence = 5
+	Assert(t, book.Apply(add), nil)
+	remove := services.L3Message{Market: marketL3, Sequence: 6, Action: services.L3Delete, OrderID: 8}
+	Assert(t, errors.Is(book.Apply(remove), services.ErrL3UnknownOrder), true)
+	Assert(t, book.Sequence, uint64(5))
+}
+
+func TestL3WebSocketStartsWithASnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 101, 1)
+	feed := services.NewL3Feed(exchange)
+	go feed.Run(ctx)
+
+	handler := api.NewCryptoExchangeHandler(exchange)
+	handler.L3 = feed
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/markets/{market}/l3", handler.L3Feed)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/markets/ETH/l3", nil)
+	Assert(t, err, nil)
+	defer conn.Close()
+
+	read := func(event interface{}) string {
+		var message struct {
+			Type  string          `json:"type"`
+			Event json.RawMessage `json:"event"`
+		}
+		Assert(t, conn.ReadJSON(&message), nil)
+		Assert(t, json.Unmarshal(message.Event, event), nil)
+		return message.Type
+	}
+	var snapshot services.L3Snapshot
+	Assert(t, read(&snapshot), api.FeedL3Snapshot)
+	Assert(t, len(snapshot.Asks), 1)
+
+	placeFor(t, exchange, "maker", true, 99, 2)
+	var add services.L3Message
+	Assert(t, read(&add), services.EventL3Add)
+	Assert(t, add, services.L3Message{Market: services.MarketETH, Sequence: snapshot.Sequence + 1, Timestamp: add.Timestamp,
+		Action: services.L3Add, OrderID: 2, Bid: true, Price: 99, Size: 2, Top: true})
+}
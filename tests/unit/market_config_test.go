@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func TestMarketConfigValidate(t *testing.T) {
+	config := services.MarketConfig{TickSize: 0.01, LotSize: 0.001, MinSize: 0.01, MaxSize: 100, MinNotional: 10}
+	cases := []struct {
+		name      string
+		orderType services.OrderType
+		price     services.Money
+		size      services.Money
+		want      error
+	}{
+		{"valid", services.OrderTypeLimit, 2000.01, 1.5, nil},
+		{"zero size", services.OrderTypeLimit, 2000, 0, services.ErrInvalidSize},
+		{"negative size", services.OrderTypeMarket, 0, -1, services.ErrInvalidSize},
+		{"off lot", services.OrderTypeLimit, 2000, 1.0005, services.ErrInvalidSize},
+		{"below min size", services.OrderTypeLimit, 2000, 0.005, services.ErrInvalidSize},
+		{"above max size", services.OrderTypeLimit, 2000, 101, services.ErrInvalidSize},
+		{"off tick", services.OrderTypeLimit, 1999.9999999, 1, services.ErrInvalidPrice},
+		{"zero price", services.OrderTypeLimit, 0, 1, services.ErrInvalidPrice},
+		{"below min notional", services.OrderTypeLimit, 1, 1, services.ErrInvalidNotional},
+		{"market order has no price", services.OrderTypeMarket, 0, 1, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := config.Validate(c.orderType, c.price, c.size)
+			if c.want == nil {
+				Assert(t, err, nil)
+				return
+			}
+			Assert(t, errors.Is(err, c.want), true)
+		})
+	}
+}
+
+func TestExchangeRejectsInvalidOrders(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+
+	o := services.NewOrder(true, -1)
+	err := exchange.PlaceLimitOrder(ctx, services.MarketETH, 100, o)
+	Assert(t, services.IsInvalidOrder(err), true)
+	Assert(t, len(exchange.OrderBooks[services.MarketETH].Bids), 0)
+
+	state, ok := exchange.Orders.Get(o.ID)
+	Assert(t, ok, true)
+	Assert(t, state.Status, services.StatusRejected)
+
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, services.NewOrder(true, 0))
+	Assert(t, services.IsInvalidOrder(err), true)
+}
+
+func TestPricesSnapToTheTick(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+
+	first := services.NewOrder(false, 1)
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 2000, first), nil)
+	second := services.NewOrder(false, 1.00000000001)
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 2000.0000000001, second), nil)
+
+	orderBook := exchange.OrderBooks[services.MarketETH]
+	Assert(t, len(orderBook.AskLimits), 1)
+	Assert(t, len(orderBook.Asks), 1)
+	Assert(t, orderBook.Asks[0].Price, services.Money(2000))
+	Assert(t, orderBook.Asks[0].TotalVolume, services.Money(2))
+	Assert(t, second.Size, services.Money(1))
+	Assert(t, second.InitialSize, services.Money(1))
+
+	third := services.NewOrder(false, 1)
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 2000.0099999999, third), nil)
+	Assert(t, third.Limit.Price, services.Money(2000.01))
+}
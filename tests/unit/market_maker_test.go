@@ -0,0 +1,158 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// eventually polls cond until it holds, failing the test after a second.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met in time")
+}
+
+// volumeAt returns the size resting on a side of the ETH book at the price, read under the book's lock.
+func volumeAt(exchange *services.CryptoExchangeService, bid bool, price services.Money) services.Money {
+	orderBook := exchange.OrderBooks[services.MarketETH]
+	var volume services.Money
+	orderBook.Exclusive(func() {
+		limits := orderBook.AskLimits
+		if bid {
+			limits = orderBook.BidLimits
+		}
+		if limit, ok := limits[price]; ok {
+			volume = limit.TotalVolume
+		}
+	})
+	return volume
+}
+
+func TestPositionAverageCost(t *testing.T) {
+	var p services.Position
+	p.Apply(true, 100, 2)
+	p.Apply(true, 110, 2)
+	Assert(t, p.AverageCost, services.Money(105))
+
+	p.Apply(false, 120, 3)
+	Assert(t, p.Base, services.Money(1))
+	Assert(t, p.RealizedPnL, services.Money(45))
+
+	// Selling 2 closes the last unit at a loss of 5 and opens a short of 1 at 100.
+	p.Apply(false, 100, 2)
+	Assert(t, p.Base, services.Money(-1))
+	Assert(t, p.AverageCost, services.Money(100))
+	Assert(t, p.RealizedPnL, services.Money(40))
+	Assert(t, p.UnrealizedPnL(90), services.Money(10))
+	Assert(t, p.PnL(90), services.Money(50))
+	Assert(t, p.Trades, 4)
+	Assert(t, p.Volume, services.Money(9))
+}
+
+func TestSymmetricSpreadSkewsWithInventory(t *testing.T) {
+	strategy := services.SymmetricSpread{SpreadBps: 100, Size: 1, SkewBps: 20, MaxInventory: 10}
+	view := services.MarketView{Config: services.DefaultMarketConfigs[services.MarketETH], BestBid: 99, BestAsk: 101}
+
+	Assert(t, strategy.OnEvent(nil, view), []services.QuoteInstruction{
+		services.PlaceQuote(true, 99.5, 1), services.PlaceQuote(false, 100.5, 1),
+	})
+
+	// Long half the limit: both quotes move down 10 bps.
+	view.Position.Base = 5
+	Assert(t, strategy.OnEvent(nil, view), []services.QuoteInstruction{
+		services.PlaceQuote(true, 99.4, 1), services.PlaceQuote(false, 100.4, 1),
+	})
+
+	// At the limit the bid is pulled and only the ask is requoted.
+	view.Position.Base = 10
+	view.Quotes = []services.MakerQuote{{OrderID: "bid", Bid: true, Price: 99.4, Size: 1}, {OrderID: "ask", Price: 100.4, Size: 0.5}}
+	Assert(t, strategy.OnEvent(nil, view), []services.QuoteInstruction{
+		services.CancelQuote("bid"), services.CancelQuote("ask"), services.PlaceQuote(false, 100.3, 1),
+	})
+
+	// A quote already at its price is kept, even partially filled.
+	view.Quotes = []services.MakerQuote{{OrderID: "ask", Price: 100.3, Size: 0.5}}
+	Assert(t, len(strategy.OnEvent(nil, view)), 0)
+
+	// Nothing to price off: every quote is pulled.
+	Assert(t, strategy.OnEvent(nil, services.MarketView{Quotes: view.Quotes}), []services.QuoteInstruction{services.CancelAllQuotes()})
+}
+
+func TestMarketMakerQuotesAndTracksFills(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	mm, _ := exchange.Users.Register("mm@example.com", "long enough")
+	mm.Wallet.Credit("ETH", 10)
+	mm.Wallet.Credit(services.QuoteAsset, 1_000)
+	placeFor(t, exchange, "other", true, 99, 5)
+	placeFor(t, exchange, "other", false, 101, 5)
+
+	maker := services.NewMarketMaker(exchange, services.MarketETH, mm.ID, services.SymmetricSpread{SpreadBps: 100, Size: 1, SkewBps: 20, MaxInventory: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		maker.Run(ctx)
+		close(done)
+	}()
+	eventually(t, func() bool { return volumeAt(exchange, true, 99.5) == 1 && volumeAt(exchange, false, 100.5) == 1 })
+
+	taker := services.NewOrder(true, 1)
+	taker.UserID = "taker"
+	_, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, taker)
+	Assert(t, err, nil)
+
+	// Short 1 at 100.5: both quotes move up 2 bps.
+	eventually(t, func() bool { return volumeAt(exchange, false, 100.52) == 1 })
+	Assert(t, volumeAt(exchange, true, 99.52), services.Money(1))
+	Assert(t, volumeAt(exchange, true, 99.5), services.Money(0))
+	position := maker.Position()
+	Assert(t, position.Base, services.Money(-1))
+	Assert(t, position.AverageCost, services.Money(100.5))
+	Assert(t, mm.Wallet.Balance(services.QuoteAsset), services.Money(1_100.5))
+	Assert(t, mm.Wallet.Balance("ETH"), services.Money(9))
+
+	cancel()
+	<-done
+	Assert(t, volumeAt(exchange, true, 99.52), services.Money(0))
+	Assert(t, volumeAt(exchange, false, 100.52), services.Money(0))
+	Assert(t, maker.Stats().Rejected, 0)
+}
+
+func TestMarketMakerPassesRiskChecks(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "other", true, 99, 5)
+	placeFor(t, exchange, "other", false, 101, 5)
+
+	// Below the market's minimum size: refused like any client's order.
+	maker := services.NewMarketMaker(exchange, services.MarketETH, "mm", services.SymmetricSpread{SpreadBps: 100, Size: 0.00001})
+	err := maker.Handle(context.Background(), nil)
+	Assert(t, errors.Is(err, services.ErrInvalidSize), true)
+	Assert(t, maker.Stats(), services.MakerStats{Rejected: 2})
+
+	_, err = exchange.SetMarketState(context.Background(), services.MarketETH, services.MarketHalted, 0)
+	Assert(t, err, nil)
+	maker.Strategy = services.SymmetricSpread{SpreadBps: 100, Size: 1}
+	err = maker.Handle(context.Background(), nil)
+	Assert(t, errors.Is(err, services.ErrMarketHalted), true)
+	Assert(t, volumeAt(exchange, true, 99.5), services.Money(0))
+}
+
+func TestSymmetricSpreadKeepsQuotesAtInexactPrices(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "other", true, 1903.70, 5)
+	placeFor(t, exchange, "other", false, 1905.70, 5)
+
+	// 1906.61 is not exact in floating point: the quote is still found at its level, so requoting
+	// leaves it alone.
+	maker := services.NewMarketMaker(exchange, services.MarketETH, "mm", services.SymmetricSpread{SpreadBps: 20, Size: 1})
+	Assert(t, maker.Handle(context.Background(), nil), nil)
+	Assert(t, maker.Handle(context.Background(), nil), nil)
+	Assert(t, maker.Stats(), services.MakerStats{Placed: 2})
+}
@@ -0,0 +1,311 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/theghostmac/cryptex/internal/app/api"
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
+)
+
+// collected keeps what is published to it.
+type collected struct {
+	events []messaging.Event
+}
+
+func (c *collected) Publish(event messaging.Event) {
+	c.events = append(c.events, event)
+}
+
+func header(sequence uint64, at time.Duration) services.EventHeader {
+	return services.EventHeader{Market: services.MarketETH, Sequence: sequence, Timestamp: epoch.Add(at).UnixNano()}
+}
+
+func readRecording(t *testing.T, path string) (*services.Recording, []services.MarketEvent) {
+	t.Helper()
+	recording, err := services.OpenRecording(path)
+	Assert(t, err, nil)
+	t.Cleanup(func() { recording.Close() })
+	events, err := recording.Events().ReadAll()
+	Assert(t, err, nil)
+	return recording, events
+}
+
+func TestRecordingRoundTripsEveryEvent(t *testing.T) {
+	events := []services.MarketEvent{
+		services.OrderAccepted{EventHeader: header(1, 0), OrderID: "o1", UserID: "alice", Type: services.OrderTypeLimit,
+			Bid: true, Price: 99.5, Size: 2, ExpiresAt: epoch.Add(time.Hour).UnixNano()},
+		services.OrderAccepted{EventHeader: header(2, 0), OrderID: "o2", UserID: "bob", Type: services.OrderTypeMarket, Size: 0.5, Notional: 50, Bound: 101},
+		services.OrderRejected{EventHeader: header(3, time.Millisecond), OrderID: "o3", UserID: "bob", Size: 1, Reason: "invalid size"},
+		services.OrderFilled{EventHeader: header(4, time.Millisecond), OrderID: "o2", UserID: "bob", Price: 99.5, FilledSize: 0.5},
+		services.OrderPartiallyFilled{EventHeader: header(5, time.Millisecond), OrderID: "o1", UserID: "alice", Price: 99.5, FilledSize: 0.5, Remaining: 1.5},
+		services.TradeExecuted{EventHeader: header(6, time.Millisecond), TradeID: "t1", Price: 99.5, Size: 0.5, BuyOrderID: "o1",
+			SellOrderID: "o2", BuyerID: "alice", SellerID: "bob", TakerOrderID: "o2"},
+		services.LevelChanged{EventHeader: header(7, time.Millisecond), Bid: true, Price: 99.5, TotalVolume: 1.5, OrderCount: 1},
+		services.OrderAmended{EventHeader: header(8, time.Second), OrderID: "o1", UserID: "alice", Bid: true, Price: 99, Size: 3, Remaining: 2.5},
+		services.OrderCancelled{EventHeader: header(9, 2*time.Second), OrderID: "o1", UserID: "alice", Remaining: 2.5, Reason: services.CancelReasonUser},
+		services.MarketStateChanged{EventHeader: header(10, 3*time.Second), MarketStatus: services.MarketStatus{
+			State: services.MarketHalted, Reason: services.StateReasonCircuitBreaker, Since: epoch.UnixNano(), ResumeAt: epoch.Add(time.Minute).UnixNano()}},
+		services.IndicativePriceChanged{EventHeader: header(11, 4*time.Second), AuctionResult: services.AuctionResult{Price: 100, Volume: 3, Imbalance: -1}},
+		services.OrderRested{EventHeader: header(12, 5*time.Second), OrderID: "o4", UserID: "carol", Price: 101, Remaining: 0.25},
+	}
+
+	path := filepath.Join(t.TempDir(), "eth.cxmd")
+	recorder, err := services.CreateRecording(path, services.MarketETH)
+	Assert(t, err, nil)
+	for _, event := range events {
+		Assert(t, recorder.Record(event), nil)
+	}
+	other := services.LevelChanged{EventHeader: services.EventHeader{Market: "BTC", Sequence: 1}}
+	Assert(t, errors.Is(recorder.Record(other), services.ErrUnrecordedEvent), true)
+	Assert(t, recorder.Close(), nil)
+
+	recording, read := readRecording(t, path)
+	Assert(t, recording.Market, services.MarketETH)
+	Assert(t, recording.Complete, true)
+	Assert(t, read, events)
+}
+
+func TestRecordingSeeksBySequenceAndTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eth.cxmd")
+	recorder, _ := services.CreateRecording(path, services.MarketETH)
+	for i := 0; i < 1000; i++ {
+		recorder.Record(services.LevelChanged{EventHeader: header(uint64(i+1), time.Duration(i)*time.Millisecond), Price: 100, TotalVolume: 1})
+	}
+	Assert(t, recorder.Close(), nil)
+
+	recording, events := readRecording(t, path)
+	Assert(t, len(events), 1000)
+	Assert(t, len(recording.Index), 4)
+	Assert(t, recording.Index[1].Sequence, uint64(257))
+
+	first := func(reader *services.RecordingReader) uint64 {
+		event, err := reader.Next()
+		Assert(t, err, nil)
+		return event.Header().Sequence
+	}
+	Assert(t, first(recording.FromSequence(1)), uint64(1))
+	Assert(t, first(recording.FromSequence(600)), uint64(600))
+	Assert(t, first(recording.FromTime(epoch.Add(700*time.Millisecond).UnixNano())), uint64(701))
+	Assert(t, first(recording.FromTime(epoch.Add(700*time.Millisecond+1).UnixNano())), uint64(702))
+	rest, _ := recording.FromSequence(990).ReadAll()
+	Assert(t, len(rest), 11)
+	_, err := recording.FromSequence(1001).Next()
+	Assert(t, err, io.EOF)
+
+	// A recorder killed mid-write leaves no index and half a record; what was written is kept.
+	data, _ := os.ReadFile(path)
+	cut := filepath.Join(t.TempDir(), "cut.cxmd")
+	os.WriteFile(cut, data[:len(data)/2], 0o644)
+	recording, events = readRecording(t, cut)
+	Assert(t, recording.Complete, false)
+	if len(events) == 0 || len(events) >= 1000 || events[len(events)-1].Header().Sequence != uint64(len(events)) {
+		t.Fatalf("expected the first half of the events, read %d", len(events))
+	}
+	Assert(t, first(recording.FromSequence(300)), uint64(300))
+	Assert(t, len(recording.Index), (len(events)+255)/256)
+}
+
+func TestRecorderReplaysAnExchangeSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	exchange, clock := clockedExchange()
+	published := exchange.Events.Subscribe("published", 256, messaging.Block, nil)
+	path := filepath.Join(t.TempDir(), "eth.cxmd")
+	recorder, err := services.CreateRecording(path, services.MarketETH)
+	Assert(t, err, nil)
+	stopped := make(chan error)
+	go func() { stopped <- recorder.Run(ctx, exchange.Events) }()
+	eventually(t, func() bool { return exchange.Events.SubscriberCount() == 2 })
+
+	// Two asks, one of them amended and one cancelled, then a buyer takes what is left.
+	first := placeFor(t, exchange, "maker", false, 101, 1)
+	clock.Advance(time.Second)
+	second := placeFor(t, exchange, "maker", false, 102, 2)
+	clock.Advance(time.Second)
+	amend := services.BatchOperation{Op: services.BatchAmend, OrderID: first.ID, Price: 100.5, Size: 1}
+	_, _, err = exchange.ExecuteBatch(ctx, services.MarketETH, "maker", []services.BatchOperation{amend}, true)
+	Assert(t, err, nil)
+	clock.Advance(time.Second)
+	Assert(t, exchange.CancelUserOrder(ctx, "maker", second.ID), nil)
+	clock.Advance(time.Second)
+	taker := services.NewOrder(true, 1)
+	taker.UserID = "taker"
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, taker)
+	Assert(t, err, nil)
+
+	cancel()
+	Assert(t, <-stopped, nil)
+	exchange.Events.Unsubscribe(published)
+	var want []messaging.Event
+	for event := range published.Events() {
+		want = append(want, event)
+	}
+
+	recording, err := services.OpenRecording(path)
+	Assert(t, err, nil)
+	defer recording.Close()
+	replayed := &collected{}
+	count, err := services.Replayer{Speed: services.ReplayMaxSpeed}.Replay(context.Background(), recording.Events(), replayed)
+	Assert(t, err, nil)
+	Assert(t, count, len(want))
+	Assert(t, replayed.events, want)
+
+	// Four seconds of trading at a thousand times the speed take at least 4ms.
+	started := time.Now()
+	count, _ = services.Replayer{Speed: 1000}.Replay(context.Background(), recording.Events(), &collected{})
+	Assert(t, count, len(want))
+	if elapsed := time.Since(started); elapsed < 4*time.Millisecond {
+		t.Fatalf("replay at 1000x took %v", elapsed)
+	}
+
+	// The orders replayed through a backtest trade the same way.
+	flow, err := services.RecordedFlow(recording.Events())
+	Assert(t, err, nil)
+	Assert(t, len(flow), 6)
+	Assert(t, []services.FlowAction{flow[2].Action, flow[3].Action, flow[4].Action},
+		[]services.FlowAction{services.FlowCancel, services.FlowPlace, services.FlowCancel})
+	report := services.NewBacktest(backtestConfig(services.LatencyModel{}, 1)).Run(flow)
+	Assert(t, report.Trades, 1)
+	Assert(t, report.Mark, services.Money(100.5))
+	Assert(t, report.Rejected+report.MissedCancels, 0)
+}
+
+func TestRecordedFlowKeepsProtectionBounds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	exchange, clock := clockedExchange()
+	path := filepath.Join(t.TempDir(), "eth.cxmd")
+	recorder, err := services.CreateRecording(path, services.MarketETH)
+	Assert(t, err, nil)
+	stopped := make(chan error)
+	go func() { stopped <- recorder.Run(ctx, exchange.Events) }()
+	eventually(t, func() bool { return exchange.Events.SubscriberCount() == 1 })
+
+	placeFor(t, exchange, "maker", false, 101, 1)
+	placeFor(t, exchange, "maker", false, 103, 1)
+	clock.Advance(time.Second)
+	// Fills 1 at 101 and rests 2 at its bound of 102.
+	rested := protectedOrder(true, 3, services.Protection{Price: 102, Remainder: services.RemainderRest})
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, rested)
+	Assert(t, err, nil)
+	clock.Advance(time.Second)
+	seller := services.NewOrder(false, 1)
+	seller.UserID = "seller"
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, seller)
+	Assert(t, err, nil)
+	clock.Advance(time.Second)
+	// 103 is beyond the bound, so nothing fills and the order is cancelled.
+	bounded := protectedOrder(true, 1, services.Protection{Price: 102})
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, bounded)
+	Assert(t, err, nil)
+	cancel()
+	Assert(t, <-stopped, nil)
+
+	recording, _ := readRecording(t, path)
+	flow, err := services.RecordedFlow(recording.Events())
+	Assert(t, err, nil)
+	Assert(t, len(flow), 5)
+	Assert(t, flow[2], services.FlowEvent{Time: epoch.Add(time.Second).UnixNano(), Action: services.FlowTrade, ID: rested.ID,
+		UserID: "taker", Bid: true, Price: 102, Size: 3, Rest: true})
+	Assert(t, []services.FlowAction{flow[3].Action, flow[4].Action}, []services.FlowAction{services.FlowPlace, services.FlowTrade})
+	Assert(t, flow[3].Price, services.Money(0))
+	Assert(t, flow[4].Price, services.Money(102))
+	Assert(t, flow[4].Rest, false)
+
+	// The backtest trades what the exchange traded: 1 at 101, then the seller against the rest at 102.
+	report := services.NewBacktest(backtestConfig(services.LatencyModel{}, 1)).Run(flow)
+	Assert(t, report.Trades, 2)
+	Assert(t, report.Mark, services.Money(102))
+	Assert(t, report.Rejected, 0)
+}
+
+func TestRecordMarketsFlushesAndClosesEveryMarket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	exchange, _ := clockedExchange()
+	dir := t.TempDir()
+	path := func(market services.Market) string { return filepath.Join(dir, string(market)+".cxmd") }
+	stopped := make(chan error)
+	go func() {
+		stopped <- services.RecordMarkets(ctx, exchange.Events, 10*time.Millisecond, func(market services.Market) (*services.MarketRecorder, error) {
+			return services.CreateRecording(path(market), market)
+		})
+	}()
+	eventually(t, func() bool { return exchange.Events.SubscriberCount() == 1 })
+
+	placeFor(t, exchange, "maker", false, 101, 1)
+	// A market listed after the recording started is recorded too.
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{}), nil)
+	btc := services.NewOrder(true, 1)
+	btc.UserID = "maker"
+	Assert(t, exchange.PlaceLimitOrder(ctx, marketBTC, 1000, btc), nil)
+
+	// The timer flushes the records while the recordings are still open.
+	eventually(t, func() bool {
+		recording, err := services.OpenRecording(path(services.MarketETH))
+		if err != nil {
+			return false
+		}
+		defer recording.Close()
+		events, err := recording.Events().ReadAll()
+		return err == nil && len(events) > 0 && !recording.Complete
+	})
+
+	cancel()
+	Assert(t, <-stopped, nil)
+	for _, market := range []services.Market{services.MarketETH, marketBTC} {
+		recording, events := readRecording(t, path(market))
+		Assert(t, recording.Complete, true)
+		Assert(t, len(events) > 0, true)
+		Assert(t, events[0].Header().Market, market)
+	}
+}
+
+func TestReplayFeedsTheMarketWebSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eth.cxmd")
+	recorder, _ := services.CreateRecording(path, services.MarketETH)
+	recorder.Record(services.OrderAccepted{EventHeader: header(1, 0), OrderID: "o1", UserID: "alice", Type: services.OrderTypeLimit, Price: 100, Size: 1})
+	recorder.Record(services.LevelChanged{EventHeader: header(2, 0), Price: 100, TotalVolume: 1, OrderCount: 1})
+	recorder.Record(services.TradeExecuted{EventHeader: header(3, time.Second), TradeID: "t1", Price: 100, Size: 1, TakerBid: true,
+		BuyOrderID: "o2", SellOrderID: "o1", BuyerID: "bob", SellerID: "alice", TakerOrderID: "o2"})
+	Assert(t, recorder.Close(), nil)
+
+	bus := messaging.NewDispatcher()
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/markets/{market}", api.MarketFeed(bus))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/markets/ETH", nil)
+	Assert(t, err, nil)
+	defer conn.Close()
+	eventually(t, func() bool { return bus.SubscriberCount() == 1 })
+
+	recording, _ := services.OpenRecording(path)
+	defer recording.Close()
+	_, err = services.Replayer{Speed: 100}.Replay(context.Background(), recording.Events(), bus)
+	Assert(t, err, nil)
+
+	// The order event is private; the trade goes out without its orders and accounts.
+	var messages []map[string]interface{}
+	for len(messages) < 2 {
+		_, data, err := conn.ReadMessage()
+		Assert(t, err, nil)
+		var message map[string]interface{}
+		json.Unmarshal(data, &message)
+		messages = append(messages, message)
+	}
+	Assert(t, []interface{}{messages[0]["type"], messages[1]["type"]}, []interface{}{services.EventLevelChanged, services.EventTradeExecuted})
+	Assert(t, messages[1]["event"], map[string]interface{}{
+		"market": "ETH", "sequence": 3.0, "timestamp": float64(epoch.Add(time.Second).UnixNano()),
+		"tradeId": "t1", "price": 100.0, "size": 1.0, "takerBid": true,
+	})
+}
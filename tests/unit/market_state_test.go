@@ -0,0 +1,107 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func TestCircuitBreakerHaltsMarket(t *testing.T) {
+	ctx := context.Background()
+	exchange, clock := clockedExchange()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	far := placeFor(t, exchange, "maker", false, 120, 1)
+
+	first := services.NewOrder(true, 1)
+	_, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, first)
+	Assert(t, err, nil)
+
+	// 120 is 20% away from the reference fill at 100, beyond the 10% allowed.
+	second := services.NewOrder(true, 1)
+	matches, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, second)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 0)
+	Assert(t, far.Size, services.Money(1))
+
+	state, _ := exchange.Orders.Get(second.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+	Assert(t, state.Reason, string(services.CancelReasonCircuitBreaker))
+
+	status, err := exchange.MarketStatus(services.MarketETH)
+	Assert(t, err, nil)
+	Assert(t, status.State, services.MarketHalted)
+	Assert(t, status.Reason, services.StateReasonCircuitBreaker)
+
+	err = exchange.PlaceLimitOrder(ctx, services.MarketETH, 100, services.NewOrder(true, 1))
+	Assert(t, err, services.ErrMarketHalted)
+	// Resting orders can still be pulled during a halt.
+	Assert(t, exchange.CancelUserOrder(ctx, "maker", far.ID), nil)
+
+	// The halt ends with a reopening auction, which then uncrosses into continuous trading.
+	// The cooldown lasts five minutes.
+	Assert(t, exchange.ResumeMarkets(ctx, clock.Advance(5*time.Minute-time.Nanosecond)), nil)
+	status, _ = exchange.MarketStatus(services.MarketETH)
+	Assert(t, status.State, services.MarketHalted)
+	Assert(t, exchange.ResumeMarkets(ctx, clock.Advance(time.Nanosecond)), nil)
+	status, _ = exchange.MarketStatus(services.MarketETH)
+	Assert(t, status.State, services.MarketAuction)
+	Assert(t, status.Reason, services.StateReasonCooldownOver)
+
+	Assert(t, exchange.ResumeMarkets(ctx, clock.Advance(30*time.Second)), nil)
+	status, _ = exchange.MarketStatus(services.MarketETH)
+	Assert(t, status.State, services.MarketOpen)
+	Assert(t, status.Reason, services.StateReasonAuctionUncrossed)
+}
+
+func TestCircuitBreakerChecksFirstFillAfterQuietSpell(t *testing.T) {
+	ctx := context.Background()
+	exchange, clock := clockedExchange()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	far := placeFor(t, exchange, "maker", false, 150, 1)
+
+	_, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, services.NewOrder(true, 1))
+	Assert(t, err, nil)
+
+	// Nothing trades for longer than the window, so 150 is measured against the last trade at 100.
+	clock.Advance(2 * time.Minute)
+	matches, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, services.NewOrder(true, 1))
+	Assert(t, err, nil)
+	Assert(t, len(matches), 0)
+	Assert(t, far.Size, services.Money(1))
+
+	status, _ := exchange.MarketStatus(services.MarketETH)
+	Assert(t, status.State, services.MarketHalted)
+	Assert(t, status.Reason, services.StateReasonCircuitBreaker)
+}
+
+func TestAdminMarketStates(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	o := placeFor(t, exchange, "maker", false, 100, 1)
+
+	_, err := exchange.SetMarketState(ctx, services.MarketETH, services.MarketCancelOnly, 0)
+	Assert(t, err, nil)
+	err = exchange.PlaceLimitOrder(ctx, services.MarketETH, 100, services.NewOrder(false, 1))
+	Assert(t, err, services.ErrMarketCancelOnly)
+	Assert(t, exchange.CancelUserOrder(ctx, "maker", o.ID), nil)
+
+	status, err := exchange.SetMarketState(ctx, services.MarketETH, services.MarketHalted, time.Minute)
+	Assert(t, err, nil)
+	Assert(t, status.ResumeAt > status.Since, true)
+
+	// Opening a halted market starts its reopening auction, and opening it again uncrosses it.
+	status, err = exchange.SetMarketState(ctx, services.MarketETH, services.MarketOpen, 0)
+	Assert(t, err, nil)
+	Assert(t, status.State, services.MarketAuction)
+	status, err = exchange.SetMarketState(ctx, services.MarketETH, services.MarketOpen, 0)
+	Assert(t, err, nil)
+	Assert(t, status.State, services.MarketOpen)
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 100, services.NewOrder(false, 1)), nil)
+
+	_, err = exchange.SetMarketState(ctx, services.MarketETH, "closed", 0)
+	Assert(t, err, services.ErrUnknownMarketState)
+	_, err = exchange.SetMarketState(ctx, "DOGE", services.MarketHalted, 0)
+	Assert(t, err, services.ErrMarketNotFound)
+}
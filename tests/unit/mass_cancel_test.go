@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func TestCancelAllByAccount(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	first := placeFor(t, exchange, "maker", true, 90, 1)
+	second := placeFor(t, exchange, "maker", false, 110, 1)
+	other := placeFor(t, exchange, "other", false, 120, 1)
+
+	cancelled, err := exchange.CancelAll(ctx, services.CancelFilter{UserID: "maker"}, services.CancelReasonMassCancel)
+	Assert(t, err, nil)
+	sort.Strings(cancelled)
+	want := []string{first.ID, second.ID}
+	sort.Strings(want)
+	Assert(t, cancelled, want)
+
+	book := exchange.OrderBooks[services.MarketETH]
+	_, resting := book.GetOrder(other.ID)
+	Assert(t, resting, true)
+	Assert(t, len(book.RestingOrders()), 1)
+	Assert(t, len(book.Bids), 0)
+
+	state, _ := exchange.Orders.Get(first.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+}
+
+func TestCancelAllBySide(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	bid := placeFor(t, exchange, "maker", true, 90, 1)
+	placeFor(t, exchange, "maker", false, 110, 1)
+
+	cancelled, err := exchange.CancelAll(ctx, services.CancelFilter{UserID: "maker", Side: services.SideBid}, services.CancelReasonMassCancel)
+	Assert(t, err, nil)
+	Assert(t, cancelled, []string{bid.ID})
+	Assert(t, exchange.OrderBooks[services.MarketETH].TotalVolumeOfAsks(), services.Money(1))
+}
+
+func TestCancelAllByMarket(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+
+	_, err := exchange.CancelAll(ctx, services.CancelFilter{Market: "DOGE"}, services.CancelReasonMassCancel)
+	Assert(t, err, services.ErrMarketNotFound)
+
+	cancelled, err := exchange.CancelAll(ctx, services.CancelFilter{Market: services.MarketETH}, services.CancelReasonMassCancel)
+	Assert(t, err, nil)
+	Assert(t, cancelled, []string{})
+}
+
+func TestCancelAllIsRecorded(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store services.Store) {
+		exchange := services.NewCryptoExchangeService()
+		exchange.UseStore(store)
+		o := placeFor(t, exchange, "maker", false, 110, 2)
+
+		_, err := exchange.CancelAll(ctx, services.CancelFilter{UserID: "maker"}, services.CancelReasonMassCancel)
+		Assert(t, err, nil)
+
+		record, err := store.Orders().GetOrder(ctx, o.ID)
+		Assert(t, err, nil)
+		Assert(t, record.Status, services.StatusCancelled)
+		Assert(t, record.Price, services.Money(110))
+		Assert(t, record.Remaining, services.Money(2))
+	})
+}
+
+func TestCancelUserOrderChecksOwnership(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	o := placeFor(t, exchange, "maker", false, 110, 1)
+
+	Assert(t, exchange.CancelUserOrder(ctx, "other", o.ID), services.ErrOrderNotResting)
+	Assert(t, exchange.CancelUserOrder(ctx, "maker", o.ID), nil)
+	Assert(t, exchange.CancelUserOrder(ctx, "maker", o.ID), services.ErrOrderNotResting)
+}
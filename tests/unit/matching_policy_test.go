@@ -0,0 +1,140 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// restingAsks builds a book with the asks placed in the given order. The book's clock is fixed, so
+// every order is stamped with the same time and only the order of arrival decides priority.
+func restingAsks(matching services.MatchingAlgorithm, lot services.Money, prices []services.Money, sizes []services.Money) (*services.CompleteOrderBook, []*services.Order) {
+	book := services.NewOrderBook()
+	book.Config = services.MarketConfig{LotSize: lot, Matching: matching}
+	book.Clock = services.FixedClock{Time: epoch}
+	var orders []*services.Order
+	for i, price := range prices {
+		o := services.NewOrder(false, sizes[i])
+		book.PlaceLimitOrder(price, o)
+		orders = append(orders, o)
+	}
+	return book, orders
+}
+
+func sizesOf(orders []*services.Order) []services.Money {
+	var sizes []services.Money
+	for _, o := range orders {
+		sizes = append(sizes, o.Size)
+	}
+	return sizes
+}
+
+func TestFIFOFillsInArrivalOrder(t *testing.T) {
+	book, orders := restingAsks(services.MatchFIFO, 0, []services.Money{100, 100, 100}, []services.Money{2, 3, 1})
+
+	matches, err := book.PlaceMarketOrder(services.NewOrder(true, 4))
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, matches[0].Ask, orders[0])
+	Assert(t, matches[1].Ask, orders[1])
+	Assert(t, sizesOf(orders), []services.Money{0, 1, 1})
+	Assert(t, book.AskLimits[100].Orders, services.Orders{orders[1], orders[2]})
+}
+
+func TestDeleteOrderKeepsTimePriority(t *testing.T) {
+	book, orders := restingAsks(services.MatchFIFO, 0, []services.Money{100, 100, 100, 100}, []services.Money{1, 1, 1, 1})
+
+	book.CancelOrder(orders[1])
+	Assert(t, book.AskLimits[100].Orders, services.Orders{orders[0], orders[2], orders[3]})
+
+	_, err := book.PlaceMarketOrder(services.NewOrder(true, 2))
+	Assert(t, err, nil)
+	Assert(t, sizesOf(orders), []services.Money{0, 1, 0, 1})
+}
+
+func TestProRataAllocation(t *testing.T) {
+	level := services.NewLimit(100)
+	for _, size := range []services.Money{5, 3, 2} {
+		level.AddOrder(services.NewOrder(false, size))
+	}
+	policy := services.ProRata{}
+
+	// Shares of 2, 1.2 and 0.8 round down to whole lots; the lot left over goes to the oldest order.
+	Assert(t, policy.Allocate(level, 4, 1), []services.Money{3, 1, 0})
+	// Without a lot size, every order gets its exact share.
+	Assert(t, policy.Allocate(level, 4, 0), []services.Money{2, 1.2, 0.8})
+	// A size covering the whole level fills everyone.
+	Assert(t, policy.Allocate(level, 12, 1), []services.Money{5, 3, 2})
+}
+
+func TestProRataLeftoverSkipsFullOrders(t *testing.T) {
+	level := services.NewLimit(100)
+	for _, size := range []services.Money{1, 1, 8} {
+		level.AddOrder(services.NewOrder(false, size))
+	}
+
+	// Shares of 0.6, 0.6 and 4.8 round to 0, 0 and 4; the oldest order takes one of the two lots
+	// left, which fills it, so the other goes to the next order.
+	Assert(t, services.ProRata{}.Allocate(level, 6, 1), []services.Money{1, 1, 4})
+	// Shares of 0.3, 0.3 and 2.4 round to 0, 0 and 2; the one lot left goes to the oldest order.
+	Assert(t, services.ProRata{}.Allocate(level, 3, 1), []services.Money{1, 0, 2})
+}
+
+func TestProRataMarketOrder(t *testing.T) {
+	book, orders := restingAsks(services.MatchProRata, 0.5, []services.Money{100, 100, 101}, []services.Money{6, 2, 4})
+
+	// 5 at 100 is shared 3.75 and 1.25, rounded down to 3.5 and 1; the 0.5 left goes to the oldest order.
+	matches, err := book.PlaceMarketOrder(services.NewOrder(true, 5))
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, sizesOf(orders), []services.Money{2, 1, 4})
+
+	// 4 takes the 3 left at 100, then 1 at 101.
+	matches, err = book.PlaceMarketOrder(services.NewOrder(true, 4))
+	Assert(t, err, nil)
+	Assert(t, len(matches), 3)
+	Assert(t, sizesOf(orders), []services.Money{0, 0, 3})
+	Assert(t, matches[2].Price, services.Money(101))
+}
+
+func TestTopOrderFIFO(t *testing.T) {
+	for _, tc := range []struct {
+		matching services.MatchingAlgorithm
+		want     []services.Money
+	}{
+		// The amend sent the top order to the back of its level, behind the order that joined it.
+		{services.MatchFIFO, []services.Money{4, 1}},
+		// The top order keeps its priority through the amend.
+		{services.MatchTopOrderFIFO, []services.Money{3, 2}},
+	} {
+		t.Run(string(tc.matching), func(t *testing.T) {
+			book, orders := restingAsks(tc.matching, 0, []services.Money{100, 100}, []services.Money{1, 2})
+			Assert(t, book.AskLimits[100].Top, orders[0])
+
+			book.AmendOrder(orders[0], 100, 4)
+			Assert(t, book.AskLimits[100].Top, orders[0])
+
+			_, err := book.PlaceMarketOrder(services.NewOrder(true, 1))
+			Assert(t, err, nil)
+			Assert(t, []services.Money{orders[0].Size, orders[1].Size}, tc.want)
+		})
+	}
+}
+
+func TestTopOrderNeedsToLeadTheBook(t *testing.T) {
+	book, orders := restingAsks(services.MatchTopOrderFIFO, 0, []services.Money{100, 101, 101}, []services.Money{1, 1, 1})
+	Assert(t, book.AskLimits[100].Top, orders[0])
+	// 101 was behind 100 when it was created, so it has no top order.
+	Assert(t, book.AskLimits[101].Top, (*services.Order)(nil))
+
+	book.CancelOrder(orders[0])
+	_, err := book.PlaceMarketOrder(services.NewOrder(true, 1))
+	Assert(t, err, nil)
+	Assert(t, sizesOf(orders[1:]), []services.Money{0, 1})
+}
+
+func TestListMarketRejectsUnknownMatching(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{Matching: "lottery"}), services.ErrUnknownMatchingPolicy)
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{Matching: services.MatchProRata}), nil)
+}
@@ -0,0 +1,146 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+const marketETHBTC services.Market = "ETH-BTC"
+
+// routerExchange lists BTC and the ETH-BTC cross market next to ETH.
+func routerExchange(t *testing.T) *services.CryptoExchangeService {
+	exchange := services.NewCryptoExchangeService()
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	Assert(t, exchange.ListMarket(marketETHBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	return exchange
+}
+
+func restOn(t *testing.T, exchange *services.CryptoExchangeService, market services.Market, bid bool, price, size services.Money) {
+	o := services.NewOrder(bid, size)
+	o.UserID = "maker"
+	Assert(t, exchange.PlaceLimitOrder(context.Background(), market, price, o), nil)
+}
+
+func roundedFills(order services.RoutedOrder) [][3]services.Money {
+	var fills [][3]services.Money
+	for _, fill := range order.Fills {
+		fills = append(fills, [3]services.Money{rounded(fill.Size), rounded(fill.Quote), rounded(fill.Price)})
+	}
+	return fills
+}
+
+func TestMarketAssets(t *testing.T) {
+	base, quote := marketETHBTC.Assets()
+	Assert(t, []services.Asset{base, quote}, []services.Asset{"ETH", "BTC"})
+	base, quote = services.MarketETH.Assets()
+	Assert(t, []services.Asset{base, quote}, []services.Asset{"ETH", services.QuoteAsset})
+	Assert(t, services.MarketFor("ETH", "BTC"), marketETHBTC)
+	Assert(t, services.MarketFor("ETH", services.QuoteAsset), services.MarketETH)
+}
+
+func TestRouterSplitsBuyAcrossPaths(t *testing.T) {
+	exchange := routerExchange(t)
+	trader, _ := exchange.Users.Register("trader@example.com", "long enough")
+	trader.Wallet.Credit("BTC", 1)
+	// Directly, 1 ETH costs 0.1 BTC and the next ones 0.12.
+	restOn(t, exchange, marketETHBTC, false, 0.1, 1)
+	restOn(t, exchange, marketETHBTC, false, 0.12, 5)
+	// Through USD, ETH costs 105 and BTC sells for 1000, so 0.105 BTC.
+	restOn(t, exchange, services.MarketETH, false, 105, 5)
+	restOn(t, exchange, marketBTC, true, 1000, 10)
+
+	order, err := exchange.Router.Route(context.Background(), trader.ID, "ETH", "BTC", true, 2, false)
+	Assert(t, err, nil)
+	Assert(t, roundedFills(order), [][3]services.Money{{1, 0.1, 0.1}, {1, 0.105, 0.105}})
+	Assert(t, order.Fills[1].Legs, []services.ConvertLeg{{Market: marketBTC}, {Market: services.MarketETH, Bid: true}})
+	Assert(t, len(order.Fills[1].OrderIDs), 2)
+	Assert(t, rounded(order.AveragePrice), services.Money(0.1025))
+
+	Assert(t, rounded(trader.Wallet.Balance("ETH")), services.Money(2))
+	Assert(t, rounded(trader.Wallet.Balance("BTC")), services.Money(0.795))
+	Assert(t, rounded(trader.Wallet.Available("BTC")), services.Money(0.795))
+	Assert(t, rounded(trader.Wallet.Balance(services.QuoteAsset)), services.Money(0))
+	Assert(t, exchange.OrderBooks[marketETHBTC].AskLimits[0.12].TotalVolume, services.Money(5))
+}
+
+func TestRouterSplitsSellAcrossPaths(t *testing.T) {
+	exchange := routerExchange(t)
+	restOn(t, exchange, marketETHBTC, true, 0.1, 1)
+	restOn(t, exchange, marketETHBTC, true, 0.09, 5)
+	// Through USD, ETH sells for 98 and BTC costs 1000, so 0.098 BTC.
+	restOn(t, exchange, services.MarketETH, true, 98, 5)
+	restOn(t, exchange, marketBTC, false, 1000, 10)
+
+	order, err := exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", false, 2, false)
+	Assert(t, err, nil)
+	Assert(t, roundedFills(order), [][3]services.Money{{1, 0.1, 0.1}, {1, 0.098, 0.098}})
+	Assert(t, rounded(order.AveragePrice), services.Money(0.099))
+}
+
+func TestRouterDryRunLeavesBooks(t *testing.T) {
+	exchange := routerExchange(t)
+	restOn(t, exchange, marketETHBTC, false, 0.1, 1)
+	restOn(t, exchange, services.MarketETH, false, 105, 5)
+	restOn(t, exchange, marketBTC, true, 1000, 10)
+
+	order, err := exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 2, true)
+	Assert(t, err, nil)
+	Assert(t, roundedFills(order), [][3]services.Money{{1, 0.1, 0.1}, {1, 0.105, 0.105}})
+	Assert(t, order.Fills[0].OrderIDs, []string(nil))
+	Assert(t, exchange.OrderBooks[marketETHBTC].AskLimits[0.1].TotalVolume, services.Money(1))
+	Assert(t, exchange.OrderBooks[services.MarketETH].AskLimits[105].TotalVolume, services.Money(5))
+}
+
+func TestRouterWithoutDirectMarket(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	Assert(t, exchange.ListMarket(marketBTC, services.MarketConfig{LotSize: 0.0001}), nil)
+	restOn(t, exchange, services.MarketETH, false, 100, 5)
+	restOn(t, exchange, marketBTC, true, 1000, 10)
+
+	order, err := exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 1, false)
+	Assert(t, err, nil)
+	Assert(t, roundedFills(order), [][3]services.Money{{1, 0.1, 0.1}})
+}
+
+func TestRouterFailures(t *testing.T) {
+	exchange := routerExchange(t)
+	restOn(t, exchange, marketETHBTC, false, 0.1, 1)
+
+	_, err := exchange.Router.Route(context.Background(), "trader", "SOL", "BTC", true, 1, false)
+	Assert(t, errors.Is(err, services.ErrNoRoute), true)
+	_, err = exchange.Router.Route(context.Background(), "trader", "ETH", "ETH", true, 1, false)
+	Assert(t, err, services.ErrSameAsset)
+	_, err = exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 0, false)
+	Assert(t, services.IsInvalidOrder(err), true)
+
+	// Neither path can take 2 ETH: nothing trades.
+	_, err = exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 2, false)
+	Assert(t, err, services.ErrInsufficientLiquidity)
+	Assert(t, exchange.OrderBooks[marketETHBTC].AskLimits[0.1].TotalVolume, services.Money(1))
+}
+
+func TestRouterSlicesAreWholeLots(t *testing.T) {
+	exchange := routerExchange(t)
+	exchange.Router.Slices = 3
+	restOn(t, exchange, marketETHBTC, false, 0.1, 0.5)
+	restOn(t, exchange, marketETHBTC, false, 0.12, 5)
+	restOn(t, exchange, services.MarketETH, false, 105, 5)
+	restOn(t, exchange, marketBTC, true, 1000, 10)
+
+	// 1 ETH is three slices of 0.3333 and a last one of a single lot.
+	order, err := exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 1, false)
+	Assert(t, err, nil)
+	Assert(t, len(order.Fills), 2)
+	Assert(t, order.Fills[0].Size, services.Money(0.3334))
+	Assert(t, order.Fills[1].Size, services.Money(0.6666))
+
+	// A size that isn't whole lots is refused before anything is planned, dry run or not.
+	for _, dryRun := range []bool{true, false} {
+		_, err = exchange.Router.Route(context.Background(), "trader", "ETH", "BTC", true, 1.00005, dryRun)
+		Assert(t, errors.Is(err, services.ErrInvalidSize), true)
+	}
+	Assert(t, rounded(exchange.OrderBooks[marketETHBTC].AskLimits[0.1].TotalVolume), services.Money(0.1666))
+}
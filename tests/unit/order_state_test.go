@@ -0,0 +1,107 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func placeFor(t *testing.T, exchange *services.CryptoExchangeService, userID string, bid bool, price, size services.Money) *services.Order {
+	o := services.NewOrder(bid, size)
+	o.UserID = userID
+	if err := exchange.PlaceLimitOrder(context.Background(), services.MarketETH, price, o); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOrderStateTracksFills(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	cheap := placeFor(t, exchange, "maker", false, 100, 1)
+	dear := placeFor(t, exchange, "maker", false, 110, 2)
+
+	taker := services.NewOrder(true, 2)
+	taker.UserID = "taker"
+	_, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, taker)
+	Assert(t, err, nil)
+
+	state, ok := exchange.Orders.Get(taker.ID)
+	Assert(t, ok, true)
+	Assert(t, state.Status, services.StatusFilled)
+	Assert(t, state.Type, services.OrderTypeMarket)
+	Assert(t, state.Size, services.Money(2))
+	Assert(t, state.FilledSize, services.Money(2))
+	Assert(t, state.AveragePrice, services.Money(105))
+	Assert(t, len(state.Fills), 2)
+	Assert(t, state.Fills[0].Liquidity, services.LiquidityTaker)
+
+	state, _ = exchange.Orders.Get(cheap.ID)
+	Assert(t, state.Status, services.StatusFilled)
+	Assert(t, state.Fills[0].Liquidity, services.LiquidityMaker)
+
+	state, _ = exchange.Orders.Get(dear.ID)
+	Assert(t, state.Status, services.StatusPartiallyFilled)
+	Assert(t, state.FilledSize, services.Money(1))
+
+	Assert(t, exchange.CancelOrder(ctx, dear), nil)
+	state, _ = exchange.Orders.Get(dear.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+
+	rejected := services.NewOrder(true, 100)
+	rejected.UserID = "taker"
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, rejected)
+	if err == nil {
+		t.Fatal("Expected the oversized market order to be rejected")
+	}
+	state, _ = exchange.Orders.Get(rejected.ID)
+	Assert(t, state.Status, services.StatusRejected)
+}
+
+func TestOrderListingAndPagination(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	var placed []*services.Order
+	for i := 0; i < 5; i++ {
+		placed = append(placed, placeFor(t, exchange, "alice", true, services.Money(90+i), 1))
+	}
+	placeFor(t, exchange, "bob", true, 80, 1)
+	exchange.CancelOrder(context.Background(), placed[0])
+
+	open, next, err := exchange.Orders.List("alice", services.OrderFilter{Status: services.StatusFilterOpen}, "", 10)
+	Assert(t, err, nil)
+	Assert(t, len(open), 4)
+	Assert(t, next, "")
+	Assert(t, open[0].ID, placed[4].ID) // newest first
+
+	page, next, _ := exchange.Orders.List("alice", services.OrderFilter{}, "", 2)
+	Assert(t, []string{page[0].ID, page[1].ID}, []string{placed[4].ID, placed[3].ID})
+	page, next, _ = exchange.Orders.List("alice", services.OrderFilter{}, next, 2)
+	Assert(t, []string{page[0].ID, page[1].ID}, []string{placed[2].ID, placed[1].ID})
+	page, next, _ = exchange.Orders.List("alice", services.OrderFilter{}, next, 2)
+	Assert(t, len(page), 1)
+	Assert(t, page[0].Status, services.StatusCancelled)
+	Assert(t, next, "")
+
+	_, _, err = exchange.Orders.List("bob", services.OrderFilter{}, placed[0].ID, 2)
+	Assert(t, err, services.ErrInvalidCursor)
+}
+
+func TestExpiredOrdersArePulled(t *testing.T) {
+	exchange, clock := clockedExchange()
+	gtt := services.NewOrder(false, 1)
+	gtt.ExpiresAt = epoch.Add(time.Minute).UnixNano()
+	exchange.PlaceLimitOrder(context.Background(), services.MarketETH, 100, gtt)
+	gtc := placeFor(t, exchange, "alice", false, 101, 1)
+
+	expired, _ := exchange.ExpireOrders(context.Background(), clock.Advance(time.Minute-time.Nanosecond))
+	Assert(t, expired, 0)
+	expired, _ = exchange.ExpireOrders(context.Background(), clock.Advance(time.Nanosecond))
+	Assert(t, expired, 1)
+
+	state, _ := exchange.Orders.Get(gtt.ID)
+	Assert(t, state.Status, services.StatusExpired)
+	_, resting := exchange.OrderBooks[services.MarketETH].GetOrder(gtc.ID)
+	Assert(t, resting, true)
+}
@@ -0,0 +1,133 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func protectedOrder(bid bool, size services.Money, protection services.Protection) *services.Order {
+	o := services.NewOrder(bid, size)
+	o.UserID = "taker"
+	o.Protection = protection
+	return o
+}
+
+func TestProtectionPriceCancelsRemainder(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	placeFor(t, exchange, "maker", false, 101, 1)
+	placeFor(t, exchange, "maker", false, 103, 5)
+
+	o := protectedOrder(true, 3, services.Protection{Price: 101})
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, services.AveragePrice(matches), services.Money(100.5))
+
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+	Assert(t, state.Reason, string(services.CancelReasonPriceProtection))
+	Assert(t, state.FilledSize, services.Money(2))
+	Assert(t, exchange.OrderBooks[services.MarketETH].AskLimits[103].TotalVolume, services.Money(5))
+}
+
+func TestSlippageRestsRemainderAtBound(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	placeFor(t, exchange, "maker", false, 101, 1)
+	placeFor(t, exchange, "maker", false, 103, 5)
+
+	// 1% from the best ask of 100 bounds the order at 101.
+	o := protectedOrder(true, 3, services.Protection{MaxSlippageBps: 100, Remainder: services.RemainderRest})
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+
+	book := exchange.OrderBooks[services.MarketETH]
+	resting, ok := book.GetOrder(o.ID)
+	Assert(t, ok, true)
+	Assert(t, resting.Size, services.Money(1))
+	Assert(t, book.BidLimits[101].Orders, services.Orders{o})
+
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Status, services.StatusPartiallyFilled)
+	Assert(t, state.Price, services.Money(101))
+}
+
+func TestTighterBoundApplies(t *testing.T) {
+	book := services.NewOrderBook()
+	book.Config = services.MarketConfig{TickSize: 0.01}
+	book.PlaceLimitOrder(100, services.NewOrder(true, 1))
+	book.PlaceLimitOrder(99.8, services.NewOrder(true, 1))
+	book.PlaceLimitOrder(99.5, services.NewOrder(true, 1))
+
+	// 30 bps below 100 is 99.7, tighter than the protection price of 99.
+	o := protectedOrder(false, 3, services.Protection{Price: 99, MaxSlippageBps: 30})
+	matches, err := book.PlaceMarketOrder(o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, o.Size, services.Money(1))
+	Assert(t, services.AveragePrice(matches), services.Money(99.9))
+}
+
+func TestBoundedOrderSkipsLiquidityCheck(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+
+	o := protectedOrder(true, 5, services.Protection{Price: 105})
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 1)
+	Assert(t, o.Size, services.Money(4))
+}
+
+func TestSlippageWithoutBestPriceFillsNothing(t *testing.T) {
+	book := services.NewOrderBook()
+	o := protectedOrder(true, 1, services.Protection{MaxSlippageBps: 50, Remainder: services.RemainderRest})
+	matches, err := book.PlaceMarketOrder(o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 0)
+	_, resting := book.GetOrder(o.ID)
+	Assert(t, resting, false)
+}
+
+func TestInvalidProtectionIsRejected(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+
+	for _, protection := range []services.Protection{
+		{Price: -1},
+		{MaxSlippageBps: -5},
+		{Price: 101, Remainder: "keep"},
+		{Remainder: services.RemainderRest},
+		{Price: 100.337, Remainder: services.RemainderRest},
+	} {
+		o := protectedOrder(true, 1, protection)
+		_, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+		Assert(t, services.IsInvalidOrder(err), true)
+		state, _ := exchange.Orders.Get(o.ID)
+		Assert(t, state.Status, services.StatusRejected)
+	}
+}
+
+func TestRemainderTooSmallToRestIsCancelled(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	placeFor(t, exchange, "maker", false, 102, 1)
+
+	// Filling 1 at 100 leaves 0.005 at the bound of 101, half the market's minimum notional.
+	o := protectedOrder(true, 1.005, services.Protection{Price: 101, Remainder: services.RemainderRest})
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 1)
+
+	book := exchange.OrderBooks[services.MarketETH]
+	_, resting := book.GetOrder(o.ID)
+	Assert(t, resting, false)
+	Assert(t, len(book.Bids), 0)
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Status, services.StatusCancelled)
+	Assert(t, state.Reason, string(services.CancelReasonPriceProtection))
+}
@@ -0,0 +1,84 @@
+package unit
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+// rounded drops the float noise of quote arithmetic below a millionth.
+func rounded(m services.Money) services.Money {
+	return services.Money(math.Round(float64(m)*1e6) / 1e6)
+}
+
+func quoteOrder(bid bool, notional services.Money) *services.Order {
+	o := services.NewOrder(bid, 0)
+	o.UserID = "taker"
+	o.Notional = notional
+	return o
+}
+
+func TestQuoteSizedBuy(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	placeFor(t, exchange, "maker", false, 102, 2)
+
+	// 100 buys the whole first level; the 150 left buys 1.4705 at 102 in lots of 0.0001.
+	o := quoteOrder(true, 250)
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, rounded(o.InitialSize), services.Money(2.4705))
+	Assert(t, o.Status(), services.StatusFilled)
+	Assert(t, rounded(services.Unspent(o, matches)), services.Money(0.009))
+
+	state, _ := exchange.Orders.Get(o.ID)
+	Assert(t, state.Notional, services.Money(250))
+	Assert(t, rounded(state.FilledSize), services.Money(2.4705))
+}
+
+func TestQuoteSizedSell(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", true, 100, 1)
+	placeFor(t, exchange, "maker", true, 99, 5)
+
+	// Selling for at most 150: 1 at 100, then 50 worth at 99 is 0.505 once rounded down to the lot.
+	o := quoteOrder(false, 150)
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, rounded(o.InitialSize), services.Money(1.505))
+	Assert(t, rounded(services.Unspent(o, matches)), services.Money(0.005))
+}
+
+func TestQuoteSizedOrderStopsAtBound(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+	placeFor(t, exchange, "maker", false, 102, 2)
+
+	o := quoteOrder(true, 1000)
+	o.Protection = services.Protection{Price: 101}
+	matches, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+	Assert(t, err, nil)
+	Assert(t, o.InitialSize, services.Money(1))
+	Assert(t, services.Unspent(o, matches), services.Money(900))
+}
+
+func TestQuoteSizedOrderRejections(t *testing.T) {
+	exchange := services.NewCryptoExchangeService()
+	placeFor(t, exchange, "maker", false, 100, 1)
+
+	tooSmall := quoteOrder(true, 0.005)
+	both := quoteOrder(true, 100)
+	both.Size, both.InitialSize = 1, 1
+	negative := quoteOrder(true, -100)
+
+	for _, o := range []*services.Order{tooSmall, both, negative} {
+		_, err := exchange.PlaceMarketOrder(context.Background(), services.MarketETH, o)
+		Assert(t, services.IsInvalidOrder(err), true)
+		state, _ := exchange.Orders.Get(o.ID)
+		Assert(t, state.Status, services.StatusRejected)
+	}
+	Assert(t, exchange.OrderBooks[services.MarketETH].AskLimits[100].TotalVolume, services.Money(1))
+}
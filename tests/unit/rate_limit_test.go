@@ -0,0 +1,69 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/theghostmac/cryptex/web/middlewares"
+)
+
+func newLimitedHandler(config middlewares.RateLimitConfig) (*middlewares.RateLimiter, http.Handler) {
+	limiter := middlewares.NewRateLimiter(config)
+	ok := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	})
+	return limiter, limiter.Middleware(middlewares.ClassOrders)(ok)
+}
+
+func sendLimited(handler http.Handler, apiKey, remoteAddr string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/cryptoexchange/trade", nil)
+	request.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		request.Header.Set(middlewares.APIKeyHeader, apiKey)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRateLimitPerKey(t *testing.T) {
+	config := middlewares.RateLimitConfig{Classes: map[middlewares.RouteClass]middlewares.ClassLimits{
+		middlewares.ClassOrders: {
+			PerKey: middlewares.Limit{Rate: 0.001, Burst: 2},
+			PerIP:  middlewares.Limit{Rate: 0.001, Burst: 100},
+		},
+	}}
+	_, handler := newLimitedHandler(config)
+
+	first := sendLimited(handler, "alice", "10.0.0.1:1000")
+	Assert(t, first.Code, http.StatusOK)
+	Assert(t, first.Header().Get("X-RateLimit-Limit"), "2")
+	Assert(t, first.Header().Get("X-RateLimit-Remaining"), "1")
+
+	Assert(t, sendLimited(handler, "alice", "10.0.0.1:1000").Code, http.StatusOK)
+
+	throttled := sendLimited(handler, "alice", "10.0.0.2:1000")
+	Assert(t, throttled.Code, http.StatusTooManyRequests)
+	Assert(t, throttled.Header().Get("X-RateLimit-Remaining"), "0")
+	if throttled.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on a throttled response")
+	}
+
+	// Another key from the same IP still has its own budget.
+	Assert(t, sendLimited(handler, "bob", "10.0.0.1:1000").Code, http.StatusOK)
+}
+
+func TestRateLimitPerIPAndReload(t *testing.T) {
+	config := middlewares.RateLimitConfig{Classes: map[middlewares.RouteClass]middlewares.ClassLimits{
+		middlewares.ClassOrders: {PerIP: middlewares.Limit{Rate: 0.001, Burst: 1}},
+	}}
+	limiter, handler := newLimitedHandler(config)
+
+	Assert(t, sendLimited(handler, "", "10.0.0.1:1000").Code, http.StatusOK)
+	Assert(t, sendLimited(handler, "", "10.0.0.1:2000").Code, http.StatusTooManyRequests)
+
+	// Dropping the class from the configuration lifts the limit without a restart.
+	limiter.Reload(middlewares.RateLimitConfig{})
+	Assert(t, sendLimited(handler, "", "10.0.0.1:1000").Code, http.StatusOK)
+}
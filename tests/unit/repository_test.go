@@ -0,0 +1,214 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/repositories"
+)
+
+// forEachStore runs a test against every Store implementation.
+func forEachStore(t *testing.T, test func(t *testing.T, store services.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, repositories.NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := repositories.OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "cryptex.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		test(t, store)
+	})
+}
+
+func TestStoreOrders(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store services.Store) {
+		ctx := context.Background()
+		order := services.OrderRecord{ID: "o1", UserID: "alice", Market: services.MarketETH, Bid: true,
+			Price: 2_000, Size: 3, Remaining: 3, Status: services.StatusNew, CreatedAt: 1, UpdatedAt: 1}
+		Assert(t, store.Orders().SaveOrder(ctx, order), nil)
+
+		order.Remaining = 1
+		order.Status = services.StatusPartiallyFilled
+		Assert(t, store.Orders().SaveOrder(ctx, order), nil)
+
+		saved, err := store.Orders().GetOrder(ctx, "o1")
+		Assert(t, err, nil)
+		Assert(t, saved, order)
+
+		// An amend moves the price and the size, and both stores keep them.
+		order.Price, order.Size, order.Remaining, order.UpdatedAt = 2_010, 4, 2, 2
+		Assert(t, store.Orders().SaveOrder(ctx, order), nil)
+		saved, err = store.Orders().GetOrder(ctx, "o1")
+		Assert(t, err, nil)
+		Assert(t, []services.Money{saved.Price, saved.Size, saved.Remaining}, []services.Money{2_010, 4, 2})
+		Assert(t, saved, order)
+
+		_, err = store.Orders().GetOrder(ctx, "missing")
+		Assert(t, err, services.ErrNotFound)
+
+		orders, _ := store.Orders().ListOrdersByUser(ctx, "alice")
+		Assert(t, len(orders), 1)
+	})
+}
+
+func TestStoreSettleTrade(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store services.Store) {
+		ctx := context.Background()
+		trade := services.Trade{ID: "t1", Market: services.MarketETH, Price: 2_000, Size: 2,
+			BuyOrderID: "b", SellOrderID: "s", BuyerID: "alice", SellerID: "bob", ExecutedAt: 10}
+
+		_, err := services.SettleTrade(ctx, store, trade, "ETH", "USD")
+		Assert(t, err, nil)
+
+		alice, _ := store.Balances().GetBalances(ctx, "alice")
+		Assert(t, alice, map[services.Asset]services.Money{"ETH": 2, "USD": -4_000})
+		bob, _ := store.Balances().GetBalances(ctx, "bob")
+		Assert(t, bob, map[services.Asset]services.Money{"ETH": -2, "USD": 4_000})
+
+		ledger, _ := store.Balances().ListLedger(ctx, "alice")
+		Assert(t, len(ledger), 2)
+		trades, _ := store.Trades().ListTrades(ctx, services.MarketETH, 0, 100)
+		Assert(t, trades, []services.Trade{trade})
+	})
+}
+
+func TestStoreRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store services.Store) {
+		ctx := context.Background()
+		order := services.OrderRecord{ID: "o1", UserID: "bob", Market: services.MarketETH, Price: 2_000, Size: 1, Remaining: 1, Status: services.StatusNew}
+		account := services.AccountRecord{ID: "bob", Email: "bob@example.com", PasswordHash: []byte("hash"), CreatedAt: 1}
+		Assert(t, store.Orders().SaveOrder(ctx, order), nil)
+		Assert(t, store.Accounts().SaveAccount(ctx, account), nil)
+		Assert(t, store.Balances().ApplyEntry(ctx, services.LedgerEntry{ID: "l0", UserID: "bob", Asset: "USD", Amount: 10}), nil)
+
+		failure := errors.New("boom")
+		err := store.InTransaction(ctx, func(tx services.Repositories) error {
+			tx.Trades().SaveTrade(ctx, services.Trade{ID: "t1", Market: services.MarketETH, ExecutedAt: 1})
+			tx.Balances().ApplyEntry(ctx, services.LedgerEntry{ID: "l1", UserID: "alice", Asset: "USD", Amount: 5})
+			tx.Balances().ApplyEntry(ctx, services.LedgerEntry{ID: "l2", UserID: "bob", Asset: "USD", Amount: -4})
+			amended := order
+			amended.Remaining, amended.Status = 0, services.StatusFilled
+			tx.Orders().SaveOrder(ctx, amended)
+			renamed := account
+			renamed.Email = "robert@example.com"
+			tx.Accounts().SaveAccount(ctx, renamed)
+			return failure
+		})
+		Assert(t, err, failure)
+
+		trades, _ := store.Trades().ListTrades(ctx, services.MarketETH, 0, 100)
+		Assert(t, len(trades), 0)
+		balances, _ := store.Balances().GetBalances(ctx, "alice")
+		Assert(t, len(balances), 0)
+		balances, _ = store.Balances().GetBalances(ctx, "bob")
+		Assert(t, balances, map[services.Asset]services.Money{"USD": 10})
+		ledger, _ := store.Balances().ListLedger(ctx, "bob")
+		Assert(t, len(ledger), 1)
+		saved, _ := store.Orders().GetOrder(ctx, "o1")
+		Assert(t, saved.Status, services.StatusNew)
+		Assert(t, saved.Remaining, services.Money(1))
+		_, err = store.Accounts().GetAccountByEmail(ctx, "robert@example.com")
+		Assert(t, err, services.ErrNotFound)
+		found, _ := store.Accounts().GetAccountByEmail(ctx, "bob@example.com")
+		Assert(t, found, account)
+	})
+}
+
+func TestSQLiteMigrationsSurviveReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cryptex.db")
+	store, err := repositories.OpenSQLite(ctx, path)
+	Assert(t, err, nil)
+	account := services.AccountRecord{ID: "u1", Email: "a@example.com", PasswordHash: []byte("hash"), CreatedAt: 1}
+	Assert(t, store.Accounts().SaveAccount(ctx, account), nil)
+	store.Close()
+
+	store, err = repositories.OpenSQLite(ctx, path)
+	Assert(t, err, nil)
+	defer store.Close()
+	saved, err := store.Accounts().GetAccountByEmail(ctx, "a@example.com")
+	Assert(t, err, nil)
+	Assert(t, saved, account)
+}
+
+func TestMarketOrderIsSettledAndPersisted(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	exchange := services.NewCryptoExchangeService()
+	exchange.UseStore(store)
+	seller, _ := exchange.Users.Register("seller@example.com", "long enough")
+	buyer, _ := exchange.Users.Register("buyer@example.com", "long enough")
+	_, err := exchange.Users.Deposit(ctx, seller.ID, "ETH", 5)
+	Assert(t, err, nil)
+	_, err = exchange.Users.Deposit(ctx, buyer.ID, "USD", 5_000)
+	Assert(t, err, nil)
+
+	ask := services.NewOrder(false, 5)
+	ask.UserID = seller.ID
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 2_000, ask), nil)
+
+	bid := services.NewOrder(true, 2)
+	bid.UserID = buyer.ID
+	matches, err := exchange.PlaceMarketOrder(ctx, services.MarketETH, bid)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 1)
+	Assert(t, matches[0].Bid, bid)
+	Assert(t, matches[0].Ask, ask)
+
+	Assert(t, buyer.Wallet.Balance("ETH"), services.Money(2))
+	Assert(t, buyer.Wallet.Balance("USD"), services.Money(1_000))
+	Assert(t, buyer.Wallet.Available("USD"), services.Money(1_000))
+	Assert(t, seller.Wallet.Balance("USD"), services.Money(4_000))
+	// The rest of the ask still holds its 3 ETH.
+	Assert(t, seller.Wallet.Balance("ETH"), services.Money(3))
+	Assert(t, seller.Wallet.Available("ETH"), services.Money(0))
+	balances, _ := store.Balances().GetBalances(ctx, buyer.ID)
+	Assert(t, balances, map[services.Asset]services.Money{"ETH": 2, "USD": 1_000})
+
+	record, _ := store.Orders().GetOrder(ctx, ask.ID)
+	Assert(t, record.Status, services.StatusPartiallyFilled)
+	Assert(t, record.Remaining, services.Money(3))
+	record, _ = store.Orders().GetOrder(ctx, bid.ID)
+	Assert(t, record.Status, services.StatusFilled)
+
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, services.NewOrder(true, 10))
+	if !errors.Is(err, services.ErrInsufficientLiquidity) {
+		t.Errorf("Expected ErrInsufficientLiquidity, got %v", err)
+	}
+}
+
+func TestUnfundedOrdersAreRejected(t *testing.T) {
+	ctx := context.Background()
+	exchange := services.NewCryptoExchangeService()
+	trader, _ := exchange.Users.Register("trader@example.com", "long enough")
+	placeFor(t, exchange, "maker", false, 100, 5)
+	orderFor := func(userID string, bid bool, size services.Money) *services.Order {
+		o := services.NewOrder(bid, size)
+		o.UserID = userID
+		return o
+	}
+
+	bid := orderFor(trader.ID, true, 1)
+	err := exchange.PlaceLimitOrder(ctx, services.MarketETH, 99, bid)
+	Assert(t, errors.Is(err, services.ErrInsufficientBalance), true)
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, orderFor(trader.ID, true, 1))
+	Assert(t, errors.Is(err, services.ErrInsufficientBalance), true)
+	state, _ := exchange.Orders.Get(bid.ID)
+	Assert(t, state.Status, services.StatusRejected)
+	Assert(t, exchange.OrderBooks[services.MarketETH].AskLimits[100].TotalVolume, services.Money(5))
+
+	// Funds held by a resting order can't be spent twice.
+	trader.Wallet.Credit("USD", 150)
+	Assert(t, exchange.PlaceLimitOrder(ctx, services.MarketETH, 99, orderFor(trader.ID, true, 1)), nil)
+	Assert(t, trader.Wallet.Available("USD"), services.Money(51))
+	_, err = exchange.PlaceMarketOrder(ctx, services.MarketETH, orderFor(trader.ID, true, 1))
+	Assert(t, errors.Is(err, services.ErrInsufficientBalance), true)
+	ask := orderFor(trader.ID, false, 1)
+	Assert(t, errors.Is(exchange.PlaceLimitOrder(ctx, services.MarketETH, 101, ask), services.ErrInsufficientBalance), true)
+	Assert(t, trader.Wallet.Balance("USD"), services.Money(150))
+}
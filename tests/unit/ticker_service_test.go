@@ -0,0 +1,58 @@
+package unit
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func TestTickerRollingWindow(t *testing.T) {
+	tickers := services.NewTickerService()
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	tickers.AddTrade(services.MarketETH, 100, 1, start)
+	tickers.AddTrade(services.MarketETH, 120, 1, start.Add(time.Hour))
+	tickers.AddTrade(services.MarketETH, 90, 2, start.Add(2*time.Hour))
+	tickers.AddTrade(services.MarketETH, 110, 1, start.Add(3*time.Hour))
+
+	ticker := tickers.Ticker(services.MarketETH, nil, start.Add(4*time.Hour))
+	Assert(t, ticker.LastPrice, services.Money(110))
+	Assert(t, []services.Money{ticker.Open, ticker.High, ticker.Low, ticker.Close}, []services.Money{100, 120, 90, 110})
+	Assert(t, ticker.Volume, services.Money(5))
+	Assert(t, ticker.QuoteVolume, services.Money(100+120+180+110))
+	Assert(t, ticker.VWAP, services.Money(102))
+	Assert(t, ticker.PriceChangePercent, 10.0)
+	Assert(t, ticker.Trades, 4)
+
+	// A day after the first trade, it and the 120 high have slid out of the window.
+	ticker = tickers.Ticker(services.MarketETH, nil, start.Add(24*time.Hour+90*time.Minute))
+	Assert(t, []services.Money{ticker.Open, ticker.High, ticker.Low, ticker.Close}, []services.Money{90, 110, 90, 110})
+	Assert(t, ticker.Volume, services.Money(3))
+	if math.Abs(ticker.PriceChangePercent-22.22) > 0.01 {
+		t.Errorf("Expected a 22.22%% change, got %.4f", ticker.PriceChangePercent)
+	}
+
+	// Once the window is empty the last price is still reported.
+	ticker = tickers.Ticker(services.MarketETH, nil, start.Add(48*time.Hour))
+	Assert(t, ticker.Volume, services.Money(0))
+	Assert(t, ticker.LastPrice, services.Money(110))
+	Assert(t, ticker.Open, services.Money(110))
+}
+
+func TestTickerTopOfBook(t *testing.T) {
+	tickers := services.NewTickerService()
+	orderBook := services.NewOrderBook()
+	orderBook.PlaceLimitOrder(99, services.NewOrder(true, 2))
+	orderBook.PlaceLimitOrder(98, services.NewOrder(true, 5))
+	orderBook.PlaceLimitOrder(101, services.NewOrder(false, 3))
+	orderBook.PlaceLimitOrder(101, services.NewOrder(false, 1))
+	orderBook.PlaceLimitOrder(105, services.NewOrder(false, 7))
+
+	ticker := tickers.Ticker(services.MarketETH, orderBook, time.Now())
+	Assert(t, ticker.BestBid, services.Money(99))
+	Assert(t, ticker.BestBidSize, services.Money(2))
+	Assert(t, ticker.BestAsk, services.Money(101))
+	Assert(t, ticker.BestAskSize, services.Money(4))
+}
@@ -1,11 +1,13 @@
 package unit
 
 import (
-	"fmt"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/theghostmac/cryptex/internal/app/services"
+	"github.com/theghostmac/cryptex/internal/infrastructure/messaging"
 )
 
 // Assert function replaces the testify framework for me.
@@ -15,23 +17,26 @@ func Assert(t *testing.T, firstParam, secondParam any) {
 	}
 }
 
-// TestNewLimit is a general test for everything.
+// TestNewLimit checks that a limit queues its orders in arrival order, and keeps the others in
+// place when one leaves.
 func TestNewLimit(t *testing.T) {
-	// Arrange.
+	clock := services.NewManualClock(epoch)
 	testLimit := services.NewLimit(10_000)
-	buyOrderA := services.NewOrder(true, 5)
-	buyOrderB := services.NewOrder(true, 6)
-	buyOrderC := services.NewOrder(true, 7)
-	// Assert.
+	buyOrderA := services.NewOrderAt(true, 5, clock.Now().UnixNano())
+	buyOrderB := services.NewOrderAt(true, 6, clock.Advance(time.Millisecond).UnixNano())
+	buyOrderC := services.NewOrderAt(true, 7, clock.Advance(time.Millisecond).UnixNano())
 	testLimit.AddOrder(buyOrderA)
 	testLimit.AddOrder(buyOrderB)
 	testLimit.AddOrder(buyOrderC)
+	Assert(t, testLimit.Orders, services.Orders{buyOrderA, buyOrderB, buyOrderC})
+	Assert(t, testLimit.TotalVolume, services.Money(18))
 
-	// Delete Order
 	testLimit.DeleteOrder(buyOrderB)
-
-	// Optionally, you can print the testLimit value for debugging
-	fmt.Println(testLimit)
+	Assert(t, testLimit.Orders, services.Orders{buyOrderA, buyOrderC})
+	Assert(t, testLimit.TotalVolume, services.Money(12))
+	Assert(t, buyOrderB.Limit, (*services.Limit)(nil))
+	Assert(t, buyOrderA.TimeStamp, epoch.UnixNano())
+	Assert(t, buyOrderC.TimeStamp, epoch.Add(2*time.Millisecond).UnixNano())
 }
 
 func Test_NewLimit(t *testing.T) {
@@ -73,18 +78,10 @@ func Test_NewOrderBook(t *testing.T) {
 	}
 }
 
-//func TestOrderString(t *testing.T) {
-//	// Create a new order with a size
-//	size := services.Money(0.0)
-//	order := services.NewOrder(true, size)
-//
-//	// Check if OrderString returns the correct formatted string
-//	expected := "[size: 10.00]"
-//	result := order.OrderString()
-//	if result != expected {
-//		t.Errorf("Expected %s, got %s", expected, result)
-//	}
-//}
+func TestOrderString(t *testing.T) {
+	order := services.NewOrder(true, 10)
+	Assert(t, order.OrderString(), "[size: 10.00]")
+}
 
 func TestNewOrder(t *testing.T) {
 	// Create a new order with a bid and size
@@ -121,63 +118,140 @@ func TestPlaceLimitOrder(t *testing.T) {
 	// --> fails, nice <---
 }
 
-//func TestPlaceMarketOrder(t *testing.T) {
-//	orderBook := services.NewOrderBook()
-//
-//	// provide liquidity
-//	sellOrder := services.NewOrder(false, 20)
-//	orderBook.PlaceLimitOrder(10_000, sellOrder)
-//
-//	// Trying a test that would fail.
-//	buyOrder := services.NewOrder(true, 100) // change size to >20 to see failing test case.
-//	matches := orderBook.PlaceMarketOrder(buyOrder)
-//
-//	Assert(t, len(matches), 1)
-//	Assert(t, len(orderBook.Asks), 1)
-//	Assert(t, orderBook.TotalVolumeOfAsks(), 10.0)
-//	Assert(t, matches[0].Bid, buyOrder)
-//	Assert(t, matches[0].SizeFilled, 10.0)
-//	Assert(t, matches[0].Price, 10_000.0)
-//	Assert(t, buyOrder.IsFilled(), true)
-//
-//	fmt.Printf("%+v", matches)
-//}
-
-//func TestPlaceMarketOrderByAWhale(t *testing.T) {
-//	orderBook := services.NewOrderBook()
-//
-//	buyOrderA := services.NewOrder(true, 5)
-//	buyOrderB := services.NewOrder(true, 8)
-//	buyOrderC := services.NewOrder(true, 10)
-//	buyOrderD := services.NewOrder(true, 1)
-//
-//	// Make 3 markets with different price levels
-//	orderBook.PlaceLimitOrder(5_000, buyOrderC)
-//	orderBook.PlaceLimitOrder(5_000, buyOrderD)
-//	orderBook.PlaceLimitOrder(9_000, buyOrderB)
-//	orderBook.PlaceLimitOrder(10_000, buyOrderA)
-//
-//	Assert(t, orderBook.TotalVolumeOfBid(), 24.00)
-//
-//	sellOrder := services.NewOrder(false, 20)
-//	matches := orderBook.PlaceMarketOrder(sellOrder)
-//
-//	Assert(t, orderBook.TotalVolumeOfBid(), 4.0)
-//	Assert(t, len(matches), 3)
-//	Assert(t, len(orderBook.Bids), 1)
-//
-//	fmt.Printf("%+v", matches)
-//}
-
-//func TestCancelOrder(t *testing.T) {
-//	orderBook := services.NewOrderBook()
-//
-//	buyOrder := services.NewOrder(true, 4)
-//	orderBook.PlaceLimitOrder(10_000.0, buyOrder)
-//
-//	Assert(t, orderBook.TotalVolumeOfBid(), 4.0)
-//
-//	orderBook.CancelOrder(buyOrder)
-//
-//	Assert(t, orderBook.TotalVolumeOfBid(), 0.0)
-//}
+// clockedBook creates an order book stamping its orders with a manual clock reading epoch.
+func clockedBook() (*services.CompleteOrderBook, *services.ManualClock) {
+	clock := services.NewManualClock(epoch)
+	book := services.NewOrderBook()
+	book.Clock = clock
+	return book, clock
+}
+
+func TestPlaceMarketOrder(t *testing.T) {
+	orderBook, clock := clockedBook()
+
+	// The worse price arrives first, but price comes before time.
+	worse := services.NewOrder(false, 5)
+	orderBook.PlaceLimitOrder(10_001, worse)
+	first := services.NewOrder(false, 20)
+	clock.Advance(time.Millisecond)
+	orderBook.PlaceLimitOrder(10_000, first)
+	second := services.NewOrder(false, 5)
+	clock.Advance(time.Millisecond)
+	orderBook.PlaceLimitOrder(10_000, second)
+	Assert(t, orderBook.AskLimits[10_000].Orders, services.Orders{first, second})
+	Assert(t, first.TimeStamp, epoch.Add(time.Millisecond).UnixNano())
+	Assert(t, second.TimeStamp, epoch.Add(2*time.Millisecond).UnixNano())
+
+	buyOrder := services.NewOrder(true, 22)
+	clock.Advance(time.Millisecond)
+	matches, err := orderBook.PlaceMarketOrder(buyOrder)
+	Assert(t, err, nil)
+	Assert(t, buyOrder.TimeStamp, epoch.Add(3*time.Millisecond).UnixNano())
+	Assert(t, buyOrder.IsFilled(), true)
+
+	// The earlier order at 10,000 fills completely before the later one is touched.
+	Assert(t, len(matches), 2)
+	Assert(t, matches[0].Ask, first)
+	Assert(t, matches[0].Bid, buyOrder)
+	Assert(t, matches[0].SizeFilled, services.Money(20))
+	Assert(t, matches[0].Price, services.Money(10_000))
+	Assert(t, matches[1].Ask, second)
+	Assert(t, matches[1].SizeFilled, services.Money(2))
+
+	Assert(t, orderBook.AskLimits[10_000].Orders, services.Orders{second})
+	Assert(t, second.Size, services.Money(3))
+	Assert(t, worse.Size, services.Money(5))
+	Assert(t, orderBook.TotalVolumeOfAsks(), services.Money(8))
+}
+
+func TestPlaceMarketOrderByAWhale(t *testing.T) {
+	orderBook, clock := clockedBook()
+
+	buyOrderA := services.NewOrder(true, 5)
+	buyOrderB := services.NewOrder(true, 8)
+	buyOrderC := services.NewOrder(true, 10)
+	buyOrderD := services.NewOrder(true, 1)
+
+	// Three price levels, with C ahead of D at 5,000.
+	for _, placed := range []struct {
+		price services.Money
+		order *services.Order
+	}{{5_000, buyOrderC}, {5_000, buyOrderD}, {9_000, buyOrderB}, {10_000, buyOrderA}} {
+		orderBook.PlaceLimitOrder(placed.price, placed.order)
+		clock.Advance(time.Millisecond)
+	}
+	Assert(t, orderBook.TotalVolumeOfBid(), services.Money(24))
+
+	sellOrder := services.NewOrder(false, 20)
+	matches, err := orderBook.PlaceMarketOrder(sellOrder)
+	Assert(t, err, nil)
+
+	// Best price first, then time within the 5,000 level.
+	Assert(t, len(matches), 3)
+	Assert(t, []*services.Order{matches[0].Bid, matches[1].Bid, matches[2].Bid}, []*services.Order{buyOrderA, buyOrderB, buyOrderC})
+	Assert(t, []services.Money{matches[0].SizeFilled, matches[1].SizeFilled, matches[2].SizeFilled}, []services.Money{5, 8, 7})
+	Assert(t, orderBook.TotalVolumeOfBid(), services.Money(4))
+	Assert(t, len(orderBook.Bids), 1)
+	Assert(t, orderBook.BidLimits[5_000].Orders, services.Orders{buyOrderC, buyOrderD})
+	Assert(t, buyOrderD.Size, services.Money(1))
+}
+
+func TestCancelOrder(t *testing.T) {
+	orderBook, clock := clockedBook()
+
+	orders := []*services.Order{services.NewOrder(true, 4), services.NewOrder(true, 2), services.NewOrder(true, 3)}
+	for _, o := range orders {
+		orderBook.PlaceLimitOrder(10_000, o)
+		clock.Advance(time.Millisecond)
+	}
+	Assert(t, orderBook.TotalVolumeOfBid(), services.Money(9))
+
+	// Cancelling the middle order keeps the others in time priority.
+	orderBook.CancelOrder(orders[1])
+	Assert(t, orderBook.TotalVolumeOfBid(), services.Money(7))
+	Assert(t, orderBook.BidLimits[10_000].Orders, services.Orders{orders[0], orders[2]})
+
+	matches, err := orderBook.PlaceMarketOrder(services.NewOrder(false, 5))
+	Assert(t, err, nil)
+	Assert(t, len(matches), 2)
+	Assert(t, matches[0].Bid, orders[0])
+	Assert(t, matches[1].Bid, orders[2])
+	Assert(t, orders[2].Size, services.Money(2))
+	Assert(t, orders[2].TimeStamp, epoch.Add(2*time.Millisecond).UnixNano())
+
+	orderBook.CancelOrder(orders[2])
+	Assert(t, orderBook.TotalVolumeOfBid(), services.Money(0))
+}
+
+func TestMarketOrderBeyondTheBookIsRejected(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	subscriber := dispatcher.Subscribe("test", 16, messaging.Block, nil)
+	orderBook := services.NewOrderBook()
+	orderBook.Events = dispatcher
+	ask := services.NewOrder(false, 1)
+	orderBook.PlaceLimitOrder(100, ask)
+
+	matches, err := orderBook.PlaceMarketOrder(services.NewOrder(true, 2))
+	Assert(t, errors.Is(err, services.ErrInsufficientLiquidity), true)
+	Assert(t, len(matches), 0)
+	Assert(t, ask.Size, services.Money(1))
+	events := drain(subscriber)
+	Assert(t, events[len(events)-1].EventType(), services.EventOrderRejected)
+}
+
+func TestUnfilledMarketOrderIsCancelledForLiquidity(t *testing.T) {
+	dispatcher := messaging.NewDispatcher()
+	subscriber := dispatcher.Subscribe("test", 16, messaging.Block, nil)
+	book, _ := restingAsks(services.MatchProRata, 1, []services.Money{100, 100}, []services.Money{1, 1})
+	book.Events = dispatcher
+
+	// Pro-rata shares are whole lots, so half a lot is left with volume still on the book.
+	o := services.NewOrder(true, 1.5)
+	matches, err := book.PlaceMarketOrder(o)
+	Assert(t, err, nil)
+	Assert(t, len(matches), 1)
+	events := drain(subscriber)
+	cancelled := events[len(events)-1].(services.OrderCancelled)
+	Assert(t, cancelled.OrderID, o.ID)
+	Assert(t, cancelled.Reason, services.CancelReasonNoLiquidity)
+}
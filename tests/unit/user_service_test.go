@@ -0,0 +1,90 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	users := services.NewUserService()
+	user, err := users.Register("Trader@Example.com", "correct horse")
+	Assert(t, err, nil)
+	Assert(t, user.Email, "trader@example.com")
+
+	_, err = users.Register("trader@example.com", "another password")
+	Assert(t, err, services.ErrEmailTaken)
+
+	_, _, err = users.Login("trader@example.com", "wrong password", "")
+	Assert(t, err, services.ErrInvalidCredentials)
+
+	token, _, err := users.Login("trader@example.com", "correct horse", "")
+	Assert(t, err, nil)
+
+	authenticated, _, err := users.Authenticate(token)
+	Assert(t, err, nil)
+	Assert(t, authenticated.ID, user.ID)
+
+	Assert(t, users.RevokeSession(token), nil)
+	_, _, err = users.Authenticate(token)
+	Assert(t, err, services.ErrSessionNotFound)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	users := services.NewUserService()
+	user, _ := users.Register("bot@example.com", "long enough")
+
+	secret, uri, err := users.EnrollTOTP(user.ID)
+	Assert(t, err, nil)
+	if uri == "" {
+		t.Error("Expected a provisioning URI")
+	}
+	// Enrollment alone does not enforce 2FA.
+	Assert(t, user.TwoFactorEnabled(), false)
+
+	Assert(t, users.ConfirmTOTP(user.ID, "000000x"), services.ErrInvalidTOTP)
+	code, _ := services.TOTPCode(secret, time.Now())
+	Assert(t, users.ConfirmTOTP(user.ID, code), nil)
+	Assert(t, user.TwoFactorEnabled(), true)
+
+	_, _, err = users.Login("bot@example.com", "long enough", "")
+	Assert(t, err, services.ErrTOTPRequired)
+
+	code, _ = services.TOTPCode(secret, time.Now())
+	_, _, err = users.Login("bot@example.com", "long enough", code)
+	Assert(t, err, nil)
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	users := services.NewUserService()
+	user, _ := users.Register("mm@example.com", "long enough")
+	first, _, _ := users.Login("mm@example.com", "long enough", "")
+	second, _, _ := users.Login("mm@example.com", "long enough", "")
+
+	Assert(t, users.RevokeAllSessions(user.ID), 2)
+	for _, token := range []string{first, second} {
+		_, _, err := users.Authenticate(token)
+		Assert(t, err, services.ErrSessionNotFound)
+	}
+}
+
+func TestTOTPKnownVector(t *testing.T) {
+	// RFC 6238 test secret "12345678901234567890" at T=59s.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	code, err := services.TOTPCode(secret, time.Unix(59, 0))
+	Assert(t, err, nil)
+	Assert(t, code, "287082")
+}
+
+func TestOrderOwnership(t *testing.T) {
+	orderBook := services.NewOrderBook()
+	mine := services.NewOrder(true, 5)
+	mine.UserID = "alice"
+	theirs := services.NewOrder(false, 3)
+	theirs.UserID = "bob"
+	orderBook.PlaceLimitOrder(1_000, mine)
+	orderBook.PlaceLimitOrder(1_100, theirs)
+
+	Assert(t, orderBook.OrdersByUser("alice"), []*services.Order{mine})
+}
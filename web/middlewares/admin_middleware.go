@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// AdminTokenHeader carries the token of admin requests.
+const AdminTokenHeader = "X-Admin-Token"
+
+// RequireAdmin only lets through requests carrying the admin token. With an empty token
+// every admin request is refused, so admin endpoints are off unless a token is configured.
+func RequireAdmin(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			given := request.Header.Get(AdminTokenHeader)
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(writer, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/theghostmac/cryptex/internal/app/services"
+)
+
+type contextKey string
+
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
+)
+
+// BearerToken extracts the session token from the Authorization header.
+func BearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// RequireSession rejects requests without a valid session token and stores the
+// authenticated user in the request context.
+func RequireSession(users *services.UserService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			user, session, err := users.Authenticate(BearerToken(request))
+			if err != nil {
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(writer, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(request.Context(), userContextKey, user)
+			ctx = context.WithValue(ctx, sessionContextKey, session)
+			next.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// UserFromContext returns the user authenticated by RequireSession.
+func UserFromContext(ctx context.Context) (*services.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*services.User)
+	return user, ok
+}
+
+// SessionFromContext returns the session authenticated by RequireSession.
+func SessionFromContext(ctx context.Context) (*services.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(*services.Session)
+	return session, ok
+}
@@ -0,0 +1,281 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteClass groups endpoints that share the same rate limit budget.
+type RouteClass string
+
+const (
+	ClassOrders     RouteClass = "orders"
+	ClassCancels    RouteClass = "cancels"
+	ClassMarketData RouteClass = "marketData"
+	ClassAuth       RouteClass = "auth"
+)
+
+// APIKeyHeader is the request header clients use to identify themselves.
+const APIKeyHeader = "X-API-Key"
+
+// Limit describes a token bucket: Rate tokens are added every second, up to Burst tokens.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// ClassLimits holds the buckets applied to a route class, one per API key and one per client IP.
+type ClassLimits struct {
+	PerKey Limit `json:"perKey"`
+	PerIP  Limit `json:"perIP"`
+}
+
+// RateLimitConfig is the complete, reloadable rate limiting configuration.
+type RateLimitConfig struct {
+	Classes map[RouteClass]ClassLimits `json:"classes"`
+	// TrustForwardedFor uses the first X-Forwarded-For address as the client IP when running behind a proxy.
+	TrustForwardedFor bool `json:"trustForwardedFor"`
+}
+
+// DefaultRateLimitConfig returns the limits used when no configuration file is provided.
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Classes: map[RouteClass]ClassLimits{
+			ClassOrders: {
+				PerKey: Limit{Rate: 10, Burst: 20},
+				PerIP:  Limit{Rate: 20, Burst: 40},
+			},
+			ClassCancels: {
+				PerKey: Limit{Rate: 20, Burst: 40},
+				PerIP:  Limit{Rate: 40, Burst: 80},
+			},
+			ClassMarketData: {
+				PerKey: Limit{Rate: 50, Burst: 100},
+				PerIP:  Limit{Rate: 50, Burst: 100},
+			},
+			// Registration and login are kept slow to make password guessing expensive.
+			ClassAuth: {
+				PerIP: Limit{Rate: 0.2, Burst: 10},
+			},
+		},
+	}
+}
+
+// LoadRateLimitConfig reads a JSON rate limit configuration from the given path.
+func LoadRateLimitConfig(path string) (RateLimitConfig, error) {
+	var config RateLimitConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// tokenBucket tracks the tokens left for a single key or IP.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type bucketKey struct {
+	class RouteClass
+	scope string // "key" or "ip"
+	id    string
+}
+
+// RateLimitDecision is the outcome of a rate limit check.
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // time until the bucket is full again.
+	RetryAfter time.Duration // time until the next request would be allowed.
+}
+
+// RateLimiter applies token bucket limits per route class, keyed by API key and by client IP.
+type RateLimiter struct {
+	mu        sync.Mutex
+	config    RateLimitConfig
+	buckets   map[bucketKey]*tokenBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// idleBucketTTL is how long an untouched bucket is kept before it is pruned.
+const idleBucketTTL = 10 * time.Minute
+
+// NewRateLimiter creates a RateLimiter with the given configuration.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:    config,
+		buckets:   make(map[bucketKey]*tokenBucket),
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Reload swaps the configuration without dropping the state of existing buckets.
+// Buckets holding more tokens than their new burst are clamped down.
+func (rl *RateLimiter) Reload(config RateLimitConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.config = config
+	for key, bucket := range rl.buckets {
+		limit, ok := rl.limitFor(key.class, key.scope)
+		if !ok {
+			delete(rl.buckets, key)
+			continue
+		}
+		bucket.tokens = math.Min(bucket.tokens, float64(limit.Burst))
+	}
+}
+
+// Config returns the configuration currently in use.
+func (rl *RateLimiter) Config() RateLimitConfig {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.config
+}
+
+func (rl *RateLimiter) limitFor(class RouteClass, scope string) (Limit, bool) {
+	limits, ok := rl.config.Classes[class]
+	if !ok {
+		return Limit{}, false
+	}
+	limit := limits.PerIP
+	if scope == "key" {
+		limit = limits.PerKey
+	}
+	return limit, limit.Rate > 0 && limit.Burst > 0
+}
+
+// refill tops up the bucket for the elapsed time and returns it, creating a full one if needed.
+func (rl *RateLimiter) refill(key bucketKey, limit Limit, now time.Time) *tokenBucket {
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = bucket
+		return bucket
+	}
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+	bucket.last = now
+	return bucket
+}
+
+// Allow checks the API key and IP buckets of the class and consumes a token from each
+// only when both have one available. An empty apiKey skips the per-key bucket.
+func (rl *RateLimiter) Allow(class RouteClass, apiKey, ip string) RateLimitDecision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.pruneIdle(now)
+
+	type check struct {
+		bucket *tokenBucket
+		limit  Limit
+	}
+	var checks []check
+	if limit, ok := rl.limitFor(class, "key"); ok && apiKey != "" {
+		checks = append(checks, check{rl.refill(bucketKey{class, "key", apiKey}, limit, now), limit})
+	}
+	if limit, ok := rl.limitFor(class, "ip"); ok && ip != "" {
+		checks = append(checks, check{rl.refill(bucketKey{class, "ip", ip}, limit, now), limit})
+	}
+	if len(checks) == 0 {
+		return RateLimitDecision{Allowed: true}
+	}
+
+	decision := RateLimitDecision{Allowed: true, Remaining: math.MaxInt}
+	for _, c := range checks {
+		if c.bucket.tokens < 1 {
+			decision.Allowed = false
+			wait := secondsToDuration((1 - c.bucket.tokens) / c.limit.Rate)
+			if wait > decision.RetryAfter {
+				decision.RetryAfter = wait
+			}
+		}
+	}
+	// The most restrictive bucket is the one reported to the client.
+	for _, c := range checks {
+		if decision.Allowed {
+			c.bucket.tokens--
+		}
+		remaining := int(math.Floor(c.bucket.tokens))
+		if remaining < decision.Remaining {
+			decision.Remaining = remaining
+			decision.Limit = c.limit.Burst
+			decision.Reset = secondsToDuration((float64(c.limit.Burst) - c.bucket.tokens) / c.limit.Rate)
+		}
+	}
+	return decision
+}
+
+// pruneIdle drops buckets that have not been touched for a while, so the map does not grow forever.
+func (rl *RateLimiter) pruneIdle(now time.Time) {
+	if now.Sub(rl.lastPrune) < idleBucketTTL {
+		return
+	}
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) > idleBucketTTL {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastPrune = now
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// Middleware returns a handler wrapper enforcing the limits of the given route class.
+// Every response carries the X-RateLimit-* headers; throttled requests get a 429 with Retry-After.
+func (rl *RateLimiter) Middleware(class RouteClass) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			decision := rl.Allow(class, request.Header.Get(APIKeyHeader), rl.ClientIP(request))
+			if decision.Limit > 0 {
+				header := writer.Header()
+				header.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+				header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+				header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+			}
+			if !decision.Allowed {
+				writer.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+				http.Error(writer, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientIP returns the address the request is accounted against.
+func (rl *RateLimiter) ClientIP(request *http.Request) string {
+	if rl.Config().TrustForwardedFor {
+		if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -1,15 +1,30 @@
 package server
 
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
 type StartRunner struct {
-	ListenAddr string
+	ListenAddr      string
+	BaseHandler     http.Handler
+	GRPCAddr        string
+	GRPCServer      *grpc.Server
+	ShutdownTimeout time.Duration
 }
 
-func (r *StartRunner) Run() error {
+// Run serves until ctx is done, then shuts the servers down gracefully.
+func (r *StartRunner) Run(ctx context.Context) error {
 	server := &GracefulShutdown{
-		ListenAddr:  r.ListenAddr,
-		BaseHandler: nil, // Replace nil with your actual HTTP handler if needed.
+		ListenAddr:      r.ListenAddr,
+		BaseHandler:     r.BaseHandler,
+		GRPCAddr:        r.GRPCAddr,
+		GRPCServer:      r.GRPCServer,
+		ShutdownTimeout: r.ShutdownTimeout,
 	}
 
-	server.Start()
-	return nil
+	return server.Start(ctx)
 }
@@ -1,33 +1,114 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/gorilla/mux"
+	"net"
 	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
+// DefaultShutdownTimeout is how long in-flight requests and streams get to finish on shutdown.
+const DefaultShutdownTimeout = 10 * time.Second
+
 type GracefulShutdown struct {
 	ListenAddr  string
 	BaseHandler http.Handler
-	httpServer  *http.Server
+	// GRPCAddr and GRPCServer, when both set, serve gRPC next to HTTP under the same lifecycle.
+	GRPCAddr        string
+	GRPCServer      *grpc.Server
+	ShutdownTimeout time.Duration
+	httpServer      *http.Server
 }
 
 func (gs *GracefulShutdown) GetRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.SkipClean(true)
-	router.Handle("/", gs.BaseHandler)
+	router.PathPrefix("/").Handler(gs.BaseHandler)
 	return router
 }
 
-func (gs *GracefulShutdown) Start() {
-	router := gs.GetRouter()
-	gs.httpServer = &http.Server{
-		Addr:    gs.ListenAddr,
-		Handler: router,
+// Start listens on the configured addresses and serves until ctx is done.
+func (gs *GracefulShutdown) Start(ctx context.Context) error {
+	httpListener, err := net.Listen("tcp", gs.ListenAddr)
+	if err != nil {
+		return err
+	}
+	var grpcListener net.Listener
+	if gs.GRPCServer != nil && gs.GRPCAddr != "" {
+		if grpcListener, err = net.Listen("tcp", gs.GRPCAddr); err != nil {
+			httpListener.Close()
+			return err
+		}
+	}
+	return gs.Serve(ctx, httpListener, grpcListener)
+}
+
+// Serve serves HTTP on httpListener and gRPC on grpcListener, when it is not nil, until ctx is
+// done or either server fails. Both are then shut down together: new connections are refused and
+// in-flight requests and streams get ShutdownTimeout to finish before they are closed.
+func (gs *GracefulShutdown) Serve(ctx context.Context, httpListener, grpcListener net.Listener) error {
+	gs.httpServer = &http.Server{Handler: gs.GetRouter()}
+	failed := make(chan error, 2)
+
+	fmt.Printf("Server is running at %s\n", httpListener.Addr())
+	go func() {
+		if err := gs.httpServer.Serve(httpListener); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+	if grpcListener != nil {
+		fmt.Printf("gRPC server is running at %s\n", grpcListener.Addr())
+		go func() {
+			if err := gs.GRPCServer.Serve(grpcListener); err != nil {
+				failed <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+	if shutdownErr := gs.shutdown(grpcListener != nil); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+func (gs *GracefulShutdown) shutdown(withGRPC bool) error {
+	timeout := gs.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	fmt.Printf("Server is running at %s\n", gs.ListenAddr)
-	if err := gs.httpServer.ListenAndServe(); err != nil {
-		fmt.Printf("Error starting server: %s\n", err)
+	stopped := make(chan struct{})
+	if withGRPC {
+		go func() {
+			gs.GRPCServer.GracefulStop()
+			close(stopped)
+		}()
+	}
+	err := gs.httpServer.Shutdown(ctx)
+	if err != nil {
+		gs.httpServer.Close()
+	}
+	if !withGRPC {
+		return err
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// Streams such as book subscriptions only end when their clients leave, so cut them off.
+		gs.GRPCServer.Stop()
+		<-stopped
 	}
+	return err
 }